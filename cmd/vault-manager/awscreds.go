@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// metadataTimeout bounds requests to the instance metadata service, which is unreachable outside of EC2
+const metadataTimeout = time.Second
+
+// awsCredentialProvider returns nil credentials when it is not configured in the environment
+type awsCredentialProvider func(region string) (*awsCredentials, error)
+
+// resolveAWSCredentials returns the credentials signing s3 requests in the order of the default credential
+// chain of the AWS SDKs: env vars, web identity (e.g. IRSA on OpenShift and EKS), the shared credentials file,
+// container credentials and the instance profile of the EC2 instance metadata service.
+// nil is returned when none of them is configured so that objects are read anonymously
+func resolveAWSCredentials(region string) (*awsCredentials, error) {
+	chain := []struct {
+		name     string
+		provider awsCredentialProvider
+	}{
+		{"environment", envCredentials},
+		{"web identity", webIdentityCredentials},
+		{"shared credentials file", sharedFileCredentials},
+		{"container", containerCredentials},
+		{"instance metadata", instanceMetadataCredentials},
+	}
+	for _, p := range chain {
+		creds, err := p.provider(region)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s credentials: %v", p.name, err))
+		}
+		if creds != nil {
+			return creds, nil
+		}
+	}
+	return nil, nil
+}
+
+func envCredentials(string) (*awsCredentials, error) {
+	creds := awsCredentials{
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.accessKeyID == "" || creds.secretAccessKey == "" {
+		return nil, nil
+	}
+	return &creds, nil
+}
+
+// webIdentityCredentials assumes AWS_ROLE_ARN with the token of AWS_WEB_IDENTITY_TOKEN_FILE
+// the regional sts endpoint is used unless overridden by AWS_ENDPOINT_URL_STS
+func webIdentityCredentials(region string) (*awsCredentials, error) {
+	tokenFile, roleARN := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), os.Getenv("AWS_ROLE_ARN")
+	if tokenFile == "" || roleARN == "" {
+		return nil, nil
+	}
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "vault-manager"
+	}
+	endpoint := fmt.Sprintf("https://sts.%s.amazonaws.com/", region)
+	if e := os.Getenv("AWS_ENDPOINT_URL_STS"); e != "" {
+		endpoint = e
+	}
+
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := awsRequest(&http.Client{Timeout: sourceTimeout}, req)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string `xml:"SecretAccessKey"`
+			SessionToken    string `xml:"SessionToken"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &resp); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to decode sts response: %v", err))
+	}
+	if resp.Credentials.AccessKeyID == "" {
+		return nil, errors.New("sts response does not contain credentials")
+	}
+	return &awsCredentials{
+		accessKeyID:     resp.Credentials.AccessKeyID,
+		secretAccessKey: resp.Credentials.SecretAccessKey,
+		sessionToken:    resp.Credentials.SessionToken,
+	}, nil
+}
+
+// sharedFileCredentials reads the static credentials of the AWS_PROFILE profile, default `default`,
+// from AWS_SHARED_CREDENTIALS_FILE, default ~/.aws/credentials
+func sharedFileCredentials(string) (*awsCredentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	explicit := profile != ""
+	if !explicit {
+		profile = "default"
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		if i := strings.Index(line, "="); i > 0 {
+			values[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	creds := awsCredentials{
+		accessKeyID:     values["aws_access_key_id"],
+		secretAccessKey: values["aws_secret_access_key"],
+		sessionToken:    values["aws_session_token"],
+	}
+	if creds.accessKeyID == "" || creds.secretAccessKey == "" {
+		if explicit {
+			return nil, errors.New(fmt.Sprintf("profile %s of %s does not contain static credentials", profile, path))
+		}
+		return nil, nil
+	}
+	return &creds, nil
+}
+
+// containerCredentials reads the credentials of the task role of ECS or of EKS pod identities
+func containerCredentials(string) (*awsCredentials, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		endpoint = "http://169.254.170.2" + relative
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	body, err := awsRequest(&http.Client{Timeout: sourceTimeout}, req)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRoleCredentials(body)
+}
+
+// instanceMetadataCredentials reads the credentials of the instance profile via IMDSv2
+// an unreachable metadata service or an instance without a profile is not an error, as outside of EC2.
+// AWS_EC2_METADATA_DISABLED=true skips the metadata service and AWS_EC2_METADATA_SERVICE_ENDPOINT overrides it
+func instanceMetadataCredentials(string) (*awsCredentials, error) {
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return nil, nil
+	}
+	endpoint := strings.TrimSuffix(os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"), "/")
+	if endpoint == "" {
+		endpoint = "http://169.254.169.254"
+	}
+	client := &http.Client{Timeout: metadataTimeout}
+
+	req, err := http.NewRequest(http.MethodPut, endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	token, err := awsRequest(client, req)
+	if err != nil {
+		log.WithError(err).Debug("[S3] instance metadata service is not available")
+		return nil, nil
+	}
+
+	get := func(path string) ([]byte, error) {
+		req, err := http.NewRequest(http.MethodGet, endpoint+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-aws-ec2-metadata-token", string(token))
+		return awsRequest(client, req)
+	}
+	roles, err := get("/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		log.WithError(err).Debug("[S3] instance has no instance profile")
+		return nil, nil
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if role == "" {
+		return nil, nil
+	}
+	body, err := get("/latest/meta-data/iam/security-credentials/" + role)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRoleCredentials(body)
+}
+
+// decodeRoleCredentials decodes the credentials returned by the container and instance metadata endpoints
+func decodeRoleCredentials(body []byte) (*awsCredentials, error) {
+	var resp struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to decode credentials: %v", err))
+	}
+	if resp.AccessKeyID == "" || resp.SecretAccessKey == "" {
+		return nil, errors.New("response does not contain credentials")
+	}
+	return &awsCredentials{accessKeyID: resp.AccessKeyID, secretAccessKey: resp.SecretAccessKey, sessionToken: resp.Token}, nil
+}
+
+// awsRequest returns the body of a successful response to req
+func awsRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, errors.New(fmt.Sprintf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body))))
+	}
+	return body, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// clearAWSEnv unsets the variables configuring the credential chain so that the environment running the tests
+// does not affect them, the instance metadata service is disabled unless a test enables it
+func clearAWSEnv(t *testing.T) {
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN",
+		"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_ROLE_SESSION_NAME", "AWS_ENDPOINT_URL_STS",
+		"AWS_PROFILE", "AWS_CONTAINER_CREDENTIALS_FULL_URI", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN", "AWS_EC2_METADATA_SERVICE_ENDPOINT"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "missing"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+}
+
+func TestResolveAWSCredentialsAnonymous(t *testing.T) {
+	clearAWSEnv(t)
+	creds, err := resolveAWSCredentials("us-east-1")
+	require.NoError(t, err)
+	require.Nil(t, creds, "objects are read anonymously without credentials")
+}
+
+func TestResolveAWSCredentialsWebIdentity(t *testing.T) {
+	clearAWSEnv(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		if r.Form.Get("Action") != "AssumeRoleWithWebIdentity" || r.Form.Get("WebIdentityToken") != "projected-token" ||
+			r.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/vault-manager" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>ASIAEXAMPLE</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>session</SessionToken>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`))
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("projected-token\n"), 0600))
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/vault-manager")
+	t.Setenv("AWS_ENDPOINT_URL_STS", server.URL)
+
+	creds, err := resolveAWSCredentials("us-east-1")
+	require.NoError(t, err)
+	require.Equal(t, &awsCredentials{accessKeyID: "ASIAEXAMPLE", secretAccessKey: "secret", sessionToken: "session"}, creds)
+
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/other")
+	_, err = resolveAWSCredentials("us-east-1")
+	require.Error(t, err, "a configured provider failing is not hidden by falling back to anonymous reads")
+}
+
+func TestResolveAWSCredentialsSharedFile(t *testing.T) {
+	clearAWSEnv(t)
+	path := filepath.Join(t.TempDir(), "credentials")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`# comment
+[default]
+aws_access_key_id = AKIADEFAULT
+aws_secret_access_key = default-secret
+
+[ci]
+aws_access_key_id=AKIACI
+aws_secret_access_key=ci-secret
+aws_session_token=ci-session
+`), 0600))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", path)
+
+	creds, err := resolveAWSCredentials("us-east-1")
+	require.NoError(t, err)
+	require.Equal(t, &awsCredentials{accessKeyID: "AKIADEFAULT", secretAccessKey: "default-secret"}, creds)
+
+	t.Setenv("AWS_PROFILE", "ci")
+	creds, err = resolveAWSCredentials("us-east-1")
+	require.NoError(t, err)
+	require.Equal(t, &awsCredentials{accessKeyID: "AKIACI", secretAccessKey: "ci-secret", sessionToken: "ci-session"}, creds)
+
+	t.Setenv("AWS_PROFILE", "missing")
+	_, err = resolveAWSCredentials("us-east-1")
+	require.Error(t, err)
+}
+
+func TestResolveAWSCredentialsInstanceMetadata(t *testing.T) {
+	clearAWSEnv(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			w.Write([]byte("imds-token"))
+		case r.Header.Get("X-aws-ec2-metadata-token") != "imds-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			w.Write([]byte("vault-manager\n"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/vault-manager":
+			w.Write([]byte(`{"Code":"Success","AccessKeyId":"ASIAIMDS","SecretAccessKey":"imds-secret","Token":"imds-session"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "")
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", server.URL)
+
+	creds, err := resolveAWSCredentials("us-east-1")
+	require.NoError(t, err)
+	require.Equal(t, &awsCredentials{accessKeyID: "ASIAIMDS", secretAccessKey: "imds-secret", sessionToken: "imds-session"}, creds)
+
+	// static credentials take precedence as in the default chain of the AWS SDKs
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	creds, err = resolveAWSCredentials("us-east-1")
+	require.NoError(t, err)
+	require.Equal(t, "AKIAENV", creds.accessKeyID)
+}
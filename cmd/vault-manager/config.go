@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/app-sre/vault-manager/pkg/utils"
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/app-sre/vault-manager/toplevel"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	stdinConfig     []byte
+	stdinConfigErr  error
+	stdinConfigOnce sync.Once
+)
+
+// configFile is a configuration document read from a file, url or stdin
+type configFile struct {
+	name string
+	cfg  config
+}
+
+// readConfigFiles reads the configuration documents at paths and merges them
+// a path of `-` reads a document from stdin, which is only read once and reused on later calls,
+// other paths may be urls, see readSource
+func readConfigFiles(paths []string) (config, error) {
+	files := []configFile{}
+	for _, path := range paths {
+		var data []byte
+		var err error
+		if path == "-" {
+			stdinConfigOnce.Do(func() {
+				stdinConfig, stdinConfigErr = ioutil.ReadAll(os.Stdin)
+			})
+			data, err = stdinConfig, stdinConfigErr
+		} else {
+			data, err = readSource(path)
+		}
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("failed to read configuration file `%s`: %v", path, err))
+		}
+		var cfg config
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, errors.New(fmt.Sprintf("failed to decode configuration file `%s`: %v", path, err))
+		}
+		files = append(files, configFile{name: path, cfg: cfg})
+	}
+	return mergeConfigs(files)
+}
+
+// mergeConfigs concatenates the entries of each top-level configuration across files
+// entries identifying the same object on the same instance more than once are an error
+func mergeConfigs(files []configFile) (config, error) {
+	merged := make(config)
+	// file containing each identified entry per top-level configuration
+	seen := make(map[string]map[string]string)
+	errs := []error{}
+	for _, f := range files {
+		names := []string{}
+		for name := range f.cfg {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if f.cfg[name] == nil {
+				continue
+			}
+			entries, ok := f.cfg[name].([]interface{})
+			if !ok {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"`%s` in configuration file `%s` must be a list", name, f.name)))
+				continue
+			}
+			if seen[name] == nil {
+				seen[name] = make(map[string]string)
+			}
+			for _, e := range entries {
+				key, err := entryKey(e)
+				if err != nil {
+					errs = append(errs, errors.New(fmt.Sprintf(
+						"invalid entry of `%s` in configuration file `%s`: %v", name, f.name, err)))
+					continue
+				}
+				if previous, dup := seen[name][key]; dup {
+					errs = append(errs, errors.New(fmt.Sprintf(
+						"duplicate entry `%s` of `%s` in configuration files `%s` and `%s`", key, name, previous, f.name)))
+					continue
+				}
+				seen[name][key] = f.name
+				existing, _ := merged[name].([]interface{})
+				merged[name] = append(existing, e)
+			}
+		}
+	}
+	if err := utils.JoinErrors(errs); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// entryIdentity holds the fields identifying an object within the entries of any top-level configuration
+type entryIdentity struct {
+	Instance    vault.Instance `yaml:"instance"`
+	Address     string         `yaml:"address"`
+	Namespace   string         `yaml:"namespace"`
+	Mount       string         `yaml:"mount"`
+	Path        string         `yaml:"_path"`
+	SecretPath  string         `yaml:"path"`
+	Name        string         `yaml:"name"`
+	OrgUsername string         `yaml:"org_username"`
+}
+
+// entryKey returns the identity of an entry made up of its instance and the fields naming it
+func entryKey(e interface{}) (string, error) {
+	data, err := yaml.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	var id entryIdentity
+	if err := yaml.Unmarshal(data, &id); err != nil {
+		return "", err
+	}
+	parts := []string{}
+	for _, part := range []string{id.Instance.Key(), id.Address, id.Namespace, id.Mount,
+		id.Path, id.SecretPath, id.Name, id.OrgUsername} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "|"), nil
+}
+
+// checkConfig validates the configuration files at paths along with the policies of policy directories
+// without connecting to any vault instance
+func checkConfig(paths []string, policyDirs []policyDir) error {
+	if len(paths) == 0 {
+		return errors.New("`-config-check` requires the configuration files to be passed with `-config`")
+	}
+	cfg, err := readConfigFiles(paths)
+	if err != nil {
+		return err
+	}
+	if err := addPolicyDirs(cfg, policyDirs); err != nil {
+		return err
+	}
+	return validateConfig(cfg)
+}
+
+// unknownKeys returns the sorted top-level keys of a configuration that no top-level configuration is registered for,
+// e.g. a misspelled `vault_polices`
+func unknownKeys(cfg config) []string {
+	unknown := []string{}
+	for name := range cfg {
+		if name != "vault_instances" && !toplevel.IsRegistered(name) {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// validateConfig validates the instances and the entries of every top-level configuration
+// all invalid entries and unknown top-level configurations are reported rather than only the first
+func validateConfig(cfg config) error {
+	names := make([]string, 0, len(cfg))
+	for name := range cfg {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	errs := []error{}
+	for _, name := range names {
+		dataBytes, err := yaml.Marshal(cfg[name])
+		if err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("failed to remarshal `%s`: %v", name, err)))
+			continue
+		}
+		switch {
+		case name == "vault_instances":
+			err = vault.ValidateInstances(dataBytes)
+		case toplevel.IsRegistered(name):
+			err = toplevel.Validate(name, dataBytes)
+		default:
+			err = errors.New(fmt.Sprintf("unknown top-level configuration `%s`", name))
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utils.JoinErrors(errs)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestMergeConfigs(t *testing.T) {
+	table := []struct {
+		description string
+		files       []string
+		expected    map[string]int
+		expectErr   bool
+	}{
+		{
+			description: "entries are concatenated per top-level configuration",
+			files: []string{
+				"vault_policies:\n- name: a\n  instance:\n    address: https://a\nvault_secret_engines:\n- _path: app/\n  instance:\n    address: https://a\n",
+				"vault_policies:\n- name: b\n  instance:\n    address: https://a\n",
+			},
+			expected: map[string]int{"vault_policies": 2, "vault_secret_engines": 1},
+		},
+		{
+			description: "same name on different instances",
+			files: []string{
+				"vault_policies:\n- name: a\n  instance:\n    address: https://a\n",
+				"vault_policies:\n- name: a\n  instance:\n    address: https://b\n",
+			},
+			expected: map[string]int{"vault_policies": 2},
+		},
+		{
+			description: "same name on different mounts",
+			files: []string{
+				"vault_roles:\n- name: a\n  mount: approle/\n  instance:\n    address: https://a\n",
+				"vault_roles:\n- name: a\n  mount: kubernetes/\n  instance:\n    address: https://a\n",
+			},
+			expected: map[string]int{"vault_roles": 2},
+		},
+		{
+			description: "duplicate entries across files",
+			files: []string{
+				"vault_policies:\n- name: a\n  instance:\n    address: https://a\n",
+				"vault_policies:\n- name: a\n  instance:\n    address: https://a\n",
+			},
+			expectErr: true,
+		},
+		{
+			description: "duplicate instances",
+			files: []string{
+				"vault_instances:\n- address: https://a\n",
+				"vault_instances:\n- address: https://a\n",
+			},
+			expectErr: true,
+		},
+		{
+			description: "configuration is not a list",
+			files:       []string{"vault_policies:\n  name: a\n"},
+			expectErr:   true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			files := []configFile{}
+			for i, f := range tt.files {
+				var cfg config
+				require.NoError(t, yaml.Unmarshal([]byte(f), &cfg))
+				files = append(files, configFile{name: string(rune('a' + i)), cfg: cfg})
+			}
+			merged, err := mergeConfigs(files)
+			if tt.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			counts := make(map[string]int)
+			for name, entries := range merged {
+				counts[name] = len(entries.([]interface{}))
+			}
+			require.Equal(t, tt.expected, counts)
+		})
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	cfg := config{
+		"vault_instances": []interface{}{},
+		"vault_policies":  []interface{}{},
+		"vault_polices":   []interface{}{},
+		"vault_aliens":    []interface{}{},
+	}
+	require.Equal(t, []string{"vault_aliens", "vault_polices"}, unknownKeys(cfg))
+	require.Empty(t, unknownKeys(config{"vault_instances": nil, "vault_roles": nil}))
+}
+
+func TestValidateConfig(t *testing.T) {
+	table := []struct {
+		description string
+		config      string
+		expectErr   bool
+	}{
+		{
+			description: "valid configuration",
+			config: "vault_instances:\n- address: https://a\n  auth:\n    provider: token\n    token:\n      path: secret/token\n      field: token\n" +
+				"vault_policies:\n- name: a\n  rules: path \"secret/*\" { capabilities = [\"read\"] }\n  instance:\n    address: https://a\n",
+			expectErr: false,
+		},
+		{
+			description: "malformed policy",
+			config:      "vault_policies:\n- name: a\n  rules: path \"secret/*\" {\n  instance:\n    address: https://a\n",
+			expectErr:   true,
+		},
+		{
+			description: "unsupported secrets engine type",
+			config:      "vault_secret_engines:\n- _path: app/\n  type: kv3\n  instance:\n    address: https://a\n",
+			expectErr:   true,
+		},
+		{
+			description: "instance missing auth attributes",
+			config:      "vault_instances:\n- address: https://a\n  auth:\n    provider: token\n",
+			expectErr:   true,
+		},
+		{
+			description: "unknown top-level configuration",
+			config:      "vault_unknown:\n- name: a\n",
+			expectErr:   true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			var cfg config
+			require.NoError(t, yaml.Unmarshal([]byte(tt.config), &cfg))
+			err := validateConfig(cfg)
+			if tt.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
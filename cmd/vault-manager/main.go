@@ -9,7 +9,11 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
-	"sort"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/app-sre/vault-manager/pkg/utils"
@@ -22,22 +26,27 @@ import (
 	"gopkg.in/yaml.v2"
 
 	// Register top-level configurations.
+	_ "github.com/app-sre/vault-manager/toplevel/approle"
 	_ "github.com/app-sre/vault-manager/toplevel/audit"
 	_ "github.com/app-sre/vault-manager/toplevel/auth"
+	_ "github.com/app-sre/vault-manager/toplevel/cors"
+	_ "github.com/app-sre/vault-manager/toplevel/database"
 	_ "github.com/app-sre/vault-manager/toplevel/entity"
 	_ "github.com/app-sre/vault-manager/toplevel/group"
+	_ "github.com/app-sre/vault-manager/toplevel/managedkey"
+	_ "github.com/app-sre/vault-manager/toplevel/mfa"
+	_ "github.com/app-sre/vault-manager/toplevel/oidc"
+	_ "github.com/app-sre/vault-manager/toplevel/pki"
+	_ "github.com/app-sre/vault-manager/toplevel/plugin"
 	_ "github.com/app-sre/vault-manager/toplevel/policy"
+	_ "github.com/app-sre/vault-manager/toplevel/quota"
 	_ "github.com/app-sre/vault-manager/toplevel/role"
+	_ "github.com/app-sre/vault-manager/toplevel/secret"
 	_ "github.com/app-sre/vault-manager/toplevel/secretsengine"
+	_ "github.com/app-sre/vault-manager/toplevel/tokenrole"
+	_ "github.com/app-sre/vault-manager/toplevel/transit"
 )
 
-type TopLevelConfig struct {
-	Name     string
-	Priority int
-}
-
-type ByPriority []TopLevelConfig
-
 var logFile *os.File
 
 func init() {
@@ -61,28 +70,260 @@ func init() {
 	}
 
 }
-func (a ByPriority) Len() int {
-	return len(a)
-}
-func (a ByPriority) Less(i, j int) bool {
-	return a[i].Priority < a[j].Priority
-}
-func (a ByPriority) Swap(i, j int) {
-	a[i], a[j] = a[j], a[i]
-}
 
 func main() {
 	defer logFile.Close()
 
 	var dryRun bool
+	var detectDrift bool
+	var output string
+	var only string
+	var configFiles string
+	var configCheck bool
+	var showDiff bool
+	var forceRecreate bool
+	var allowRemount bool
+	var forceDeleteManagedKeys bool
+	var maxDeletes int
+	var confirmMassDelete bool
+	var followStandby bool
+	var parallelInstances bool
+	var prune bool
 	var runOnce bool
+	var strict bool
+	var strictKeys bool
 	var threadPoolSize int
+	var threadPoolSizes string
+	var readTimeout time.Duration
+	var writeTimeout time.Duration
+	var maxRetries int
+	var writeRate float64
+	var retryBaseDelay time.Duration
+	var maxRuntime time.Duration
+	var shutdownGracePeriod time.Duration
+	var lockPath string
+	var lockTTL time.Duration
+	var healthAddress string
+	var planFilePath string
+	var includeInstances stringList
+	var excludeInstances stringList
+	var applyPlanPath string
+	var auditHashPath string
+	var auditHashInput string
+	var cachePath string
+	var full bool
+	var summaryWebhook string
+	var summaryEvent bool
+	var previewAddress string
+	var policyDirValues stringList
+	var reloadAuditDevices bool
 	flag.BoolVar(&dryRun, "dry-run", false, "If true, will only print planned actions")
+	flag.BoolVar(&detectDrift, "detect-drift", false, "If true, performs a dry run and exits with status 2 if any instance differs from the configuration")
+	flag.StringVar(&output, "output", "text", "Format of dry-run output, either text or json")
+	flag.BoolVar(&parallelInstances, "parallel-instances", false, "Reconcile vault instances concurrently, bounded by thread-pool-size")
+	flag.BoolVar(&forceRecreate, "force-recreate", false, "If true, secrets engines whose type changed are disabled and enabled again, destroying their data")
+	flag.BoolVar(&allowRemount, "allow-remount", false, "If true, secrets engines whose path changed are moved to the new path along with their data")
+	flag.BoolVar(&forceDeleteManagedKeys, "force-delete-managed-keys", false, "If true, managed keys missing from the configuration are deleted with -prune even if secrets engines are allowed to use them")
+	flag.IntVar(&maxDeletes, "max-deletes", 0, "Number of objects each top-level configuration may delete from an instance, exceeding it fails the top-level configuration. 0 disables the limit")
+	flag.BoolVar(&confirmMassDelete, "confirm-mass-delete", false, "If true, top-level configurations may delete more objects than -max-deletes")
+	flag.BoolVar(&showDiff, "show-diff", false, "Output the line-level difference between existing and desired policy rules during a dry run")
+	flag.StringVar(&only, "only", "", "Comma separated list of top-level configurations to reconcile, e.g. vault_policies,vault_roles. Empty reconciles all")
+	flag.StringVar(&configFiles, "config", "", "Comma separated list of yaml files or file://, http(s):// or s3:// urls to read the configuration from instead of the graphql server, - reads from stdin")
+	flag.BoolVar(&followStandby, "follow-standby", false, "If true, instances whose address resolves to a standby node are reconciled through the active node instead of being skipped")
+	flag.BoolVar(&configCheck, "config-check", false, "If true, validates the configuration files passed with -config without connecting to vault and exits")
+	flag.BoolVar(&prune, "prune", false, "If true, objects missing from the configuration are deleted from vault")
+	flag.BoolVar(&strict, "strict", false, "If true, a failure reconciling any single instance fails the whole run")
+	flag.BoolVar(&strictKeys, "strict-keys", false, "If true, top-level keys of the configuration that are not a known top-level configuration fail the run instead of being skipped")
 	flag.IntVar(&threadPoolSize, "thread-pool-size", 10, "Some operations are running in parallel"+
 		" to achieve the best performance, so -thread-pool-size determine how many threads can be utilized, default is 10")
+	flag.StringVar(&threadPoolSizes, "thread-pool-sizes", "", "Comma separated list of per top-level configuration overrides of -thread-pool-size, e.g. vault_policies=20,vault_secret_engines=4")
 	flag.BoolVar(&runOnce, "run-once", true, "If true, program will skip loop and exit after first reconcile attempt")
+	flag.DurationVar(&readTimeout, "read-timeout", 30*time.Second, "Timeout applied to each read/list request made to a vault instance")
+	flag.DurationVar(&writeTimeout, "write-timeout", 30*time.Second, "Timeout applied to each write/delete request made to a vault instance")
+	flag.IntVar(&maxRetries, "max-retries", 2, "Number of times a request failing with a 5xx, 429 or network error is retried")
+	flag.Float64Var(&writeRate, "write-rate", 100, "Write requests per second made to each instance, 0 disables throttling")
+	flag.DurationVar(&retryBaseDelay, "retry-base-delay", time.Second, "Delay before the first retry, doubled with each subsequent retry")
+	flag.StringVar(&planFilePath, "plan-file", "", "File a dry run writes the changes of each top-level configuration per instance to, to be applied with -apply-plan")
+	flag.StringVar(&applyPlanPath, "apply-plan", "", "Plan file written by a dry run with -plan-file, only the changes it contains are applied")
+	flag.Var(&includeInstances, "instance", "Address of an instance to reconcile, may be repeated. Reconciles all instances when unset")
+	flag.Var(&policyDirValues, "policy-dir", "Directory of .hcl files written as acl policies named after each file to an instance, of the form <instance address>=<directory>, may be repeated")
+	flag.Var(&excludeInstances, "exclude-instance", "Address of an instance not to reconcile, may be repeated")
+	flag.StringVar(&auditHashPath, "audit-hash", "", "Path of an audit device to hash -audit-hash-input with, prints the hash and exits. Requires a single -instance")
+	flag.StringVar(&auditHashInput, "audit-hash-input", "", "Input hashed with the audit device passed to -audit-hash")
+	flag.BoolVar(&reloadAuditDevices, "reload-audit-devices", false, "If true, file audit devices configured with reload_on_apply are disabled and enabled again to reopen their files. Requires -run-once")
+	flag.DurationVar(&maxRuntime, "max-runtime", 0, "Deadline of each reconcile after which no further top-level configurations are applied, 0 disables the deadline")
+	flag.DurationVar(&shutdownGracePeriod, "shutdown-grace-period", 25*time.Second, "Time to complete in-flight operations after a termination signal before exiting regardless")
+	flag.StringVar(&lockPath, "lock-path", "", "Path of a kv v2 secret on the master instance used as a lock to prevent concurrent runs, e.g. app-sre/vault-manager/lock")
+	flag.DurationVar(&lockTTL, "lock-ttl", time.Hour, "Time after which a lock that was not refreshed or released can be acquired by another run")
+	flag.StringVar(&cachePath, "cache-path", "", "File storing a hash of the configuration each top-level configuration was last successfully applied with per instance, unchanged configurations are skipped. A changed object applies its whole top-level configuration again, and configurations reading values from secrets in vault (e.g. database passwords) are never skipped. Empty disables the cache")
+	flag.BoolVar(&full, "full", false, "If true, top-level configurations are applied regardless of the cache passed with -cache-path, which is updated")
+	flag.StringVar(&summaryWebhook, "summary-webhook", "", "URL a json summary of each reconcile is posted to once it has completed. Empty disables the webhook")
+	flag.BoolVar(&summaryEvent, "summary-event", false, "If true, a summary of each reconcile is emitted as a Kubernetes Event of the pod vault-manager runs in")
+	flag.StringVar(&previewAddress, "preview-address", "", "Address to serve an html page of the planned changes on once a dry run has completed, e.g. localhost:8081. Implies -dry-run and -show-diff, requires -run-once")
+	flag.StringVar(&healthAddress, "health-address", "", "Address to serve /healthz, /readyz and /metrics on when -run-once=false, e.g. :8080. Empty disables the server")
 	flag.Parse()
 
+	vault.SetRequestTimeouts(readTimeout, writeTimeout)
+	vault.SetRetryPolicy(maxRetries, retryBaseDelay)
+	vault.SetWriteRate(writeRate)
+	vault.SetInstanceFilter(includeInstances, excludeInstances)
+	// the preview is only meant for reviewing changes locally and therefore never applies them
+	if previewAddress != "" {
+		if !runOnce {
+			log.Fatalln("`-preview-address` can only be used with `-run-once`")
+		}
+		dryRun = true
+		showDiff = true
+	}
+	if showDiff {
+		vault.EnableShowDiff()
+	}
+	if forceRecreate {
+		vault.EnableForceRecreate()
+	}
+	if allowRemount {
+		vault.EnableRemount()
+	}
+	if forceDeleteManagedKeys {
+		vault.EnableForceDeleteManagedKeys()
+	}
+	if followStandby {
+		vault.EnableFollowStandby()
+	}
+	// reloading discards the salt of the devices, so it is only done when requested rather than with every reconcile
+	// the audit devices are applied regardless of the cache as they would otherwise only be reloaded once changed
+	if reloadAuditDevices {
+		if !runOnce {
+			log.Fatalln("`-reload-audit-devices` can only be used with `-run-once`")
+		}
+		vault.EnableReloadAuditDevices()
+		full = true
+	}
+	if maxDeletes < 0 {
+		log.Fatalln("`-max-deletes` must not be negative")
+	}
+	vault.SetMaxDeletes(maxDeletes)
+	if confirmMassDelete {
+		vault.EnableConfirmMassDelete()
+	}
+
+	if maxRuntime < 0 {
+		log.Fatalln("`-max-runtime` must not be negative")
+	}
+	if shutdownGracePeriod < 0 {
+		log.Fatalln("`-shutdown-grace-period` must not be negative")
+	}
+	if lockTTL <= 0 {
+		log.Fatalln("`-lock-ttl` must be greater than 0")
+	}
+	if summaryWebhook != "" {
+		if err := vault.ValidateWebhook(summaryWebhook); err != nil {
+			log.WithError(err).Fatal("invalid `-summary-webhook`")
+		}
+	}
+	if summaryEvent && !vault.InCluster() {
+		log.Warn("[Summary] `-summary-event` is set but vault-manager is not running within a kubernetes cluster, no events are emitted")
+	}
+	if threadPoolSize < 1 {
+		log.Fatalln("`-thread-pool-size` must be greater than 0")
+	}
+	poolSizes, err := parseThreadPoolSizes(threadPoolSizes)
+	if err != nil {
+		log.WithError(err).Fatal("invalid `-thread-pool-sizes`")
+	}
+	policyDirs, err := parsePolicyDirs(policyDirValues)
+	if err != nil {
+		log.WithError(err).Fatal("invalid `-policy-dir`")
+	}
+
+	onlyConfigs := make(map[string]bool)
+	for _, name := range strings.Split(only, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !toplevel.IsRegistered(name) {
+			log.Fatalf("unknown top-level configuration `%s` passed to `-only`", name)
+		}
+		onlyConfigs[name] = true
+	}
+
+	configPaths := []string{}
+	for _, path := range strings.Split(configFiles, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			configPaths = append(configPaths, path)
+		}
+	}
+
+	if detectDrift {
+		if !runOnce {
+			log.Fatalln("`-detect-drift` can only be used with `-run-once`")
+		}
+		dryRun = true
+	}
+
+	if planFilePath != "" && (!dryRun || !runOnce) {
+		log.Fatalln("`-plan-file` can only be used with `-dry-run` and `-run-once`")
+	}
+	var appliedPlan *vault.PlanFile
+	if applyPlanPath != "" {
+		if dryRun || !runOnce {
+			log.Fatalln("`-apply-plan` can only be used with `-run-once` and without `-dry-run`")
+		}
+		appliedPlan, err = vault.ReadPlanFile(applyPlanPath)
+		if err != nil {
+			log.WithError(err).Fatal("failed to read plan file")
+		}
+	}
+
+	// dry runs and plans always compare against the instances and therefore neither read nor update the cache
+	var cache *vault.ApplyCache
+	if cachePath != "" && !dryRun && appliedPlan == nil {
+		cache, err = vault.ReadApplyCache(cachePath, version)
+		if err != nil {
+			log.WithError(err).Fatal("failed to read cache")
+		}
+	}
+
+	if configCheck {
+		if err := checkConfig(configPaths, policyDirs); err != nil {
+			log.WithError(err).Error("configuration failed validation")
+			logFile.Close()
+			os.Exit(1)
+		}
+		log.Info("configuration is valid")
+		return
+	}
+
+	if auditHashPath != "" {
+		if len(includeInstances) != 1 {
+			log.Fatalln("`-audit-hash` requires a single `-instance`")
+		}
+		if err := auditHash(configPaths, includeInstances[0], auditHashPath, auditHashInput, threadPoolSize); err != nil {
+			log.WithError(err).Error("failed to hash audit input")
+			logFile.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	switch output {
+	case "text":
+	case "json":
+		if !dryRun {
+			log.Fatalln("`-output=json` can only be used with `-dry-run`")
+		}
+		// keep stdout reserved for the json document
+		if logFile != nil {
+			log.SetOutput(io.MultiWriter(os.Stderr, logFile))
+		} else {
+			log.SetOutput(os.Stderr)
+		}
+		vault.EnablePlan()
+	default:
+		log.Fatalf("unsupported output format `%s`", output)
+	}
+
 	var sleepDuration time.Duration
 	if !runOnce {
 		// configure sleep duration
@@ -107,51 +348,357 @@ func main() {
 		go func() {
 			http.ListenAndServe(fmt.Sprintf(":%s", port), nil)
 		}()
+
+		// readiness reflects the last reconcile so that a deployment failing to reconcile is reported as unready
+		if healthAddress != "" {
+			go func() {
+				err := http.ListenAndServe(healthAddress, utils.HealthHandler())
+				log.WithError(err).WithField("address", healthAddress).Fatal("[Health] failed to serve health probes")
+			}()
+		}
+	} else if healthAddress != "" {
+		log.Fatalln("`-health-address` requires `-run-once=false`")
 	}
 
+	// a termination signal, e.g. on pod eviction, stops further top-level configurations from being applied
+	// so that the process exits once the in-flight ones are applied rather than in the middle of writes
+	shutdownCtx, shutdown := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		log.WithFields(log.Fields{
+			"signal":       sig.String(),
+			"grace_period": shutdownGracePeriod,
+		}).Warn("[Shutdown] received termination signal, completing in-flight operations")
+		utils.RecordTermination()
+		shutdown()
+		time.Sleep(shutdownGracePeriod)
+		log.Error("[Shutdown] grace period exceeded, exiting with operations in flight")
+		log.Exit(1)
+	}()
+
+	// dry runs do not change any instance and are not prevented by the lock
+	var lock *vault.Lock
+	var lockM sync.Mutex
+	releaseLock := func() {
+		lockM.Lock()
+		defer lockM.Unlock()
+		if lock == nil {
+			return
+		}
+		if err := lock.Release(); err != nil {
+			log.WithError(err).Error("[Lock] failed to release lock")
+		}
+		lock = nil
+	}
+	// every exit from here on, including log.Fatal, exits through log.Exit so that the lock is released
+	// rather than blocking the following runs until it expires
+	log.RegisterExitHandler(func() {
+		releaseLock()
+		logFile.Close()
+	})
+
 	for {
-		cfg, err := getConfig()
+		// in-flight operations are not interrupted once the deadline is exceeded or on termination,
+		// only new work is not started
+		reconcileStart := time.Now()
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if maxRuntime > 0 {
+			ctx, cancel = context.WithTimeout(shutdownCtx, maxRuntime)
+		} else {
+			ctx, cancel = context.WithCancel(shutdownCtx)
+		}
+
+		var cfg config
+		var err error
+		if len(configPaths) > 0 {
+			cfg, err = readConfigFiles(configPaths)
+		} else {
+			cfg, err = getConfig()
+		}
 		if err != nil {
 			log.WithError(err).Fatal("failed to parse config")
 		}
+		if err := addPolicyDirs(cfg, policyDirs); err != nil {
+			log.WithError(err).Fatal("failed to add policies of `-policy-dir`")
+		}
 
-		// initialize vault clients and gather list of instance addresses for reconciliation
+		// initialize vault clients and gather list of instance keys for reconciliation
 		instanceAddresses := initInstances(cfg, threadPoolSize)
 
+		// instances that failed client initialization are excluded from instanceAddresses
+		for _, address := range vault.InvalidInstances() {
+			if strict {
+				vault.Logger(address, "").Fatal("[Strict] failed to initialize instance")
+			}
+			if !runOnce {
+				utils.RecordMetrics(address, 1, 0)
+			}
+		}
+
 		// remove disabled toplevels
 		if disabled, _ := os.LookupEnv("DISABLE_IDENTITY"); disabled == "true" {
 			delete(cfg, "vault_entities")
 			delete(cfg, "vault_groups")
 		}
 
-		topLevelConfigs := []TopLevelConfig{}
+		// keys without a registered top-level configuration, e.g. typos, would otherwise never be applied
+		if unknown := unknownKeys(cfg); len(unknown) > 0 {
+			if strictKeys {
+				log.WithField("keys", unknown).Fatal("[Strict] configuration contains unknown top-level keys")
+			}
+			log.WithField("keys", unknown).Warn("skipping unknown top-level keys of the configuration")
+			for _, key := range unknown {
+				delete(cfg, key)
+			}
+		}
 
+		names := []string{}
 		for key := range cfg {
-			c := TopLevelConfig{key, resolveConfigPriority(key)}
-			topLevelConfigs = append(topLevelConfigs, c)
+			if len(onlyConfigs) > 0 && !onlyConfigs[key] {
+				continue
+			}
+			names = append(names, key)
+		}
+
+		// order configs so that each is applied after the configs it depends on
+		topLevelConfigs, err := toplevel.Order(names)
+		if err != nil {
+			log.WithError(err).Fatal("failed to order top-level configurations")
+		}
+
+		// Marshal the contents of each object back into bytes so that it can be
+		// unmarshaled into a specific type in the application.
+		// every configuration is validated before any is applied to avoid partially applied changes
+		configBytes := make(map[string][]byte)
+		// configurations with content they reference resolved, e.g. files of policy rules, which are
+		// compared by the cache so that changing a referenced file changes the configuration
+		resolvedBytes := make(map[string][]byte)
+		validationErrs := []error{}
+		for _, name := range topLevelConfigs {
+			dataBytes, err := yaml.Marshal(cfg[name])
+			if err != nil {
+				log.WithField("name", name).Fatal("failed to remarshal configuration")
+			}
+			configBytes[name] = dataBytes
+			if err := toplevel.Validate(name, dataBytes); err != nil {
+				validationErrs = append(validationErrs, err)
+				continue
+			}
+			resolved, err := toplevel.Resolve(name, dataBytes)
+			if err != nil {
+				validationErrs = append(validationErrs, err)
+				continue
+			}
+			resolvedBytes[name] = resolved
 		}
+		if err := utils.JoinErrors(validationErrs); err != nil {
+			log.WithError(err).Fatal("configuration failed validation")
+		}
+
+		// the lock is acquired once the configuration is validated and refreshed with each reconcile
+		if lockPath != "" && !dryRun {
+			lockM.Lock()
+			acquire := lock == nil
+			if acquire {
+				lock, err = vault.AcquireLock(lockPath, lockTTL)
+			} else {
+				err = lock.Refresh()
+			}
+			lockM.Unlock()
+			if err != nil && acquire {
+				log.WithError(err).Fatal("[Lock] failed to acquire lock, another run may be in progress")
+			} else if err != nil {
+				log.WithError(err).Fatal("[Lock] failed to refresh lock")
+			}
+		}
+
+		// toplevels with pending changes per instance when detecting drift
+		var driftM sync.Mutex
+		drifted := make(map[string][]string)
 
-		// sort configs by priority
-		sort.Sort(ByPriority(topLevelConfigs))
+		// plans of each toplevel per instance when writing a plan file
+		var planFileM sync.Mutex
+		planFile := vault.NewPlanFile()
+
+		// changes applied to, or planned for, each instance when summarizing the reconcile
+		var summaryM sync.Mutex
+		summaryPlans := make(map[string]*vault.Plan)
 
 		// perform reconcile process per instance
-		for _, address := range instanceAddresses {
+		// top-level configurations are always applied to an instance serially in order of dependencies
+		// an instance is skipped by all remaining configurations once it has been marked invalid
+		reconcile := func(address string) {
 			start := time.Now()
 			status := 0
 
-			for _, config := range topLevelConfigs {
-				// Marshal the contents of this object back into bytes so that it can be
-				// unmarshaled into a specific type in the application.
-				dataBytes, err := yaml.Marshal(cfg[config.Name])
+			// the instance may have been sealed or lost its active node since its client was initialized
+			if err := vault.CheckHealth(address); err != nil {
+				vault.Logger(address, "").WithError(err).Error("[Vault System] instance is not available for reconciliation")
+				vault.RecordFailure(address, "", "health check", "", err)
+				vault.AddInvalid(address)
+			}
+			if !vault.IsInvalid(address) {
+				if err := vault.CheckSystemLeaseTTLs(address); err != nil {
+					vault.Logger(address, "").WithError(err).Error("[Vault System] failed to check system lease ttls")
+					vault.RecordFailure(address, "", "system lease ttls", "", err)
+				}
+			}
+			// a dry run against an instance that only contains the objects vault creates when initialized
+			// plans its full provisioning, which is reported as such rather than as a large drift
+			bootstrap := false
+			if dryRun && !vault.IsInvalid(address) {
+				unconfigured, err := vault.IsUnconfigured(address)
 				if err != nil {
-					log.WithField("name", config.Name).Fatal("failed to remarshal configuration")
+					vault.Logger(address, "").WithError(err).Warn("[Bootstrap] failed to determine if instance is unconfigured")
+				} else if unconfigured {
+					bootstrap = true
+					vault.Logger(address, "").Info("[Dry Run] [Bootstrap] instance is unconfigured, planning its full provisioning")
+				}
+			}
+			provisioning := vault.NewPlan()
+
+			// no further top-level configurations are applied once the deadline is exceeded or on termination
+			stopped := func(name string) bool {
+				if ctx.Err() == nil {
+					return false
+				}
+				msg := "[Deadline] `-max-runtime` exceeded, skipping remaining reconciliation"
+				if shutdownCtx.Err() != nil {
+					msg = "[Shutdown] terminating, skipping remaining reconciliation"
+				}
+				vault.Logger(address, name).WithError(ctx.Err()).Error(msg)
+				vault.RecordFailure(address, name, "reconcile", "", ctx.Err())
+				vault.AddInvalid(address)
+				return true
+			}
+			poolSizeOf := func(name string) int {
+				if size, ok := poolSizes[name]; ok {
+					return size
+				}
+				return threadPoolSize
+			}
+			// record records the outcome of applying a top-level configuration, which is only completed
+			// once none of its changes are deferred
+			record := func(name string, plan *vault.Plan, err error, completed bool) {
+				if err == nil && (summaryWebhook != "" || summaryEvent) {
+					summaryM.Lock()
+					if summaryPlans[address] == nil {
+						summaryPlans[address] = vault.NewPlan()
+					}
+					summaryPlans[address].Merge(plan)
+					summaryM.Unlock()
+				}
+				succeeded := err == nil && !vault.IsInvalid(address) && !vault.HasFailures(address, name)
+				if cache != nil {
+					if !succeeded {
+						cache.Forget(address, name)
+					} else if completed && !vault.HasUnsupported(address, name) && toplevel.Cacheable(name) {
+						// skipped entries are applied by the first reconcile after the instance is upgraded
+						cache.Record(address, name, resolvedBytes[name], prune)
+					}
+				}
+				if succeeded && completed {
+					vault.RecordCompleted(address, name)
 				}
-				err = toplevel.Apply(config.Name, address, dataBytes, dryRun, threadPoolSize)
 				if err != nil {
 					fmt.Println(fmt.Sprintf("SKIPPING REMAINING RECONCILIATION FOR %s", address))
-					status = 1
+				}
+			}
+
+			// with -prune, deletes are deferred until all top-level configurations have been written and then applied
+			// in reverse order of dependencies, so that objects are only deleted once no object written refers to them,
+			// e.g. a secrets engine still referenced by a policy. Dry runs plan deletes along with writes
+			deferDeletes := prune && !dryRun
+			deferred := []string{}
+			for _, name := range topLevelConfigs {
+				if stopped(name) {
 					break
 				}
+				poolSize := poolSizeOf(name)
+				if cache != nil && !full && !vault.IsInvalid(address) && toplevel.Cacheable(name) &&
+					cache.Unchanged(address, name, resolvedBytes[name], prune) {
+					vault.Logger(address, name).Debug("[Cache] configuration is unchanged since last applied, skipping")
+					vault.RecordCompleted(address, name)
+					continue
+				}
+				// changes are only applied if they are the changes of the plan file, which would otherwise
+				// differ when either the configuration or the instance changed since the plan file was written
+				if appliedPlan != nil && !vault.IsInvalid(address) {
+					current, err := toplevel.Apply(name, address, configBytes[name], true, prune, poolSize)
+					if err == nil {
+						err = appliedPlan.Check(address, name, current, resolvedBytes[name])
+						if err != nil {
+							vault.Logger(address, name).WithError(err).Error("[Plan] changes differ from the plan file")
+							vault.RecordFailure(address, name, "apply plan", "", err)
+							vault.AddInvalid(address)
+						}
+					}
+					if err != nil {
+						fmt.Println(fmt.Sprintf("SKIPPING REMAINING RECONCILIATION FOR %s", address))
+						continue
+					}
+					if current.Empty() {
+						vault.RecordCompleted(address, name)
+						continue
+					}
+				}
+				if deferDeletes {
+					vault.DeferDeletes(address)
+				}
+				plan, err := toplevel.Apply(name, address, configBytes[name], dryRun, prune && !deferDeletes, poolSize)
+				hasDeferred := false
+				if deferDeletes {
+					hasDeferred = vault.StopDeferringDeletes(address) > 0 && err == nil
+				}
+				if hasDeferred {
+					deferred = append(deferred, name)
+				}
+				if dryRun {
+					vault.RecordPlan(address, plan)
+				}
+				if (planFilePath != "" || previewAddress != "") && err == nil && !vault.IsInvalid(address) {
+					planFileM.Lock()
+					planFile.Add(address, name, plan, resolvedBytes[name])
+					planFileM.Unlock()
+				}
+				// instances skipped due to an earlier failure have nothing to summarize
+				if dryRun && err == nil && !vault.IsInvalid(address) {
+					provisioning.Merge(plan)
+					vault.Logger(address, name).Infof("[Dry Run] %s", plan.Summary())
+					if detectDrift && !plan.Empty() {
+						driftM.Lock()
+						drifted[address] = append(drifted[address], name)
+						driftM.Unlock()
+					}
+				}
+				record(name, plan, err, !hasDeferred)
+			}
+			for i := len(deferred) - 1; i >= 0; i-- {
+				name := deferred[i]
+				if stopped(name) {
+					break
+				}
+				vault.Logger(address, name).Debug("applying deferred deletes")
+				vault.ApplyDeferredDeletes(address)
+				plan, err := toplevel.Apply(name, address, configBytes[name], false, true, poolSizeOf(name))
+				vault.DeferredDeletesApplied(address)
+				record(name, plan, err, true)
+			}
+			if bootstrap && !vault.IsInvalid(address) {
+				vault.Logger(address, "").WithField("created", len(provisioning.Created)).Info(
+					"[Dry Run] [Bootstrap] full provisioning of instance planned")
+			}
+			if vault.IsInvalid(address) {
+				status = 1
+			}
+
+			if status != 0 && strict {
+				vault.LogFailures()
+				vault.LogProgress(instanceAddresses)
+				vault.Logger(address, "").Fatal("[Strict] failed to reconcile instance")
 			}
 
 			if !runOnce {
@@ -159,10 +706,92 @@ func main() {
 			}
 		}
 
+		if parallelInstances {
+			bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+			for _, address := range instanceAddresses {
+				bwg.Add(1)
+
+				go func(address string) {
+					defer bwg.Done()
+					reconcile(address)
+				}(address)
+			}
+			bwg.Wait()
+		} else {
+			for _, address := range instanceAddresses {
+				reconcile(address)
+			}
+		}
+
+		if planFilePath != "" {
+			if err := vault.WritePlanFile(planFilePath, planFile); err != nil {
+				log.WithError(err).Fatal("failed to write plan file")
+			}
+			log.WithField("path", planFilePath).Info("[Plan] plan file is successfully written")
+		}
+
+		if previewAddress != "" {
+			servePreview(shutdownCtx, previewAddress, planFile, vault.Failures(), reconcileStart)
+		}
+
+		if cache != nil {
+			if err := vault.WriteApplyCache(cachePath, cache); err != nil {
+				log.WithError(err).Error("[Cache] failed to write cache")
+			}
+		}
+
+		if output == "json" {
+			if err := vault.WritePlan(os.Stdout); err != nil {
+				log.WithError(err).Error("failed to write dry-run plan")
+			}
+		}
+
+		// failures are otherwise buried within the logs of the reconcile
+		vault.LogFailures()
+		if len(vault.InvalidInstances()) > 0 || len(vault.Failures()) > 0 {
+			vault.LogProgress(instanceAddresses)
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			log.WithField("max_runtime", maxRuntime).Error("[Deadline] deadline exceeded, reconcile was not completed")
+			utils.RecordDeadlineExceeded()
+		}
+		cancel()
+
+		// summaries are informational, failing to deliver them never fails the reconcile
+		if summaryWebhook != "" || summaryEvent {
+			summary := vault.NewSummary(reconcileStart, time.Since(reconcileStart), dryRun, summaryPlans,
+				vault.Failures(), vault.InvalidInstances())
+			if summaryWebhook != "" {
+				if err := vault.SendSummary(summaryWebhook, summary); err != nil {
+					log.WithError(err).Warn("[Summary] failed to post summary to webhook")
+				}
+			}
+			if summaryEvent && vault.InCluster() {
+				if err := vault.EmitSummaryEvent(summary); err != nil {
+					log.WithError(err).Warn("[Summary] failed to emit summary event")
+				}
+			}
+		}
+
 		if runOnce {
+			releaseLock()
+			if failed := vault.InvalidInstances(); len(failed) > 0 || len(vault.Failures()) > 0 {
+				log.WithField("instances", failed).Error("reconcile failed for one or more instances")
+				log.Exit(1)
+			}
+			if len(drifted) > 0 {
+				log.WithField("instances", drifted).Error("[Drift] one or more instances differ from the configuration")
+				log.Exit(2)
+			}
 			return
 		} else {
-			time.Sleep(sleepDuration)
+			select {
+			case <-shutdownCtx.Done():
+				log.Info("[Shutdown] in-flight operations completed, exiting")
+				releaseLock()
+				return
+			case <-time.After(sleepDuration):
+			}
 		}
 	}
 }
@@ -214,9 +843,80 @@ func getConfig() (config, error) {
 	return response, nil
 }
 
+// parseThreadPoolSizes parses a comma separated list of `<toplevel>=<size>` pairs
+// each toplevel must be registered and each size must be positive
+func parseThreadPoolSizes(value string) (map[string]int, error) {
+	sizes := make(map[string]int)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New(fmt.Sprintf("`%s` must be formatted as <top-level configuration>=<size>", pair))
+		}
+		name := strings.TrimSpace(parts[0])
+		if !toplevel.IsRegistered(name) {
+			return nil, errors.New(fmt.Sprintf("unknown top-level configuration `%s`", name))
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || size < 1 {
+			return nil, errors.New(fmt.Sprintf("thread pool size of `%s` must be a positive integer", name))
+		}
+		sizes[name] = size
+	}
+	return sizes, nil
+}
+
+// auditHash prints the hash the audit device at path of an instance computes for input
+// used to verify that an audit log contains a known value, as audit logs only contain hashes of sensitive values
+func auditHash(configPaths []string, instance, path, input string, threadPoolSize int) error {
+	var cfg config
+	var err error
+	if len(configPaths) > 0 {
+		cfg, err = readConfigFiles(configPaths)
+	} else {
+		cfg, err = getConfig()
+	}
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, key := range initInstances(cfg, threadPoolSize) {
+		found = found || key == instance
+	}
+	if !found {
+		return errors.New(fmt.Sprintf("%s is not an instance key, use `<address>|<namespace>` for namespaces", instance))
+	}
+	if vault.IsInvalid(instance) {
+		return errors.New(fmt.Sprintf("instance %s could not be initialized", instance))
+	}
+	hash, err := vault.AuditHash(instance, path, input)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hash)
+	return nil
+}
+
+// stringList is a flag that may be passed multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	if value = strings.TrimSpace(value); value == "" {
+		return errors.New("must not be empty")
+	}
+	*l = append(*l, value)
+	return nil
+}
+
 // gathers instances referenced across all applicable file definitions and initializes the clients
 // clients are set as private global witihn client.go
-// return is list of strings containing addresses of vault instances
+// return is list of strings containing keys of vault instances (address and optional namespace)
 func initInstances(cfg config, threadPoolSize int) []string {
 	const INSTANCE_KEY = "vault_instances"
 	dataBytes, err := yaml.Marshal(cfg[INSTANCE_KEY])
@@ -227,28 +927,3 @@ func initInstances(cfg config, threadPoolSize int) []string {
 	delete(cfg, INSTANCE_KEY)
 	return vault.GetInstances(dataBytes, threadPoolSize)
 }
-
-func resolveConfigPriority(s string) int {
-	var priority int
-	switch s {
-	case "vault_instances":
-		priority = 1
-	case "vault_policies":
-		priority = 2
-	case "vault_audit_backends":
-		priority = 3
-	case "vault_secret_engines":
-		priority = 4
-	case "vault_auth_backends":
-		priority = 5
-	case "vault_roles":
-		priority = 6
-	case "vault_entities":
-		priority = 7
-	case "vault_groups":
-		priority = 8
-	default:
-		priority = 0
-	}
-	return priority
-}
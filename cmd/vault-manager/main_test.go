@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/app-sre/vault-manager/toplevel"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseThreadPoolSizes(t *testing.T) {
+	table := []struct {
+		description string
+		value       string
+		expected    map[string]int
+		expectErr   bool
+	}{
+		{
+			description: "empty",
+			value:       "",
+			expected:    map[string]int{},
+		},
+		{
+			description: "overrides",
+			value:       "vault_policies=20, vault_secret_engines=4",
+			expected:    map[string]int{"vault_policies": 20, "vault_secret_engines": 4},
+		},
+		{
+			description: "unknown top-level configuration",
+			value:       "vault_unknown=4",
+			expectErr:   true,
+		},
+		{
+			description: "zero size",
+			value:       "vault_policies=0",
+			expectErr:   true,
+		},
+		{
+			description: "missing size",
+			value:       "vault_policies",
+			expectErr:   true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			sizes, err := parseThreadPoolSizes(tt.value)
+			if tt.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, sizes)
+		})
+	}
+}
+
+// every registered configuration can be applied within a single configuration
+func TestOrderRegisteredConfigurations(t *testing.T) {
+	names := toplevel.Names()
+	require.NotEmpty(t, names)
+	ordered, err := toplevel.Order(names)
+	require.NoError(t, err)
+	require.ElementsMatch(t, names, ordered)
+}
+
+// values read from secrets in vault are not part of the cached configuration
+func TestSecretReadersNotCached(t *testing.T) {
+	for _, name := range []string{"vault_auth_backends", "vault_databases", "vault_managed_keys", "vault_mfa"} {
+		require.False(t, toplevel.Cacheable(name), name)
+	}
+	require.True(t, toplevel.Cacheable("vault_policies"))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const policiesKey = "vault_policies"
+
+// policyDir is a directory of acl policies passed with `-policy-dir` that are written to a single instance
+type policyDir struct {
+	address string
+	path    string
+}
+
+// parsePolicyDirs parses the values of `-policy-dir`, each of the form `<instance address>=<directory>`
+func parsePolicyDirs(values []string) ([]policyDir, error) {
+	dirs := []policyDir{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, errors.New(fmt.Sprintf("`%s` must be of the form <instance address>=<directory>", value))
+		}
+		dirs = append(dirs, policyDir{address: strings.TrimSpace(parts[0]), path: strings.TrimSpace(parts[1])})
+	}
+	return dirs, nil
+}
+
+// readPolicyDir synthesizes a `vault_policies` entry for each `.hcl` file within a directory and its
+// subdirectories, named after the basename of the file. Files sharing a basename are an error
+func readPolicyDir(d policyDir) ([]interface{}, error) {
+	files := make(map[string]string)
+	err := filepath.Walk(d.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".hcl" {
+			return nil
+		}
+		name := strings.TrimSuffix(info.Name(), ".hcl")
+		if previous, dup := files[name]; dup {
+			return errors.New(fmt.Sprintf("policy `%s` is defined by both `%s` and `%s`", name, previous, path))
+		}
+		files[name] = path
+		return nil
+	})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to read policy directory `%s`: %v", d.path, err))
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		rules, err := ioutil.ReadFile(files[name])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("failed to read policy `%s`: %v", files[name], err))
+		}
+		entries = append(entries, map[interface{}]interface{}{
+			"name":     name,
+			"type":     "acl",
+			"rules":    string(rules),
+			"instance": map[interface{}]interface{}{"address": d.address},
+		})
+	}
+	return entries, nil
+}
+
+// addPolicyDirs adds the policies of directories to the policies of a configuration
+// a policy defined both within a directory and the configuration, or within two directories, is an error
+func addPolicyDirs(cfg config, dirs []policyDir) error {
+	if len(dirs) == 0 {
+		return nil
+	}
+	files := []configFile{{name: "configuration", cfg: config{policiesKey: cfg[policiesKey]}}}
+	for _, d := range dirs {
+		entries, err := readPolicyDir(d)
+		if err != nil {
+			return err
+		}
+		files = append(files, configFile{name: d.path, cfg: config{policiesKey: entries}})
+	}
+	merged, err := mergeConfigs(files)
+	if err != nil {
+		return err
+	}
+	if merged[policiesKey] != nil {
+		cfg[policiesKey] = merged[policiesKey]
+	}
+	return nil
+}
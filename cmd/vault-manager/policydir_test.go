@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func writePolicyFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestParsePolicyDirs(t *testing.T) {
+	dirs, err := parsePolicyDirs([]string{"https://vault.test=policies/"})
+	require.NoError(t, err)
+	require.Equal(t, []policyDir{{address: "https://vault.test", path: "policies/"}}, dirs)
+
+	_, err = parsePolicyDirs([]string{"policies/"})
+	require.Error(t, err)
+	_, err = parsePolicyDirs([]string{"https://vault.test="})
+	require.Error(t, err)
+}
+
+func TestReadPolicyDir(t *testing.T) {
+	dir := writePolicyFiles(t, map[string]string{
+		"admin.hcl":       `path "*" { capabilities = ["sudo"] }`,
+		"teams/dev.hcl":   `path "dev/*" { capabilities = ["read"] }`,
+		"README.md":       "policies of the instance",
+		"teams/notes.txt": "ignored",
+	})
+
+	entries, err := readPolicyDir(policyDir{address: "https://vault.test", path: dir})
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{
+		map[interface{}]interface{}{
+			"name":     "admin",
+			"type":     "acl",
+			"rules":    `path "*" { capabilities = ["sudo"] }`,
+			"instance": map[interface{}]interface{}{"address": "https://vault.test"},
+		},
+		map[interface{}]interface{}{
+			"name":     "dev",
+			"type":     "acl",
+			"rules":    `path "dev/*" { capabilities = ["read"] }`,
+			"instance": map[interface{}]interface{}{"address": "https://vault.test"},
+		},
+	}, entries)
+
+	dup := writePolicyFiles(t, map[string]string{"a/dev.hcl": "", "b/dev.hcl": ""})
+	_, err = readPolicyDir(policyDir{address: "https://vault.test", path: dup})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "policy `dev` is defined by both")
+
+	_, err = readPolicyDir(policyDir{address: "https://vault.test", path: filepath.Join(dir, "missing")})
+	require.Error(t, err)
+}
+
+func TestAddPolicyDirs(t *testing.T) {
+	dir := writePolicyFiles(t, map[string]string{"dev.hcl": `path "dev/*" { capabilities = ["read"] }`})
+
+	var cfg config
+	require.NoError(t, yaml.Unmarshal([]byte(
+		"vault_policies:\n- name: admin\n  instance:\n    address: https://vault.test\n"), &cfg))
+	require.NoError(t, addPolicyDirs(cfg, []policyDir{{address: "https://vault.test", path: dir}}))
+	require.Len(t, cfg[policiesKey], 2)
+	require.NoError(t, validateConfig(cfg))
+
+	require.NoError(t, addPolicyDirs(config{}, []policyDir{{address: "https://other.test", path: dir}}),
+		"configurations without policies")
+
+	var colliding config
+	require.NoError(t, yaml.Unmarshal([]byte(
+		"vault_policies:\n- name: dev\n  instance:\n    address: https://vault.test\n"), &colliding))
+	err := addPolicyDirs(colliding, []policyDir{{address: "https://vault.test", path: dir}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "duplicate entry `https://vault.test|dev` of `vault_policies`")
+
+	require.NoError(t, addPolicyDirs(colliding, []policyDir{{address: "https://other.test", path: dir}}),
+		"policies of the same name on other instances do not collide")
+}
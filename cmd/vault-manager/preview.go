@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/app-sre/vault-manager/pkg/vault"
+	log "github.com/sirupsen/logrus"
+)
+
+// previewInstance is an instance of the plan rendered by the preview server
+type previewInstance struct {
+	Address   string
+	Created   int
+	Updated   int
+	Deleted   int
+	Toplevels []previewToplevel
+	Failures  []vault.FailureSummary
+}
+
+// previewToplevel is the plan of a top-level configuration for an instance
+type previewToplevel struct {
+	Name    string
+	Plan    *vault.Plan
+	Changes int
+}
+
+// previewLine is a single line of a diff along with its class, i.e. added, removed or unchanged
+type previewLine struct {
+	Class string
+	Text  string
+}
+
+var previewFuncs = template.FuncMap{
+	"diffLines": diffLines,
+	"join":      strings.Join,
+}
+
+var previewTemplate = template.Must(template.New("preview").Funcs(previewFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>vault-manager plan</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+details { margin: 0.5em 0 0.5em 1em; }
+summary { cursor: pointer; }
+table { border-collapse: collapse; margin: 0.5em 0; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; vertical-align: top; }
+pre { background: #f6f8fa; padding: 0.5em; margin: 0; }
+.created { color: #22863a; }
+.updated { color: #b08800; }
+.deleted, .failed { color: #cb2431; }
+.added { background: #e6ffed; }
+.removed { background: #ffeef0; }
+</style>
+</head>
+<body>
+<h1>vault-manager plan</h1>
+<p>Generated {{ .Generated.Format "2006-01-02 15:04:05 MST" }} by a dry run, nothing has been applied.</p>
+{{ range .Instances }}
+<details{{ if or .Failures (or .Created .Updated .Deleted) }} open{{ end }}>
+<summary><strong>{{ .Address }}</strong>:
+<span class="created">{{ .Created }} to create</span>,
+<span class="updated">{{ .Updated }} to update</span>,
+<span class="deleted">{{ .Deleted }} to delete</span>{{ if .Failures }},
+<span class="failed">{{ len .Failures }} failures</span>{{ end }}</summary>
+{{ if .Failures }}
+<table>
+<tr><th>Top-level configuration</th><th>Operation</th><th>Key</th><th>Error</th></tr>
+{{ range .Failures }}<tr class="failed"><td>{{ .Toplevel }}</td><td>{{ .Operation }}</td><td>{{ .Key }}</td><td>{{ .Error }}</td></tr>
+{{ end }}</table>
+{{ end }}
+{{ range .Toplevels }}
+<details{{ if .Changes }} open{{ end }}>
+<summary>{{ .Name }}: {{ .Plan.Summary }}</summary>
+{{ if .Changes }}
+<table>
+<tr><th>Change</th><th>Type</th><th>Name</th><th>Details</th></tr>
+{{ range .Plan.Created }}<tr><td class="created">create</td><td>{{ .Type }}</td><td>{{ .Name }}</td><td>{{ template "diff" . }}</td></tr>
+{{ end }}{{ range .Plan.Updated }}<tr><td class="updated">update</td><td>{{ .Type }}</td><td>{{ .Name }}</td><td>{{ join .ChangedFields ", " }}{{ template "diff" . }}</td></tr>
+{{ end }}{{ range .Plan.Deleted }}<tr><td class="deleted">delete</td><td>{{ .Type }}</td><td>{{ .Name }}</td><td></td></tr>
+{{ end }}</table>
+{{ end }}
+</details>
+{{ end }}
+</details>
+{{ else }}
+<p>No instances were planned.</p>
+{{ end }}
+</body>
+</html>
+{{ define "diff" }}{{ if .Diff }}<details><summary>diff</summary><pre>{{ range diffLines .Diff }}<span class="{{ .Class }}">{{ .Text }}</span>
+{{ end }}</pre></details>{{ end }}{{ end }}
+`))
+
+// renderPreview renders the plan of a dry run along with its failures as an html page
+// instances and top-level configurations without changes are collapsed
+func renderPreview(w io.Writer, planFile *vault.PlanFile, failures []vault.Failure, generated time.Time) error {
+	failuresByInstance := make(map[string][]vault.FailureSummary)
+	for _, f := range failures {
+		msg := ""
+		if f.Err != nil {
+			msg = f.Err.Error()
+		}
+		failuresByInstance[f.Instance] = append(failuresByInstance[f.Instance], vault.FailureSummary{
+			Instance:  f.Instance,
+			Toplevel:  f.Toplevel,
+			Operation: f.Operation,
+			Key:       f.Key,
+			Error:     msg,
+		})
+	}
+
+	addresses := []string{}
+	for address := range planFile.Instances {
+		addresses = append(addresses, address)
+	}
+	for address := range failuresByInstance {
+		if _, ok := planFile.Instances[address]; !ok {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+
+	instances := make([]previewInstance, 0, len(addresses))
+	for _, address := range addresses {
+		instance := previewInstance{Address: address, Failures: failuresByInstance[address]}
+		names := []string{}
+		for name := range planFile.Instances[address] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			plan := planFile.Instances[address][name]
+			instance.Created += len(plan.Created)
+			instance.Updated += len(plan.Updated)
+			instance.Deleted += len(plan.Deleted)
+			instance.Toplevels = append(instance.Toplevels, previewToplevel{
+				Name:    name,
+				Plan:    plan,
+				Changes: len(plan.Created) + len(plan.Updated) + len(plan.Deleted),
+			})
+		}
+		instances = append(instances, instance)
+	}
+
+	return previewTemplate.Execute(w, struct {
+		Generated time.Time
+		Instances []previewInstance
+	}{generated, instances})
+}
+
+// previewHandler serves the rendered plan on /, the page is rendered once as the plan does not change
+func previewHandler(planFile *vault.PlanFile, failures []vault.Failure, generated time.Time) (http.Handler, error) {
+	var page bytes.Buffer
+	if err := renderPreview(&page, planFile, failures, generated); err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page.Bytes())
+	})
+	return mux, nil
+}
+
+// servePreview serves the plan of a dry run until a termination signal is received
+func servePreview(ctx context.Context, address string, planFile *vault.PlanFile, failures []vault.Failure, generated time.Time) {
+	handler, err := previewHandler(planFile, failures, generated)
+	if err != nil {
+		log.WithError(err).Fatal("[Preview] failed to render plan")
+	}
+	server := &http.Server{Addr: address, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	log.WithField("address", address).Info("[Preview] serving planned changes, interrupt to exit")
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.WithError(err).WithField("address", address).Fatal("[Preview] failed to serve planned changes")
+	}
+}
+
+// diffLines splits a unified diff into lines classified by whether they were added or removed
+func diffLines(diff string) []previewLine {
+	lines := []previewLine{}
+	for _, line := range strings.Split(strings.TrimRight(diff, "\n"), "\n") {
+		class := ""
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"):
+			class = "added"
+		case strings.HasPrefix(line, "-"):
+			class = "removed"
+		}
+		lines = append(lines, previewLine{Class: class, Text: line})
+	}
+	return lines
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderPreview(t *testing.T) {
+	planFile := vault.NewPlanFile()
+	planFile.Add("https://a.test", "vault_policies", &vault.Plan{
+		Created: []vault.Change{{Type: "policy", Name: "admin", Diff: "--- admin\n+++ admin\n+path \"secret/*\" {}\n"}},
+		Updated: []vault.Change{{Type: "policy", Name: "<ci>", ChangedFields: []string{"rules"}}},
+		Deleted: []vault.Change{{Type: "policy", Name: "old"}},
+	}, nil)
+	planFile.Add("https://a.test", "vault_roles", vault.NewPlan(), nil)
+	failures := []vault.Failure{{Instance: "https://b.test", Toplevel: "vault_roles", Operation: "read",
+		Err: errors.New("permission denied")}}
+
+	var page bytes.Buffer
+	require.NoError(t, renderPreview(&page, planFile, failures, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)))
+	html := page.String()
+	require.Contains(t, html, "<strong>https://a.test</strong>")
+	require.Contains(t, html, "<strong>https://b.test</strong>", "instances that only failed are rendered")
+	require.Contains(t, html, "permission denied")
+	require.Contains(t, html, "<summary>vault_policies: 1 to write, 1 to delete, 1 to update</summary>")
+	require.Contains(t, html, "<summary>vault_roles: 0 to write, 0 to delete, 0 to update</summary>")
+	require.Contains(t, html, `<span class="added">&#43;path &#34;secret/*&#34; {}</span>`)
+	require.Contains(t, html, "&lt;ci&gt;", "names are escaped")
+	require.NotContains(t, html, "<ci>")
+}
+
+func TestPreviewHandler(t *testing.T) {
+	handler, err := previewHandler(vault.NewPlanFile(), nil, time.Now())
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "No instances were planned.")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/favicon.ico", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestDiffLines(t *testing.T) {
+	require.Equal(t, []previewLine{
+		{Class: "", Text: "--- admin"},
+		{Class: "", Text: "+++ admin"},
+		{Class: "removed", Text: "-old"},
+		{Class: "added", Text: "+new"},
+		{Class: "", Text: " same"},
+	}, diffLines("--- admin\n+++ admin\n-old\n+new\n same\n"))
+}
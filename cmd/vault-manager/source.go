@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// checksumSuffix separates a source from the sha256 checksum its content must match,
+// e.g. `https://config.example.com/vault.yaml#sha256=<hex>`
+const checksumSuffix = "#sha256="
+
+// sourceTimeout bounds fetching a configuration document from a remote source
+const sourceTimeout = time.Minute
+
+// readSource reads a configuration document from a local path or a `file://`, `http(s)://` or `s3://` url
+// the content is verified against the checksum appended to the source, if any, which plain http sources require
+func readSource(source string) ([]byte, error) {
+	location, checksum := splitChecksum(source)
+
+	var data []byte
+	var err error
+	switch {
+	// the configuration may contain secret data, which plain http exposes to anyone able to alter the response
+	case strings.HasPrefix(location, "http://") && checksum == "":
+		err = errors.New("plain http sources must pin the checksum of their content, use https or append #sha256=<hex>")
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		data, err = fetch(location, nil)
+	case strings.HasPrefix(location, "s3://"):
+		data, err = fetchS3(location)
+	case strings.HasPrefix(location, "file://"):
+		data, err = ioutil.ReadFile(strings.TrimPrefix(location, "file://"))
+	case strings.Contains(location, "://"):
+		err = errors.New("unsupported scheme, must be file, http, https or s3")
+	default:
+		data, err = ioutil.ReadFile(location)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if checksum != "" {
+		if err := verifyChecksum(data, checksum); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// splitChecksum returns the location of a source and its expected checksum, empty if none is set
+func splitChecksum(source string) (string, string) {
+	i := strings.LastIndex(source, checksumSuffix)
+	if i < 0 {
+		return source, ""
+	}
+	return source[:i], source[i+len(checksumSuffix):]
+}
+
+func verifyChecksum(data []byte, expected string) error {
+	sum := sha256.Sum256(data)
+	if actual := hex.EncodeToString(sum[:]); !strings.EqualFold(actual, expected) {
+		return errors.New(fmt.Sprintf("sha256 checksum %s does not match expected checksum %s", actual, expected))
+	}
+	return nil
+}
+
+// fetch returns the body of a GET request to location, sign prepares the request if set
+func fetch(location string, sign func(*http.Request) error) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	if sign != nil {
+		if err := sign(req); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := (&http.Client{Timeout: sourceTimeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body))))
+	}
+	return body, nil
+}
+
+// fetchS3 reads an object referenced as `s3://<bucket>/<key>`
+// requests are signed with the credentials of the default AWS credential chain, see resolveAWSCredentials,
+// otherwise the object must be public.
+// AWS_ENDPOINT_URL_S3 overrides the endpoint, e.g. for s3 compatible object storage, addressing the bucket by path
+func fetchS3(location string) ([]byte, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, errors.New("s3 source must be of the form s3://<bucket>/<key>")
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	object := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, region, s3Escape(key))
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL_S3"); endpoint != "" {
+		object = fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(endpoint, "/"), bucket, s3Escape(key))
+	}
+
+	creds, err := resolveAWSCredentials(region)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to resolve aws credentials: %v", err))
+	}
+	if creds == nil {
+		return fetch(object, nil)
+	}
+	return fetch(object, func(req *http.Request) error {
+		signV4(req, *creds, region, time.Now())
+		return nil
+	})
+}
+
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// emptyPayloadHash is the sha256 of the empty body of GET requests
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// signV4 signs a bodiless s3 request with AWS signature version 4, including all headers already set on req
+func signV4(req *http.Request, creds awsCredentials, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", emptyPayloadHash)
+	if creds.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+	scope := strings.Join([]string{date, region, "s3", "aws4_request"}, "/")
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(hashed[:])}, "\n")
+	signature := hex.EncodeToString(hmacSHA256(signingKey(creds.secretAccessKey, date, region, "s3"), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKeyID, scope, signedHeaders, signature))
+}
+
+// signingKey derives the key signing requests to a service in a region on a date
+func signingKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape encodes an object key as required by s3, leaving unreserved characters and slashes as they are
+func s3Escape(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
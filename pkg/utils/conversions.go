@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 )
 
 // Yaml unmarshal limitation causes nested options objects to be decode as strings with json format
@@ -24,3 +25,29 @@ func UnmarshalJsonObj(key string, obj interface{}) (map[string]interface{}, erro
 	}
 	return unmarshalled, nil
 }
+
+// OptionString converts an option given as a scalar of any type, e.g. `version: 2` or `force_no_cache: true`,
+// into the string vault expects. Numbers are formatted without exponent, as numbers read from json are floats
+// that would otherwise be written as e.g. `2.592e+06`. Lists and objects are not options and are an error
+func OptionString(key string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	default:
+		return "", errors.New(fmt.Sprintf("option `%s` must be a string, number or boolean", key))
+	}
+}
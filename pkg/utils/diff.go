@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// UnifiedDiff returns the line-level difference between the existing and desired
+// content of an object in unified diff format. Returns an empty string when equal.
+func UnifiedDiff(name, existing, desired string) string {
+	// SplitLines treats a trailing newline as an additional empty line
+	// an error can only be returned when writing the diff fails
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(strings.TrimSuffix(existing, "\n")),
+		B:        difflib.SplitLines(strings.TrimSuffix(desired, "\n")),
+		FromFile: name + " (existing)",
+		ToFile:   name + " (desired)",
+		Context:  3,
+	})
+	return diff
+}
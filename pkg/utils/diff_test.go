@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	table := []struct {
+		description string
+		existing    string
+		desired     string
+		expected    string
+	}{
+		{
+			description: "equal content",
+			existing:    "a\nb\n",
+			desired:     "a\nb\n",
+			expected:    "",
+		},
+		{
+			description: "changed line",
+			existing:    "a\nb\n",
+			desired:     "a\nc\n",
+			expected: "--- policy (existing)\n" +
+				"+++ policy (desired)\n" +
+				"@@ -1,2 +1,2 @@\n" +
+				" a\n" +
+				"-b\n" +
+				"+c\n",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, UnifiedDiff("policy", tt.existing, tt.desired))
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+)
+
+// JoinErrors combines multiple errors into a single error listing each message.
+// Returns nil when there are no errors.
+func JoinErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// HealthHandler serves the probes of vault-manager running as a long-lived service alongside its metrics
+// /healthz succeeds as long as the process is serving, /readyz once the last reconcile
+// of every instance succeeded, as reported by `qontract_reconcile_last_run_status`
+func HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !LastReconcileSucceeded() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("last reconcile failed or not yet completed\n"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+// LastReconcileSucceeded determines if the last reconcile of each instance succeeded
+// false until the first reconcile has completed
+func LastReconcileSucceeded() bool {
+	return lastRunSucceeded(lastReconcileSuccessGauge)
+}
+
+func lastRunSucceeded(gauge *prometheus.GaugeVec) bool {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		gauge.Collect(ch)
+		close(ch)
+	}()
+
+	recorded := false
+	succeeded := true
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil || metric.Gauge == nil {
+			succeeded = false
+			continue
+		}
+		recorded = true
+		if metric.Gauge.GetValue() != 0 {
+			succeeded = false
+		}
+	}
+	return recorded && succeeded
+}
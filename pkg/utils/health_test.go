@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLastRunSucceeded(t *testing.T) {
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_last_run_status"}, []string{"shard_id"})
+	require.False(t, lastRunSucceeded(gauge))
+
+	gauge.With(prometheus.Labels{"shard_id": "a"}).Set(0)
+	require.True(t, lastRunSucceeded(gauge))
+
+	gauge.With(prometheus.Labels{"shard_id": "b"}).Set(1)
+	require.False(t, lastRunSucceeded(gauge))
+
+	gauge.With(prometheus.Labels{"shard_id": "b"}).Set(0)
+	require.True(t, lastRunSucceeded(gauge))
+}
+
+func TestHealthHandler(t *testing.T) {
+	handler := HealthHandler()
+	probe := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusOK, probe("/healthz"))
+	require.Equal(t, http.StatusServiceUnavailable, probe("/readyz"))
+
+	RecordMetrics("https://vault.test", 0, 0)
+	defer lastReconcileSuccessGauge.Reset()
+	require.Equal(t, http.StatusOK, probe("/readyz"))
+	require.Equal(t, http.StatusOK, probe("/metrics"))
+}
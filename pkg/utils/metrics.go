@@ -6,6 +6,15 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+const INTEGRATION = "vault-manager"
+
+// operations performed against objects within a vault instance
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
 var (
 	reconcileSuccessCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -38,6 +47,59 @@ var (
 			"integration",
 		},
 	)
+	operationsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "vault_manager_operations_total",
+			Help: "Increment by one for each object successfully created, updated or deleted by a top-level configuration.",
+		},
+		[]string{
+			"address",
+			"integration",
+			"toplevel",
+			"operation",
+		},
+	)
+	pendingChangesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "qontract_reconcile_pending_changes",
+			Help: `Number of objects differing from the desired configuration at the start of the last reconcile ` +
+				`of a top-level configuration. Includes deletes skipped as pruning is disabled.`,
+		},
+		[]string{
+			"address",
+			"toplevel",
+		},
+	)
+	deadlineExceededCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "vault_manager_deadline_exceeded_total",
+			Help: "Increment by one for each reconcile that did not complete within `-max-runtime`.",
+		},
+		[]string{
+			"integration",
+		},
+	)
+	terminationCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "vault_manager_terminations_total",
+			Help: "Increment by one for each termination signal after which in-flight operations are completed before exiting.",
+		},
+		[]string{
+			"integration",
+		},
+	)
+	plannedOperationsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "vault_manager_planned_operations_total",
+			Help: "Increment by the number of objects a dry run determined would be created, updated or deleted by a top-level configuration.",
+		},
+		[]string{
+			"address",
+			"integration",
+			"toplevel",
+			"operation",
+		},
+	)
 )
 
 // register custom metrics at package import
@@ -45,11 +107,14 @@ func init() {
 	prometheus.MustRegister(reconcileSuccessCounter)
 	prometheus.MustRegister(lastReconcileSuccessGauge)
 	prometheus.MustRegister(executionDurationGauge)
+	prometheus.MustRegister(operationsCounter)
+	prometheus.MustRegister(plannedOperationsCounter)
+	prometheus.MustRegister(pendingChangesGauge)
+	prometheus.MustRegister(deadlineExceededCounter)
+	prometheus.MustRegister(terminationCounter)
 }
 
 func RecordMetrics(instance string, status int, duration time.Duration) {
-	const INTEGRATION = "vault-manager"
-
 	lastReconcileSuccessGauge.With(
 		prometheus.Labels{
 			"shard_id":    instance,
@@ -71,3 +136,56 @@ func RecordMetrics(instance string, status int, duration time.Duration) {
 			"integration": INTEGRATION,
 		}).Set(duration.Seconds())
 }
+
+// RecordOperation increments the counter of operations performed by a top-level configuration.
+// Should only be called once the operation has succeeded.
+func RecordOperation(instance, toplevel, operation string) {
+	operationsCounter.With(
+		prometheus.Labels{
+			"address":     instance,
+			"integration": INTEGRATION,
+			"toplevel":    toplevel,
+			"operation":   operation,
+		}).Inc()
+}
+
+// RecordPlannedOperations increments the counter of operations a dry run determined
+// would be performed by a top-level configuration.
+func RecordPlannedOperations(instance, toplevel, operation string, count int) {
+	if count == 0 {
+		return
+	}
+	plannedOperationsCounter.With(
+		prometheus.Labels{
+			"address":     instance,
+			"integration": INTEGRATION,
+			"toplevel":    toplevel,
+			"operation":   operation,
+		}).Add(float64(count))
+}
+
+// RecordPendingChanges sets the number of changes required for a top-level configuration to
+// match the desired state. Should be called on every reconcile so a lack of drift resets it to zero.
+func RecordPendingChanges(instance, toplevel string, count int) {
+	pendingChangesGauge.With(
+		prometheus.Labels{
+			"address":  instance,
+			"toplevel": toplevel,
+		}).Set(float64(count))
+}
+
+// RecordDeadlineExceeded increments the counter of reconciles that exceeded their deadline.
+func RecordDeadlineExceeded() {
+	deadlineExceededCounter.With(
+		prometheus.Labels{
+			"integration": INTEGRATION,
+		}).Inc()
+}
+
+// RecordTermination increments the counter of termination signals received.
+func RecordTermination() {
+	terminationCounter.With(
+		prometheus.Labels{
+			"integration": INTEGRATION,
+		}).Inc()
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+// sensitiveKeys are option keys whose values must never be logged
+// keys are matched exactly or as a suffix (e.g. `db_password`)
+var sensitiveKeys = []string{
+	"access_key",
+	"bindpass",
+	"client_secret",
+	"credentials",
+	"integration_key",
+	"password",
+	"pin",
+	"private_key",
+	"secret_key",
+	"settings_file_base64",
+	"token",
+}
+
+var credentialsPattern = regexp.MustCompile(`^(.*//)?([^:/@]+):(.+)@`)
+
+// IsSensitiveKey determines if the value of an option should be masked
+func IsSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if key == s || strings.HasSuffix(key, "_"+s) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactOptions returns a copy of opts that is safe to log
+// sensitive values are masked and urls containing credentials have their password removed
+func RedactOptions(opts map[string]interface{}) map[string]interface{} {
+	if opts == nil {
+		return nil
+	}
+	result := make(map[string]interface{}, len(opts))
+	for k, v := range opts {
+		result[k] = redactValue(k, v)
+	}
+	return result
+}
+
+// RedactStringOptions is RedactOptions for options decoded as strings
+func RedactStringOptions(opts map[string]string) map[string]interface{} {
+	if opts == nil {
+		return nil
+	}
+	converted := make(map[string]interface{}, len(opts))
+	for k, v := range opts {
+		converted[k] = v
+	}
+	return RedactOptions(converted)
+}
+
+// sensitiveHeaderParts are parts of header names whose values must never be logged, e.g. `X-Gateway-Jwt`
+var sensitiveHeaderParts = []string{"auth", "cookie", "jwt", "key", "secret", "session", "token"}
+
+// RedactHeaders returns a copy of headers that is safe to log
+// values of headers whose name contains a sensitive part are masked
+func RedactHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	result := make(map[string]string, len(headers))
+	for name, value := range headers {
+		result[name] = value
+		lower := strings.ToLower(name)
+		for _, part := range sensitiveHeaderParts {
+			if strings.Contains(lower, part) {
+				result[name] = redacted
+				break
+			}
+		}
+	}
+	return result
+}
+
+// RedactURL masks any password embedded within a url or dsn
+func RedactURL(url string) string {
+	return credentialsPattern.ReplaceAllString(url, "${1}${2}:"+redacted+"@")
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	if IsSensitiveKey(key) {
+		return redacted
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return RedactOptions(v)
+	case map[interface{}]interface{}:
+		nested := make(map[string]interface{}, len(v))
+		for nk, nv := range v {
+			nested[fmt.Sprintf("%v", nk)] = nv
+		}
+		return RedactOptions(nested)
+	case string:
+		return RedactURL(v)
+	default:
+		return value
+	}
+}
@@ -0,0 +1,55 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// mount accessors of auth backends per instance
+// accessors change whenever a mount is recreated so they are looked up once per reconcile
+var (
+	mountAccessors  = make(map[string]map[string]string)
+	mountAccessorsM sync.Mutex
+)
+
+// GetMountAccessor returns the accessor of the auth backend mounted at path
+// lookups are cached per instance until the auth backends of the instance change
+func GetMountAccessor(instanceAddr, path string) (string, error) {
+	mountAccessorsM.Lock()
+	defer mountAccessorsM.Unlock()
+
+	accessors, cached := mountAccessors[instanceAddr]
+	if !cached {
+		authBackends, err := ListAuthBackends(instanceAddr)
+		if err != nil {
+			return "", err
+		}
+		accessors = make(map[string]string, len(authBackends))
+		for k, v := range authBackends {
+			accessors[strings.Trim(k, "/")] = v.Accessor
+		}
+		mountAccessors[instanceAddr] = accessors
+	}
+
+	accessor, exists := accessors[strings.Trim(path, "/")]
+	if !exists {
+		return "", errors.New(fmt.Sprintf(
+			"[Vault Client] no auth backend mounted at `%s` within %s", path, instanceAddr))
+	}
+	return accessor, nil
+}
+
+// invalidateMountAccessors clears cached accessors of an instance
+// or of all instances when instanceAddr is empty
+func invalidateMountAccessors(instanceAddr string) {
+	mountAccessorsM.Lock()
+	defer mountAccessorsM.Unlock()
+
+	if instanceAddr == "" {
+		mountAccessors = make(map[string]map[string]string)
+		return
+	}
+	delete(mountAccessors, instanceAddr)
+}
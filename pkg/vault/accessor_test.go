@@ -0,0 +1,85 @@
+package vault
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/require"
+)
+
+// cacheMountAccessors replaces the cached accessors for the duration of a test
+func cacheMountAccessors(t *testing.T, accessors map[string]map[string]string) {
+	mountAccessorsM.Lock()
+	previous := mountAccessors
+	mountAccessors = accessors
+	mountAccessorsM.Unlock()
+	t.Cleanup(func() {
+		mountAccessorsM.Lock()
+		mountAccessors = previous
+		mountAccessorsM.Unlock()
+	})
+}
+
+func cachedMountAccessors(instanceAddr string) bool {
+	mountAccessorsM.Lock()
+	defer mountAccessorsM.Unlock()
+	_, cached := mountAccessors[instanceAddr]
+	return cached
+}
+
+func TestGetMountAccessorCached(t *testing.T) {
+	// no client exists for the instance, so any lookup not served by the cache would fail
+	cacheMountAccessors(t, map[string]map[string]string{
+		"https://vault.test": {"github": "auth_github_1234", "oidc": "auth_oidc_5678"},
+	})
+
+	accessor, err := GetMountAccessor("https://vault.test", "github/")
+	require.NoError(t, err)
+	require.Equal(t, "auth_github_1234", accessor)
+
+	_, err = GetMountAccessor("https://vault.test", "ldap")
+	require.Error(t, err, "paths without an auth backend are reported rather than resolved to an empty accessor")
+	require.Contains(t, err.Error(), "no auth backend mounted at `ldap`")
+}
+
+func TestMountAccessorsInvalidated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/sys/health" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"initialized":true,"sealed":false,"standby":false}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	client, err := api.NewClient(config)
+	require.NoError(t, err)
+	previous := vaultClients
+	vaultClients = map[string]*api.Client{server.URL: client}
+	defer func() { vaultClients = previous }()
+
+	cacheMountAccessors(t, map[string]map[string]string{
+		server.URL:           {"github": "auth_github_1234"},
+		"https://other.test": {"github": "auth_github_5678"},
+	})
+	require.NoError(t, EnableAuthWithOptions(server.URL, "oidc", &api.EnableAuthOptions{Type: "oidc"}))
+	require.False(t, cachedMountAccessors(server.URL), "accessors are looked up again once an auth backend is enabled")
+	require.True(t, cachedMountAccessors("https://other.test"), "accessors of other instances remain cached")
+
+	cacheMountAccessors(t, map[string]map[string]string{server.URL: {"github": "auth_github_1234"}})
+	require.NoError(t, DisableAuth(server.URL, "github"))
+	require.False(t, cachedMountAccessors(server.URL), "accessors are looked up again once an auth backend is disabled")
+
+	// clients are initialized anew with each reconcile, as are the accessors of their auth backends
+	cacheMountAccessors(t, map[string]map[string]string{server.URL: {"github": "auth_github_1234"}})
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_AUTHTYPE", TOKEN_AUTH)
+	t.Setenv("VAULT_TOKEN", "s.token")
+	initClients(map[string]AuthBundle{}, 1)
+	require.False(t, cachedMountAccessors(server.URL))
+}
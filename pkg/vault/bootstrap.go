@@ -0,0 +1,59 @@
+package vault
+
+// objects vault creates when an instance is initialized, a dev server additionally mounts a kv engine at `secret/`
+var (
+	initialMountTypes  = map[string]bool{"cubbyhole": true, "identity": true, "system": true}
+	initialAuthTypes   = map[string]bool{"token": true}
+	initialPolicyNames = map[string]bool{"default": true, "root": true}
+)
+
+// IsUnconfigured determines if an instance only contains the objects vault creates when it is initialized,
+// i.e. the instance is provisioned for the first time and every configured object is to be created
+func IsUnconfigured(instanceAddr string) (bool, error) {
+	mounts, err := ListSecretsEngines(instanceAddr)
+	if err != nil {
+		return false, err
+	}
+	auths, err := ListAuthBackends(instanceAddr)
+	if err != nil {
+		return false, err
+	}
+	policies, err := ListVaultPolicies(instanceAddr)
+	if err != nil {
+		return false, err
+	}
+	mountTypes := make(map[string]string, len(mounts))
+	for path, m := range mounts {
+		mountTypes[path] = m.Type
+	}
+	authTypes := make(map[string]string, len(auths))
+	for path, a := range auths {
+		authTypes[path] = a.Type
+	}
+	return unconfigured(mountTypes, authTypes, policies), nil
+}
+
+// unconfigured determines if the secrets engines and auth backends, by path and type, and the policies
+// of an instance are only those of a newly initialized instance
+func unconfigured(mounts, auths map[string]string, policies []string) bool {
+	for path, mountType := range mounts {
+		if initialMountTypes[mountType] {
+			continue
+		}
+		if EqualPathNames(path, "secret/") && mountType == "kv" {
+			continue
+		}
+		return false
+	}
+	for _, authType := range auths {
+		if !initialAuthTypes[authType] {
+			return false
+		}
+	}
+	for _, name := range policies {
+		if !initialPolicyNames[name] {
+			return false
+		}
+	}
+	return true
+}
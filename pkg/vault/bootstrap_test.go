@@ -0,0 +1,85 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnconfigured(t *testing.T) {
+	initialMounts := map[string]string{"cubbyhole/": "cubbyhole", "identity/": "identity", "sys/": "system"}
+	initialAuths := map[string]string{"token/": "token"}
+	initialPolicies := []string{"default", "root"}
+
+	table := []struct {
+		description string
+		mounts      map[string]string
+		auths       map[string]string
+		policies    []string
+		expected    bool
+	}{
+		{
+			description: "newly initialized instance",
+			mounts:      initialMounts,
+			auths:       initialAuths,
+			policies:    initialPolicies,
+			expected:    true,
+		},
+		{
+			description: "dev server with kv engine at secret/",
+			mounts:      map[string]string{"cubbyhole/": "cubbyhole", "identity/": "identity", "sys/": "system", "secret/": "kv"},
+			auths:       initialAuths,
+			policies:    initialPolicies,
+			expected:    true,
+		},
+		{
+			description: "nothing listed",
+			expected:    true,
+		},
+		{
+			description: "configured secrets engine",
+			mounts:      map[string]string{"sys/": "system", "app/": "kv"},
+			auths:       initialAuths,
+			policies:    initialPolicies,
+			expected:    false,
+		},
+		{
+			description: "configured auth backend",
+			mounts:      initialMounts,
+			auths:       map[string]string{"token/": "token", "approle/": "approle"},
+			policies:    initialPolicies,
+			expected:    false,
+		},
+		{
+			description: "configured policy",
+			mounts:      initialMounts,
+			auths:       initialAuths,
+			policies:    []string{"default", "root", "admin"},
+			expected:    false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, unconfigured(tt.mounts, tt.auths, tt.policies))
+		})
+	}
+}
+
+func TestDiffItemsAgainstEmptyInstance(t *testing.T) {
+	desired := []Item{item{name: "a"}, item{name: "b"}}
+
+	toBeWritten, toBeDeleted, toBeUpdated := DiffItems(desired, []Item{})
+	require.Equal(t, desired, toBeWritten, "every desired object is written in the order configured")
+	require.Empty(t, toBeDeleted)
+	require.Empty(t, toBeUpdated)
+
+	plan := NewPlan()
+	plan.Add("test", toBeWritten, toBeUpdated, toBeDeleted, []Item{})
+	require.Equal(t, []Change{{Type: "test", Name: "a"}, {Type: "test", Name: "b"}}, plan.Created)
+	require.Empty(t, plan.Updated)
+	require.Empty(t, plan.Deleted)
+
+	_, toBeDeleted, _ = DiffItems([]Item{}, []Item{})
+	require.Empty(t, toBeDeleted)
+}
@@ -0,0 +1,101 @@
+package vault
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// ApplyCache records a hash of the configuration each top-level configuration was last successfully
+// applied to an instance with, so that later runs can skip reading and comparing the objects of
+// top-level configurations whose configuration did not change. Changes made to an instance outside
+// of vault-manager are not detected for skipped configurations, nor are changes to values read from secrets,
+// which is why top-level configurations reading such values are not cached.
+// The cache is discarded when written by a different version of vault-manager.
+type ApplyCache struct {
+	Version string `json:"version"`
+	// Hashes of the configuration per top-level configuration per instance
+	Hashes map[string]map[string]string `json:"hashes"`
+	m      sync.Mutex
+}
+
+// NewApplyCache returns an empty cache for a version of vault-manager.
+func NewApplyCache(version string) *ApplyCache {
+	return &ApplyCache{Version: version, Hashes: make(map[string]map[string]string)}
+}
+
+// ReadApplyCache reads a cache written by WriteApplyCache
+// an empty cache is returned if the file does not exist or was written by a different version
+func ReadApplyCache(path, version string) (*ApplyCache, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return NewApplyCache(version), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := NewApplyCache(version)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to decode cache %s: %v", path, err))
+	}
+	if c.Version != version {
+		return NewApplyCache(version), nil
+	}
+	if c.Hashes == nil {
+		c.Hashes = make(map[string]map[string]string)
+	}
+	return c, nil
+}
+
+// WriteApplyCache writes a cache as json.
+func WriteApplyCache(path string, c *ApplyCache) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Unchanged determines if a top-level configuration was last successfully applied to an instance
+// with the same configuration and prune setting
+func (c *ApplyCache) Unchanged(instanceAddr, toplevelName string, config []byte, prune bool) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	hash, ok := c.Hashes[instanceAddr][toplevelName]
+	return ok && hash == configHash(config, prune)
+}
+
+// Record records that a top-level configuration was successfully applied to an instance.
+func (c *ApplyCache) Record(instanceAddr, toplevelName string, config []byte, prune bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.Hashes[instanceAddr] == nil {
+		c.Hashes[instanceAddr] = make(map[string]string)
+	}
+	c.Hashes[instanceAddr][toplevelName] = configHash(config, prune)
+}
+
+// Forget removes a top-level configuration of an instance from the cache, e.g. after failing to apply it,
+// so that it is applied in full by the next run
+func (c *ApplyCache) Forget(instanceAddr, toplevelName string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	delete(c.Hashes[instanceAddr], toplevelName)
+}
+
+// configHash hashes a configuration along with the prune setting, as objects missing from the
+// configuration are only deleted when pruning
+func configHash(config []byte, prune bool) string {
+	h := sha256.New()
+	h.Write(config)
+	h.Write([]byte(strconv.FormatBool(prune)))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,41 @@
+package vault
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyCache(t *testing.T) {
+	const addr = "http://127.0.0.1:8200"
+	config := []byte("- name: a\n")
+
+	c := NewApplyCache("v1")
+	require.False(t, c.Unchanged(addr, "vault_policies", config, false))
+
+	c.Record(addr, "vault_policies", config, false)
+	require.True(t, c.Unchanged(addr, "vault_policies", config, false))
+	require.False(t, c.Unchanged(addr, "vault_policies", []byte("- name: b\n"), false), "changed configuration")
+	require.False(t, c.Unchanged(addr, "vault_policies", config, true), "changed prune setting")
+	require.False(t, c.Unchanged(addr, "vault_roles", config, false), "other top-level configuration")
+	require.False(t, c.Unchanged("http://127.0.0.2:8200", "vault_policies", config, false), "other instance")
+
+	path := filepath.Join(t.TempDir(), "cache.json")
+	require.NoError(t, WriteApplyCache(path, c))
+
+	read, err := ReadApplyCache(path, "v1")
+	require.NoError(t, err)
+	require.True(t, read.Unchanged(addr, "vault_policies", config, false))
+
+	read.Forget(addr, "vault_policies")
+	require.False(t, read.Unchanged(addr, "vault_policies", config, false))
+
+	read, err = ReadApplyCache(path, "v2")
+	require.NoError(t, err)
+	require.False(t, read.Unchanged(addr, "vault_policies", config, false), "cache of another version is discarded")
+
+	read, err = ReadApplyCache(filepath.Join(t.TempDir(), "missing.json"), "v1")
+	require.NoError(t, err)
+	require.Empty(t, read.Hashes)
+}
@@ -3,15 +3,52 @@
 package vault
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/consts"
 	log "github.com/sirupsen/logrus"
 )
 
+// ErrPathInUse is returned when enabling a secrets engine or audit device at a path
+// that is already in use, e.g. when it was enabled since the existing objects were listed
+var ErrPathInUse = errors.New("path is already in use")
+
+// isPathInUse determines if vault refused to enable an object because its path is taken
+// secrets engines report `path is already in use` and audit devices `path already in use`
+func isPathInUse(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "already in use")
+}
+
+// timeouts applied to vault api requests by call category
+// reads include list and health requests, writes include enable/disable/delete requests
+var (
+	readTimeout  = 30 * time.Second
+	writeTimeout = 30 * time.Second
+)
+
+// SetRequestTimeouts overrides the default timeouts applied to vault api requests
+func SetRequestTimeouts(read, write time.Duration) {
+	readTimeout = read
+	writeTimeout = write
+}
+
+// requestContext returns a context that expires after the specified timeout
+func requestContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// IsTimeout returns whether an error was caused by a request exceeding its timeout
+func IsTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
 // attempts to read/proccess a single access credential for a particular vault instance
 func GetVaultSecretField(instanceAddr, path, field, engineVersion string) (string, error) {
 	secret, err := ReadSecret(instanceAddr, path, engineVersion)
@@ -35,15 +72,16 @@ func GetVaultSecretField(instanceAddr, path, field, engineVersion string) (strin
 
 // return proper secret path format based upon kv version
 // kv v2 api inserts /data/ between the root engine name and remaining path
-func FormatSecretPath(secret string, secretEngine string) string {
+func FormatSecretPath(secret string, secretEngine string) (string, error) {
 	if secretEngine == KV_V2 {
 		sliced := strings.SplitN(secret, "/", 2)
 		if len(sliced) < 2 {
-			log.Fatal("[Vault Instance] Error processessing kv_v2 secret path")
+			return "", errors.New(fmt.Sprintf(
+				"[Vault Instance] kv_v2 secret path `%s` must include the path of the secrets engine", secret))
 		}
-		return fmt.Sprintf("%s/data/%s", sliced[0], sliced[1])
+		return fmt.Sprintf("%s/data/%s", sliced[0], sliced[1]), nil
 	} else {
-		return secret
+		return secret, nil
 	}
 }
 
@@ -51,47 +89,69 @@ func FormatSecretPath(secret string, secretEngine string) string {
 func WriteSecret(instanceAddr, secretPath, engineVersion string, secretData map[string]interface{}) error {
 	dataExists, err := DataInSecret(instanceAddr, secretData, secretPath, engineVersion)
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"path":     secretPath,
-			"instance": instanceAddr,
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": secretPath,
 		}).Info("[Vault Client] failed to write Vault secret")
 		return err
 	}
 	if !dataExists {
-		versionedPath := FormatSecretPath(secretPath, engineVersion)
-		var err error
-		switch engineVersion {
-		case KV_V1:
-			_, err = getClient(instanceAddr).Logical().Write(versionedPath, secretData)
-		case KV_V2:
-			// need to wrap data within json with key "data"
-			v2Data := make(map[string]interface{})
-			v2Data["data"] = secretData
-			_, err = getClient(instanceAddr).Logical().Write(versionedPath, v2Data)
-		}
-		if err != nil {
-			log.WithError(err).WithFields(log.Fields{
-				"path":     secretPath,
-				"instance": instanceAddr,
-			}).Info("[Vault Client] failed to write Vault secret")
-			return err
-		}
+		return OverwriteSecret(instanceAddr, secretPath, engineVersion, secretData)
+	}
+	return nil
+}
+
+// write secret to vault replacing any data already stored at the path
+func OverwriteSecret(instanceAddr, secretPath, engineVersion string, secretData map[string]interface{}) error {
+	versionedPath, err := FormatSecretPath(secretPath, engineVersion)
+	if err != nil {
+		return err
+	}
+	switch engineVersion {
+	case KV_V1:
+		ctx, cancel := writeContext(instanceAddr)
+		defer cancel()
+		_, err = getClient(instanceAddr).Logical().WriteWithContext(ctx, versionedPath, secretData)
+	case KV_V2:
+		// need to wrap data within json with key "data"
+		v2Data := make(map[string]interface{})
+		v2Data["data"] = secretData
+		ctx, cancel := writeContext(instanceAddr)
+		defer cancel()
+		_, err = getClient(instanceAddr).Logical().WriteWithContext(ctx, versionedPath, v2Data)
+	}
+	if err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": secretPath,
+		}).Info("[Vault Client] failed to write Vault secret")
+		return err
 	}
 	return nil
 }
 
 // read secret from vault and return the secret map
 func ReadSecret(instanceAddr, secretPath, engineVersion string) (map[string]interface{}, error) {
-	versionedPath := FormatSecretPath(secretPath, engineVersion)
+	versionedPath, err := FormatSecretPath(secretPath, engineVersion)
+	if err != nil {
+		return nil, err
+	}
 	// vault manager does not support reverting and should always reference latest data within a-i
 	// therefore, secret version is not specified for KV V2 secrets
-	raw, err := getClient(instanceAddr).Logical().Read(versionedPath)
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	raw, err := getClient(instanceAddr).Logical().ReadWithContext(ctx, versionedPath)
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
+		fields := Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
 			"path":          secretPath,
-			"instance":      instanceAddr,
 			"engineVersion": engineVersion,
-		}).Fatal("[Vault Client] failed to read Vault secret")
+		})
+		// a timed out instance is skipped rather than aborting reconciliation of all instances
+		if IsTimeout(err) {
+			fields.Info("[Vault Client] timed out reading Vault secret")
+			AddInvalid(instanceAddr)
+			return nil, err
+		}
+		fields.Error("[Vault Client] failed to read Vault secret")
+		return nil, err
 	}
 	if raw == nil {
 		return nil, nil
@@ -101,23 +161,22 @@ func ReadSecret(instanceAddr, secretPath, engineVersion string) (map[string]inte
 	case KV_V1:
 		return raw.Data, nil
 	case KV_V2:
-		if len(raw.Data) == 0 {
+		// the data of a secret whose latest version is deleted or destroyed is null while its metadata remains
+		if len(raw.Data) == 0 || raw.Data["data"] == nil {
 			return nil, nil
 		}
 		mapped, ok := raw.Data["data"].(map[string]interface{})
 		if !ok {
-			log.WithError(err).WithFields(log.Fields{
+			Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
 				"path":          secretPath,
-				"instance":      instanceAddr,
 				"engineVersion": engineVersion,
 			}).Info("[Vault Client] failed to process `data` from result of read")
 			return nil, errors.New("failed to convert `data` to map")
 		}
 		return mapped, nil
 	default:
-		log.WithFields(log.Fields{
+		Logger(instanceAddr, "").WithFields(log.Fields{
 			"path":          secretPath,
-			"instance":      instanceAddr,
 			"engineVersion": engineVersion,
 		}).Info("[Vault Client] unsupported KV engine version passed to ReadSecret()")
 		return nil, errors.New("unsupported engine version specified")
@@ -126,11 +185,12 @@ func ReadSecret(instanceAddr, secretPath, engineVersion string) (map[string]inte
 
 // list secrets
 func ListSecrets(instanceAddr string, path string) (*api.Secret, error) {
-	secretsList, err := getClient(instanceAddr).Logical().List(path)
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	secretsList, err := getClient(instanceAddr).Logical().ListWithContext(ctx, path)
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"path":     path,
-			"instance": instanceAddr,
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": path,
 		}).Info("[Vault Client] failed to list Vault secrets")
 		return nil, errors.New("failed to list secrets")
 	}
@@ -139,24 +199,89 @@ func ListSecrets(instanceAddr string, path string) (*api.Secret, error) {
 
 // delete secret from vault
 func DeleteSecret(instanceAddr string, secretPath string) error {
-	_, err := getClient(instanceAddr).Logical().Delete(secretPath)
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	_, err := getClient(instanceAddr).Logical().DeleteWithContext(ctx, secretPath)
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"path":     secretPath,
-			"instance": instanceAddr,
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": secretPath,
 		}).Info("[Vault Client] failed to delete Vault secret")
 		return errors.New("failed to delete secret")
 	}
 	return nil
 }
 
+// read data stored at an arbitrary path
+// returns nil when nothing exists at the path
+func ReadRaw(instanceAddr, path string) (map[string]interface{}, error) {
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	raw, err := getClient(instanceAddr).Logical().ReadWithContext(ctx, path)
+	if err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": path,
+		}).Info("[Vault Client] failed to read Vault path")
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+	return raw.Data, nil
+}
+
+// list the keys at an arbitrary path
+// returns an empty list when nothing exists at the path
+func ListRaw(instanceAddr, path string) ([]string, error) {
+	secret, err := ListSecrets(instanceAddr, path)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	if secret == nil {
+		return keys, nil
+	}
+	raw, _ := secret.Data["keys"].([]interface{})
+	for _, k := range raw {
+		keys = append(keys, fmt.Sprintf("%v", k))
+	}
+	return keys, nil
+}
+
+// write data to an arbitrary path
+func WriteRaw(instanceAddr, path string, data map[string]interface{}) error {
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	_, err := getClient(instanceAddr).Logical().WriteWithContext(ctx, path, data)
+	if err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": path,
+		}).Info("[Vault Client] failed to write Vault path")
+		return err
+	}
+	return nil
+}
+
+// delete data at an arbitrary path
+func DeleteRaw(instanceAddr, path string) error {
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	_, err := getClient(instanceAddr).Logical().DeleteWithContext(ctx, path)
+	if err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": path,
+		}).Info("[Vault Client] failed to delete Vault path")
+		return err
+	}
+	return nil
+}
+
 // list existing enabled Audits Devices.
 func ListAuditDevices(instanceAddr string) (map[string]*api.Audit, error) {
-	enabledAuditDevices, err := getClient(instanceAddr).Sys().ListAudit()
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	enabledAuditDevices, err := getClient(instanceAddr).Sys().ListAuditWithContext(ctx)
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"instance": instanceAddr,
-		}).Info("[Vault Audit] failed to list audit devices")
+		Logger(instanceAddr, "").WithError(err).Info("[Vault Audit] failed to list audit devices")
 		return nil, errors.New("failed to list audit devices")
 	}
 	return enabledAuditDevices, nil
@@ -164,43 +289,94 @@ func ListAuditDevices(instanceAddr string) (map[string]*api.Audit, error) {
 
 // enable audit device with options
 func EnableAuditDevice(instanceAddr, path string, options *api.EnableAuditOptions) error {
-	if err := getClient(instanceAddr).Sys().EnableAuditWithOptions(path, options); err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"path":     path,
-			"instance": instanceAddr,
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	if err := getClient(instanceAddr).Sys().EnableAuditWithOptionsWithContext(ctx, path, options); err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": path,
 		}).Info("[Vault Audit] failed to enable audit device")
+		if isPathInUse(err) {
+			return ErrPathInUse
+		}
 		return errors.New("failed to enable audit device")
 	}
-	log.WithFields(log.Fields{
-		"path":     path,
-		"instance": instanceAddr,
+	Logger(instanceAddr, "").WithFields(log.Fields{
+		"path": path,
 	}).Info("[Vault Audit] audit device is successfully enabled")
 	return nil
 }
 
 // disable audit device
 func DisableAuditDevice(instanceAddr string, path string) error {
-	if err := getClient(instanceAddr).Sys().DisableAudit(path); err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"path":     path,
-			"instance": instanceAddr,
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	if err := getClient(instanceAddr).Sys().DisableAuditWithContext(ctx, path); err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": path,
 		}).Info("[Vault Audit] failed to disable audit device")
 		return errors.New("failed to disable audit device")
 	}
-	log.WithFields(log.Fields{
-		"path":     path,
-		"instance": instanceAddr,
+	Logger(instanceAddr, "").WithFields(log.Fields{
+		"path": path,
 	}).Info("[Vault Audit] audit device is successfully disabled")
 	return nil
 }
 
+// ReloadAuditDevice makes vault reopen the file of a file audit device, e.g. after the file was rotated
+// out-of-band. Vault cannot reload a single device through its api, so the device is disabled and enabled
+// again with its current options. Requests are not written to it in between and disabling the device
+// discards its salt, so values are hashed differently after the reload
+func ReloadAuditDevice(instanceAddr, path string) error {
+	devices, err := ListAuditDevices(instanceAddr)
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		if !EqualPathNames(d.Path, path) {
+			continue
+		}
+		if d.Type != "file" {
+			return errors.New(fmt.Sprintf("audit device `%s` of type %s cannot be reloaded, only file audit devices can", path, d.Type))
+		}
+		if err := DisableAuditDevice(instanceAddr, d.Path); err != nil {
+			return err
+		}
+		if err := EnableAuditDevice(instanceAddr, d.Path, &api.EnableAuditOptions{
+			Type:        d.Type,
+			Description: d.Description,
+			Options:     d.Options,
+			Local:       d.Local,
+		}); err != nil {
+			return err
+		}
+		Logger(instanceAddr, "").WithField("path", path).Info("[Vault Audit] audit device is successfully reloaded")
+		return nil
+	}
+	return errors.New(fmt.Sprintf("audit device `%s` is not enabled", path))
+}
+
+// AuditHash returns the hash an audit device writes to its log for the input, e.g. to verify that
+// an audit log entry contains a known value. The hash is computed with the salt of the device.
+func AuditHash(instanceAddr, path, input string) (string, error) {
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	hash, err := getClient(instanceAddr).Sys().AuditHashWithContext(ctx, path, input)
+	if err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": path,
+		}).Info("[Vault Audit] failed to hash input")
+		return "", errors.New(fmt.Sprintf("failed to hash input with audit device %s", path))
+	}
+	return hash, nil
+}
+
 // list existing auth backends
 func ListAuthBackends(instanceAddr string) (map[string]*api.AuthMount, error) {
-	existingAuthMounts, err := getClient(instanceAddr).Sys().ListAuth()
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	existingAuthMounts, err := getClient(instanceAddr).Sys().ListAuthWithContext(ctx)
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"instance": instanceAddr,
-		}).Info("[Vault Auth] failed to list auth backends")
+		Logger(instanceAddr, "").WithError(err).Info("[Vault Auth] failed to list auth backends")
 		return nil, errors.New("failed to list auth backends")
 	}
 	return existingAuthMounts, nil
@@ -208,45 +384,47 @@ func ListAuthBackends(instanceAddr string) (map[string]*api.AuthMount, error) {
 
 // enable auth backend
 func EnableAuthWithOptions(instanceAddr string, path string, options *api.EnableAuthOptions) error {
-	if err := getClient(instanceAddr).Sys().EnableAuthWithOptions(path, options); err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"path":     path,
-			"type":     options.Type,
-			"instance": instanceAddr,
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	if err := getClient(instanceAddr).Sys().EnableAuthWithOptionsWithContext(ctx, path, options); err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": path,
+			"type": options.Type,
 		}).Info("[Vault Auth] failed to enable auth backend")
 		return errors.New("failed to enable auth backend")
 	}
-	log.WithFields(log.Fields{
-		"path":     path,
-		"type":     options.Type,
-		"instance": instanceAddr,
+	invalidateMountAccessors(instanceAddr)
+	Logger(instanceAddr, "").WithFields(log.Fields{
+		"path": path,
+		"type": options.Type,
 	}).Info("[Vault Auth] successfully enabled auth backend")
 	return nil
 }
 
 // disable auth backend
 func DisableAuth(instanceAddr string, path string) error {
-	if err := getClient(instanceAddr).Sys().DisableAuth(path); err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"path":     path,
-			"instance": instanceAddr,
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	if err := getClient(instanceAddr).Sys().DisableAuthWithContext(ctx, path); err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": path,
 		}).Info("[Vault Auth] failed to disable auth backend")
 		return errors.New("failed to disable auth backend")
 	}
-	log.WithFields(log.Fields{
-		"path":     path,
-		"instance": instanceAddr,
+	invalidateMountAccessors(instanceAddr)
+	Logger(instanceAddr, "").WithFields(log.Fields{
+		"path": path,
 	}).Info("[Vault Auth] successfully disabled auth backend")
 	return nil
 }
 
 // returns a list of existing policy names for a specific instance
 func ListVaultPolicies(instanceAddr string) ([]string, error) {
-	existingPolicyNames, err := getClient(instanceAddr).Sys().ListPolicies()
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	existingPolicyNames, err := getClient(instanceAddr).Sys().ListPoliciesWithContext(ctx)
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"instance": instanceAddr,
-		}).Info("[Vault Policy] failed to list existing policies")
+		Logger(instanceAddr, "").WithError(err).Info("[Vault Policy] failed to list existing policies")
 		return nil, errors.New("[Vault Policy] failed to list existing policies")
 	}
 	return existingPolicyNames, nil
@@ -254,13 +432,12 @@ func ListVaultPolicies(instanceAddr string) ([]string, error) {
 
 // get vault policy name
 func GetVaultPolicy(instanceAddr string, name string) (string, error) {
-	policy, err := getClient(instanceAddr).Sys().GetPolicy(name)
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	policy, err := getClient(instanceAddr).Sys().GetPolicyWithContext(ctx, name)
 	if err != nil {
-		log.WithError(err).WithFields(
-			log.Fields{
-				"name":     name,
-				"instance": instanceAddr,
-			}).Info("[Vault Policy] failed to get existing Vault policy")
+		Logger(instanceAddr, "").WithError(err).WithField("name", name).Info(
+			"[Vault Policy] failed to get existing Vault policy")
 		return "", err
 	}
 	return policy, nil
@@ -268,41 +445,209 @@ func GetVaultPolicy(instanceAddr string, name string) (string, error) {
 
 // put vault policy
 func PutVaultPolicy(instanceAddr string, name string, rules string) error {
-	if err := getClient(instanceAddr).Sys().PutPolicy(name, rules); err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"name":     name,
-			"instance": instanceAddr,
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	if err := getClient(instanceAddr).Sys().PutPolicyWithContext(ctx, name, rules); err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"name": name,
 		}).Info("[Vault Policy] failed to write policy to Vault instance")
 		return err
 	}
-	log.WithFields(log.Fields{
-		"name":     name,
-		"instance": instanceAddr,
+	Logger(instanceAddr, "").WithFields(log.Fields{
+		"name": name,
 	}).Info("[Vault Policy] policy successfully written to Vault instance")
 	return nil
 }
 
 // delete vault policy
 func DeleteVaultPolicy(instanceAddr string, name string) error {
-	if err := getClient(instanceAddr).Sys().DeletePolicy(name); err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"name":     name,
-			"instance": instanceAddr,
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	if err := getClient(instanceAddr).Sys().DeletePolicyWithContext(ctx, name); err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"name": name,
 		}).Info("[Vault Policy] failed to delete vault policy")
 		return err
 	}
-	log.WithFields(log.Fields{
-		"name":     name,
-		"instance": instanceAddr,
+	Logger(instanceAddr, "").WithFields(log.Fields{
+		"name": name,
 	}).Info("[Vault Policy] successfully deleted policy from Vault instance")
 	return nil
 }
 
+// returns a list of existing sentinel policy names of type rgp or egp for a specific instance
+func ListVaultSentinelPolicies(instanceAddr, policyType string) ([]string, error) {
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	existing, err := getClient(instanceAddr).Logical().ListWithContext(ctx, fmt.Sprintf("sys/policies/%s", policyType))
+	if err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"type": policyType,
+		}).Info("[Vault Policy] failed to list existing sentinel policies")
+		return nil, errors.New("[Vault Policy] failed to list existing sentinel policies")
+	}
+	names := []string{}
+	if existing == nil {
+		return names, nil
+	}
+	keys, ok := existing.Data["keys"].([]interface{})
+	if !ok {
+		return names, nil
+	}
+	for _, k := range keys {
+		names = append(names, k.(string))
+	}
+	return names, nil
+}
+
+// get vault sentinel policy of type rgp or egp
+func GetVaultSentinelPolicy(instanceAddr, policyType, name string) (map[string]interface{}, error) {
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	policy, err := getClient(instanceAddr).Logical().ReadWithContext(ctx, fmt.Sprintf("sys/policies/%s/%s", policyType, name))
+	if err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"name": name,
+			"type": policyType,
+		}).Info("[Vault Policy] failed to get existing Vault sentinel policy")
+		return nil, err
+	}
+	if policy == nil {
+		return nil, nil
+	}
+	return policy.Data, nil
+}
+
+// put vault sentinel policy of type rgp or egp
+// paths are only applicable to egp policies
+func PutVaultSentinelPolicy(instanceAddr, policyType, name, rules, enforcementLevel string, paths []string) error {
+	data := map[string]interface{}{
+		"policy":            rules,
+		"enforcement_level": enforcementLevel,
+	}
+	if len(paths) > 0 {
+		data["paths"] = paths
+	}
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	if _, err := getClient(instanceAddr).Logical().WriteWithContext(ctx, fmt.Sprintf("sys/policies/%s/%s", policyType, name), data); err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"name": name,
+			"type": policyType,
+		}).Info("[Vault Policy] failed to write sentinel policy to Vault instance")
+		return err
+	}
+	Logger(instanceAddr, "").WithFields(log.Fields{
+		"name": name,
+		"type": policyType,
+	}).Info("[Vault Policy] sentinel policy successfully written to Vault instance")
+	return nil
+}
+
+// delete vault sentinel policy of type rgp or egp
+func DeleteVaultSentinelPolicy(instanceAddr, policyType, name string) error {
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	if _, err := getClient(instanceAddr).Logical().DeleteWithContext(ctx, fmt.Sprintf("sys/policies/%s/%s", policyType, name)); err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"name": name,
+			"type": policyType,
+		}).Info("[Vault Policy] failed to delete vault sentinel policy")
+		return err
+	}
+	Logger(instanceAddr, "").WithFields(log.Fields{
+		"name": name,
+		"type": policyType,
+	}).Info("[Vault Policy] successfully deleted sentinel policy from Vault instance")
+	return nil
+}
+
+// returns the names of existing quotas of a type, either rate-limit or lease-count
+func ListQuotas(instanceAddr, quotaType string) ([]string, error) {
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	secret, err := getClient(instanceAddr).Logical().ListWithContext(ctx, filepath.Join("sys/quotas", quotaType))
+	if err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"type": quotaType,
+		}).Info("[Vault Quota] failed to list existing quotas")
+		return nil, errors.New("[Vault Quota] failed to list existing quotas")
+	}
+	names := []string{}
+	if secret == nil {
+		return names, nil
+	}
+	keys, _ := secret.Data["keys"].([]interface{})
+	for _, k := range keys {
+		names = append(names, fmt.Sprintf("%v", k))
+	}
+	return names, nil
+}
+
+// read the configuration of a quota
+// returns nil when the quota does not exist
+func ReadQuota(instanceAddr, quotaType, name string) (map[string]interface{}, error) {
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	secret, err := getClient(instanceAddr).Logical().ReadWithContext(ctx, filepath.Join("sys/quotas", quotaType, name))
+	if err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"name": name,
+			"type": quotaType,
+		}).Info("[Vault Quota] failed to read quota")
+		return nil, err
+	}
+	if secret == nil {
+		return nil, nil
+	}
+	return secret.Data, nil
+}
+
+// create or update a quota
+func WriteQuota(instanceAddr, quotaType, name string, data map[string]interface{}) error {
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	_, err := getClient(instanceAddr).Logical().WriteWithContext(ctx, filepath.Join("sys/quotas", quotaType, name), data)
+	if err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"name": name,
+			"type": quotaType,
+		}).Info("[Vault Quota] failed to write quota to Vault instance")
+		return err
+	}
+	Logger(instanceAddr, "").WithFields(log.Fields{
+		"name": name,
+		"type": quotaType,
+	}).Info("[Vault Quota] quota successfully written to Vault instance")
+	return nil
+}
+
+// delete a quota
+func DeleteQuota(instanceAddr, quotaType, name string) error {
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	_, err := getClient(instanceAddr).Logical().DeleteWithContext(ctx, filepath.Join("sys/quotas", quotaType, name))
+	if err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"name": name,
+			"type": quotaType,
+		}).Info("[Vault Quota] failed to delete quota")
+		return err
+	}
+	Logger(instanceAddr, "").WithFields(log.Fields{
+		"name": name,
+		"type": quotaType,
+	}).Info("[Vault Quota] successfully deleted quota from Vault instance")
+	return nil
+}
+
 // return secret engines
 func ListSecretsEngines(instanceAddr string) (map[string]*api.MountOutput, error) {
-	existingMounts, err := getClient(instanceAddr).Sys().ListMounts()
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	existingMounts, err := getClient(instanceAddr).Sys().ListMountsWithContext(ctx)
 	if err != nil {
-		log.WithError(err).WithField("instance", instanceAddr).Info(
+		Logger(instanceAddr, "").WithError(err).Info(
 			"[Vault Secrets engine] failed to list Vault secrets engines")
 		return nil, err
 	}
@@ -311,69 +656,202 @@ func ListSecretsEngines(instanceAddr string) (map[string]*api.MountOutput, error
 
 // enable secrets engine
 func EnableSecretsEngine(instanceAddr string, path string, mount *api.MountInput) error {
-	if err := getClient(instanceAddr).Sys().Mount(path, mount); err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"path":     path,
-			"type":     mount.Type,
-			"instance": instanceAddr,
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	if err := getClient(instanceAddr).Sys().MountWithContext(ctx, path, mount); err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": path,
+			"type": mount.Type,
 		}).Info("[Vault Secrets engine] failed to enable secrets-engine")
+		if isPathInUse(err) {
+			return ErrPathInUse
+		}
 		return err
 	}
-	log.WithFields(log.Fields{
-		"path":     path,
-		"type":     mount.Type,
-		"instance": instanceAddr,
+	Logger(instanceAddr, "").WithFields(log.Fields{
+		"path": path,
+		"type": mount.Type,
 	}).Info("[Vault Secrets engine] successfully enabled secrets-engine")
 	return nil
 }
 
 // update secrets engine
 func UpdateSecretsEngine(instanceAddr string, path string, config api.MountConfigInput) error {
-	if err := getClient(instanceAddr).Sys().TuneMount(path, config); err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"path":     path,
-			"instance": instanceAddr,
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	if err := getClient(instanceAddr).Sys().TuneMountWithContext(ctx, path, config); err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": path,
 		}).Info("[Vault Secrets engine] failed to update secrets-engine")
 		return err
 	}
-	log.WithFields(log.Fields{
-		"path":     path,
-		"instance": instanceAddr,
+	Logger(instanceAddr, "").WithFields(log.Fields{
+		"path": path,
 	}).Info("[Vault Secrets engine] successfully updated secrets-engine")
 	return nil
 }
 
+// reload the plugin backing a mount so that a newly tuned plugin version takes effect
+func ReloadPlugin(instanceAddr string, path string) error {
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	_, err := getClient(instanceAddr).Sys().ReloadPluginWithContext(ctx, &api.ReloadPluginInput{
+		Mounts: []string{path},
+	})
+	if err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": path,
+		}).Info("[Vault Secrets engine] failed to reload secrets-engine plugin")
+		return err
+	}
+	return nil
+}
+
 // disable secrets engine
 func DisableSecretsEngine(instanceAddr string, path string) error {
-	if err := getClient(instanceAddr).Sys().Unmount(path); err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"path":     path,
-			"instance": instanceAddr,
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	if err := getClient(instanceAddr).Sys().UnmountWithContext(ctx, path); err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": path,
 		}).Info("[Vault Secrets engine] failed to disable secrets-engine")
 		return err
 	}
-	log.WithFields(log.Fields{
-		"path":     path,
-		"instance": instanceAddr,
+	Logger(instanceAddr, "").WithFields(log.Fields{
+		"path": path,
 	}).Info("[Vault Secrets engine] successfully disabled secrets-engine")
 	return nil
 }
 
+// RemountSecretsEngine moves a secrets engine and its data from one path to another
+// waits until vault reports the migration as completed, bounded by the write timeout
+func RemountSecretsEngine(instanceAddr string, from, to string) error {
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	if err := getClient(instanceAddr).Sys().RemountWithContext(ctx, from, to); err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"from": from,
+			"to":   to,
+		}).Info("[Vault Secrets engine] failed to move secrets-engine")
+		return err
+	}
+	Logger(instanceAddr, "").WithFields(log.Fields{
+		"from": from,
+		"to":   to,
+	}).Info("[Vault Secrets engine] successfully moved secrets-engine")
+	return nil
+}
+
+// ListPlugins returns the plugins registered within the catalog of an instance, including builtin plugins
+func ListPlugins(instanceAddr string) ([]api.PluginDetails, error) {
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	plugins, err := getClient(instanceAddr).Sys().ListPluginsWithContext(ctx, &api.ListPluginsInput{})
+	if err != nil {
+		Logger(instanceAddr, "").WithError(err).Info("[Vault Plugin] failed to list plugin catalog")
+		return nil, err
+	}
+	return plugins.Details, nil
+}
+
+// GetPlugin returns the registration of a plugin of a type, e.g. `secret`, and version
+func GetPlugin(instanceAddr, pluginType, name, version string) (*api.GetPluginResponse, error) {
+	t, err := consts.ParsePluginType(pluginType)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	plugin, err := getClient(instanceAddr).Sys().GetPluginWithContext(ctx, &api.GetPluginInput{
+		Name:    name,
+		Type:    t,
+		Version: version,
+	})
+	if err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"name": name,
+			"type": pluginType,
+		}).Info("[Vault Plugin] failed to read plugin")
+		return nil, err
+	}
+	return plugin, nil
+}
+
+// RegisterPlugin registers a plugin within the catalog, replacing an existing registration of the same version
+func RegisterPlugin(instanceAddr, pluginType string, input *api.RegisterPluginInput) error {
+	t, err := consts.ParsePluginType(pluginType)
+	if err != nil {
+		return err
+	}
+	input.Type = t
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	if err := getClient(instanceAddr).Sys().RegisterPluginWithContext(ctx, input); err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"name": input.Name,
+			"type": pluginType,
+		}).Info("[Vault Plugin] failed to register plugin")
+		return err
+	}
+	return nil
+}
+
+// DeregisterPlugin removes a version of a plugin from the catalog
+func DeregisterPlugin(instanceAddr, pluginType, name, version string) error {
+	t, err := consts.ParsePluginType(pluginType)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	err = getClient(instanceAddr).Sys().DeregisterPluginWithContext(ctx, &api.DeregisterPluginInput{
+		Name:    name,
+		Type:    t,
+		Version: version,
+	})
+	if err != nil {
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"name": name,
+			"type": pluginType,
+		}).Info("[Vault Plugin] failed to deregister plugin")
+		return err
+	}
+	return nil
+}
+
 // GetVaultVersion returns the vault server version
 func GetVaultVersion(instanceAddr string) (string, error) {
-	info, err := getClient(instanceAddr).Sys().Health()
+	if v, ok := cachedVersion(instanceAddr); ok {
+		return v, nil
+	}
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	info, err := getClient(instanceAddr).Sys().HealthWithContext(ctx)
 	if err != nil {
-		log.WithError(err).WithField("instance", instanceAddr).Info(
+		Logger(instanceAddr, "").WithError(err).Info(
 			"[Vault System] failed to retrieve vault system information")
 		return "", err
 	}
+	cacheVersion(instanceAddr, info.Version)
 	return info.Version, nil
 }
 
+// IsEnterprise determines whether an instance is running vault enterprise
+// enterprise versions are reported with `+ent` build metadata
+func IsEnterprise(instanceAddr string) (bool, error) {
+	ver, err := GetVaultVersion(instanceAddr)
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(ver, "+ent"), nil
+}
+
 func ListEntities(instanceAddr string) (map[string]interface{}, error) {
-	existingEntities, err := getClient(instanceAddr).Logical().List("identity/entity/id")
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	existingEntities, err := getClient(instanceAddr).Logical().ListWithContext(ctx, "identity/entity/id")
 	if err != nil {
-		log.WithError(err).WithField("instance", instanceAddr).Info(
+		Logger(instanceAddr, "").WithError(err).Info(
 			"[Vault Identity] failed to list Vault entities")
 	}
 	if existingEntities == nil {
@@ -383,11 +861,12 @@ func ListEntities(instanceAddr string) (map[string]interface{}, error) {
 }
 
 func GetEntityInfo(instanceAddr string, name string) (map[string]interface{}, error) {
-	entity, err := getClient(instanceAddr).Logical().Read(fmt.Sprintf("identity/entity/name/%s", name))
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	entity, err := getClient(instanceAddr).Logical().ReadWithContext(ctx, fmt.Sprintf("identity/entity/name/%s", name))
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"instance": instanceAddr,
-			"name":     name,
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"name": name,
 		}).Info("[Vault Identity] failed to get entity info")
 		return nil, err
 	}
@@ -398,11 +877,12 @@ func GetEntityInfo(instanceAddr string, name string) (map[string]interface{}, er
 }
 
 func GetEntityAliasInfo(instanceAddr string, id string) (map[string]interface{}, error) {
-	entityAlias, err := getClient(instanceAddr).Logical().Read(fmt.Sprintf("identity/entity-alias/id/%s", id))
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	entityAlias, err := getClient(instanceAddr).Logical().ReadWithContext(ctx, fmt.Sprintf("identity/entity-alias/id/%s", id))
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"instance": instanceAddr,
-			"id":       id,
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"id": id,
 		}).Info("[Vault Identity] failed to get info for entity alias")
 		return nil, err
 	}
@@ -410,11 +890,12 @@ func GetEntityAliasInfo(instanceAddr string, id string) (map[string]interface{},
 }
 
 func WriteEntityAlias(instanceAddr string, secretPath string, secretData map[string]interface{}) error {
-	_, err := getClient(instanceAddr).Logical().Write(secretPath, secretData)
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	_, err := getClient(instanceAddr).Logical().WriteWithContext(ctx, secretPath, secretData)
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"path":     secretPath,
-			"instance": instanceAddr,
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": secretPath,
 		}).Info("[Vault Client] failed to write entity-alias secret")
 		return err
 	}
@@ -422,9 +903,11 @@ func WriteEntityAlias(instanceAddr string, secretPath string, secretData map[str
 }
 
 func ListGroups(instanceAddr string) (map[string]interface{}, error) {
-	existingGroups, err := getClient(instanceAddr).Logical().List("identity/group/id")
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	existingGroups, err := getClient(instanceAddr).Logical().ListWithContext(ctx, "identity/group/id")
 	if err != nil {
-		log.WithError(err).WithField("instance", instanceAddr).Info(
+		Logger(instanceAddr, "").WithError(err).Info(
 			"[Vault Group] failed to list Vault groups")
 		return nil, err
 	}
@@ -435,11 +918,12 @@ func ListGroups(instanceAddr string) (map[string]interface{}, error) {
 }
 
 func GetGroupInfo(instanceAddr string, name string) (map[string]interface{}, error) {
-	entity, err := getClient(instanceAddr).Logical().Read(fmt.Sprintf("identity/group/name/%s", name))
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	entity, err := getClient(instanceAddr).Logical().ReadWithContext(ctx, fmt.Sprintf("identity/group/name/%s", name))
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"instance": instanceAddr,
-			"name":     name,
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"name": name,
 		}).Info("[Vault Group] failed to get info for group")
 		return nil, err
 	}
@@ -452,11 +936,12 @@ func GetGroupInfo(instanceAddr string, name string) (map[string]interface{}, err
 // "write" empty secret to approle secret-id endpoint in order to generate new secret_id
 // https://www.vaultproject.io/docs/auth/approle#via-the-api-1
 func GenerateApproleSecretID(instanceAddr, secretPath string) (*api.Secret, error) {
-	secret, err := getClient(instanceAddr).Logical().Write(secretPath, map[string]interface{}{})
+	ctx, cancel := writeContext(instanceAddr)
+	defer cancel()
+	secret, err := getClient(instanceAddr).Logical().WriteWithContext(ctx, secretPath, map[string]interface{}{})
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"path":     secretPath,
-			"instance": instanceAddr,
+		Logger(instanceAddr, "").WithError(err).WithFields(log.Fields{
+			"path": secretPath,
 		}).Info("[Vault Client] failed to write Vault secret")
 		return nil, errors.New("failed to write secret")
 	}
@@ -478,3 +963,10 @@ func defaultGetenv(name, defaultName string) string {
 	}
 	return env
 }
+
+func defaultString(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,40 @@
+package vault
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPathInUse(t *testing.T) {
+	require.True(t, isPathInUse(errors.New("Error making API request.\n\nCode: 400. Errors:\n\n* path is already in use at aws/")))
+	require.True(t, isPathInUse(errors.New("Error making API request.\n\nCode: 400. Errors:\n\n* path already in use")))
+	require.False(t, isPathInUse(errors.New("permission denied")))
+	require.False(t, isPathInUse(nil))
+}
+
+func TestReadSecretDeletedVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the latest version of a kv v2 secret was soft deleted
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{"data":null,"metadata":{"deletion_time":"2024-01-01T00:00:00Z","destroyed":false,"version":2}}}`))
+	}))
+	defer server.Close()
+
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	client, err := api.NewClient(config)
+	require.NoError(t, err)
+	previous := vaultClients
+	vaultClients = map[string]*api.Client{server.URL: client}
+	defer func() { vaultClients = previous }()
+
+	data, err := ReadSecret(server.URL, "secret/deleted", KV_V2)
+	require.NoError(t, err)
+	require.Nil(t, data, "deleted secrets are absent so that they are written again")
+}
@@ -0,0 +1,91 @@
+package vault
+
+import (
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Failure is a failed operation on an instance, recorded so that all failures of a
+// reconcile can be reported together once it has completed
+type Failure struct {
+	Instance string
+	// Toplevel is empty for failures that are not specific to a top-level configuration,
+	// e.g. failing to initialize the client of an instance
+	Toplevel  string
+	Operation string
+	// Key identifies the object the operation failed on, if any
+	Key string
+	Err error
+}
+
+// tracks failures of the current reconcile
+// reset with each call to GetInstances()
+var (
+	failures  = []Failure{}
+	failuresM sync.Mutex
+)
+
+// RecordFailure records a failure to be reported by LogFailures
+func RecordFailure(instanceAddr, toplevelName, operation, key string, err error) {
+	failuresM.Lock()
+	defer failuresM.Unlock()
+	failures = append(failures, Failure{
+		Instance:  instanceAddr,
+		Toplevel:  toplevelName,
+		Operation: operation,
+		Key:       key,
+		Err:       err,
+	})
+}
+
+// Failures returns the failures of the current reconcile ordered by instance and top-level configuration.
+// Failures of the same instance and top-level configuration keep the order they were recorded in.
+func Failures() []Failure {
+	failuresM.Lock()
+	defer failuresM.Unlock()
+	result := make([]Failure, len(failures))
+	copy(result, failures)
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Instance != result[j].Instance {
+			return result[i].Instance < result[j].Instance
+		}
+		return result[i].Toplevel < result[j].Toplevel
+	})
+	return result
+}
+
+// HasFailures determines if any failure of a top-level configuration of an instance was recorded in the current reconcile
+func HasFailures(instanceAddr, toplevelName string) bool {
+	failuresM.Lock()
+	defer failuresM.Unlock()
+	for _, f := range failures {
+		if f.Instance == instanceAddr && f.Toplevel == toplevelName {
+			return true
+		}
+	}
+	return false
+}
+
+// LogFailures logs a summary of all failures of the current reconcile
+func LogFailures() {
+	recorded := Failures()
+	if len(recorded) == 0 {
+		return
+	}
+	log.WithField("count", len(recorded)).Error("[Failures] reconcile completed with failures")
+	for _, f := range recorded {
+		fields := log.Fields{"operation": f.Operation}
+		if f.Key != "" {
+			fields["key"] = f.Key
+		}
+		Logger(f.Instance, f.Toplevel).WithError(f.Err).WithFields(fields).Error("[Failures] failed operation")
+	}
+}
+
+func resetFailures() {
+	failuresM.Lock()
+	defer failuresM.Unlock()
+	failures = []Failure{}
+}
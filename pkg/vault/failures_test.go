@@ -0,0 +1,32 @@
+package vault
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailures(t *testing.T) {
+	resetFailures()
+	defer resetFailures()
+
+	RecordFailure("https://b.example.com", "vault_policies", "apply", "", errors.New("b"))
+	RecordFailure("https://a.example.com", "vault_transit_keys", "update", "transit/keys/x", errors.New("x"))
+	RecordFailure("https://a.example.com", "", "login", "", errors.New("a"))
+	RecordFailure("https://a.example.com", "vault_transit_keys", "update", "transit/keys/y", errors.New("y"))
+
+	keys := []string{}
+	for _, f := range Failures() {
+		keys = append(keys, f.Instance+" "+f.Toplevel+" "+f.Err.Error())
+	}
+	require.Equal(t, []string{
+		"https://a.example.com  a",
+		"https://a.example.com vault_transit_keys x",
+		"https://a.example.com vault_transit_keys y",
+		"https://b.example.com vault_policies b",
+	}, keys)
+
+	resetFailures()
+	require.Empty(t, Failures())
+}
@@ -0,0 +1,78 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+var followStandby bool
+
+// EnableFollowStandby configures clients of instances whose address resolves to a standby node
+// to use the address of the active node instead of skipping the instance.
+// must be called prior to GetInstances() for clients to be configured accordingly
+func EnableFollowStandby() {
+	followStandby = true
+}
+
+// CheckHealth ensures that an instance can be reconciled, see checkHealth
+func CheckHealth(instanceAddr string) error {
+	return checkHealth(instanceAddr, getClient(instanceAddr))
+}
+
+// checkHealth ensures that the node a client is configured for can be reconciled.
+// Sealed and uninitialized nodes fail the check. Standby nodes fail the check unless
+// following standby nodes is enabled, in which case the client is pointed at the active node.
+// Performance standby nodes forward writes to the active node and pass the check.
+func checkHealth(instanceAddr string, client *api.Client) error {
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	health, err := client.Sys().HealthWithContext(ctx)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to check health of %s: %v", instanceAddr, err))
+	}
+	standby, err := healthState(health)
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s %v", instanceAddr, err))
+	}
+	if !standby {
+		return nil
+	}
+	if !followStandby {
+		return errors.New(fmt.Sprintf(
+			"%s is a standby node, use the address of the active node or `-follow-standby`", instanceAddr))
+	}
+
+	ctx, cancel = requestContext(readTimeout)
+	defer cancel()
+	leader, err := client.Sys().LeaderWithContext(ctx)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to determine active node of %s: %v", instanceAddr, err))
+	}
+	if leader.LeaderAddress == "" {
+		return errors.New(fmt.Sprintf("%s is a standby node without an active node", instanceAddr))
+	}
+	if err := client.SetAddress(leader.LeaderAddress); err != nil {
+		return errors.New(fmt.Sprintf("failed to use active node %s of %s: %v", leader.LeaderAddress, instanceAddr, err))
+	}
+	Logger(instanceAddr, "").WithFields(log.Fields{
+		"active": leader.LeaderAddress,
+	}).Info("[Vault Client] following standby node to active node")
+	return nil
+}
+
+// healthState determines if a node is a standby node
+// an error is returned for nodes that cannot serve requests
+func healthState(health *api.HealthResponse) (bool, error) {
+	switch {
+	case !health.Initialized:
+		return false, errors.New("is not initialized")
+	case health.Sealed:
+		return false, errors.New("is sealed")
+	case health.Standby && !health.PerformanceStandby:
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,53 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthState(t *testing.T) {
+	table := []struct {
+		description string
+		health      api.HealthResponse
+		standby     bool
+		expectErr   bool
+	}{
+		{
+			description: "active node",
+			health:      api.HealthResponse{Initialized: true},
+		},
+		{
+			description: "sealed node",
+			health:      api.HealthResponse{Initialized: true, Sealed: true},
+			expectErr:   true,
+		},
+		{
+			description: "uninitialized node",
+			health:      api.HealthResponse{Sealed: true},
+			expectErr:   true,
+		},
+		{
+			description: "standby node",
+			health:      api.HealthResponse{Initialized: true, Standby: true},
+			standby:     true,
+		},
+		{
+			description: "performance standby node",
+			health:      api.HealthResponse{Initialized: true, Standby: true, PerformanceStandby: true},
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			standby, err := healthState(&tt.health)
+			if tt.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.standby, standby)
+		})
+	}
+}
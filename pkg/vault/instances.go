@@ -3,6 +3,9 @@ package vault
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"sort"
 	"strings"
 	"sync"
 
@@ -10,12 +13,68 @@ import (
 
 	"github.com/app-sre/vault-manager/pkg/utils"
 	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/consts"
 	log "github.com/sirupsen/logrus"
 )
 
 type Instance struct {
-	Address string `yaml:"address"`
-	Auth    auth   `yaml:"auth"`
+	Address   string `yaml:"address"`
+	Namespace string `yaml:"namespace"`
+	Auth      auth   `yaml:"auth"`
+	TLS       *tls   `yaml:"tls"`
+	// SystemLeaseTTLs opts the instance into checking its system lease ttls for drift
+	SystemLeaseTTLs *leaseTTLs `yaml:"systemLeaseTTLs"`
+	// Headers are sent with every request to the instance, e.g. for a proxy in front of it
+	Headers map[string]string `yaml:"headers"`
+}
+
+// UnmarshalYAML resolves environment variables referenced by the address, e.g. `${VAULT_ADDR}`, so that
+// the same configuration can be applied to instances of different environments
+func (i *Instance) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Instance
+	if err := unmarshal((*plain)(i)); err != nil {
+		return err
+	}
+	address, err := expandEnv(i.Address)
+	if err != nil {
+		return errors.New(fmt.Sprintf("[Vault Instance] failed to resolve address `%s`: %v", i.Address, err))
+	}
+	i.Address = address
+	// header values are not part of the error as they may be credentials
+	for name, value := range i.Headers {
+		expanded, err := expandEnv(value)
+		if err != nil {
+			return errors.New(fmt.Sprintf("[Vault Instance] failed to resolve header `%s` of %s: %v", name, i.Address, err))
+		}
+		i.Headers[name] = expanded
+	}
+	return nil
+}
+
+// expandEnv replaces `${VAR}` and `$VAR` references with the values of the environment variables
+// referencing an unset variable is an error, while variables set to an empty value are permitted
+func expandEnv(s string) (string, error) {
+	unset := []string{}
+	expanded := os.Expand(s, func(name string) string {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			unset = append(unset, name)
+		}
+		return value
+	})
+	if len(unset) > 0 {
+		return "", errors.New(fmt.Sprintf("environment variable `%s` is not set", strings.Join(unset, "`, `")))
+	}
+	return expanded, nil
+}
+
+// Key returns the identifier used to reference an instance throughout reconciliation
+// instances sharing an address are differentiated by their enterprise namespace
+func (i Instance) Key() string {
+	if i.Namespace == "" {
+		return i.Address
+	}
+	return fmt.Sprintf("%s|%s", i.Address, strings.Trim(i.Namespace, "/"))
 }
 
 type auth struct {
@@ -24,6 +83,41 @@ type auth struct {
 	RoleID       secret `yaml:"roleID"`
 	SecretID     secret `yaml:"secretID"`
 	Token        secret `yaml:"token"`
+	// kubernetes auth settings, the token path and mount are optional
+	KubernetesRole      string `yaml:"kubernetesRole"`
+	KubernetesTokenPath string `yaml:"kubernetesTokenPath"`
+	KubernetesMount     string `yaml:"kubernetesMount"`
+	// TokenSinkPath is the file a vault agent writes the token of its auto-auth to
+	TokenSinkPath string `yaml:"tokenSinkPath"`
+}
+
+// provider returns the configured auth provider
+// when unset, the provider is selected based on which credentials are populated
+func (a auth) provider() string {
+	switch {
+	case a.Provider != "":
+		return strings.ToLower(a.Provider)
+	case a.KubernetesRole != "":
+		return KUBERNETES_AUTH
+	case a.TokenSinkPath != "":
+		return AGENT_SINK_AUTH
+	case a.RoleID.Path != "" && a.SecretID.Path != "":
+		return APPROLE_AUTH
+	case a.Token.Path != "":
+		return TOKEN_AUTH
+	default:
+		return ""
+	}
+}
+
+// tls configures how the certificate of an instance is verified, e.g. for instances using an internal CA
+// instances without tls settings use the settings of the `VAULT_CACERT` family of env vars
+type tls struct {
+	CACert     string `yaml:"caCert"`
+	ClientCert string `yaml:"clientCert"`
+	ClientKey  string `yaml:"clientKey"`
+	// InsecureSkipVerify disables verification of the instance's certificate and is discouraged
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
 }
 
 type secret struct {
@@ -41,8 +135,25 @@ type VaultSecret struct {
 }
 
 type AuthBundle struct {
+	Address      string
+	Namespace    string
 	SecretEngine string
+	Provider     string
 	VaultSecrets []*VaultSecret
+	Kubernetes   KubernetesLogin
+	// TokenSinkPath is only set for instances using agent sink auth
+	TokenSinkPath string
+	// TLS is only set for instances with tls settings
+	TLS *api.TLSConfig
+	// Headers is only set for instances with custom headers
+	Headers http.Header
+}
+
+// KubernetesLogin contains the settings used to login via kubernetes auth
+type KubernetesLogin struct {
+	Role      string
+	TokenPath string
+	Mount     string
 }
 
 // names to assign to access attributes
@@ -54,6 +165,15 @@ const (
 	TOKEN_AUTH   = "token"
 	KV_V1        = "kv_v1"
 	KV_V2        = "kv_v2"
+	// WRAPPED_TOKEN_AUTH authenticates with the token obtained by unwrapping a response-wrapped token
+	WRAPPED_TOKEN_AUTH = "wrapped_token"
+	// KUBERNETES_AUTH authenticates with the service account token of the pod vault-manager runs in
+	KUBERNETES_AUTH = "kubernetes"
+	// AGENT_SINK_AUTH authenticates with the token a vault agent writes to a sink file
+	AGENT_SINK_AUTH = "agent_sink"
+
+	defaultKubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	defaultKubernetesMount     = "kubernetes"
 )
 
 // global that maps instance addresses to configured vault clients
@@ -61,36 +181,118 @@ const (
 // GetInstances() is called a single time within main
 var vaultClients map[string]*api.Client
 
+// tracks instances that failed client initialization or reconciliation
+// reset with each call to GetInstances()
+var (
+	invalidInstances  = make(map[string]bool)
+	invalidInstancesM sync.Mutex
+)
+
+// instances limited to by SetInstanceFilter
+var (
+	includedInstances []string
+	excludedInstances []string
+)
+
+// SetInstanceFilter limits reconciliation to the included instances, or all instances if none are included,
+// without the excluded instances. Instances are referenced by address, which includes all namespaces of the
+// address, or by key (`<address>|<namespace>`).
+// must be called prior to GetInstances()
+func SetInstanceFilter(include, exclude []string) {
+	includedInstances = include
+	excludedInstances = exclude
+}
+
+// filterInstances applies the instance filter, referencing an instance missing from the configuration is an error
+func filterInstances(instances []Instance, include, exclude []string) ([]Instance, error) {
+	matches := func(i Instance, refs []string) bool {
+		for _, ref := range refs {
+			if ref == i.Address || ref == i.Key() {
+				return true
+			}
+		}
+		return false
+	}
+	for _, ref := range append(append([]string{}, include...), exclude...) {
+		found := false
+		for _, i := range instances {
+			if matches(i, []string{ref}) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New(fmt.Sprintf("instance `%s` is not configured", ref))
+		}
+	}
+
+	filtered := []Instance{}
+	for _, i := range instances {
+		if len(include) > 0 && !matches(i, include) {
+			continue
+		}
+		if matches(i, exclude) {
+			continue
+		}
+		filtered = append(filtered, i)
+	}
+	return filtered, nil
+}
+
 // Utilized to initialize vault instance clients for use by other toplevel integrations
-// returns list of instance addresses being included in reconcile
+// returns list of instance keys being included in reconcile
 func GetInstances(entriesBytes []byte, threadPoolSize int) []string {
 	var instances []Instance
 	if err := yaml.Unmarshal(entriesBytes, &instances); err != nil {
 		log.WithError(err).Fatal("[Vault Instance] failed to decode instance configuration")
 	}
+	instances, err := filterInstances(instances, includedInstances, excludedInstances)
+	if err != nil {
+		log.WithError(err).Fatal("[Vault Instance] invalid instance filter")
+	}
 
 	instanceCreds, err := processInstances(instances)
 	if err != nil {
 		log.WithError(err).Fatal("[Vault Instance] failed to retrieve access credentials")
 	}
 	initClients(instanceCreds, threadPoolSize)
+	desiredLeaseTTLs = map[string]leaseTTLs{}
+	for _, i := range instances {
+		if i.SystemLeaseTTLs != nil {
+			desiredLeaseTTLs[i.Key()] = *i.SystemLeaseTTLs
+		}
+	}
+
+	// return list of instance keys that clients were initialized for
+	keys := []string{}
+	for key := range vaultClients {
+		keys = append(keys, key)
+	}
+	return keys
+}
 
-	// return list of addresses that clients were initialized for
-	addresses := []string{}
-	for address := range vaultClients {
-		addresses = append(addresses, address)
+// ValidateInstances ensures the auth attributes of each instance are complete
+// no credentials are read and no clients are initialized
+func ValidateInstances(entriesBytes []byte) error {
+	var instances []Instance
+	if err := yaml.Unmarshal(entriesBytes, &instances); err != nil {
+		return errors.New(fmt.Sprintf("[Vault Instance] failed to decode instance configuration: %v", err))
 	}
-	return addresses
+	_, err := processInstances(instances)
+	return err
 }
 
-// generates map of instance addresses to access credentials stored in master vault
+// generates map of instance keys to access credentials stored in master vault
 func processInstances(instances []Instance) (map[string]AuthBundle, error) {
 	instanceCreds := make(map[string]AuthBundle)
 	for _, i := range instances {
 		bundle := AuthBundle{
+			Address:      i.Address,
+			Namespace:    i.Namespace,
 			SecretEngine: i.Auth.SecretEngine,
+			Provider:     i.Auth.provider(),
 		}
-		switch strings.ToLower(i.Auth.Provider) {
+		switch bundle.Provider {
 		case APPROLE_AUTH:
 			// ensure required values exist
 			if i.Auth.RoleID.Field == "" || i.Auth.RoleID.Path == "" ||
@@ -113,24 +315,70 @@ func processInstances(instances []Instance) (map[string]AuthBundle, error) {
 					Version: i.Auth.SecretID.Version,
 				},
 			}
-		case TOKEN_AUTH:
+		case TOKEN_AUTH, WRAPPED_TOKEN_AUTH:
 			if i.Auth.Token.Field == "" || i.Auth.Token.Path == "" {
 				return nil, errors.New("A required token authentication attribute is missing")
 			}
 			bundle.VaultSecrets = []*VaultSecret{
 				{
 					Name:    TOKEN,
-					Type:    TOKEN_AUTH,
+					Type:    bundle.Provider,
 					Path:    i.Auth.Token.Path,
 					Field:   i.Auth.Token.Field,
 					Version: i.Auth.Token.Version,
 				},
 			}
+		case KUBERNETES_AUTH:
+			if i.Auth.KubernetesRole == "" {
+				return nil, errors.New("A required kubernetes authentication attribute is missing")
+			}
+			bundle.Kubernetes = KubernetesLogin{
+				Role:      i.Auth.KubernetesRole,
+				TokenPath: defaultString(i.Auth.KubernetesTokenPath, defaultKubernetesTokenPath),
+				Mount:     defaultString(i.Auth.KubernetesMount, defaultKubernetesMount),
+			}
+		case AGENT_SINK_AUTH:
+			if i.Auth.TokenSinkPath == "" {
+				return nil, errors.New("A required agent sink authentication attribute is missing")
+			}
+			bundle.TokenSinkPath = i.Auth.TokenSinkPath
 		default:
 			return nil, errors.New(fmt.Sprintf(
 				"Unable to process `auth` attribute of instance definition with address %s", i.Address))
 		}
-		instanceCreds[i.Address] = bundle
+		if i.SystemLeaseTTLs != nil {
+			if i.Namespace != "" {
+				return nil, errors.New(fmt.Sprintf(
+					"`systemLeaseTTLs` of instance definition with address %s cannot be set for a namespace", i.Address))
+			}
+			if err := i.SystemLeaseTTLs.validate(); err != nil {
+				return nil, errors.New(fmt.Sprintf(
+					"invalid `systemLeaseTTLs` of instance definition with address %s: %v", i.Address, err))
+			}
+		}
+		if i.TLS != nil {
+			if (i.TLS.ClientCert == "") != (i.TLS.ClientKey == "") {
+				return nil, errors.New(fmt.Sprintf(
+					"`clientCert` and `clientKey` of instance definition with address %s must be set together", i.Address))
+			}
+			bundle.TLS = &api.TLSConfig{
+				CACert:     i.TLS.CACert,
+				ClientCert: i.TLS.ClientCert,
+				ClientKey:  i.TLS.ClientKey,
+				Insecure:   i.TLS.InsecureSkipVerify,
+			}
+		}
+		if len(i.Headers) > 0 {
+			bundle.Headers = make(http.Header, len(i.Headers))
+			for name, value := range i.Headers {
+				if err := validateHeader(name); err != nil {
+					return nil, errors.New(fmt.Sprintf(
+						"invalid header of instance definition with address %s: %v", i.Address, err))
+				}
+				bundle.Headers.Set(name, value)
+			}
+		}
+		instanceCreds[i.Key()] = bundle
 	}
 	return instanceCreds, nil
 }
@@ -139,15 +387,23 @@ func processInstances(instances []Instance) (map[string]AuthBundle, error) {
 // This allows reconciliation of multiple vault instances
 func initClients(instanceCreds map[string]AuthBundle, threadPoolSize int) {
 	vaultClients = make(map[string]*api.Client)
-	masterAddress := configureMaster()
+	invalidInstancesM.Lock()
+	invalidInstances = make(map[string]bool)
+	invalidInstancesM.Unlock()
+	resetFailures()
+	resetProgress()
+	resetVersions()
+	invalidateMountAccessors("")
+	stopTokenWatchers()
+	masterAddress := configureMaster(instanceCreds)
 	bwg := utils.NewBoundedWaitGroup(threadPoolSize)
 	var mutex = &sync.Mutex{}
 	// read access credentials for other vault instances and configure clients
-	for addr, bundle := range instanceCreds {
+	for key, bundle := range instanceCreds {
 		// client already configured separately for master
-		if addr != masterAddress {
+		if key != masterAddress {
 			bwg.Add(1)
-			go createClient(addr, masterAddress, bundle, &bwg, mutex)
+			go createClient(key, masterAddress, bundle, &bwg, mutex)
 		}
 	}
 	bwg.Wait()
@@ -155,32 +411,60 @@ func initClients(instanceCreds map[string]AuthBundle, threadPoolSize int) {
 
 // configureMaster initializes vault client for the master instance
 // This is the only client configured using environment variables
-// env vars: VAULT_ADDR, VAULT_AUTHTYPE, VAULT_ROLE_ID, VAULT_SECRET_ID, VAULT_TOKEN
-func configureMaster() string {
+// env vars: VAULT_ADDR, VAULT_AUTHTYPE, VAULT_ROLE_ID, VAULT_SECRET_ID, VAULT_TOKEN, VAULT_WRAPPING_TOKEN,
+// VAULT_KUBERNETES_ROLE, VAULT_KUBERNETES_TOKEN_PATH, VAULT_KUBERNETES_MOUNT
+// custom headers are the headers of the instance definition of the master instance, if any
+func configureMaster(instanceCreds map[string]AuthBundle) string {
 	masterVaultCFG := api.DefaultConfig()
 	masterVaultCFG.Address = mustGetenv("VAULT_ADDR")
+	configureRetries(masterVaultCFG)
 
 	client, err := api.NewClient(masterVaultCFG)
 	if err != nil {
 		log.WithError(err).Fatal("failed to initialize master Vault client")
 	}
+	setHeaders(masterVaultCFG.Address, client, instanceCreds[masterVaultCFG.Address].Headers)
+
+	err = checkHealth(masterVaultCFG.Address, client)
+	if err != nil {
+		log.WithError(err).Fatal("[Vault Client] master Vault is not available for reconciliation")
+	}
 
 	var clientToken string
-	switch authType := defaultGetenv("VAULT_AUTHTYPE", "approle"); strings.ToLower(authType) {
+	switch authType := defaultGetenv("VAULT_AUTHTYPE", masterAuthType()); strings.ToLower(authType) {
 	case APPROLE_AUTH:
 		roleID := mustGetenv("VAULT_ROLE_ID")
 		secretID := mustGetenv("VAULT_SECRET_ID")
 
-		secret, err := client.Logical().Write("auth/approle/login", map[string]interface{}{
-			"role_id":   roleID,
-			"secret_id": secretID,
-		})
+		err := LoginAppRole(masterVaultCFG.Address, client, roleID, secretID)
 		if err != nil {
 			log.WithError(err).Fatal("[Vault Client] failed to login to master Vault with AppRole")
 		}
-		clientToken = secret.Auth.ClientToken
+		clientToken = client.Token()
 	case TOKEN_AUTH:
 		clientToken = mustGetenv("VAULT_TOKEN")
+	case WRAPPED_TOKEN_AUTH:
+		clientToken, err = UnwrapToken(client, mustGetenv("VAULT_WRAPPING_TOKEN"))
+		if err != nil {
+			log.WithError(err).Fatal("[Vault Client] failed to login to master Vault with wrapped token")
+		}
+	case KUBERNETES_AUTH:
+		err := LoginKubernetes(masterVaultCFG.Address, client,
+			defaultGetenv("VAULT_KUBERNETES_MOUNT", defaultKubernetesMount),
+			mustGetenv("VAULT_KUBERNETES_ROLE"),
+			defaultGetenv("VAULT_KUBERNETES_TOKEN_PATH", defaultKubernetesTokenPath))
+		if err != nil {
+			log.WithError(err).Fatal("[Vault Client] failed to login to master Vault with kubernetes auth")
+		}
+		clientToken = client.Token()
+	case AGENT_SINK_AUTH:
+		sinkPath := mustGetenv("VAULT_TOKEN_SINK_PATH")
+		clientToken, err = TokenFromSink(sinkPath)
+		if err != nil {
+			log.WithError(err).Fatal("[Vault Client] failed to login to master Vault with agent sink token")
+		}
+		watchSink(masterVaultCFG.Address, client, sinkPath)
+		retryDeniedWithSink(masterVaultCFG.Address, masterVaultCFG, client, sinkPath)
 	default:
 		log.WithField("authType", authType).Fatal("[Vault Client] unsupported auth type")
 	}
@@ -190,9 +474,28 @@ func configureMaster() string {
 	return masterVaultCFG.Address
 }
 
+// masterAuthType selects the auth type of the master instance when `VAULT_AUTHTYPE` is unset
+// based on which credentials are set, defaulting to approle
+func masterAuthType() string {
+	switch {
+	case os.Getenv("VAULT_ROLE_ID") != "":
+		return APPROLE_AUTH
+	case os.Getenv("VAULT_KUBERNETES_ROLE") != "":
+		return KUBERNETES_AUTH
+	case os.Getenv("VAULT_WRAPPING_TOKEN") != "":
+		return WRAPPED_TOKEN_AUTH
+	case os.Getenv("VAULT_TOKEN_SINK_PATH") != "":
+		return AGENT_SINK_AUTH
+	case os.Getenv("VAULT_TOKEN") != "":
+		return TOKEN_AUTH
+	default:
+		return APPROLE_AUTH
+	}
+}
+
 // goroutine support function for initClients()
 // initializes one vault client
-func createClient(addr, masterAddress string, bundle AuthBundle, bwg *utils.BoundedWaitGroup, mutex *sync.Mutex) {
+func createClient(key, masterAddress string, bundle AuthBundle, bwg *utils.BoundedWaitGroup, mutex *sync.Mutex) {
 	defer bwg.Done()
 
 	accessCreds := make(map[string]string)
@@ -207,33 +510,102 @@ func createClient(addr, masterAddress string, bundle AuthBundle, bwg *utils.Boun
 
 	// Init new client
 	config := api.DefaultConfig()
-	config.Address = addr
+	config.Address = bundle.Address
+	configureRetries(config)
+	if bundle.TLS != nil {
+		if bundle.TLS.Insecure {
+			Logger(key, "").Warn("[Vault Client] certificate verification is disabled for instance, use `caCert` instead")
+		}
+		err := config.ConfigureTLS(bundle.TLS)
+		if err != nil {
+			Logger(key, "").WithError(err).Error("[Vault Client] failed to configure tls")
+			fmt.Println(fmt.Sprintf("SKIPPING ALL RECONCILIATION FOR: %s\n", key))
+			RecordFailure(key, "", "initialize client", "", err)
+			AddInvalid(key)
+			return
+		}
+	}
 	client, err := api.NewClient(config)
 	if err != nil {
 		log.WithError(err)
-		fmt.Println(fmt.Sprintf("Failed to initialize Vault client for %s", addr))
-		fmt.Println(fmt.Sprintf("SKIPPING ALL RECONCILIATION FOR: %s\n", addr))
+		fmt.Println(fmt.Sprintf("Failed to initialize Vault client for %s", key))
+		fmt.Println(fmt.Sprintf("SKIPPING ALL RECONCILIATION FOR: %s\n", key))
+		RecordFailure(key, "", "initialize client", "", err)
+		AddInvalid(key)
 		return // skip entire reconcilation for this instance
 	}
+	setHeaders(key, client, bundle.Headers)
+
+	// sealed and standby nodes fail every request with errors unrelated to the actual cause
+	err = checkHealth(key, client)
+	if err != nil {
+		Logger(key, "").WithError(err).Error("[Vault Client] instance is not available for reconciliation")
+		fmt.Println(fmt.Sprintf("SKIPPING ALL RECONCILIATION FOR: %s\n", key))
+		RecordFailure(key, "", "health check", "", err)
+		AddInvalid(key)
+		return
+	}
+
+	if bundle.Namespace != "" {
+		// oss vault ignores the namespace header and would otherwise apply
+		// namespaced configuration to the root of the instance
+		err = checkNamespaceSupport(client)
+		if err != nil {
+			Logger(key, "").WithError(err).WithField("namespace", bundle.Namespace).Info(
+				"[Vault Client] namespace configured for instance without namespace support")
+			fmt.Println(fmt.Sprintf("SKIPPING ALL RECONCILIATION FOR: %s\n", key))
+			RecordFailure(key, "", "namespace check", "", err)
+			AddInvalid(key)
+			return
+		}
+		client.SetNamespace(bundle.Namespace)
+	}
 
-	// at minimum, one element will exist in secrets regardless of type
-	// type is same across all VaultSecrets associated with a particular instance address
 	var token string
-	switch bundle.VaultSecrets[0].Type {
+	switch bundle.Provider {
 	case APPROLE_AUTH:
-		t, err := client.Logical().Write("auth/approle/login", map[string]interface{}{
-			"role_id":   accessCreds[ROLE_ID],
-			"secret_id": accessCreds[SECRET_ID],
-		})
+		err := LoginAppRole(key, client, accessCreds[ROLE_ID], accessCreds[SECRET_ID])
 		if err != nil {
 			log.WithError(err)
-			fmt.Println(fmt.Sprintf("[Vault Client] failed to login to %s with AppRole credentials", addr))
-			fmt.Println(fmt.Sprintf("SKIPPING ALL RECONCILIATION FOR: %s\n", addr))
+			fmt.Println(fmt.Sprintf("[Vault Client] failed to login to %s with AppRole credentials", key))
+			fmt.Println(fmt.Sprintf("SKIPPING ALL RECONCILIATION FOR: %s\n", key))
+			RecordFailure(key, "", "login", "", err)
+			AddInvalid(key)
 			return // skip entire reconcilation for this instance
 		}
-		token = t.Auth.ClientToken
+		token = client.Token()
 	case TOKEN_AUTH:
 		token = accessCreds[TOKEN]
+	case WRAPPED_TOKEN_AUTH:
+		token, err = UnwrapToken(client, accessCreds[TOKEN])
+		if err != nil {
+			Logger(key, "").WithError(err).Error("[Vault Client] failed to login with wrapped token")
+			fmt.Println(fmt.Sprintf("SKIPPING ALL RECONCILIATION FOR: %s\n", key))
+			RecordFailure(key, "", "login", "", err)
+			AddInvalid(key)
+			return // skip entire reconcilation for this instance
+		}
+	case KUBERNETES_AUTH:
+		err := LoginKubernetes(key, client, bundle.Kubernetes.Mount, bundle.Kubernetes.Role, bundle.Kubernetes.TokenPath)
+		if err != nil {
+			Logger(key, "").WithError(err).Info("[Vault Client] failed to login with kubernetes auth")
+			fmt.Println(fmt.Sprintf("SKIPPING ALL RECONCILIATION FOR: %s\n", key))
+			RecordFailure(key, "", "login", "", err)
+			AddInvalid(key)
+			return // skip entire reconcilation for this instance
+		}
+		token = client.Token()
+	case AGENT_SINK_AUTH:
+		token, err = TokenFromSink(bundle.TokenSinkPath)
+		if err != nil {
+			Logger(key, "").WithError(err).Error("[Vault Client] failed to login with agent sink token")
+			fmt.Println(fmt.Sprintf("SKIPPING ALL RECONCILIATION FOR: %s\n", key))
+			RecordFailure(key, "", "login", "", err)
+			AddInvalid(key)
+			return // skip entire reconcilation for this instance
+		}
+		watchSink(key, client, bundle.TokenSinkPath)
+		retryDeniedWithSink(key, config, client, bundle.TokenSinkPath)
 	}
 
 	// add new address/client pair to global
@@ -245,15 +617,94 @@ func createClient(addr, masterAddress string, bundle AuthBundle, bwg *utils.Boun
 	_, err = client.Sys().ListAuth()
 	if err != nil {
 		log.WithError(err)
-		fmt.Println(fmt.Sprintf("[Vault Client] failed to login to %s", addr))
-		fmt.Println(fmt.Sprintf("SKIPPING ALL RECONCILIATION FOR: %s\n", addr))
+		fmt.Println(fmt.Sprintf("[Vault Client] failed to login to %s", key))
+		fmt.Println(fmt.Sprintf("SKIPPING ALL RECONCILIATION FOR: %s\n", key))
+		RecordFailure(key, "", "login", "", err)
+		AddInvalid(key)
+		return
+	}
+
+	vaultClients[key] = client
+}
+
+// headers set by the client itself that must not be overridden
+var reservedHeaders = map[string]bool{
+	http.CanonicalHeaderKey(consts.AuthHeaderName):      true,
+	http.CanonicalHeaderKey(consts.NamespaceHeaderName): true,
+}
+
+// validateHeader ensures a custom header has a valid name that is not set by the client itself
+func validateHeader(name string) error {
+	if name == "" || strings.ContainsAny(name, " :\r\n") {
+		return errors.New(fmt.Sprintf("`%s` is not a valid header name", name))
+	}
+	if reservedHeaders[http.CanonicalHeaderKey(name)] {
+		return errors.New(fmt.Sprintf("`%s` is set by vault-manager and cannot be configured", name))
+	}
+	return nil
+}
+
+// setHeaders adds custom headers to those sent with every request of a client
+// must be called before the namespace of the client is set
+func setHeaders(key string, client *api.Client, headers http.Header) {
+	if len(headers) == 0 {
 		return
 	}
+	merged := client.Headers()
+	if merged == nil {
+		merged = make(http.Header)
+	}
+	logged := make(map[string]string, len(headers))
+	for name := range headers {
+		merged.Set(name, headers.Get(name))
+		logged[name] = headers.Get(name)
+	}
+	client.SetHeaders(merged)
+	Logger(key, "").WithField("headers", utils.RedactHeaders(logged)).Debug("[Vault Client] custom headers configured")
+}
+
+// AddInvalid marks an instance as failed for the current reconcile
+func AddInvalid(key string) {
+	invalidInstancesM.Lock()
+	defer invalidInstancesM.Unlock()
+	invalidInstances[key] = true
+}
 
-	vaultClients[addr] = client
+// IsInvalid returns whether an instance has been marked as failed for the current reconcile
+func IsInvalid(key string) bool {
+	invalidInstancesM.Lock()
+	defer invalidInstancesM.Unlock()
+	return invalidInstances[key]
+}
+
+// InvalidInstances returns a sorted list of instances marked as failed for the current reconcile
+func InvalidInstances() []string {
+	invalidInstancesM.Lock()
+	defer invalidInstancesM.Unlock()
+	keys := []string{}
+	for key := range invalidInstances {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// namespaces are only available within vault enterprise
+// enterprise versions are reported with `+ent` build metadata
+func checkNamespaceSupport(client *api.Client) error {
+	info, err := client.Sys().Health()
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(info.Version, "+ent") {
+		return errors.New(fmt.Sprintf(
+			"namespaces require Vault Enterprise but %s reports version %s", client.Address(), info.Version))
+	}
+	return nil
 }
 
-// returns the vault client associated with instance address
+// returns the vault client associated with instance key
+// see Instance.Key() for the format of keys referencing namespaced instances
 func getClient(instanceAddr string) *api.Client {
 	if vaultClients[instanceAddr] == nil {
 		log.Fatalf("[Vault Client] client does not exist for address: %s", instanceAddr)
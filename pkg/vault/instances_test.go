@@ -0,0 +1,237 @@
+package vault
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestAuthProvider(t *testing.T) {
+	table := []struct {
+		description string
+		auth        auth
+		expected    string
+	}{
+		{
+			description: "configured provider is used",
+			auth:        auth{Provider: "Token", RoleID: secret{Path: "a"}, SecretID: secret{Path: "b"}},
+			expected:    TOKEN_AUTH,
+		},
+		{
+			description: "approle selected from role and secret ids",
+			auth:        auth{RoleID: secret{Path: "a"}, SecretID: secret{Path: "b"}},
+			expected:    APPROLE_AUTH,
+		},
+		{
+			description: "token selected from token",
+			auth:        auth{Token: secret{Path: "a"}},
+			expected:    TOKEN_AUTH,
+		},
+		{
+			description: "kubernetes selected from kubernetes role",
+			auth:        auth{KubernetesRole: "vault-manager"},
+			expected:    KUBERNETES_AUTH,
+		},
+		{
+			description: "agent sink selected from token sink path",
+			auth:        auth{TokenSinkPath: "/vault/agent/token"},
+			expected:    AGENT_SINK_AUTH,
+		},
+		{
+			description: "role id without secret id is not selected",
+			auth:        auth{RoleID: secret{Path: "a"}},
+			expected:    "",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.auth.provider())
+		})
+	}
+}
+
+func TestTokenFromSink(t *testing.T) {
+	dir := t.TempDir()
+
+	sink := filepath.Join(dir, "token")
+	require.NoError(t, ioutil.WriteFile(sink, []byte("s.token\n"), 0600))
+	token, err := TokenFromSink(sink)
+	require.NoError(t, err)
+	require.Equal(t, "s.token", token)
+
+	empty := filepath.Join(dir, "empty")
+	require.NoError(t, ioutil.WriteFile(empty, []byte("\n"), 0600))
+	_, err = TokenFromSink(empty)
+	require.Error(t, err)
+
+	_, err = TokenFromSink(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
+
+func TestValidateInstancesTLS(t *testing.T) {
+	instance := "- address: https://vault.example.com\n  auth:\n    tokenSinkPath: /vault/agent/token\n"
+
+	require.NoError(t, ValidateInstances([]byte(instance+"  tls:\n    caCert: /etc/vault/ca.pem\n")))
+	require.NoError(t, ValidateInstances([]byte(
+		instance+"  tls:\n    clientCert: /etc/vault/tls.crt\n    clientKey: /etc/vault/tls.key\n")))
+	require.Error(t, ValidateInstances([]byte(instance+"  tls:\n    clientCert: /etc/vault/tls.crt\n")))
+
+	creds, err := processInstances([]Instance{{
+		Address: "https://vault.example.com",
+		Auth:    auth{TokenSinkPath: "/vault/agent/token"},
+		TLS:     &tls{CACert: "/etc/vault/ca.pem", InsecureSkipVerify: true},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, "/etc/vault/ca.pem", creds["https://vault.example.com"].TLS.CACert)
+	require.True(t, creds["https://vault.example.com"].TLS.Insecure)
+}
+
+func TestFilterInstances(t *testing.T) {
+	instances := []Instance{
+		{Address: "https://a.example.com"},
+		{Address: "https://a.example.com", Namespace: "team"},
+		{Address: "https://b.example.com"},
+	}
+	keys := func(xs []Instance) []string {
+		result := []string{}
+		for _, x := range xs {
+			result = append(result, x.Key())
+		}
+		return result
+	}
+
+	table := []struct {
+		description string
+		include     []string
+		exclude     []string
+		expected    []string
+		err         bool
+	}{
+		{
+			description: "no filter",
+			expected:    []string{"https://a.example.com", "https://a.example.com|team", "https://b.example.com"},
+		},
+		{
+			description: "include address with all namespaces",
+			include:     []string{"https://a.example.com"},
+			expected:    []string{"https://a.example.com", "https://a.example.com|team"},
+		},
+		{
+			description: "exclude namespace",
+			exclude:     []string{"https://a.example.com|team"},
+			expected:    []string{"https://a.example.com", "https://b.example.com"},
+		},
+		{
+			description: "exclude wins over include",
+			include:     []string{"https://a.example.com"},
+			exclude:     []string{"https://a.example.com|team"},
+			expected:    []string{"https://a.example.com"},
+		},
+		{
+			description: "unknown instance",
+			include:     []string{"https://c.example.com"},
+			err:         true,
+		},
+		{
+			description: "unknown excluded instance",
+			exclude:     []string{"https://b.example.com|team"},
+			err:         true,
+		},
+	}
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			filtered, err := filterInstances(instances, tt.include, tt.exclude)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, keys(filtered))
+		})
+	}
+}
+
+func TestValidateInstancesSystemLeaseTTLs(t *testing.T) {
+	instance := "- address: https://vault.example.com\n  auth:\n    tokenSinkPath: /vault/agent/token\n"
+
+	require.NoError(t, ValidateInstances([]byte(instance+"  systemLeaseTTLs:\n    defaultLeaseTTL: 24h\n    maxLeaseTTL: 768h\n")))
+	require.NoError(t, ValidateInstances([]byte(instance+"  systemLeaseTTLs:\n    maxLeaseTTL: 2764800\n")))
+	require.Error(t, ValidateInstances([]byte(instance+"  systemLeaseTTLs:\n    defaultLeaseTTL: 768h\n    maxLeaseTTL: 24h\n")))
+	require.Error(t, ValidateInstances([]byte(instance+"  systemLeaseTTLs:\n    defaultLeaseTTL: a day\n")))
+	require.Error(t, ValidateInstances([]byte(instance+"  namespace: team\n  systemLeaseTTLs:\n    maxLeaseTTL: 24h\n")))
+}
+
+func TestInstanceAddressFromEnv(t *testing.T) {
+	t.Setenv("TEST_VAULT_ADDR", "https://vault.stage.example.com")
+
+	var instances []Instance
+	require.NoError(t, yaml.Unmarshal([]byte(
+		"- address: ${TEST_VAULT_ADDR}\n- address: $TEST_VAULT_ADDR\n  namespace: team\n- address: https://vault.example.com\n"),
+		&instances))
+	require.Equal(t, "https://vault.stage.example.com", instances[0].Key())
+	require.Equal(t, "https://vault.stage.example.com|team", instances[1].Key())
+	require.Equal(t, "https://vault.example.com", instances[2].Key())
+
+	err := yaml.Unmarshal([]byte("- address: ${TEST_VAULT_ADDR_UNSET}\n"), &instances)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "TEST_VAULT_ADDR_UNSET")
+}
+
+func TestProcessInstancesHeaders(t *testing.T) {
+	t.Setenv("GATEWAY_JWT", "secret-jwt")
+	instance := "- address: https://vault.example.com\n  auth:\n    tokenSinkPath: /vault/agent/token\n"
+
+	var instances []Instance
+	require.NoError(t, yaml.Unmarshal([]byte(instance+
+		"  headers:\n    x-gateway-jwt: ${GATEWAY_JWT}\n    X-Tenant: team-a\n"), &instances))
+	creds, err := processInstances(instances)
+	require.NoError(t, err)
+	headers := creds["https://vault.example.com"].Headers
+	require.Equal(t, "secret-jwt", headers.Get("X-Gateway-Jwt"), "values may reference environment variables")
+	require.Equal(t, "team-a", headers.Get("X-Tenant"))
+
+	require.Error(t, yaml.Unmarshal([]byte(instance+"  headers:\n    X-Tenant: ${UNSET_TENANT}\n"), &instances))
+	require.Error(t, ValidateInstances([]byte(instance+"  headers:\n    X-Vault-Token: x\n")))
+	require.Error(t, ValidateInstances([]byte(instance+"  headers:\n    x-vault-namespace: team\n")))
+	require.NoError(t, ValidateInstances([]byte(instance+"  headers:\n    X-Tenant: team-a\n")))
+}
+
+func TestRetryDeniedWithSink(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(consts.AuthHeaderName) != "s.rotated" || (r.Method == http.MethodPut && len(body) == 0) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, ioutil.WriteFile(sink, []byte("s.previous\n"), 0600))
+
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	config.MaxRetries = 0
+	client, err := api.NewClient(config)
+	require.NoError(t, err)
+	client.SetToken("s.previous")
+	retryDeniedWithSink(server.URL, config, client, sink)
+
+	_, err = client.Logical().Write("secret/a", map[string]interface{}{"value": "a"})
+	require.Error(t, err, "requests are not retried while the sink contains the denied token")
+
+	require.NoError(t, ioutil.WriteFile(sink, []byte("s.rotated\n"), 0600))
+	_, err = client.Logical().Write("secret/a", map[string]interface{}{"value": "a"})
+	require.NoError(t, err, "denied requests are retried with the token rotated by vault agent, including their body")
+	require.Equal(t, "s.rotated", client.Token())
+}
@@ -0,0 +1,126 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Lock prevents runs of vault-manager from reconciling concurrently, e.g. a scheduled run overlapping
+// with a manual one. The lock is a kv v2 secret on the master instance written with check-and-set,
+// so that only one run can acquire it, and expires after its ttl in case a run exits without releasing it.
+type Lock struct {
+	path    string
+	holder  string
+	ttl     time.Duration
+	version int
+}
+
+// lockState is the content of the lock secret
+type lockState struct {
+	Holder  string
+	Expires time.Time
+}
+
+// heldByOther returns whether the lock is held by a holder other than holder at the given time
+func (s lockState) heldByOther(holder string, now time.Time) bool {
+	return s.Holder != "" && s.Holder != holder && now.Before(s.Expires)
+}
+
+// AcquireLock acquires the lock stored at the kv v2 secret path of the master instance for ttl
+// an error is returned if another run holds the lock
+func AcquireLock(secretPath string, ttl time.Duration) (*Lock, error) {
+	path, err := FormatSecretPath(secretPath, KV_V2)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	l := &Lock{
+		path:   path,
+		holder: fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		ttl:    ttl,
+	}
+	state, version, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+	if state.heldByOther(l.holder, time.Now()) {
+		return nil, errors.New(fmt.Sprintf("lock %s is held by %s until %s",
+			secretPath, state.Holder, state.Expires.Format(time.RFC3339)))
+	}
+	l.version = version
+	if err := l.write(l.holder, time.Now().Add(ttl)); err != nil {
+		return nil, err
+	}
+	Logger(masterAddress(), "").WithFields(log.Fields{
+		"path":   secretPath,
+		"holder": l.holder,
+	}).Info("[Lock] lock is successfully acquired")
+	return l, nil
+}
+
+// Refresh extends the lock by its ttl
+// an error is returned if the lock expired and was acquired by another run in the meantime
+func (l *Lock) Refresh() error {
+	return l.write(l.holder, time.Now().Add(l.ttl))
+}
+
+// Release releases the lock so that other runs can acquire it without waiting for it to expire
+func (l *Lock) Release() error {
+	if err := l.write("", time.Now()); err != nil {
+		return err
+	}
+	Logger(masterAddress(), "").WithField("holder", l.holder).Info("[Lock] lock is successfully released")
+	return nil
+}
+
+// read returns the state of the lock and the version of the secret, 0 if the lock was never written
+func (l *Lock) read() (lockState, int, error) {
+	state := lockState{}
+	ctx, cancel := requestContext(readTimeout)
+	defer cancel()
+	secret, err := getClient(masterAddress()).Logical().ReadWithContext(ctx, l.path)
+	if err != nil {
+		return state, 0, errors.New(fmt.Sprintf("failed to read lock: %v", err))
+	}
+	if secret == nil || secret.Data == nil {
+		return state, 0, nil
+	}
+	metadata, _ := secret.Data["metadata"].(map[string]interface{})
+	version, _ := strconv.Atoi(fmt.Sprintf("%v", metadata["version"]))
+	data, _ := secret.Data["data"].(map[string]interface{})
+	state.Holder, _ = data["holder"].(string)
+	if expires, ok := data["expires"].(string); ok {
+		state.Expires, _ = time.Parse(time.RFC3339, expires)
+	}
+	return state, version, nil
+}
+
+// write writes the lock if its secret is still at the version last read or written by l
+func (l *Lock) write(holder string, expires time.Time) error {
+	ctx, cancel := writeContext(masterAddress())
+	defer cancel()
+	secret, err := getClient(masterAddress()).Logical().WriteWithContext(ctx, l.path, map[string]interface{}{
+		"options": map[string]interface{}{"cas": l.version},
+		"data": map[string]interface{}{
+			"holder":  holder,
+			"expires": expires.UTC().Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to write lock, it may have been acquired by another run: %v", err))
+	}
+	if secret != nil {
+		l.version, _ = strconv.Atoi(fmt.Sprintf("%v", secret.Data["version"]))
+	}
+	return nil
+}
+
+// masterAddress returns the key of the master instance, which holds the lock
+func masterAddress() string {
+	return mustGetenv("VAULT_ADDR")
+}
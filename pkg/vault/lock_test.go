@@ -0,0 +1,50 @@
+package vault
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockHeldByOther(t *testing.T) {
+	now := time.Now()
+
+	table := []struct {
+		description string
+		state       lockState
+		expected    bool
+	}{
+		{
+			description: "never acquired",
+			state:       lockState{},
+			expected:    false,
+		},
+		{
+			description: "released",
+			state:       lockState{Holder: "", Expires: now.Add(-time.Minute)},
+			expected:    false,
+		},
+		{
+			description: "held by other run",
+			state:       lockState{Holder: "other-1", Expires: now.Add(time.Minute)},
+			expected:    true,
+		},
+		{
+			description: "expired",
+			state:       lockState{Holder: "other-1", Expires: now.Add(-time.Minute)},
+			expected:    false,
+		},
+		{
+			description: "held by this run",
+			state:       lockState{Holder: "this-1", Expires: now.Add(time.Minute)},
+			expected:    false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.state.heldByOther("this-1", now))
+		})
+	}
+}
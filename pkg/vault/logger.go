@@ -0,0 +1,22 @@
+package vault
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Logger returns a log entry populated with the instance, the top-level configuration
+// being applied and the enterprise namespace of the instance, if any, so that logs can be
+// filtered per instance. An empty toplevelName omits the toplevel field.
+func Logger(instanceAddr, toplevelName string) *log.Entry {
+	fields := log.Fields{"instance": instanceAddr}
+	if toplevelName != "" {
+		fields["toplevel"] = toplevelName
+	}
+	// instance keys of namespaced instances are formatted as `<address>|<namespace>`
+	if i := strings.Index(instanceAddr, "|"); i >= 0 {
+		fields["namespace"] = instanceAddr[i+1:]
+	}
+	return log.WithFields(fields)
+}
@@ -0,0 +1,20 @@
+package vault
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger(t *testing.T) {
+	require.Equal(t, log.Fields{
+		"instance": "https://vault.test",
+		"toplevel": "vault_policies",
+	}, Logger("https://vault.test", "vault_policies").Data)
+
+	require.Equal(t, log.Fields{
+		"instance":  "https://vault.test|team/app",
+		"namespace": "team/app",
+	}, Logger("https://vault.test|team/app", "").Data)
+}
@@ -0,0 +1,245 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+)
+
+// watchers renewing the tokens of initialized clients per instance
+// watchers are stopped whenever clients are reinitialized
+var (
+	tokenWatchers  = make(map[string]*api.LifetimeWatcher)
+	tokenWatchersM sync.Mutex
+)
+
+// watchers re-reading the token sinks of initialized clients per instance
+// watchers are stopped whenever clients are reinitialized
+var (
+	sinkWatchers  = make(map[string]chan struct{})
+	sinkWatchersM sync.Mutex
+)
+
+// sinkPollInterval is how often token sinks are read again to pick up tokens rotated by vault agent
+const sinkPollInterval = 30 * time.Second
+
+// TokenFromSink reads the token a vault agent has written to the sink file at path
+func TokenFromSink(path string) (string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("failed to read token sink at %s: %v", path, err))
+	}
+	token := strings.TrimSpace(string(raw))
+	if token == "" {
+		return "", errors.New(fmt.Sprintf("token sink at %s is empty, ensure vault agent auto-auth succeeded", path))
+	}
+	return token, nil
+}
+
+// LoginAppRole authenticates a client via approle and sets the resulting token on the client.
+// The token is renewed in the background and a new login is performed once the token can no
+// longer be renewed so that long reconciles are not interrupted by an expired token.
+func LoginAppRole(key string, client *api.Client, roleID, secretID string) error {
+	secret, err := client.Logical().Write("auth/approle/login", map[string]interface{}{
+		"role_id":   roleID,
+		"secret_id": secretID,
+	})
+	if err != nil {
+		return err
+	}
+	if secret == nil || secret.Auth == nil {
+		return errors.New("approle login response does not contain a token")
+	}
+	client.SetToken(secret.Auth.ClientToken)
+
+	return watchToken(key, client, secret, func() error {
+		return LoginAppRole(key, client, roleID, secretID)
+	})
+}
+
+// LoginKubernetes authenticates a client via kubernetes auth using the service account token stored at
+// tokenPath and sets the resulting token on the client. The token is renewed like approle tokens and the
+// service account token is read again for each login as projected tokens are rotated.
+func LoginKubernetes(key string, client *api.Client, mount, role, tokenPath string) error {
+	jwt, err := ioutil.ReadFile(tokenPath)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to read service account token at %s: %v", tokenPath, err))
+	}
+	path := filepath.Join("auth", mount, "login")
+	secret, err := client.Logical().Write(path, map[string]interface{}{
+		"role": role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return errors.New(fmt.Sprintf(
+			"failed to login with kubernetes role `%s` at %s, ensure the role exists and is bound to the service account: %v",
+			role, path, err))
+	}
+	if secret == nil || secret.Auth == nil {
+		return errors.New(fmt.Sprintf("kubernetes login response from %s does not contain a token", path))
+	}
+	client.SetToken(secret.Auth.ClientToken)
+
+	return watchToken(key, client, secret, func() error {
+		return LoginKubernetes(key, client, mount, role, tokenPath)
+	})
+}
+
+// watchToken renews the token of a login secret until it reaches its max ttl, at which point relogin is called
+func watchToken(key string, client *api.Client, secret *api.Secret, relogin func() error) error {
+	// tokens without a ttl never expire
+	if secret.Auth.LeaseDuration <= 0 {
+		return nil
+	}
+	watcher, err := client.NewLifetimeWatcher(&api.LifetimeWatcherInput{Secret: secret})
+	if err != nil {
+		return err
+	}
+
+	tokenWatchersM.Lock()
+	if existing, ok := tokenWatchers[key]; ok {
+		existing.Stop()
+	}
+	tokenWatchers[key] = watcher
+	tokenWatchersM.Unlock()
+
+	go watcher.Start()
+	go func() {
+		for {
+			select {
+			case <-watcher.RenewCh():
+				Logger(key, "").Debug("[Vault Client] token successfully renewed")
+			case err := <-watcher.DoneCh():
+				tokenWatchersM.Lock()
+				current := tokenWatchers[key] == watcher
+				tokenWatchersM.Unlock()
+				// stopped watchers belong to clients that have since been replaced
+				if !current {
+					return
+				}
+				if err != nil {
+					Logger(key, "").WithError(err).Info("[Vault Client] failed to renew token")
+				}
+				if err := relogin(); err != nil {
+					Logger(key, "").WithError(err).Error("[Vault Client] failed to login again")
+				}
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// watchSink reads the token sink at path periodically and sets the token on the client once
+// vault agent has rotated it, so that a run is not interrupted by the previous token being revoked
+func watchSink(key string, client *api.Client, path string) {
+	stop := make(chan struct{})
+	sinkWatchersM.Lock()
+	if existing, ok := sinkWatchers[key]; ok {
+		close(existing)
+	}
+	sinkWatchers[key] = stop
+	sinkWatchersM.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(sinkPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				token, err := TokenFromSink(path)
+				if err != nil {
+					Logger(key, "").WithError(err).Error("[Vault Client] failed to read token sink again")
+					continue
+				}
+				if token != client.Token() {
+					client.SetToken(token)
+					Logger(key, "").Info("[Vault Client] token rotated by vault agent is used")
+				}
+			}
+		}
+	}()
+}
+
+// sinkTransport reads the token sink again when a request is denied and retries the request once with
+// the token read, if vault agent rotated it since the sink was last read, so that requests made before
+// watchSink picks up a rotated token do not fail
+type sinkTransport struct {
+	base   http.RoundTripper
+	key    string
+	client *api.Client
+	path   string
+}
+
+// retryDeniedWithSink makes the requests of a client denied with the token of a sink retry with the
+// token the sink currently contains, must be called with the config the client was created with
+func retryDeniedWithSink(key string, config *api.Config, client *api.Client, path string) {
+	config.HttpClient.Transport = &sinkTransport{
+		base:   config.HttpClient.Transport,
+		key:    key,
+		client: client,
+		path:   path,
+	}
+}
+
+func (t *sinkTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// the body is kept to be sent again, request bodies sent to vault are small json documents
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusForbidden {
+		return resp, err
+	}
+	token, sinkErr := TokenFromSink(t.path)
+	if sinkErr != nil || token == req.Header.Get(consts.AuthHeaderName) {
+		return resp, nil
+	}
+
+	retry := req.Clone(req.Context())
+	if body != nil {
+		retry.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	retry.Header.Set(consts.AuthHeaderName, token)
+	resp.Body.Close()
+	if token != t.client.Token() {
+		t.client.SetToken(token)
+		Logger(t.key, "").Info("[Vault Client] request denied, token rotated by vault agent is used")
+	}
+	return t.base.RoundTrip(retry)
+}
+
+// stopTokenWatchers stops the renewal of all tokens and the watching of all token sinks
+func stopTokenWatchers() {
+	tokenWatchersM.Lock()
+	defer tokenWatchersM.Unlock()
+	for key, watcher := range tokenWatchers {
+		watcher.Stop()
+		delete(tokenWatchers, key)
+	}
+
+	sinkWatchersM.Lock()
+	defer sinkWatchersM.Unlock()
+	for key, stop := range sinkWatchers {
+		close(stop)
+		delete(sinkWatchers, key)
+	}
+}
@@ -0,0 +1,343 @@
+package vault
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Change describes a single planned change to an object within a Vault instance.
+type Change struct {
+	Type          string   `json:"type"`
+	Name          string   `json:"name"`
+	ChangedFields []string `json:"changed_fields,omitempty"`
+	// Diff is the line-level difference between the existing and desired content of the object,
+	// only recorded for objects such as policies when `-show-diff` is enabled
+	Diff string `json:"diff,omitempty"`
+}
+
+// Plan contains the changes determined for a single Vault instance.
+type Plan struct {
+	Created []Change `json:"created"`
+	Updated []Change `json:"updated"`
+	Deleted []Change `json:"deleted"`
+}
+
+// NewPlan returns a Plan without any changes.
+func NewPlan() *Plan {
+	return &Plan{Created: []Change{}, Updated: []Change{}, Deleted: []Change{}}
+}
+
+// Add adds the changes determined by DiffItems for a type of object to the plan.
+// Written items whose key already exists are added as updates.
+func (p *Plan) Add(objType string, toBeWritten, toBeUpdated, toBeDeleted, existing []Item) {
+	existingByKey := make(map[string]Item, len(existing))
+	for _, e := range existing {
+		existingByKey[strings.Trim(e.Key(), "/")] = e
+	}
+
+	for _, w := range append(append([]Item{}, toBeWritten...), toBeUpdated...) {
+		if e, exists := existingByKey[strings.Trim(w.Key(), "/")]; exists {
+			p.Updated = append(p.Updated, Change{Type: objType, Name: w.Key(), ChangedFields: ChangedFields(w, e)})
+		} else {
+			p.Created = append(p.Created, Change{Type: objType, Name: w.Key()})
+		}
+	}
+	for _, d := range toBeDeleted {
+		p.Deleted = append(p.Deleted, Change{Type: objType, Name: d.Key()})
+	}
+}
+
+// SetDiff attaches the line-level difference between the existing and desired content of an object
+// to be created or updated to its change.
+func (p *Plan) SetDiff(objType, name, diff string) {
+	for _, changes := range [][]Change{p.Created, p.Updated} {
+		for i := range changes {
+			if changes[i].Type == objType && changes[i].Name == name {
+				changes[i].Diff = diff
+			}
+		}
+	}
+}
+
+// Summary returns the number of changes within the plan, e.g. `3 to write, 1 to delete, 0 to update`.
+func (p *Plan) Summary() string {
+	return fmt.Sprintf("%d to write, %d to delete, %d to update", len(p.Created), len(p.Deleted), len(p.Updated))
+}
+
+// Empty returns whether the plan contains no changes.
+func (p *Plan) Empty() bool {
+	return p == nil || len(p.Created)+len(p.Updated)+len(p.Deleted) == 0
+}
+
+// Merge adds all changes of another plan to the plan.
+func (p *Plan) Merge(other *Plan) {
+	if other == nil {
+		return
+	}
+	p.Created = append(p.Created, other.Created...)
+	p.Updated = append(p.Updated, other.Updated...)
+	p.Deleted = append(p.Deleted, other.Deleted...)
+}
+
+// Equal determines if two plans contain the same changes regardless of their order.
+func (p *Plan) Equal(other *Plan) bool {
+	if p.Empty() || other.Empty() {
+		return p.Empty() && other.Empty()
+	}
+	return reflect.DeepEqual(changeKeys(p.Created), changeKeys(other.Created)) &&
+		reflect.DeepEqual(changeKeys(p.Updated), changeKeys(other.Updated)) &&
+		reflect.DeepEqual(changeKeys(p.Deleted), changeKeys(other.Deleted))
+}
+
+// changeKeys returns a sorted list identifying each change
+func changeKeys(changes []Change) []string {
+	keys := make([]string, 0, len(changes))
+	for _, c := range changes {
+		keys = append(keys, fmt.Sprintf("%s|%s|%s", c.Type, c.Name, strings.Join(c.ChangedFields, ",")))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// PlanFile contains the plans of each top-level configuration per instance
+// so that a reviewed dry run can later be applied as planned.
+// Changes are compared by object and changed fields rather than by value, so a hash of the configuration
+// each plan was determined with is recorded as well, which ensures the reviewed values are applied.
+type PlanFile struct {
+	Instances map[string]map[string]*Plan `json:"instances"`
+	// Hashes of the configuration per top-level configuration per instance
+	Hashes map[string]map[string]string `json:"hashes"`
+}
+
+// NewPlanFile returns a PlanFile without any plans.
+func NewPlanFile() *PlanFile {
+	return &PlanFile{Instances: make(map[string]map[string]*Plan), Hashes: make(map[string]map[string]string)}
+}
+
+// Add records the plan of a top-level configuration for an instance along with the configuration it was determined with.
+func (f *PlanFile) Add(instanceAddr, toplevelName string, p *Plan, config []byte) {
+	if f.Instances[instanceAddr] == nil {
+		f.Instances[instanceAddr] = make(map[string]*Plan)
+		f.Hashes[instanceAddr] = make(map[string]string)
+	}
+	if p == nil {
+		p = NewPlan()
+	}
+	f.Instances[instanceAddr][toplevelName] = p
+	f.Hashes[instanceAddr][toplevelName] = planHash(config)
+}
+
+// Check ensures that a plan, determined with a configuration, matches the plan recorded for a top-level
+// configuration of an instance and that the configuration is the configuration the plan was determined with.
+func (f *PlanFile) Check(instanceAddr, toplevelName string, p *Plan, config []byte) error {
+	planned, ok := f.Instances[instanceAddr][toplevelName]
+	if !ok {
+		return errors.New(fmt.Sprintf("plan file does not contain a plan of %s for %s", toplevelName, instanceAddr))
+	}
+	if f.Hashes[instanceAddr][toplevelName] != planHash(config) {
+		return errors.New(fmt.Sprintf("configuration of %s for %s changed since the plan file was written",
+			toplevelName, instanceAddr))
+	}
+	if !planned.Equal(p) {
+		return errors.New(fmt.Sprintf("changes of %s for %s differ from the plan file, planned: %s, current: %s",
+			toplevelName, instanceAddr, planned.Summary(), p.Summary()))
+	}
+	return nil
+}
+
+// WritePlanFile writes a plan file as json.
+func WritePlanFile(path string, f *PlanFile) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// ReadPlanFile reads a plan file written by WritePlanFile.
+func ReadPlanFile(path string) (*PlanFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := NewPlanFile()
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to decode plan file %s: %v", path, err))
+	}
+	if f.Hashes == nil {
+		f.Hashes = make(map[string]map[string]string)
+	}
+	return f, nil
+}
+
+// planHash hashes the configuration a plan was determined with
+func planHash(config []byte) string {
+	h := sha256.Sum256(config)
+	return hex.EncodeToString(h[:])
+}
+
+var (
+	plan        = make(map[string]*Plan)
+	planEnabled bool
+	planM       sync.Mutex
+	showDiff    bool
+	recreate    bool
+	remount     bool
+	// forceDeleteManagedKeys allows deleting managed keys that mounts are still allowed to use
+	forceDeleteManagedKeys bool
+	// maxDeletes limits the objects each top-level configuration deletes, 0 disables the limit
+	maxDeletes        int
+	confirmMassDelete bool
+	// reloadAuditDevices reloads file audit devices configured with `reload_on_apply`
+	reloadAuditDevices bool
+)
+
+// EnableReloadAuditDevices reloads file audit devices configured with `reload_on_apply`, which disables
+// and enables them again.
+func EnableReloadAuditDevices() {
+	reloadAuditDevices = true
+}
+
+// ReloadAuditDevices determines if file audit devices configured with `reload_on_apply` are reloaded.
+func ReloadAuditDevices() bool {
+	return reloadAuditDevices
+}
+
+// SetMaxDeletes limits the number of objects each top-level configuration may delete from an instance
+// in a single reconcile. 0 disables the limit.
+func SetMaxDeletes(n int) {
+	maxDeletes = n
+}
+
+// MaxDeletes returns the number of objects each top-level configuration may delete, 0 if unlimited.
+func MaxDeletes() int {
+	return maxDeletes
+}
+
+// EnableConfirmMassDelete allows top-level configurations to delete more objects than `-max-deletes`.
+func EnableConfirmMassDelete() {
+	confirmMassDelete = true
+}
+
+// ConfirmMassDelete determines if deletes exceeding `-max-deletes` were confirmed.
+func ConfirmMassDelete() bool {
+	return confirmMassDelete
+}
+
+// EnableForceRecreate allows objects that cannot be changed in place, such as the type of
+// a secrets engine, to be disabled and enabled again. Doing so destroys any data they contain.
+func EnableForceRecreate() {
+	recreate = true
+}
+
+// ForceRecreate determines if objects that cannot be changed in place may be recreated.
+func ForceRecreate() bool {
+	return recreate
+}
+
+// EnableRemount allows secrets engines whose path changed to be moved to the new path
+// along with their data rather than enabled anew.
+func EnableRemount() {
+	remount = true
+}
+
+// Remount determines if secrets engines whose path changed may be moved.
+func Remount() bool {
+	return remount
+}
+
+// EnableForceDeleteManagedKeys allows managed keys that secrets engines are still allowed to use
+// to be deleted, which breaks any use of the key by those secrets engines.
+func EnableForceDeleteManagedKeys() {
+	forceDeleteManagedKeys = true
+}
+
+// ForceDeleteManagedKeys determines if managed keys referenced by secrets engines may be deleted.
+func ForceDeleteManagedKeys() bool {
+	return forceDeleteManagedKeys
+}
+
+// EnableShowDiff enables the output of the difference between existing and desired
+// content of objects to be changed during a dry run.
+func EnableShowDiff() {
+	showDiff = true
+}
+
+// ShowDiff determines if dry runs should output the difference between existing and
+// desired content of objects to be changed.
+func ShowDiff() bool {
+	return showDiff
+}
+
+// EnablePlan enables recording of changes via RecordPlan.
+func EnablePlan() {
+	planM.Lock()
+	defer planM.Unlock()
+	planEnabled = true
+}
+
+// RecordPlan records the plan of an instance so it can later be written as a structured
+// document with WritePlan. Plans recorded for the same instance are merged.
+func RecordPlan(instanceAddr string, p *Plan) {
+	planM.Lock()
+	defer planM.Unlock()
+
+	if !planEnabled {
+		return
+	}
+
+	recorded, ok := plan[instanceAddr]
+	if !ok {
+		recorded = NewPlan()
+		plan[instanceAddr] = recorded
+	}
+	recorded.Merge(p)
+}
+
+// WritePlan writes all recorded changes as json and clears the recorded plan.
+func WritePlan(w io.Writer) error {
+	planM.Lock()
+	defer planM.Unlock()
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(map[string]interface{}{"instances": plan})
+	plan = make(map[string]*Plan)
+	return err
+}
+
+// ChangedFields returns the names of the exported fields that differ between two
+// values of the same struct type. Instance references and toggles are ignored.
+func ChangedFields(desired, existing interface{}) []string {
+	dv := reflect.ValueOf(desired)
+	ev := reflect.ValueOf(existing)
+	if dv.Kind() != reflect.Struct || dv.Type() != ev.Type() {
+		return nil
+	}
+
+	changed := []string{}
+	for i := 0; i < dv.NumField(); i++ {
+		field := dv.Type().Field(i)
+		if field.PkgPath != "" || field.Type == reflect.TypeOf(Instance{}) || field.Type == reflect.TypeOf(Toggle{}) {
+			continue
+		}
+		if reflect.DeepEqual(dv.Field(i).Interface(), ev.Field(i).Interface()) {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			name = field.Name
+		}
+		changed = append(changed, name)
+	}
+	return changed
+}
@@ -0,0 +1,86 @@
+package vault
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordPlan(t *testing.T) {
+	EnablePlan()
+
+	existing := intoInterface([]item{{"x", "old", "x", "x"}, {"y", "y", "y", "y"}})
+	toBeWritten := intoInterface([]item{{"x", "new", "x", "x"}, {"z", "z", "z", "z"}})
+	toBeDeleted := intoInterface([]item{{"y", "y", "y", "y"}})
+
+	p := NewPlan()
+	p.Add("item", toBeWritten, nil, toBeDeleted, existing)
+	RecordPlan("http://127.0.0.1:8200", p)
+	require.Equal(t, &Plan{
+		Created: []Change{{Type: "item", Name: "z"}},
+		Updated: []Change{{Type: "item", Name: "x", ChangedFields: []string{}}},
+		Deleted: []Change{{Type: "item", Name: "y"}},
+	}, plan["http://127.0.0.1:8200"])
+
+	require.Equal(t, "1 to write, 1 to delete, 1 to update", p.Summary())
+	require.False(t, p.Empty())
+	require.True(t, NewPlan().Empty())
+
+	var buf bytes.Buffer
+	require.NoError(t, WritePlan(&buf))
+	require.Contains(t, buf.String(), `"instances"`)
+	require.Empty(t, plan)
+}
+
+func TestChangedFields(t *testing.T) {
+	type entry struct {
+		Name     string            `yaml:"name"`
+		Rules    string            `yaml:"rules"`
+		Options  map[string]string `yaml:"options"`
+		Instance Instance          `yaml:"instance"`
+	}
+
+	require.Equal(t, []string{"rules", "options"}, ChangedFields(
+		entry{Name: "x", Rules: "new", Options: map[string]string{"a": "b"}, Instance: Instance{Address: "a"}},
+		entry{Name: "x", Rules: "old", Instance: Instance{Address: "b"}},
+	))
+	require.Nil(t, ChangedFields(entry{}, item{}))
+}
+
+func TestPlanFile(t *testing.T) {
+	existing := intoInterface([]item{{"x", "old", "x", "x"}})
+	p := NewPlan()
+	p.Add("item", intoInterface([]item{{"x", "new", "x", "x"}, {"y", "y", "y", "y"}, {"z", "z", "z", "z"}}),
+		nil, nil, existing)
+
+	f := NewPlanFile()
+	cfg := []byte("- name: x\n  value: new\n")
+	f.Add("http://127.0.0.1:8200", "vault_items", p, cfg)
+	f.Add("http://127.0.0.1:8200", "vault_others", nil, nil)
+	path := filepath.Join(t.TempDir(), "plan.json")
+	require.NoError(t, WritePlanFile(path, f))
+
+	read, err := ReadPlanFile(path)
+	require.NoError(t, err)
+
+	// changes determined in a different order match the plan
+	reordered := NewPlan()
+	reordered.Add("item", intoInterface([]item{{"z", "z", "z", "z"}, {"y", "y", "y", "y"}, {"x", "new", "x", "x"}}),
+		nil, nil, existing)
+	require.NoError(t, read.Check("http://127.0.0.1:8200", "vault_items", reordered, cfg))
+	require.NoError(t, read.Check("http://127.0.0.1:8200", "vault_others", NewPlan(), nil))
+	// the same changes determined with different values are not the reviewed changes
+	require.Error(t, read.Check("http://127.0.0.1:8200", "vault_items", reordered, []byte("- name: x\n  value: newer\n")))
+
+	changed := NewPlan()
+	changed.Add("item", intoInterface([]item{{"y", "y", "y", "y"}}), nil, nil, existing)
+	require.Error(t, read.Check("http://127.0.0.1:8200", "vault_items", changed, cfg))
+	require.Error(t, read.Check("http://127.0.0.1:8200", "vault_others", p, nil))
+	require.Error(t, read.Check("http://127.0.0.1:8200", "vault_missing", NewPlan(), nil))
+	require.Error(t, read.Check("http://127.0.0.1:8300", "vault_items", p, cfg))
+
+	_, err = ReadPlanFile(filepath.Join(t.TempDir(), "missing.json"))
+	require.Error(t, err)
+}
@@ -0,0 +1,71 @@
+package vault
+
+import (
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tracks the top-level configurations completed per instance in the current reconcile
+// so that a reconcile failing partway through reports what was already applied
+// reset with each call to GetInstances()
+var (
+	completed  = make(map[string][]string)
+	completedM sync.Mutex
+)
+
+// RecordCompleted records that a top-level configuration was applied to an instance without error
+func RecordCompleted(instanceAddr, toplevelName string) {
+	completedM.Lock()
+	defer completedM.Unlock()
+	completed[instanceAddr] = append(completed[instanceAddr], toplevelName)
+}
+
+// Completed returns the top-level configurations completed for an instance in the order they were applied
+func Completed(instanceAddr string) []string {
+	completedM.Lock()
+	defer completedM.Unlock()
+	return append([]string{}, completed[instanceAddr]...)
+}
+
+// failedToplevels returns the sorted top-level configurations with failures recorded for an instance
+func failedToplevels(instanceAddr string) []string {
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, f := range Failures() {
+		if f.Instance != instanceAddr || f.Toplevel == "" || seen[f.Toplevel] {
+			continue
+		}
+		seen[f.Toplevel] = true
+		names = append(names, f.Toplevel)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LogProgress logs the top-level configurations completed for each instance of the current reconcile
+// along with those that failed, so that a failed run can be judged safe to repeat
+// instances marked invalid were not reconciled past their first failure
+func LogProgress(instanceAddrs []string) {
+	addrs := append([]string{}, instanceAddrs...)
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		fields := log.Fields{
+			"completed": Completed(addr),
+			"failed":    failedToplevels(addr),
+		}
+		if IsInvalid(addr) {
+			Logger(addr, "").WithFields(fields).Error(
+				"[Progress] reconcile of instance stopped, only the completed top-level configurations were applied")
+			continue
+		}
+		Logger(addr, "").WithFields(fields).Info("[Progress] reconcile of instance completed")
+	}
+}
+
+func resetProgress() {
+	completedM.Lock()
+	defer completedM.Unlock()
+	completed = make(map[string][]string)
+}
@@ -0,0 +1,30 @@
+package vault
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgress(t *testing.T) {
+	resetProgress()
+	resetFailures()
+	defer resetProgress()
+	defer resetFailures()
+
+	RecordCompleted("https://a.example.com", "vault_policies")
+	RecordCompleted("https://a.example.com", "vault_roles")
+	RecordCompleted("https://b.example.com", "vault_policies")
+	RecordFailure("https://b.example.com", "vault_roles", "apply", "", errors.New("x"))
+	RecordFailure("https://b.example.com", "vault_roles", "write", "ci", errors.New("y"))
+	RecordFailure("https://b.example.com", "", "health check", "", errors.New("z"))
+
+	require.Equal(t, []string{"vault_policies", "vault_roles"}, Completed("https://a.example.com"),
+		"completed top-level configurations keep the order they were applied in")
+	require.Equal(t, []string{"vault_roles"}, failedToplevels("https://b.example.com"))
+	require.Empty(t, failedToplevels("https://a.example.com"))
+
+	resetProgress()
+	require.Empty(t, Completed("https://a.example.com"))
+}
@@ -1,9 +1,13 @@
 package vault
 
 import (
+	"errors"
 	"fmt"
+	"path"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -20,6 +24,8 @@ type Item interface {
 const (
 	OIDC_CLIENT_SECRET        = "oidc_client_secret"
 	OIDC_CLIENT_SECRET_KV_VER = "oidc_client_secret_kv_version"
+	LDAP_BINDPASS             = "bindpass"
+	LDAP_BINDPASS_KV_VER      = "bindpass_kv_version"
 )
 
 // DiffItems is a pure function that determines what changes need to be made to
@@ -65,6 +71,156 @@ func DiffItems(desired, existing []Item) (toBeWritten, toBeDeleted, toBeUpdated
 	return
 }
 
+// instances whose deletes are deferred along with the number of deletes deferred
+// and the instances whose deferred deletes are being applied
+var (
+	deferredDeletes  = make(map[string]int)
+	applyingDeferred = make(map[string]bool)
+	deferredDeletesM sync.Mutex
+)
+
+// ApplyDeferredDeletes marks that top-level configurations are applied to an instance for the deletes they
+// deferred, until DeferredDeletesApplied is called. Changes other than deletes were already made by the first
+// apply and are not to be repeated, see ApplyingDeferredDeletes.
+func ApplyDeferredDeletes(instanceAddr string) {
+	deferredDeletesM.Lock()
+	defer deferredDeletesM.Unlock()
+	applyingDeferred[instanceAddr] = true
+}
+
+// DeferredDeletesApplied marks that the deferred deletes of an instance have been applied.
+func DeferredDeletesApplied(instanceAddr string) {
+	deferredDeletesM.Lock()
+	defer deferredDeletesM.Unlock()
+	delete(applyingDeferred, instanceAddr)
+}
+
+// ApplyingDeferredDeletes determines if top-level configurations are applied to an instance for their deferred deletes.
+func ApplyingDeferredDeletes(instanceAddr string) bool {
+	deferredDeletesM.Lock()
+	defer deferredDeletesM.Unlock()
+	return applyingDeferred[instanceAddr]
+}
+
+// DeferDeletes makes SkipDeletes defer the deletes of an instance, e.g. until all top-level configurations
+// have been written, rather than log them as skipped as pruning is disabled.
+func DeferDeletes(instanceAddr string) {
+	deferredDeletesM.Lock()
+	defer deferredDeletesM.Unlock()
+	deferredDeletes[instanceAddr] = 0
+}
+
+// DeferringDeletes determines if the deletes of an instance are deferred, i.e. they are applied once
+// all top-level configurations have been written even though pruning is disabled for the current apply.
+func DeferringDeletes(instanceAddr string) bool {
+	deferredDeletesM.Lock()
+	defer deferredDeletesM.Unlock()
+	_, deferring := deferredDeletes[instanceAddr]
+	return deferring
+}
+
+// StopDeferringDeletes stops deferring the deletes of an instance and returns the number of deletes
+// deferred since DeferDeletes was called.
+func StopDeferringDeletes(instanceAddr string) int {
+	deferredDeletesM.Lock()
+	defer deferredDeletesM.Unlock()
+	count := deferredDeletes[instanceAddr]
+	delete(deferredDeletes, instanceAddr)
+	return count
+}
+
+// SkipDeletes logs the items that would have been deleted had pruning been
+// enabled and returns an empty list to be used in place of toBeDeleted.
+// Items matching ignore (e.g. builtin mounts or policies) are never deleted and are not logged.
+// Deletes of instances whose deletes are deferred are counted rather than logged.
+func SkipDeletes(instanceAddr string, description string, toBeDeleted []Item, ignore func(Item) bool) []Item {
+	deferredDeletesM.Lock()
+	defer deferredDeletesM.Unlock()
+	_, deferring := deferredDeletes[instanceAddr]
+	for _, d := range toBeDeleted {
+		if ignore != nil && ignore(d) {
+			continue
+		}
+		if deferring {
+			deferredDeletes[instanceAddr]++
+			continue
+		}
+		Logger(instanceAddr, "").WithFields(log.Fields{
+			"name": d.Key(),
+		}).Infof("%s not deleted as pruning is disabled", description)
+	}
+	return make([]Item, 0)
+}
+
+// CheckDeletes ensures that the objects a top-level configuration is about to delete from an instance
+// do not exceed `-max-deletes`, guarding against e.g. a configuration that was accidentally emptied.
+// The limit applies to all objects the top-level configuration deletes, regardless of their type.
+func CheckDeletes(instanceAddr string, description string, toBeDeleted ...[]Item) error {
+	limit := MaxDeletes()
+	if limit <= 0 {
+		return nil
+	}
+	count := 0
+	for _, items := range toBeDeleted {
+		count += len(items)
+	}
+	if count <= limit {
+		return nil
+	}
+	if ConfirmMassDelete() {
+		Logger(instanceAddr, "").WithFields(log.Fields{
+			"count":       count,
+			"max_deletes": limit,
+		}).Warnf("%s deleting more objects than `-max-deletes` as `-confirm-mass-delete` is set", description)
+		return nil
+	}
+	return errors.New(fmt.Sprintf("%s %d objects to be deleted exceed `-max-deletes` of %d, "+
+		"check the configuration or use `-confirm-mass-delete` to delete them", description, count, limit))
+}
+
+// ExcludeItems returns the items that do not match exclude.
+func ExcludeItems(items []Item, exclude func(Item) bool) []Item {
+	result := make([]Item, 0, len(items))
+	for _, i := range items {
+		if !exclude(i) {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// Toggle is embedded inline into configuration entries to allow excluding an entry from
+// reconciliation without removing it from the configuration.
+// Entries with a MinVaultVersion are left unmanaged on instances running an older version of vault,
+// see toplevel.Apply
+type Toggle struct {
+	Managed         *bool  `yaml:"managed"`
+	MinVaultVersion string `yaml:"min_vault_version"`
+}
+
+// Unmanaged determines if an entry is configured with `managed: false`
+func (t Toggle) Unmanaged() bool {
+	return t.Managed != nil && !*t.Managed
+}
+
+// ExcludeUnmanaged removes unmanaged items from the desired items along with the existing items
+// of the same key, so that neither writes nor deletes are determined for them and the objects are
+// left untouched in vault. Keys are compared as paths as mounts are reported with a trailing slash.
+func ExcludeUnmanaged(desired, existing []Item) ([]Item, []Item) {
+	unmanaged := make(map[string]bool)
+	managed := make([]Item, 0, len(desired))
+	for _, d := range desired {
+		if t, ok := d.(interface{ Unmanaged() bool }); ok && t.Unmanaged() {
+			unmanaged[NormalizePath(d.Key())] = true
+			continue
+		}
+		managed = append(managed, d)
+	}
+	return managed, ExcludeItems(existing, func(i Item) bool {
+		return unmanaged[NormalizePath(i.Key())]
+	})
+}
+
 func in(y Item, xs []Item) bool {
 	for _, x := range xs {
 		if y.Equals(x) {
@@ -102,7 +258,11 @@ func keyDescription(y Item, xs []Item) bool {
 }
 
 // OptionsEqual compares two sets of options mappings.
+// A nil mapping is equal to an empty one as vault omits options for mounts configured without any.
 func OptionsEqual(xopts, yopts map[string]interface{}) bool {
+	if len(xopts) == 0 && len(yopts) == 0 {
+		return true
+	}
 	if len(xopts) != len(yopts) {
 		return false
 	}
@@ -112,27 +272,109 @@ func OptionsEqual(xopts, yopts map[string]interface{}) bool {
 		if !ok {
 			return false
 		}
-
-		// option values that need to be processed as numbers
-		if strings.HasSuffix(k, "ttl") || strings.HasSuffix(k, "period") ||
-			strings.HasSuffix(k, "leeway") || k == "max_age" {
-			if !ttlEqual(fmt.Sprintf("%v", v), fmt.Sprintf("%v", xv)) {
-				return false
-			}
-			continue
-		} else if k == "bound_claims" || k == "claim_mappings" {
-			if reflect.DeepEqual(xv, v) {
-				continue
-			}
+		if !optionEqual(k, xv, v) {
 			return false
 		}
+	}
 
-		if fmt.Sprintf("%v", v) != fmt.Sprintf("%v", xv) {
-			return false
+	return true
+}
+
+// optionEqual compares the values of an option
+func optionEqual(k string, x, y interface{}) bool {
+	// option values that need to be processed as numbers
+	if strings.HasSuffix(k, "ttl") || strings.HasSuffix(k, "period") ||
+		strings.HasSuffix(k, "leeway") || k == "max_age" {
+		return ttlEqual(fmt.Sprintf("%v", y), fmt.Sprintf("%v", x))
+	} else if k == "bound_claims" || k == "claim_mappings" {
+		return reflect.DeepEqual(x, y)
+	}
+	// lists, e.g. policies or redirect uris, are compared regardless of their order
+	if xl, ok := stringList(x); ok {
+		if yl, ok := stringList(y); ok {
+			return reflect.DeepEqual(xl, yl)
 		}
 	}
+	xs, ys := fmt.Sprintf("%v", x), fmt.Sprintf("%v", y)
+	if xs == ys {
+		return true
+	}
+	// vault reports boolean options inconsistently across engine types and versions, e.g. `True`, `true` or `1`
+	xb, xok := parseBoolLike(xs)
+	yb, yok := parseBoolLike(ys)
+	return xok && yok && xb == yb
+}
 
-	return true
+// stringList returns the sorted values of a list as strings, and false if the value is not a list
+func stringList(v interface{}) ([]string, bool) {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) {
+		return nil, false
+	}
+	values := make([]string, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		values = append(values, fmt.Sprintf("%v", rv.Index(i).Interface()))
+	}
+	sort.Strings(values)
+	return values, true
+}
+
+// parseBoolLike parses the representations of booleans used for options
+// any other value, including other numbers, is not boolean-like
+func parseBoolLike(s string) (bool, bool) {
+	switch strings.ToLower(s) {
+	case "true", "1":
+		return true, true
+	case "false", "0":
+		return false, true
+	}
+	return false, false
+}
+
+// FieldDiff is a field, or option, whose desired value differs from the value of the existing object
+// a nil value means the field is not set
+type FieldDiff struct {
+	Field    string
+	Desired  interface{}
+	Existing interface{}
+}
+
+func (d FieldDiff) String() string {
+	format := func(v interface{}) string {
+		if v == nil {
+			return "<unset>"
+		}
+		return fmt.Sprintf("%v", v)
+	}
+	return fmt.Sprintf("%s: desired=%s existing=%s", d.Field, format(d.Desired), format(d.Existing))
+}
+
+// OptionsDifferences returns the options differing between desired and existing options, compared as by OptionsEqual,
+// ordered by name. Options are prefixed with prefix, e.g. `options.`, and sensitive values must be redacted by the caller
+func OptionsDifferences(prefix string, desired, existing map[string]interface{}) []FieldDiff {
+	keys := make(map[string]bool)
+	for k := range desired {
+		keys[k] = true
+	}
+	for k := range existing {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	diffs := []FieldDiff{}
+	for _, k := range sorted {
+		dv, dok := desired[k]
+		ev, eok := existing[k]
+		if dok && eok && optionEqual(k, dv, ev) {
+			continue
+		}
+		diffs = append(diffs, FieldDiff{Field: prefix + k, Desired: dv, Existing: ev})
+	}
+	return diffs
 }
 
 func ttlEqual(x, y string) bool {
@@ -151,13 +393,28 @@ func ttlEqual(x, y string) bool {
 }
 
 // EqualPathNames determines if two paths are the same.
+// Paths are compared in their normalized form, e.g. `aws`, `aws/` and `/aws/` are the same path.
 func EqualPathNames(x, y string) bool {
-	return strings.Trim(x, "/") == strings.Trim(y, "/")
+	return NormalizePath(x) == NormalizePath(y)
+}
+
+// NormalizePath returns a path without leading or trailing slashes,
+// repeated slashes and `.` segments.
+func NormalizePath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+// MountPath returns a path in the form vault reports mounts in, with a single trailing slash.
+func MountPath(p string) string {
+	return NormalizePath(p) + "/"
 }
 
 // ParseDuration parses a string duration from Vault.
 // Defaults to seconds if no unit is found at the end of the string.
 func ParseDuration(duration string) (time.Duration, error) {
+	if duration == "" {
+		return 0, errors.New("empty duration")
+	}
 	lastChar := string([]rune(duration)[len(duration)-1])
 	if strings.ContainsAny(lastChar, "1234567890") {
 		duration += "s"
@@ -184,13 +441,20 @@ func DataInSecret(instanceAddr string, data map[string]interface{}, path string,
 				return false, err
 			}
 			v = int64(dur.Seconds())
-		} else if k == OIDC_CLIENT_SECRET || k == OIDC_CLIENT_SECRET_KV_VER { // not returned from ReadSecret()
+		} else if k == OIDC_CLIENT_SECRET || k == OIDC_CLIENT_SECRET_KV_VER ||
+			k == LDAP_BINDPASS || k == LDAP_BINDPASS_KV_VER { // not returned from ReadSecret()
 			continue
 		}
 
 		if fmt.Sprintf("%v", secret[k]) == fmt.Sprintf("%v", v) {
 			continue
 		}
+		// lists of auth backend configs, e.g. `token_policies`, are compared regardless of their order
+		if xl, ok := stringList(secret[k]); ok {
+			if yl, ok := stringList(v); ok && reflect.DeepEqual(xl, yl) {
+				continue
+			}
+		}
 		return false, nil
 	}
 	return true, nil
@@ -1,6 +1,7 @@
 package vault
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -145,6 +146,36 @@ func TestOptionsEqual(t *testing.T) {
 			y:           map[string]interface{}{},
 			expected:    true,
 		},
+		{
+			description: "map of len(0) equals nil",
+			x:           map[string]interface{}{},
+			y:           nil,
+			expected:    true,
+		},
+		{
+			description: "populated map does not equal nil",
+			x:           map[string]interface{}{"x": "x"},
+			y:           nil,
+			expected:    false,
+		},
+		{
+			description: "nil does not equal populated map",
+			x:           nil,
+			y:           map[string]interface{}{"x": "x"},
+			expected:    false,
+		},
+		{
+			description: "populated map does not equal map of len(0)",
+			x:           map[string]interface{}{"x": "x"},
+			y:           map[string]interface{}{},
+			expected:    false,
+		},
+		{
+			description: "map of len(0) does not equal populated map",
+			x:           map[string]interface{}{},
+			y:           map[string]interface{}{"x": "x"},
+			expected:    false,
+		},
 		{
 			description: "same values, but out of order",
 			x:           map[string]interface{}{"x": "x", "y": "y"},
@@ -163,6 +194,18 @@ func TestOptionsEqual(t *testing.T) {
 			y:           map[string]interface{}{"x": "x", "y": "y"},
 			expected:    false,
 		},
+		{
+			description: "lists in different order are equal",
+			x:           map[string]interface{}{"allowed_redirect_uris": []interface{}{"https://a", "https://b"}},
+			y:           map[string]interface{}{"allowed_redirect_uris": []string{"https://b", "https://a"}},
+			expected:    true,
+		},
+		{
+			description: "lists with different values are not equal",
+			x:           map[string]interface{}{"token_policies": []interface{}{"a", "b"}},
+			y:           map[string]interface{}{"token_policies": []interface{}{"a"}},
+			expected:    false,
+		},
 		{
 			description: "ttl keys in minutes and seconds are equal",
 			x:           map[string]interface{}{"x_ttl": "60s"},
@@ -177,3 +220,143 @@ func TestOptionsEqual(t *testing.T) {
 		})
 	}
 }
+
+func TestOptionsEqualBooleans(t *testing.T) {
+	table := []struct {
+		x, y     interface{}
+		expected bool
+	}{
+		{x: "true", y: "True", expected: true},
+		{x: "TRUE", y: true, expected: true},
+		{x: "1", y: "true", expected: true},
+		{x: true, y: "1", expected: true},
+		{x: "false", y: "False", expected: true},
+		{x: "0", y: false, expected: true},
+		{x: "FALSE", y: "0", expected: true},
+		{x: "true", y: "false", expected: false},
+		{x: "1", y: "0", expected: false},
+		{x: "True", y: "0", expected: false},
+		{x: "2", y: "true", expected: false},
+		{x: "yes", y: "true", expected: false},
+		{x: "10", y: "1", expected: false},
+		{x: "Hello", y: "hello", expected: false},
+	}
+
+	for _, tt := range table {
+		t.Run(fmt.Sprintf("%v=%v", tt.x, tt.y), func(t *testing.T) {
+			require.Equal(t, tt.expected, OptionsEqual(
+				map[string]interface{}{"seal_wrap": tt.x}, map[string]interface{}{"seal_wrap": tt.y}))
+		})
+	}
+}
+
+func TestSkipDeletes(t *testing.T) {
+	toBeDeleted := intoInterface([]item{{"x", "x", "x", "x"}, {"y", "y", "y", "y"}})
+	require.Equal(t, []item{}, outOfInterface(SkipDeletes("http://127.0.0.1:8200", "item", toBeDeleted, nil)))
+	require.Equal(t, []item{}, outOfInterface(SkipDeletes("http://127.0.0.1:8200", "item", toBeDeleted, func(i Item) bool {
+		return i.Key() == "x"
+	})))
+
+	DeferDeletes("http://127.0.0.1:8200")
+	require.Equal(t, []item{}, outOfInterface(SkipDeletes("http://127.0.0.1:8200", "item", toBeDeleted, nil)))
+	require.Equal(t, []item{}, outOfInterface(SkipDeletes("http://127.0.0.1:8200", "item", toBeDeleted, func(i Item) bool {
+		return i.Key() == "x"
+	})))
+	SkipDeletes("http://127.0.0.2:8200", "item", toBeDeleted, nil)
+	require.Equal(t, 3, StopDeferringDeletes("http://127.0.0.1:8200"), "ignored items are not deferred")
+	require.Equal(t, 0, StopDeferringDeletes("http://127.0.0.1:8200"))
+}
+
+func TestApplyingDeferredDeletes(t *testing.T) {
+	require.False(t, ApplyingDeferredDeletes("http://127.0.0.1:8200"))
+	ApplyDeferredDeletes("http://127.0.0.1:8200")
+	require.True(t, ApplyingDeferredDeletes("http://127.0.0.1:8200"))
+	require.False(t, ApplyingDeferredDeletes("http://127.0.0.2:8200"))
+	DeferredDeletesApplied("http://127.0.0.1:8200")
+	require.False(t, ApplyingDeferredDeletes("http://127.0.0.1:8200"))
+}
+
+func TestCheckDeletes(t *testing.T) {
+	defer func() {
+		SetMaxDeletes(0)
+		confirmMassDelete = false
+	}()
+	toBeDeleted := intoInterface([]item{{"x", "x", "x", "x"}, {"y", "y", "y", "y"}})
+	aliases := intoInterface([]item{{"z", "z", "z", "z"}})
+
+	require.NoError(t, CheckDeletes("http://127.0.0.1:8200", "[Item]", toBeDeleted), "no limit by default")
+
+	SetMaxDeletes(2)
+	require.NoError(t, CheckDeletes("http://127.0.0.1:8200", "[Item]", toBeDeleted))
+	err := CheckDeletes("http://127.0.0.1:8200", "[Item]", toBeDeleted, aliases)
+	require.EqualError(t, err, "[Item] 3 objects to be deleted exceed `-max-deletes` of 2, "+
+		"check the configuration or use `-confirm-mass-delete` to delete them")
+
+	EnableConfirmMassDelete()
+	require.NoError(t, CheckDeletes("http://127.0.0.1:8200", "[Item]", toBeDeleted, aliases))
+}
+
+func TestEqualPathNames(t *testing.T) {
+	table := []struct {
+		x        string
+		y        string
+		expected bool
+	}{
+		{"aws", "aws", true},
+		{"aws", "aws/", true},
+		{"aws/", "/aws", true},
+		{"aws//", "aws/", true},
+		{"team/aws", "team/aws/", true},
+		{"team//aws/", "team/aws", true},
+		{"team/./aws", "team/aws/", true},
+		{"aws", "team/aws/", false},
+		{"team/aws", "aws/", false},
+		{"aws", "aws2/", false},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.x+" "+tt.y, func(t *testing.T) {
+			require.Equal(t, tt.expected, EqualPathNames(tt.x, tt.y))
+			require.Equal(t, tt.expected, EqualPathNames(tt.y, tt.x))
+			require.Equal(t, tt.expected, MountPath(tt.x) == MountPath(tt.y))
+		})
+	}
+}
+
+type toggledItem struct {
+	item
+	Toggle
+}
+
+func TestExcludeUnmanaged(t *testing.T) {
+	managed := true
+	unmanaged := false
+	desired := []Item{
+		toggledItem{item{"x", "x", "x", "x"}, Toggle{}},
+		toggledItem{item{"y", "y", "y", "y"}, Toggle{Managed: &managed}},
+		toggledItem{item{"z", "z", "z", "z"}, Toggle{Managed: &unmanaged}},
+	}
+	existing := []Item{
+		item{"x", "x", "x", "x"},
+		item{"z", "old", "z", "z"},
+		item{"w", "w", "w", "w"},
+	}
+
+	d, e := ExcludeUnmanaged(desired, existing)
+	require.Equal(t, desired[:2], d)
+	require.Equal(t, []Item{existing[0], existing[2]}, e)
+}
+
+func TestOptionsDifferences(t *testing.T) {
+	desired := map[string]interface{}{"default_lease_ttl": "1h", "version": "2", "audit_non_hmac_request_keys": "a"}
+	existing := map[string]interface{}{"default_lease_ttl": "3600", "version": "1", "max_lease_ttl": "768h"}
+
+	diffs := OptionsDifferences("options.", desired, existing)
+	require.Equal(t, []FieldDiff{
+		{Field: "options.audit_non_hmac_request_keys", Desired: "a"},
+		{Field: "options.max_lease_ttl", Existing: "768h"},
+		{Field: "options.version", Desired: "2", Existing: "1"},
+	}, diffs)
+	require.Equal(t, "options.max_lease_ttl: desired=<unset> existing=768h", diffs[1].String())
+	require.Empty(t, OptionsDifferences("", desired, desired))
+}
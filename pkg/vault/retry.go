@@ -0,0 +1,72 @@
+package vault
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/hashicorp/vault/api"
+)
+
+// retry policy applied to requests made by every vault client
+// defaults match those of the vault api client
+var (
+	maxRetries     = 2
+	retryBaseDelay = 1 * time.Second
+)
+
+// SetRetryPolicy overrides the number of retries and the base delay used for exponential backoff
+// must be called prior to GetInstances() for clients to be configured with the policy
+func SetRetryPolicy(retries int, baseDelay time.Duration) {
+	maxRetries = retries
+	retryBaseDelay = baseDelay
+}
+
+// configureRetries applies the retry policy to the config of a vault client
+// the delay between attempts doubles with each retry
+func configureRetries(config *api.Config) {
+	config.MaxRetries = maxRetries
+	config.MinRetryWait = retryBaseDelay
+	config.MaxRetryWait = time.Duration(math.Pow(2, float64(maxRetries))) * retryBaseDelay
+	config.Backoff = jitteredBackoff
+	config.CheckRetry = checkRetry
+}
+
+// jitteredBackoff randomizes the exponential backoff between half and all of the delay so that
+// concurrent requests rejected together are not retried together
+// the Retry-After header (in seconds) of rate limited responses is honored as is
+func jitteredBackoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	delay := retryablehttp.DefaultBackoff(min, max, attemptNum, resp)
+	if delay <= 1 {
+		return delay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+}
+
+// checkRetry determines whether a failed request should be retried
+// transient failures (network errors, 5xx) and rate limited requests (429) are retried
+// while other 4xx responses such as permission denied are returned immediately
+func checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	if err != nil {
+		return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	}
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		return true, nil
+	}
+	if resp != nil && resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented {
+		return true, nil
+	}
+	return false, nil
+}
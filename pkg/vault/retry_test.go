@@ -0,0 +1,101 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckRetry(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	table := []struct {
+		description string
+		ctx         context.Context
+		resp        *http.Response
+		err         error
+		expected    bool
+	}{
+		{
+			description: "successful response is not retried",
+			ctx:         context.Background(),
+			resp:        &http.Response{StatusCode: http.StatusOK},
+			expected:    false,
+		},
+		{
+			description: "permission denied is not retried",
+			ctx:         context.Background(),
+			resp:        &http.Response{StatusCode: http.StatusForbidden},
+			expected:    false,
+		},
+		{
+			description: "bad request is not retried",
+			ctx:         context.Background(),
+			resp:        &http.Response{StatusCode: http.StatusBadRequest},
+			expected:    false,
+		},
+		{
+			description: "internal server error is retried",
+			ctx:         context.Background(),
+			resp:        &http.Response{StatusCode: http.StatusInternalServerError},
+			expected:    true,
+		},
+		{
+			description: "service unavailable is retried",
+			ctx:         context.Background(),
+			resp:        &http.Response{StatusCode: http.StatusServiceUnavailable},
+			expected:    true,
+		},
+		{
+			description: "rate limited request is retried",
+			ctx:         context.Background(),
+			resp:        &http.Response{StatusCode: http.StatusTooManyRequests},
+			expected:    true,
+		},
+		{
+			description: "not implemented is not retried",
+			ctx:         context.Background(),
+			resp:        &http.Response{StatusCode: http.StatusNotImplemented},
+			expected:    false,
+		},
+		{
+			description: "connection error is retried",
+			ctx:         context.Background(),
+			err:         errors.New("connection reset by peer"),
+			expected:    true,
+		},
+		{
+			description: "cancelled request is not retried",
+			ctx:         cancelled,
+			resp:        &http.Response{StatusCode: http.StatusServiceUnavailable},
+			expected:    false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			retry, _ := checkRetry(tt.ctx, tt.resp, tt.err)
+			require.Equal(t, tt.expected, retry)
+		})
+	}
+}
+
+func TestJitteredBackoff(t *testing.T) {
+	for attempt := 0; attempt < 3; attempt++ {
+		delay := jitteredBackoff(time.Second, 8*time.Second, attempt, nil)
+		expected := time.Duration(math.Pow(2, float64(attempt))) * time.Second
+		require.True(t, delay >= expected/2 && delay <= expected, "attempt %d: %v", attempt, delay)
+	}
+
+	rateLimited := &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": []string{"3"}},
+	}
+	require.Equal(t, 3*time.Second, jitteredBackoff(time.Second, 8*time.Second, 0, rateLimited))
+}
@@ -0,0 +1,254 @@
+package vault
+
+import (
+	"bytes"
+	cryptotls "crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// summaryTimeout bounds each request delivering a summary so that an unreachable
+// receiver delays the end of a reconcile by no more than the timeout
+const summaryTimeout = 10 * time.Second
+
+// location of the service account credentials mounted into pods
+var serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Summary describes the outcome of a reconcile, delivered to a webhook or as a Kubernetes Event
+// once the reconcile has completed
+type Summary struct {
+	Succeeded       bool                        `json:"succeeded"`
+	DryRun          bool                        `json:"dry_run"`
+	StartedAt       time.Time                   `json:"started_at"`
+	DurationSeconds float64                     `json:"duration_seconds"`
+	Created         int                         `json:"created"`
+	Updated         int                         `json:"updated"`
+	Deleted         int                         `json:"deleted"`
+	Instances       map[string]*InstanceSummary `json:"instances"`
+	Failures        []FailureSummary            `json:"failures"`
+}
+
+// InstanceSummary contains the changes made to, or planned for, a single instance
+type InstanceSummary struct {
+	Succeeded bool  `json:"succeeded"`
+	Created   int   `json:"created"`
+	Updated   int   `json:"updated"`
+	Deleted   int   `json:"deleted"`
+	Changes   *Plan `json:"changes"`
+}
+
+// FailureSummary is a Failure whose error is rendered as a string
+type FailureSummary struct {
+	Instance  string `json:"instance"`
+	Toplevel  string `json:"toplevel,omitempty"`
+	Operation string `json:"operation"`
+	Key       string `json:"key,omitempty"`
+	Error     string `json:"error"`
+}
+
+// NewSummary summarizes a reconcile of instances from the plans applied to each instance,
+// the failures recorded and the instances marked invalid
+func NewSummary(start time.Time, duration time.Duration, dryRun bool, plans map[string]*Plan,
+	failed []Failure, invalid []string) *Summary {
+	s := &Summary{
+		Succeeded:       len(failed) == 0 && len(invalid) == 0,
+		DryRun:          dryRun,
+		StartedAt:       start.UTC(),
+		DurationSeconds: duration.Seconds(),
+		Instances:       make(map[string]*InstanceSummary),
+		Failures:        []FailureSummary{},
+	}
+	instance := func(address string) *InstanceSummary {
+		i, ok := s.Instances[address]
+		if !ok {
+			i = &InstanceSummary{Succeeded: true, Changes: NewPlan()}
+			s.Instances[address] = i
+		}
+		return i
+	}
+	for address, p := range plans {
+		i := instance(address)
+		i.Changes.Merge(p)
+		i.Created, i.Updated, i.Deleted = len(i.Changes.Created), len(i.Changes.Updated), len(i.Changes.Deleted)
+		s.Created += i.Created
+		s.Updated += i.Updated
+		s.Deleted += i.Deleted
+	}
+	for _, f := range failed {
+		msg := ""
+		if f.Err != nil {
+			msg = f.Err.Error()
+		}
+		s.Failures = append(s.Failures, FailureSummary{
+			Instance:  f.Instance,
+			Toplevel:  f.Toplevel,
+			Operation: f.Operation,
+			Key:       f.Key,
+			Error:     msg,
+		})
+		instance(f.Instance).Succeeded = false
+	}
+	for _, address := range invalid {
+		instance(address).Succeeded = false
+	}
+	return s
+}
+
+// Message renders the summary as a single line, e.g. the message of a Kubernetes Event
+func (s *Summary) Message() string {
+	failedInstances := []string{}
+	for address, i := range s.Instances {
+		if !i.Succeeded {
+			failedInstances = append(failedInstances, address)
+		}
+	}
+	sort.Strings(failedInstances)
+	verb := "reconciled"
+	if s.DryRun {
+		verb = "planned"
+	}
+	msg := fmt.Sprintf("%s %d instances in %.1fs: %d created, %d updated, %d deleted, %d failures",
+		verb, len(s.Instances), s.DurationSeconds, s.Created, s.Updated, s.Deleted, len(s.Failures))
+	if len(failedInstances) > 0 {
+		msg += fmt.Sprintf(", failed instances: %v", failedInstances)
+	}
+	return msg
+}
+
+// SendSummary posts a summary as json to a webhook
+// errors do not include the url of the webhook as it may contain credentials
+func SendSummary(webhook string, s *Summary) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: summaryTimeout}
+	resp, err := client.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("webhook responded with status %d", resp.StatusCode))
+	}
+	return nil
+}
+
+// ValidateWebhook ensures a webhook is an absolute http(s) url
+func ValidateWebhook(webhook string) error {
+	u, err := url.Parse(webhook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("must be an absolute http or https url")
+	}
+	return nil
+}
+
+// InCluster determines if vault-manager runs within a Kubernetes pod with a service account
+func InCluster() bool {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" || os.Getenv("KUBERNETES_SERVICE_PORT") == "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(serviceAccountDir, "token"))
+	return err == nil
+}
+
+// EmitSummaryEvent creates a Kubernetes Event describing a summary for the pod vault-manager runs in
+// the pod is named by `POD_NAME`, defaulting to the hostname, and its service account must be allowed to create events
+func EmitSummaryEvent(s *Summary) error {
+	if !InCluster() {
+		return errors.New("not running within a kubernetes cluster")
+	}
+	token, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return err
+	}
+	namespace, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		return err
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return errors.New("failed to parse service account ca certificate")
+	}
+	pod := os.Getenv("POD_NAME")
+	if pod == "" {
+		if pod, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+
+	ns := string(bytes.TrimSpace(namespace))
+	body, err := json.Marshal(summaryEvent(s, ns, pod, time.Now()))
+	if err != nil {
+		return err
+	}
+	host := net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"))
+	endpoint := fmt.Sprintf("https://%s/api/v1/namespaces/%s/events", host, ns)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+string(bytes.TrimSpace(token)))
+
+	client := &http.Client{
+		Timeout:   summaryTimeout,
+		Transport: &http.Transport{TLSClientConfig: &cryptotls.Config{RootCAs: pool}},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("kubernetes api responded with status %d", resp.StatusCode))
+	}
+	return nil
+}
+
+// summaryEvent builds a core/v1 Event involving the pod vault-manager runs in
+func summaryEvent(s *Summary, namespace, pod string, now time.Time) map[string]interface{} {
+	eventType, reason := "Normal", "ReconcileSucceeded"
+	if !s.Succeeded {
+		eventType, reason = "Warning", "ReconcileFailed"
+	}
+	timestamp := now.UTC().Format(time.RFC3339)
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Event",
+		"metadata": map[string]interface{}{
+			"generateName": "vault-manager-",
+			"namespace":    namespace,
+		},
+		"involvedObject": map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"name":       pod,
+			"namespace":  namespace,
+		},
+		"reason":         reason,
+		"message":        s.Message(),
+		"type":           eventType,
+		"source":         map[string]interface{}{"component": "vault-manager"},
+		"firstTimestamp": timestamp,
+		"lastTimestamp":  timestamp,
+		"count":          1,
+	}
+}
@@ -0,0 +1,98 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSummary(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	plans := map[string]*Plan{
+		"https://a.test": {
+			Created: []Change{{Type: "policy", Name: "admin"}},
+			Updated: []Change{{Type: "role", Name: "ci"}},
+			Deleted: []Change{},
+		},
+		"https://b.test": {
+			Created: []Change{},
+			Updated: []Change{},
+			Deleted: []Change{{Type: "policy", Name: "old"}},
+		},
+	}
+	failed := []Failure{{Instance: "https://b.test", Toplevel: "vault_roles", Operation: "write", Key: "ci",
+		Err: errors.New("permission denied")}}
+
+	s := NewSummary(start, 90*time.Second, false, plans, failed, []string{"https://c.test"})
+	require.False(t, s.Succeeded)
+	require.Equal(t, 90.0, s.DurationSeconds)
+	require.Equal(t, 1, s.Created)
+	require.Equal(t, 1, s.Updated)
+	require.Equal(t, 1, s.Deleted)
+	require.Len(t, s.Instances, 3)
+	require.True(t, s.Instances["https://a.test"].Succeeded)
+	require.False(t, s.Instances["https://b.test"].Succeeded)
+	require.False(t, s.Instances["https://c.test"].Succeeded, "invalid instances without plans are failed")
+	require.Equal(t, []FailureSummary{{Instance: "https://b.test", Toplevel: "vault_roles", Operation: "write",
+		Key: "ci", Error: "permission denied"}}, s.Failures)
+	require.Equal(t, "reconciled 3 instances in 90.0s: 1 created, 1 updated, 1 deleted, 1 failures, "+
+		"failed instances: [https://b.test https://c.test]", s.Message())
+
+	s = NewSummary(start, time.Second, true, map[string]*Plan{}, nil, nil)
+	require.True(t, s.Succeeded)
+	require.Equal(t, "planned 0 instances in 1.0s: 0 created, 0 updated, 0 deleted, 0 failures", s.Message())
+}
+
+func TestSendSummary(t *testing.T) {
+	var received Summary
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		body, _ := ioutil.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &received))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s := NewSummary(time.Now(), time.Second, false, map[string]*Plan{"https://a.test": NewPlan()}, nil, nil)
+	require.NoError(t, SendSummary(server.URL, s))
+	require.True(t, received.Succeeded)
+	require.Contains(t, received.Instances, "https://a.test")
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	require.EqualError(t, SendSummary(failing.URL, s), "webhook responded with status 502")
+
+	err := SendSummary("http://127.0.0.1:1/hooks/secret-token", s)
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "secret-token", "the webhook url is not part of errors")
+}
+
+func TestValidateWebhook(t *testing.T) {
+	require.NoError(t, ValidateWebhook("https://hooks.test/reconcile"))
+	require.Error(t, ValidateWebhook("hooks.test/reconcile"))
+	require.Error(t, ValidateWebhook("ftp://hooks.test"))
+}
+
+func TestSummaryEvent(t *testing.T) {
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := NewSummary(now, time.Second, false, nil, nil, []string{"https://a.test"})
+
+	event := summaryEvent(s, "vault-manager", "vault-manager-1", now)
+	require.Equal(t, "Warning", event["type"])
+	require.Equal(t, "ReconcileFailed", event["reason"])
+	require.Equal(t, "2022-01-01T12:00:00Z", event["lastTimestamp"])
+	require.Equal(t, map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"name":       "vault-manager-1",
+		"namespace":  "vault-manager",
+	}, event["involvedObject"])
+}
@@ -0,0 +1,99 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// vaultDefaultLeaseTTL is the lease ttl vault uses when its server configuration sets none
+const vaultDefaultLeaseTTL = 768 * time.Hour
+
+// leaseTTLs are the system-wide default and max lease ttls expected of an instance
+// vault only reads these from its server configuration, so they are checked for drift but cannot be changed
+type leaseTTLs struct {
+	Default string `yaml:"defaultLeaseTTL"`
+	Max     string `yaml:"maxLeaseTTL"`
+}
+
+// validate ensures the configured ttls can be parsed and the default does not exceed the max
+func (l leaseTTLs) validate() error {
+	desired, err := l.durations()
+	if err != nil {
+		return err
+	}
+	if desired["default"] > 0 && desired["max"] > 0 && desired["default"] > desired["max"] {
+		return errors.New("`defaultLeaseTTL` must not exceed `maxLeaseTTL`")
+	}
+	return nil
+}
+
+// durations returns the configured ttls, omitted ttls are not included
+func (l leaseTTLs) durations() (map[string]time.Duration, error) {
+	result := map[string]time.Duration{}
+	for name, ttl := range map[string]string{"default": l.Default, "max": l.Max} {
+		if ttl == "" {
+			continue
+		}
+		d, err := ParseDuration(ttl)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid %s lease ttl `%s`: %v", name, ttl, err))
+		}
+		result[name] = d
+	}
+	return result, nil
+}
+
+// desired system lease ttls of instances that opted into checking them
+// populated with each call to GetInstances()
+var desiredLeaseTTLs = map[string]leaseTTLs{}
+
+// CheckSystemLeaseTTLs compares the system lease ttls of an instance with the configured ones,
+// if the instance is configured with `systemLeaseTTLs`. An error is returned if they differ,
+// as the server configuration of the instance must be changed to correct them.
+func CheckSystemLeaseTTLs(instanceAddr string) error {
+	desired, ok := desiredLeaseTTLs[instanceAddr]
+	if !ok {
+		return nil
+	}
+	durations, err := desired.durations()
+	if err != nil {
+		return err
+	}
+	state, err := ReadRaw(instanceAddr, "sys/config/state/sanitized")
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return errors.New("failed to read system configuration")
+	}
+
+	drifted := []string{}
+	for _, name := range []string{"default", "max"} {
+		expected, ok := durations[name]
+		if !ok {
+			continue
+		}
+		current := vaultDefaultLeaseTTL
+		// the sanitized configuration reports ttls in seconds, 0 if the server configuration sets none
+		seconds, _ := strconv.ParseInt(fmt.Sprintf("%v", state[name+"_lease_ttl"]), 10, 64)
+		if seconds > 0 {
+			current = time.Duration(seconds) * time.Second
+		}
+		fields := log.Fields{"ttl": name, "current": current.String(), "desired": expected.String()}
+		if current != expected {
+			Logger(instanceAddr, "").WithFields(fields).Warn(
+				"[Vault System] system lease ttl differs from configuration, change the server configuration of the instance")
+			drifted = append(drifted, name)
+			continue
+		}
+		Logger(instanceAddr, "").WithFields(fields).Debug("[Vault System] system lease ttl matches configuration")
+	}
+	if len(drifted) > 0 {
+		return errors.New(fmt.Sprintf("system %v lease ttl differs from configuration", drifted))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package vault
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rate of write requests per second allowed for each instance address
+// namespaces of an address share the rate as vault limits requests per node
+var (
+	writeRate     = 100.0
+	writeLimiters = map[string]*rate.Limiter{}
+	writeLimitM   sync.Mutex
+)
+
+// SetWriteRate overrides the number of write requests per second made to each instance
+// a rate of 0 disables throttling
+// must be called prior to GetInstances()
+func SetWriteRate(opsPerSecond float64) {
+	writeLimitM.Lock()
+	defer writeLimitM.Unlock()
+	writeRate = opsPerSecond
+	writeLimiters = map[string]*rate.Limiter{}
+}
+
+// writeContext waits until a write to the instance is permitted by its rate limiter and returns
+// a context for the write request. The wait does not count towards the write timeout.
+func writeContext(instanceAddr string) (context.Context, context.CancelFunc) {
+	if limiter := writeLimiter(instanceAddr); limiter != nil {
+		time.Sleep(limiter.Reserve().Delay())
+	}
+	return requestContext(writeTimeout)
+}
+
+// writeLimiter returns the token bucket of the address of an instance
+// bursts of up to one second worth of writes are permitted
+func writeLimiter(instanceAddr string) *rate.Limiter {
+	writeLimitM.Lock()
+	defer writeLimitM.Unlock()
+	if writeRate <= 0 {
+		return nil
+	}
+	address := strings.SplitN(instanceAddr, "|", 2)[0]
+	if writeLimiters[address] == nil {
+		burst := int(writeRate)
+		if burst < 1 {
+			burst = 1
+		}
+		writeLimiters[address] = rate.NewLimiter(rate.Limit(writeRate), burst)
+	}
+	return writeLimiters[address]
+}
@@ -0,0 +1,24 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteLimiter(t *testing.T) {
+	defer SetWriteRate(writeRate)
+
+	SetWriteRate(10)
+	limiter := writeLimiter("https://vault.example.com")
+	require.NotNil(t, limiter)
+	require.Same(t, limiter, writeLimiter("https://vault.example.com|team"))
+	require.NotSame(t, limiter, writeLimiter("https://other.example.com"))
+	require.Equal(t, 10, limiter.Burst())
+
+	SetWriteRate(0.5)
+	require.Equal(t, 1, writeLimiter("https://vault.example.com").Burst())
+
+	SetWriteRate(0)
+	require.Nil(t, writeLimiter("https://vault.example.com"))
+}
@@ -0,0 +1,54 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// tokens obtained by unwrapping a wrapping token per instance address
+// a wrapping token can only be unwrapped once while clients are initialized on every reconcile
+var (
+	unwrappedTokens  = make(map[string]string)
+	unwrappedTokensM sync.Mutex
+)
+
+// UnwrapToken unwraps a response-wrapped token using the provided client and returns the wrapped token.
+// The result is cached so a wrapping token is only unwrapped once per process.
+func UnwrapToken(client *api.Client, wrappingToken string) (string, error) {
+	unwrappedTokensM.Lock()
+	defer unwrappedTokensM.Unlock()
+
+	key := fmt.Sprintf("%s|%s", client.Address(), wrappingToken)
+	if token, ok := unwrappedTokens[key]; ok {
+		return token, nil
+	}
+
+	// the wrapping token is used to authenticate the unwrap request itself
+	current := client.Token()
+	client.SetToken(wrappingToken)
+	secret, err := client.Logical().Unwrap("")
+	client.SetToken(current)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf(
+			"failed to unwrap token for %s, the wrapping token may have expired or already been used: %v",
+			client.Address(), err))
+	}
+
+	var token string
+	switch {
+	case secret != nil && secret.Auth != nil:
+		token = secret.Auth.ClientToken
+	case secret != nil && secret.Data != nil:
+		// tokens wrapped as kv data are expected under a `token` key
+		token, _ = secret.Data["token"].(string)
+	}
+	if token == "" {
+		return "", errors.New(fmt.Sprintf("unwrapped response for %s does not contain a token", client.Address()))
+	}
+
+	unwrappedTokens[key] = token
+	return token, nil
+}
@@ -0,0 +1,97 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/go-version"
+)
+
+// versions caches the version of vault each instance runs for the current reconcile
+// reset with each call to GetInstances() so that an upgrade between reconciles is picked up
+// along with the top-level configurations of each instance with entries skipped for requiring a newer version,
+// which are not cached as applied so that they are applied once the instance is upgraded
+var (
+	versions    = make(map[string]string)
+	unsupported = make(map[string]map[string]bool)
+	versionsM   sync.Mutex
+)
+
+// GetVersion returns the version of vault an instance runs, e.g. `1.12.3+ent`, read once per instance
+func GetVersion(instanceAddr string) (*version.Version, error) {
+	raw, err := GetVaultVersion(instanceAddr)
+	if err != nil {
+		return nil, err
+	}
+	v, err := version.NewVersion(raw)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to parse version `%s` of %s: %v", raw, instanceAddr, err))
+	}
+	return v, nil
+}
+
+// MeetsMinVersion determines if an instance runs at least the minimum version of vault
+// build metadata such as `+ent` is ignored
+func MeetsMinVersion(instanceAddr, minimum string) (bool, *version.Version, error) {
+	min, err := version.NewVersion(minimum)
+	if err != nil {
+		return false, nil, errors.New(fmt.Sprintf("invalid minimum vault version `%s`: %v", minimum, err))
+	}
+	current, err := GetVersion(instanceAddr)
+	if err != nil {
+		return false, nil, err
+	}
+	return meetsMinVersion(current, min), current, nil
+}
+
+func meetsMinVersion(current, minimum *version.Version) bool {
+	return current.Core().GreaterThanOrEqual(minimum.Core())
+}
+
+// ValidateMinVersion ensures a minimum vault version can be parsed
+func ValidateMinVersion(minimum string) error {
+	if _, err := version.NewVersion(minimum); err != nil {
+		return errors.New(fmt.Sprintf("invalid minimum vault version `%s`: %v", minimum, err))
+	}
+	return nil
+}
+
+func cachedVersion(instanceAddr string) (string, bool) {
+	versionsM.Lock()
+	defer versionsM.Unlock()
+	v, ok := versions[instanceAddr]
+	return v, ok
+}
+
+func cacheVersion(instanceAddr, v string) {
+	versionsM.Lock()
+	defer versionsM.Unlock()
+	versions[instanceAddr] = v
+}
+
+// RecordUnsupported records that a top-level configuration, or some of its entries, was skipped for an instance
+// running an older version of vault than required
+func RecordUnsupported(instanceAddr, toplevelName string) {
+	versionsM.Lock()
+	defer versionsM.Unlock()
+	if unsupported[instanceAddr] == nil {
+		unsupported[instanceAddr] = make(map[string]bool)
+	}
+	unsupported[instanceAddr][toplevelName] = true
+}
+
+// HasUnsupported determines if a top-level configuration, or some of its entries, was skipped for an instance
+// in the current reconcile as the instance runs an older version of vault than required
+func HasUnsupported(instanceAddr, toplevelName string) bool {
+	versionsM.Lock()
+	defer versionsM.Unlock()
+	return unsupported[instanceAddr][toplevelName]
+}
+
+func resetVersions() {
+	versionsM.Lock()
+	defer versionsM.Unlock()
+	versions = make(map[string]string)
+	unsupported = make(map[string]map[string]bool)
+}
@@ -0,0 +1,49 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeetsMinVersion(t *testing.T) {
+	resetVersions()
+	defer resetVersions()
+	cacheVersion("https://a.example.com", "1.12.3+ent")
+	cacheVersion("https://b.example.com", "1.9.10")
+
+	tests := []struct {
+		address  string
+		minimum  string
+		expected bool
+	}{
+		{"https://a.example.com", "1.12.0", true},
+		{"https://a.example.com", "1.12.3", true},
+		{"https://a.example.com", "1.13", false},
+		{"https://b.example.com", "1.10.0", false},
+		{"https://b.example.com", "1.9", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address+" "+tt.minimum, func(t *testing.T) {
+			supported, _, err := MeetsMinVersion(tt.address, tt.minimum)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, supported)
+		})
+	}
+
+	_, _, err := MeetsMinVersion("https://a.example.com", "latest")
+	require.Error(t, err)
+}
+
+func TestUnsupported(t *testing.T) {
+	resetVersions()
+	defer resetVersions()
+
+	RecordUnsupported("https://a.example.com", "vault_managed_keys")
+	require.True(t, HasUnsupported("https://a.example.com", "vault_managed_keys"))
+	require.False(t, HasUnsupported("https://a.example.com", "vault_policies"))
+	require.False(t, HasUnsupported("https://b.example.com", "vault_managed_keys"))
+
+	resetVersions()
+	require.False(t, HasUnsupported("https://a.example.com", "vault_managed_keys"))
+}
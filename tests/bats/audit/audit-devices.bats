@@ -7,7 +7,7 @@ load ../helpers
     # CASE: enable audit device
     #
     export GRAPHQL_QUERY_FILE=/tests/fixtures/audit/enable_audit_device.graphql
-    run vault-manager
+    run vault-manager -prune
     [ "$status" -eq 0 ]
     # check vault-manager output
     echo $output
@@ -22,7 +22,9 @@ load ../helpers
     # we test for creation of this entity to further validate error handling on per instance basis
     vault delete identity/entity/name/tester
 
-    run vault-manager
+    run vault-manager -prune
+    # a failed instance results in a non-zero exit code
+    [ "$status" -eq 1 ]
     [[ "${output}" == *"SKIPPING ALL RECONCILIATION FOR: http://127.0.0.1:8202"* ]]
     [[ "${output}" == *"[Vault Auth] successfully enabled auth backend"*"instance=\"http://127.0.0.1:8200\""*"path=oidc/"*"type=oidc"* ]]
     [[ "${output}" == *"[Vault Identity] entity successfully written"*"instance=\"http://127.0.0.1:8200\""*"path=identity/entity/name/tester"*"type=entity"* ]]
@@ -49,7 +51,8 @@ load ../helpers
     export VAULT_ADDR=http://127.0.0.1:8200
     vault delete identity/entity/name/tester
 
-    run vault-manager
+    run vault-manager -prune
+    [ "$status" -eq 1 ]
     [[ "${output}" == *"SKIPPING REMAINING RECONCILIATION FOR http://127.0.0.1:8202"* ]]
     [[ "${output}" == *"[Vault Identity] entity successfully written"*"instance=\"http://127.0.0.1:8200\""*"path=identity/entity/name/tester"*"type=entity"* ]]
     [[ "${output}" == *"[Vault Identity] entity alias successfully written"*"instance=\"http://127.0.0.1:8200\""*"path=identity/entity-alias/tester"*"type=oidc"* ]]
@@ -1,6 +1,6 @@
 # rerun vault-manager to ensure that nothing happens on further runs
 rerun_check() {
-    run vault-manager
+    run vault-manager -prune
     [ "$status" -eq 0 ]
     # check vault-manager output
     [[ "${output}" == "" ]]
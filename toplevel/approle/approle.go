@@ -0,0 +1,338 @@
+// Package approle implements the application of a declarative configuration
+// for roles of Vault AppRole auth backends.
+//
+// Only roles within the mounts present in the configuration are managed, so a
+// mount should not also be configured through `vault_roles`.
+package approle
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/app-sre/vault-manager/pkg/utils"
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/app-sre/vault-manager/toplevel"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+type entry struct {
+	Mount    string         `yaml:"mount"`
+	Instance vault.Instance `yaml:"instance"`
+	Roles    []role         `yaml:"roles"`
+}
+
+type role struct {
+	Name            string   `yaml:"name"`
+	TokenPolicies   []string `yaml:"token_policies"`
+	TokenTTL        string   `yaml:"token_ttl"`
+	TokenMaxTTL     string   `yaml:"token_max_ttl"`
+	SecretIDTTL     string   `yaml:"secret_id_ttl"`
+	SecretIDNumUses int      `yaml:"secret_id_num_uses"`
+	// BindSecretID defaults to true when unset, matching vault
+	BindSecretID *bool  `yaml:"bind_secret_id"`
+	Mount        string `yaml:"-"`
+	vault.Toggle `yaml:",inline"`
+}
+
+var _ vault.Item = role{}
+
+func (r role) Key() string {
+	return filepath.Join("auth", r.Mount, "role", r.Name)
+}
+
+func (r role) KeyForType() string {
+	return ""
+}
+
+func (r role) KeyForDescription() string {
+	return ""
+}
+
+// Equals compares policies regardless of order and ttls regardless of their unit
+func (r role) Equals(i interface{}) bool {
+	rl, ok := i.(role)
+	if !ok {
+		return false
+	}
+
+	return r.Key() == rl.Key() &&
+		equalStrings(r.TokenPolicies, rl.TokenPolicies) &&
+		r.SecretIDNumUses == rl.SecretIDNumUses &&
+		r.bindSecretID() == rl.bindSecretID() &&
+		vault.OptionsEqual(r.ttls(), rl.ttls())
+}
+
+func (r role) bindSecretID() bool {
+	return r.BindSecretID == nil || *r.BindSecretID
+}
+
+func (r role) ttls() map[string]interface{} {
+	return map[string]interface{}{
+		"token_ttl":     ttlOrZero(r.TokenTTL),
+		"token_max_ttl": ttlOrZero(r.TokenMaxTTL),
+		"secret_id_ttl": ttlOrZero(r.SecretIDTTL),
+	}
+}
+
+type config struct{}
+
+var _ toplevel.Configuration = config{}
+
+const toplevelName = "vault_approles"
+
+func init() {
+	toplevel.RegisterConfiguration(toplevelName, config{}, "vault_auth_backends", "vault_policies")
+}
+
+// Validate ensures each role is named and its ttls and secret id uses are valid.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault AppRole] failed to decode approle configuration: %v", err))
+	}
+	errs := []error{}
+	for _, e := range entries {
+		for _, r := range e.Roles {
+			if r.Name == "" {
+				errs = append(errs, errors.New(fmt.Sprintf("[Vault AppRole] role without name in mount `%s`", e.Mount)))
+				continue
+			}
+			for option, ttl := range r.ttls() {
+				if _, err := vault.ParseDuration(ttl.(string)); err != nil {
+					errs = append(errs, errors.New(fmt.Sprintf(
+						"[Vault AppRole] invalid `%s` of role `%s` in mount `%s`: %v", option, r.Name, e.Mount, err)))
+				}
+			}
+			if r.SecretIDNumUses < 0 {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault AppRole] `secret_id_num_uses` of role `%s` in mount `%s` must not be negative", r.Name, e.Mount)))
+			}
+		}
+	}
+	return utils.JoinErrors(errs)
+}
+
+// Apply ensures that the roles of an instance's AppRole auth backends are
+// configured exactly as provided.
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return nil, errors.New(fmt.Sprintf("[Vault AppRole] failed to decode approle configuration: %v", err))
+	}
+
+	mounts := []string{}
+	desiredRoles := []role{}
+	for _, e := range entries {
+		if e.Instance.Key() != address {
+			continue
+		}
+		mount := strings.Trim(e.Mount, "/")
+		mounts = append(mounts, mount)
+		for _, r := range e.Roles {
+			r.Mount = mount
+			desiredRoles = append(desiredRoles, r)
+		}
+	}
+
+	existingRoles, err := getExistingRoles(address, mounts, threadPoolSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// roles are written in full so that updates and creations are handled alike
+	toBeWritten, toBeDeleted, _ := vault.DiffItems(
+		vault.ExcludeUnmanaged(asItems(desiredRoles), asItems(existingRoles)))
+	utils.RecordPendingChanges(address, toplevelName, len(toBeWritten)+len(toBeDeleted))
+	if !prune {
+		toBeDeleted = vault.SkipDeletes(address, "[Vault AppRole] role", toBeDeleted, nil)
+	}
+	if err := vault.CheckDeletes(address, "[Vault AppRole]", toBeDeleted); err != nil {
+		return nil, err
+	}
+
+	plan := vault.NewPlan()
+	plan.Add("approle", toBeWritten, nil, toBeDeleted, asItems(existingRoles))
+
+	if dryRun == true {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate, len(toBeWritten))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationDelete, len(toBeDeleted))
+		for _, w := range toBeWritten {
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"path":     w.Key(),
+				"policies": w.(role).TokenPolicies,
+			}).Info("[Dry Run] [Vault AppRole] role to be written")
+		}
+		for _, d := range toBeDeleted {
+			vault.Logger(address, toplevelName).WithField("path", d.Key()).Info(
+				"[Dry Run] [Vault AppRole] role to be deleted")
+		}
+		return plan, nil
+	}
+
+	for _, w := range toBeWritten {
+		err := writeRole(address, w.(role))
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationCreate)
+	}
+	for _, d := range toBeDeleted {
+		err := vault.DeleteRaw(address, d.Key())
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationDelete)
+		vault.Logger(address, toplevelName).WithField("path", d.Key()).Info(
+			"[Vault AppRole] role is successfully deleted")
+	}
+
+	return plan, nil
+}
+
+func writeRole(address string, r role) error {
+	data := map[string]interface{}{
+		"token_policies":     r.TokenPolicies,
+		"token_ttl":          ttlOrZero(r.TokenTTL),
+		"token_max_ttl":      ttlOrZero(r.TokenMaxTTL),
+		"secret_id_ttl":      ttlOrZero(r.SecretIDTTL),
+		"secret_id_num_uses": r.SecretIDNumUses,
+		"bind_secret_id":     r.bindSecretID(),
+	}
+	if r.TokenPolicies == nil {
+		data["token_policies"] = []string{}
+	}
+	err := vault.WriteRaw(address, r.Key(), data)
+	if err != nil {
+		return err
+	}
+	vault.Logger(address, toplevelName).WithField("path", r.Key()).Info(
+		"[Vault AppRole] role is successfully written")
+	return nil
+}
+
+// getExistingRoles reads the roles of each mount
+// mounts that are not enabled as approle auth backends, e.g. during a dry run, do not contain any roles
+func getExistingRoles(address string, mounts []string, threadPoolSize int) ([]role, error) {
+	backends, err := vault.ListAuthBackends(address)
+	if err != nil {
+		return nil, err
+	}
+	enabled := make(map[string]bool)
+	for path, backend := range backends {
+		if backend.Type == "approle" {
+			enabled[strings.Trim(path, "/")] = true
+		}
+	}
+
+	existing := []role{}
+	for _, mount := range mounts {
+		if !enabled[mount] {
+			continue
+		}
+		names, err := listNames(address, filepath.Join("auth", mount, "role"))
+		if err != nil {
+			return nil, err
+		}
+
+		var mutex = &sync.Mutex{}
+		var readErr error
+		bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+
+		for _, name := range names {
+			bwg.Add(1)
+
+			go func(name string) {
+				defer bwg.Done()
+
+				r := role{Name: name, Mount: mount}
+				data, err := vault.ReadRaw(address, r.Key())
+
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if err != nil {
+					readErr = err
+					return
+				}
+				if data == nil {
+					return
+				}
+				r.TokenPolicies = toStrings(data["token_policies"])
+				r.TokenTTL = fmt.Sprintf("%v", data["token_ttl"])
+				r.TokenMaxTTL = fmt.Sprintf("%v", data["token_max_ttl"])
+				r.SecretIDTTL = fmt.Sprintf("%v", data["secret_id_ttl"])
+				r.SecretIDNumUses, _ = strconv.Atoi(fmt.Sprintf("%v", data["secret_id_num_uses"]))
+				bind, _ := strconv.ParseBool(fmt.Sprintf("%v", data["bind_secret_id"]))
+				r.BindSecretID = &bind
+				existing = append(existing, r)
+			}(name)
+		}
+		bwg.Wait()
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	return existing, nil
+}
+
+// listNames returns the keys listed at path or an empty list if nothing exists
+func listNames(address, path string) ([]string, error) {
+	secret, err := vault.ListSecrets(address, path)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return []string{}, nil
+	}
+	return toStrings(secret.Data["keys"]), nil
+}
+
+func ttlOrZero(ttl string) string {
+	if ttl == "" {
+		return "0"
+	}
+	return ttl
+}
+
+func toStrings(raw interface{}) []string {
+	values, ok := raw.([]interface{})
+	if !ok {
+		return []string{}
+	}
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, fmt.Sprintf("%v", v))
+	}
+	return strs
+}
+
+func equalStrings(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	xs := append([]string{}, x...)
+	ys := append([]string{}, y...)
+	sort.Strings(xs)
+	sort.Strings(ys)
+	for i := range xs {
+		if xs[i] != ys[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func asItems(xs []role) (items []vault.Item) {
+	items = make([]vault.Item, 0)
+	for _, x := range xs {
+		items = append(items, x)
+	}
+
+	return
+}
@@ -0,0 +1,73 @@
+package approle
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoleEquals(t *testing.T) {
+	bind := true
+	existing := role{Name: "ci", Mount: "approle", TokenPolicies: []string{"read", "write"},
+		TokenTTL: "3600", TokenMaxTTL: "0", SecretIDTTL: "86400", SecretIDNumUses: 0, BindSecretID: &bind}
+
+	table := []struct {
+		description string
+		desired     role
+		expected    bool
+	}{
+		{
+			description: "policies in different order and ttls with units",
+			desired: role{Name: "ci", Mount: "approle", TokenPolicies: []string{"write", "read"},
+				TokenTTL: "1h", SecretIDTTL: "24h"},
+			expected: true,
+		},
+		{
+			description: "different policies",
+			desired: role{Name: "ci", Mount: "approle", TokenPolicies: []string{"read"},
+				TokenTTL: "1h", SecretIDTTL: "24h"},
+			expected: false,
+		},
+		{
+			description: "different ttl",
+			desired: role{Name: "ci", Mount: "approle", TokenPolicies: []string{"read", "write"},
+				TokenTTL: "2h", SecretIDTTL: "24h"},
+			expected: false,
+		},
+		{
+			description: "different secret id uses",
+			desired: role{Name: "ci", Mount: "approle", TokenPolicies: []string{"read", "write"},
+				TokenTTL: "1h", SecretIDTTL: "24h", SecretIDNumUses: 1},
+			expected: false,
+		},
+		{
+			description: "secret id not bound",
+			desired: role{Name: "ci", Mount: "approle", TokenPolicies: []string{"read", "write"},
+				TokenTTL: "1h", SecretIDTTL: "24h", BindSecretID: new(bool)},
+			expected: false,
+		},
+		{
+			description: "different mount",
+			desired: role{Name: "ci", Mount: "approle-ci", TokenPolicies: []string{"read", "write"},
+				TokenTTL: "1h", SecretIDTTL: "24h"},
+			expected: false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.desired.Equals(existing))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, config{}.Validate([]byte(
+		"- mount: approle\n  roles:\n  - name: ci\n    token_ttl: 1h\n    secret_id_ttl: 3600\n")))
+	require.Error(t, config{}.Validate([]byte(
+		"- mount: approle\n  roles:\n  - name: ci\n    token_ttl: 1 hour\n")))
+	require.Error(t, config{}.Validate([]byte(
+		"- mount: approle\n  roles:\n  - name: ci\n    secret_id_num_uses: -1\n")))
+	require.Error(t, config{}.Validate([]byte(
+		"- mount: approle\n  roles:\n  - token_ttl: 1h\n")))
+}
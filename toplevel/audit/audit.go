@@ -3,6 +3,12 @@
 package audit
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/app-sre/vault-manager/pkg/utils"
 	"github.com/app-sre/vault-manager/pkg/vault"
 	"github.com/app-sre/vault-manager/toplevel"
 
@@ -17,6 +23,13 @@ type entry struct {
 	Description string            `yaml:"description"`
 	Instance    vault.Instance    `yaml:"instance"`
 	Options     map[string]string `yaml:"options"`
+	// Filter is an expression selecting the requests and responses written to the audit device
+	// filters require vault 1.16 or later and cannot be changed without re-enabling the device
+	Filter *string `yaml:"filter"`
+	// ReloadOnApply reopens the file of a file audit device when the configuration is applied with
+	// `-reload-audit-devices`, e.g. so that files rotated out-of-band are written again
+	ReloadOnApply bool `yaml:"reload_on_apply"`
+	vault.Toggle  `yaml:",inline"`
 }
 
 var _ vault.Item = entry{}
@@ -42,41 +55,157 @@ func (e entry) Equals(i interface{}) bool {
 	return vault.EqualPathNames(e.Path, entry.Path) &&
 		e.Type == entry.Type &&
 		e.Description == entry.Description &&
-		vault.OptionsEqual(e.ambiguousOptions(), entry.ambiguousOptions())
+		vault.OptionsEqual(e.normalizedOptions(), entry.normalizedOptions())
 }
 
-func (e entry) ambiguousOptions() map[string]interface{} {
-	opts := make(map[string]interface{}, len(e.Options))
+// options returns the options passed to vault when enabling the audit device, including the filter
+func (e entry) options() map[string]string {
+	opts := make(map[string]string, len(e.Options)+1)
 	for k, v := range e.Options {
 		opts[k] = v
 	}
+	if e.Filter != nil {
+		opts["filter"] = *e.Filter
+	}
 	return opts
 }
 
+// commonOptionDefaults are the values vault assumes for options omitted from any type of audit device
+var commonOptionDefaults = map[string]string{
+	"format":               "json",
+	"log_raw":              "false",
+	"hmac_accessor":        "true",
+	"elide_list_responses": "false",
+}
+
+// optionDefaults are the values vault assumes for options omitted from each type of audit device
+var optionDefaults = map[string]map[string]string{
+	"file":   {"mode": "0600"},
+	"socket": {"socket_type": "tcp", "write_timeout": "2s"},
+	"syslog": {"facility": "AUTH", "tag": "vault"},
+}
+
+// boolOptions are the options vault accepts in any of the forms understood by strconv.ParseBool
+var boolOptions = map[string]bool{
+	"log_raw":              true,
+	"hmac_accessor":        true,
+	"elide_list_responses": true,
+}
+
+// normalizedOptions returns the options of the audit device with defaults applied for
+// omitted options and known options in canonical form, so that options that vault
+// stores differently than they are configured do not appear to have drifted
+func (e entry) normalizedOptions() map[string]interface{} {
+	opts := make(map[string]interface{}, len(e.Options))
+	for k, v := range commonOptionDefaults {
+		opts[k] = v
+	}
+	for k, v := range optionDefaults[e.Type] {
+		opts[k] = v
+	}
+	for k, v := range e.options() {
+		opts[k] = normalizeOption(k, v)
+	}
+	return opts
+}
+
+// normalizeOption returns the canonical form of an option value
+// values that cannot be parsed are returned unchanged
+func normalizeOption(key, value string) string {
+	if value == "" {
+		return value
+	}
+	switch {
+	case boolOptions[key]:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return strconv.FormatBool(b)
+		}
+	case key == "mode":
+		// file modes are octal, e.g. `600` and `0600` are the same mode
+		if m, err := strconv.ParseUint(value, 8, 32); err == nil {
+			return fmt.Sprintf("%04o", m)
+		}
+	case key == "write_timeout":
+		if d, err := vault.ParseDuration(value); err == nil {
+			return d.String()
+		}
+	}
+	return value
+}
+
 type config struct{}
 
 var _ toplevel.Configuration = config{}
 
+const toplevelName = "vault_audit_backends"
+
 func init() {
-	toplevel.RegisterConfiguration("vault_audit_backends", config{})
+	toplevel.RegisterConfiguration(toplevelName, config{})
+}
+
+// requiredOptions are the options that must be configured for each type of audit device
+var requiredOptions = map[string][]string{
+	"file":   {"file_path"},
+	"socket": {"address"},
+	"syslog": {},
+}
+
+// Validate ensures each audit device has a supported type and the options required by that type.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault Audit] failed to decode audit device configuration: %v", err))
+	}
+	errs := []error{}
+	for _, e := range entries {
+		required, ok := requiredOptions[e.Type]
+		if !ok {
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault Audit] unsupported type `%s` for audit device `%s`", e.Type, e.Path)))
+			continue
+		}
+		for _, option := range required {
+			if e.Options[option] == "" {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Audit] option `%s` is required for %s audit device `%s`", option, e.Type, e.Path)))
+			}
+		}
+		for option := range boolOptions {
+			if v, ok := e.Options[option]; ok {
+				if _, err := strconv.ParseBool(v); err != nil {
+					errs = append(errs, errors.New(fmt.Sprintf(
+						"[Vault Audit] option `%s` of audit device `%s` must be a boolean", option, e.Path)))
+				}
+			}
+		}
+		if e.Filter != nil && strings.TrimSpace(*e.Filter) == "" {
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault Audit] `filter` of audit device `%s` must not be empty, omit it to audit all requests", e.Path)))
+		}
+		if e.ReloadOnApply && e.Type != "file" {
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault Audit] `reload_on_apply` of audit device `%s` requires type file", e.Path)))
+		}
+	}
+	return utils.JoinErrors(errs)
 }
 
 // Apply ensures that an instance of Vault's Audit Devices are configured
 // exactly as provided.
-func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPoolSize int) error {
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
 	var entries []entry
 	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
-		log.WithError(err).Fatal("[Vault Audit] failed to decode audit device configuration")
+		return nil, errors.New(fmt.Sprintf("[Vault Audit] failed to decode audit device configuration: %v", err))
 	}
 	instancesToDesiredAudits := make(map[string][]entry)
 	for _, e := range entries {
-		instancesToDesiredAudits[e.Instance.Address] = append(instancesToDesiredAudits[e.Instance.Address], e)
+		instancesToDesiredAudits[e.Instance.Key()] = append(instancesToDesiredAudits[e.Instance.Key()], e)
 	}
 
 	// perform reconcile operations for specific instance
 	enabledAudits, err := vault.ListAuditDevices(address)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// format raw vault api result
@@ -91,21 +220,58 @@ func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPo
 	}
 	// Diff the local configuration with the Vault instance.
 	toBeWritten, toBeDeleted, _ :=
-		vault.DiffItems(asItems(instancesToDesiredAudits[address]), asItems(existingAduits))
+		vault.DiffItems(vault.ExcludeUnmanaged(asItems(instancesToDesiredAudits[address]), asItems(existingAduits)))
+
+	// audit devices cannot be tuned in place so drifted devices are
+	// separated out and re-enabled with the desired options
+	toBeWritten, toBeDeleted, toBeUpdated := determineUpdates(toBeWritten, toBeDeleted, existingAduits)
+	utils.RecordPendingChanges(address, toplevelName, len(toBeWritten)+len(toBeUpdated)+len(toBeDeleted))
+	if !prune {
+		toBeDeleted = vault.SkipDeletes(address, "[Vault Audit] audit device", toBeDeleted, nil)
+	}
+	if err := vault.CheckDeletes(address, "[Vault Audit]", toBeDeleted); err != nil {
+		return nil, err
+	}
+
+	updated := make([]vault.Item, 0, len(toBeUpdated))
+	for _, u := range toBeUpdated {
+		updated = append(updated, u.desired)
+	}
+	plan := vault.NewPlan()
+	plan.Add("audit", toBeWritten, updated, toBeDeleted, asItems(existingAduits))
+
+	// devices are not reloaded again when the configuration is applied for its deferred deletes
+	reloaded := []entry{}
+	if vault.ReloadAuditDevices() && !vault.ApplyingDeferredDeletes(address) {
+		reloaded = toBeReloaded(instancesToDesiredAudits[address], toBeWritten, toBeUpdated)
+	}
 
 	if dryRun == true {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate, len(toBeWritten))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationUpdate, len(toBeUpdated))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationDelete, len(toBeDeleted))
 		for _, w := range toBeWritten {
-			log.WithFields(log.Fields{
-				"path":     w.Key(),
-				"instance": address,
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"path":    w.Key(),
+				"options": utils.RedactStringOptions(w.(entry).options()),
 			}).Info("[Dry Run] [Vault Audit] audit device to be enabled")
 		}
+		for _, u := range toBeUpdated {
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"path":    u.desired.Path,
+				"options": utils.RedactStringOptions(u.desired.options()),
+			}).Info("[Dry Run] [Vault Audit] audit device to be updated")
+		}
 		for _, d := range toBeDeleted {
-			log.WithFields(log.Fields{
-				"path":     d.Key(),
-				"instance": address,
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"path": d.Key(),
 			}).Info("[Dry Run] [Vault Audit] audit device to be disabled")
 		}
+		for _, r := range reloaded {
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"path": r.Path,
+			}).Info("[Dry Run] [Vault Audit] audit device to be reloaded")
+		}
 	} else {
 		// Write any missing Audit Devices to the Vault instance.
 		for _, e := range toBeWritten {
@@ -113,24 +279,166 @@ func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPo
 			err := vault.EnableAuditDevice(address, ent.Path, &api.EnableAuditOptions{
 				Type:        ent.Type,
 				Description: ent.Description,
-				Options:     ent.Options,
+				Options:     ent.options(),
 			})
+			if err == vault.ErrPathInUse {
+				// the device was enabled since existing devices were listed, e.g. by an overlapping run
+				err = updateEnabled(address, ent)
+			}
 			if err != nil {
-				return err
+				return nil, err
 			}
+			utils.RecordOperation(address, toplevelName, utils.OperationCreate)
+		}
+		// Re-enable any drifted Audit Devices with the desired options.
+		// Newly written devices are enabled above so that auditing is not
+		// left completely off while a drifted device is being replaced.
+		for _, u := range toBeUpdated {
+			err := updateAuditDevice(address, u)
+			if err != nil {
+				return nil, err
+			}
+			utils.RecordOperation(address, toplevelName, utils.OperationUpdate)
 		}
 		// Delete any Audit Devices from the Vault instance.
 		for _, e := range toBeDeleted {
 			err := vault.DisableAuditDevice(address, e.(entry).Path)
 			if err != nil {
-				return err
+				return nil, err
 			}
+			utils.RecordOperation(address, toplevelName, utils.OperationDelete)
+		}
+		// Reload file Audit Devices, those enabled or re-enabled above already opened their files.
+		for _, r := range reloaded {
+			err := vault.ReloadAuditDevice(address, r.Path)
+			if err != nil {
+				return nil, err
+			}
+			utils.RecordOperation(address, toplevelName, utils.OperationUpdate)
 		}
 	}
 
+	return plan, nil
+}
+
+// toBeReloaded returns the managed file audit devices configured with `reload_on_apply`
+// that are neither enabled nor re-enabled with new options by the apply
+func toBeReloaded(desired []entry, toBeWritten []vault.Item, toBeUpdated []auditUpdate) []entry {
+	reloaded := []entry{}
+	for _, e := range desired {
+		if !e.ReloadOnApply || e.Type != "file" || e.Unmanaged() {
+			continue
+		}
+		applied := false
+		for _, w := range toBeWritten {
+			if vault.EqualPathNames(e.Path, w.(entry).Path) {
+				applied = true
+			}
+		}
+		for _, u := range toBeUpdated {
+			if vault.EqualPathNames(e.Path, u.desired.Path) {
+				applied = true
+			}
+		}
+		if !applied {
+			reloaded = append(reloaded, e)
+		}
+	}
+	return reloaded
+}
+
+// auditUpdate pairs an existing audit device with its desired configuration
+type auditUpdate struct {
+	existing entry
+	desired  entry
+}
+
+// determineUpdates removes desired audit devices that already exist under the
+// same path from the to be written set and returns them as updates.
+// Existing devices being updated are removed from the to be deleted set.
+func determineUpdates(toBeWritten, toBeDeleted []vault.Item, existing []entry) ([]vault.Item, []vault.Item, []auditUpdate) {
+	written := make([]vault.Item, 0)
+	updates := []auditUpdate{}
+	for _, w := range toBeWritten {
+		ent := w.(entry)
+		found := false
+		for _, e := range existing {
+			if vault.EqualPathNames(ent.Path, e.Path) {
+				updates = append(updates, auditUpdate{existing: e, desired: ent})
+				found = true
+				break
+			}
+		}
+		if !found {
+			written = append(written, w)
+		}
+	}
+
+	deleted := make([]vault.Item, 0)
+	for _, d := range toBeDeleted {
+		updated := false
+		for _, u := range updates {
+			if vault.EqualPathNames(d.(entry).Path, u.existing.Path) {
+				updated = true
+				break
+			}
+		}
+		if !updated {
+			deleted = append(deleted, d)
+		}
+	}
+	return written, deleted, updates
+}
+
+// updateAuditDevice applies new configuration to an existing audit device.
+// vault does not allow two devices to share a path so the existing device is
+// disabled before being enabled with the desired options.
+func updateAuditDevice(instanceAddr string, u auditUpdate) error {
+	err := vault.DisableAuditDevice(instanceAddr, u.existing.Path)
+	if err != nil {
+		return err
+	}
+	err = vault.EnableAuditDevice(instanceAddr, u.desired.Path, &api.EnableAuditOptions{
+		Type:        u.desired.Type,
+		Description: u.desired.Description,
+		Options:     u.desired.options(),
+	})
+	if err != nil {
+		return err
+	}
+	vault.Logger(instanceAddr, toplevelName).WithFields(log.Fields{
+		"path": u.desired.Path,
+	}).Info("[Vault Audit] audit device is successfully updated")
 	return nil
 }
 
+// updateEnabled updates an audit device that could not be enabled as its path is already in use
+// the existing device is left in place if it already matches the desired configuration
+func updateEnabled(instanceAddr string, desired entry) error {
+	enabledAudits, err := vault.ListAuditDevices(instanceAddr)
+	if err != nil {
+		return err
+	}
+	for _, a := range enabledAudits {
+		if !vault.EqualPathNames(a.Path, desired.Path) {
+			continue
+		}
+		existing := entry{
+			Path:        a.Path,
+			Type:        a.Type,
+			Description: a.Description,
+			Options:     a.Options,
+		}
+		if desired.Equals(existing) {
+			vault.Logger(instanceAddr, toplevelName).WithField("path", desired.Path).Info(
+				"[Vault Audit] audit device is already enabled")
+			return nil
+		}
+		return updateAuditDevice(instanceAddr, auditUpdate{existing: existing, desired: desired})
+	}
+	return vault.ErrPathInUse
+}
+
 func asItems(xs []entry) (items []vault.Item) {
 	items = make([]vault.Item, 0)
 	for _, x := range xs {
@@ -0,0 +1,163 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryEqualsOptions(t *testing.T) {
+	filter := `mount_type == "kv"`
+	table := []struct {
+		description string
+		desired     entry
+		existing    entry
+		expected    bool
+	}{
+		{
+			description: "file mode without leading zero",
+			desired:     entry{Path: "file/", Type: "file", Options: map[string]string{"file_path": "/tmp/audit.log", "mode": "600"}},
+			existing:    entry{Path: "file/", Type: "file", Options: map[string]string{"file_path": "/tmp/audit.log", "mode": "0600"}},
+			expected:    true,
+		},
+		{
+			description: "omitted file mode equals default",
+			desired:     entry{Path: "file/", Type: "file", Options: map[string]string{"file_path": "/tmp/audit.log"}},
+			existing:    entry{Path: "file/", Type: "file", Options: map[string]string{"file_path": "/tmp/audit.log", "mode": "0600"}},
+			expected:    true,
+		},
+		{
+			description: "different file mode",
+			desired:     entry{Path: "file/", Type: "file", Options: map[string]string{"file_path": "/tmp/audit.log", "mode": "0644"}},
+			existing:    entry{Path: "file/", Type: "file", Options: map[string]string{"file_path": "/tmp/audit.log", "mode": "0600"}},
+			expected:    false,
+		},
+		{
+			description: "boolean forms",
+			desired:     entry{Path: "file/", Type: "file", Options: map[string]string{"file_path": "stdout", "log_raw": "1", "hmac_accessor": "False"}},
+			existing:    entry{Path: "file/", Type: "file", Options: map[string]string{"file_path": "stdout", "log_raw": "true", "hmac_accessor": "false"}},
+			expected:    true,
+		},
+		{
+			description: "omitted booleans equal defaults",
+			desired:     entry{Path: "file/", Type: "file", Options: map[string]string{"file_path": "stdout"}},
+			existing:    entry{Path: "file/", Type: "file", Options: map[string]string{"file_path": "stdout", "log_raw": "false", "hmac_accessor": "true", "format": "json"}},
+			expected:    true,
+		},
+		{
+			description: "different boolean",
+			desired:     entry{Path: "file/", Type: "file", Options: map[string]string{"file_path": "stdout", "log_raw": "true"}},
+			existing:    entry{Path: "file/", Type: "file", Options: map[string]string{"file_path": "stdout"}},
+			expected:    false,
+		},
+		{
+			description: "omitted socket options equal defaults",
+			desired:     entry{Path: "socket/", Type: "socket", Options: map[string]string{"address": "127.0.0.1:9090"}},
+			existing:    entry{Path: "socket/", Type: "socket", Options: map[string]string{"address": "127.0.0.1:9090", "socket_type": "tcp", "write_timeout": "2s"}},
+			expected:    true,
+		},
+		{
+			description: "socket write timeout without unit",
+			desired:     entry{Path: "socket/", Type: "socket", Options: map[string]string{"address": "127.0.0.1:9090", "write_timeout": "5"}},
+			existing:    entry{Path: "socket/", Type: "socket", Options: map[string]string{"address": "127.0.0.1:9090", "write_timeout": "5s"}},
+			expected:    true,
+		},
+		{
+			description: "different socket type",
+			desired:     entry{Path: "socket/", Type: "socket", Options: map[string]string{"address": "127.0.0.1:9090", "socket_type": "udp"}},
+			existing:    entry{Path: "socket/", Type: "socket", Options: map[string]string{"address": "127.0.0.1:9090"}},
+			expected:    false,
+		},
+		{
+			description: "matching filter",
+			desired:     entry{Path: "file/", Type: "file", Options: map[string]string{"file_path": "stdout"}, Filter: &filter},
+			existing:    entry{Path: "file/", Type: "file", Options: map[string]string{"file_path": "stdout", "filter": filter}},
+			expected:    true,
+		},
+		{
+			description: "filter removed",
+			desired:     entry{Path: "file/", Type: "file", Options: map[string]string{"file_path": "stdout"}},
+			existing:    entry{Path: "file/", Type: "file", Options: map[string]string{"file_path": "stdout", "filter": filter}},
+			expected:    false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.desired.Equals(tt.existing))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	table := []struct {
+		description string
+		config      string
+		expectErr   bool
+	}{
+		{
+			description: "filter",
+			config:      "- _path: file/\n  type: file\n  filter: operation == \"update\"\n  options:\n    file_path: stdout\n",
+			expectErr:   false,
+		},
+		{
+			description: "empty filter",
+			config:      "- _path: file/\n  type: file\n  filter: \"\"\n  options:\n    file_path: stdout\n",
+			expectErr:   true,
+		},
+		{
+			description: "hmac accessor disabled",
+			config:      "- _path: file/\n  type: file\n  options:\n    file_path: stdout\n    hmac_accessor: \"false\"\n",
+			expectErr:   false,
+		},
+		{
+			description: "hmac accessor not a boolean",
+			config:      "- _path: file/\n  type: file\n  options:\n    file_path: stdout\n    hmac_accessor: \"no\"\n",
+			expectErr:   true,
+		},
+		{
+			description: "reload file device",
+			config:      "- _path: file/\n  type: file\n  reload_on_apply: true\n  options:\n    file_path: /var/log/vault/audit.log\n",
+			expectErr:   false,
+		},
+		{
+			description: "reload socket device",
+			config:      "- _path: socket/\n  type: socket\n  reload_on_apply: true\n  options:\n    address: 127.0.0.1:9090\n",
+			expectErr:   true,
+		},
+		{
+			description: "missing required option",
+			config:      "- _path: file/\n  type: file\n",
+			expectErr:   true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			err := config{}.Validate([]byte(tt.config))
+			if tt.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestToBeReloaded(t *testing.T) {
+	unmanaged := false
+	desired := []entry{
+		{Path: "file/", Type: "file", ReloadOnApply: true},
+		{Path: "new/", Type: "file", ReloadOnApply: true},
+		{Path: "drifted/", Type: "file", ReloadOnApply: true},
+		{Path: "unmanaged/", Type: "file", ReloadOnApply: true, Toggle: vault.Toggle{Managed: &unmanaged}},
+		{Path: "other/", Type: "file"},
+	}
+	toBeWritten := []vault.Item{entry{Path: "new/", Type: "file"}}
+	toBeUpdated := []auditUpdate{{existing: entry{Path: "drifted/"}, desired: entry{Path: "drifted/", Type: "file"}}}
+
+	reloaded := toBeReloaded(desired, toBeWritten, toBeUpdated)
+	require.Len(t, reloaded, 1, "devices enabled by the apply already opened their files")
+	require.Equal(t, "file/", reloaded[0].Path)
+}
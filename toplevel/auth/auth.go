@@ -22,8 +22,21 @@ type entry struct {
 	Type           string                            `yaml:"type"`
 	Description    string                            `yaml:"description"`
 	Instance       vault.Instance                    `yaml:"instance"`
+	Options        map[string]string                 `yaml:"options"`
 	Settings       map[string]map[string]interface{} `yaml:"settings"`
 	PolicyMappings []policyMapping                   `yaml:"policy_mappings"`
+	// LdapGroups only apply to ldap backends, existing groups are left unmanaged when unset
+	LdapGroups []ldapGroup `yaml:"ldap_groups"`
+	// Local marks the backend as local to the cluster, excluding it from replication, and
+	// cannot be changed once the backend is enabled
+	Local bool `yaml:"local"`
+	// TokenType, DefaultLeaseTTL, MaxLeaseTTL and ListingVisibility are tuned on the
+	// auth backend and are left unmanaged when unset
+	TokenType         string `yaml:"token_type"`
+	DefaultLeaseTTL   string `yaml:"default_lease_ttl"`
+	MaxLeaseTTL       string `yaml:"max_lease_ttl"`
+	ListingVisibility string `yaml:"listing_visibility"`
+	vault.Toggle      `yaml:",inline"`
 }
 
 type policyMapping struct {
@@ -56,7 +69,64 @@ func (e entry) Equals(i interface{}) bool {
 	}
 
 	return vault.EqualPathNames(e.Path, entry.Path) &&
-		e.Type == entry.Type
+		e.Type == entry.Type &&
+		e.Local == entry.Local &&
+		e.tuneEquals(entry)
+}
+
+// tuneEquals compares the tune settings set on the desired backend e with the existing backend
+func (e entry) tuneEquals(existing entry) bool {
+	desiredTTLs := map[string]interface{}{}
+	existingTTLs := map[string]interface{}{}
+	if e.DefaultLeaseTTL != "" {
+		desiredTTLs["default_lease_ttl"] = e.DefaultLeaseTTL
+		existingTTLs["default_lease_ttl"] = existing.DefaultLeaseTTL
+	}
+	if e.MaxLeaseTTL != "" {
+		desiredTTLs["max_lease_ttl"] = e.MaxLeaseTTL
+		existingTTLs["max_lease_ttl"] = existing.MaxLeaseTTL
+	}
+	return (e.TokenType == "" || e.TokenType == existing.TokenType) &&
+		(e.ListingVisibility == "" || e.ListingVisibility == existing.ListingVisibility) &&
+		vault.OptionsEqual(desiredTTLs, existingTTLs)
+}
+
+// tuned returns whether any tune setting of the auth backend is managed
+func (e entry) tuned() bool {
+	return e.TokenType != "" || e.DefaultLeaseTTL != "" || e.MaxLeaseTTL != "" || e.ListingVisibility != ""
+}
+
+// tuneData returns the managed tune settings written to `sys/auth/<path>/tune`
+func (e entry) tuneData() map[string]interface{} {
+	data := map[string]interface{}{}
+	for k, v := range map[string]string{
+		"token_type":         e.TokenType,
+		"default_lease_ttl":  e.DefaultLeaseTTL,
+		"max_lease_ttl":      e.MaxLeaseTTL,
+		"listing_visibility": e.ListingVisibility,
+	} {
+		if v != "" {
+			data[k] = v
+		}
+	}
+	return data
+}
+
+// enableOptions returns the options enabling the auth backend
+// tune settings are applied when enabling so that a new backend does not need to be tuned
+func (e entry) enableOptions() *api.EnableAuthOptions {
+	return &api.EnableAuthOptions{
+		Type:        e.Type,
+		Description: e.Description,
+		Options:     e.Options,
+		Local:       e.Local,
+		Config: api.MountConfigInput{
+			DefaultLeaseTTL:   e.DefaultLeaseTTL,
+			MaxLeaseTTL:       e.MaxLeaseTTL,
+			TokenType:         e.TokenType,
+			ListingVisibility: e.ListingVisibility,
+		},
+	}
 }
 
 func (p policyMapping) Key() string {
@@ -97,28 +167,85 @@ type config struct{}
 
 var _ toplevel.Configuration = config{}
 
+const toplevelName = "vault_auth_backends"
+
 func init() {
-	toplevel.RegisterConfiguration("vault_auth_backends", config{})
+	toplevel.RegisterConfiguration(toplevelName, config{}, "vault_policies", "vault_secrets", "vault_plugins")
+	// the oidc client secret and the ldap bindpass are read from secrets
+	toplevel.ReadsSecrets(toplevelName)
+}
+
+// tokenTypes are the token types vault accepts for auth backends
+var tokenTypes = map[string]bool{
+	"":                true,
+	"service":         true,
+	"batch":           true,
+	"default-service": true,
+	"default-batch":   true,
+}
+
+// listingVisibilities are the listing visibilities vault accepts for auth backends
+var listingVisibilities = map[string]bool{
+	"":       true,
+	"hidden": true,
+	"unauth": true,
+}
+
+// Validate ensures the configuration can be decoded and the tune settings are valid.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault Auth] failed to decode auth backend configuration: %v", err))
+	}
+	errs := []error{}
+	for _, e := range entries {
+		if !tokenTypes[e.TokenType] {
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault Auth] unsupported token_type `%s` for auth backend `%s`", e.TokenType, e.Path)))
+		}
+		if !listingVisibilities[e.ListingVisibility] {
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault Auth] unsupported listing_visibility `%s` for auth backend `%s`, must be hidden or unauth",
+				e.ListingVisibility, e.Path)))
+		}
+		for name, ttl := range map[string]string{"default_lease_ttl": e.DefaultLeaseTTL, "max_lease_ttl": e.MaxLeaseTTL} {
+			if ttl == "" {
+				continue
+			}
+			if _, err := vault.ParseDuration(ttl); err != nil {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Auth] invalid `%s` of auth backend `%s`: %v", name, e.Path, err)))
+			}
+		}
+		if bindpass, ok := e.Settings["config"][vault.LDAP_BINDPASS]; ok {
+			if _, ok := bindpass.(map[interface{}]interface{}); !ok {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Auth] `bindpass` of auth backend `%s` must reference a secret by `path` and `field`", e.Path)))
+			}
+		}
+		errs = append(errs, validateLdapGroups(e)...)
+	}
+	return utils.JoinErrors(errs)
 }
 
 // Apply ensures that an instance of Vault's authentication backends are
 // configured exactly as provided.
-func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPoolSize int) error {
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
 	// Unmarshal the list of configured auth backends.
 	var entries []entry
 	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
-		log.WithError(err).Fatal("[Vault Auth] failed to decode auth backend configuration")
+		return nil, errors.New(fmt.Sprintf("[Vault Auth] failed to decode auth backend configuration: %v", err))
 	}
 	// organize by instance
 	instancesToDesired := make(map[string][]entry)
 	for _, e := range entries {
-		instancesToDesired[e.Instance.Address] = append(instancesToDesired[e.Instance.Address], e)
+		instancesToDesired[e.Instance.Key()] = append(instancesToDesired[e.Instance.Key()], e)
 	}
 
 	// Get the existing auth backends
 	existingAuthMounts, err := vault.ListAuthBackends(address)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Build a array of all the existing entries.
@@ -130,35 +257,64 @@ func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPo
 				Path:        path,
 				Type:        backend.Type,
 				Description: backend.Description,
+				Local:       backend.Local,
 				Instance:    vault.Instance{Address: address},
 			})
 		}
 	}
 
+	err = readTuneSettings(address, instancesToDesired[address], existingBackends, threadPoolSize)
+	if err != nil {
+		return nil, err
+	}
+
 	// perform auth reconcile
 	toBeWritten, toBeDeleted, _ :=
-		vault.DiffItems(entriesAsItems(instancesToDesired[address]), entriesAsItems(existingBackends))
+		vault.DiffItems(vault.ExcludeUnmanaged(entriesAsItems(instancesToDesired[address]), entriesAsItems(existingBackends)))
+	toBeWritten = skipLocalChanges(address, toBeWritten, existingBackends)
+	toBeWritten, toBeTuned := determineTuneUpdates(toBeWritten, existingBackends)
+	// policy mapping changes are added to the pending changes once determined for each github mount
+	pendingChanges := len(toBeWritten) + len(toBeTuned) + len(vault.ExcludeItems(toBeDeleted, isDefault))
+	if !prune {
+		toBeDeleted = vault.SkipDeletes(address, "[Vault Auth] auth backend", toBeDeleted, isDefault)
+	}
+	// builtin backends are never disabled, so they are excluded before both dry runs and applies
+	toBeDeleted = vault.ExcludeItems(toBeDeleted, isDefault)
+	if err := vault.CheckDeletes(address, "[Vault Auth]", toBeDeleted); err != nil {
+		return nil, err
+	}
+	plan := vault.NewPlan()
+	plan.Add("auth", toBeWritten, toBeTuned, toBeDeleted, entriesAsItems(existingBackends))
 	err = enableAuth(address, toBeWritten, dryRun)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	err = tuneAuth(address, toBeTuned, dryRun)
+	if err != nil {
+		return nil, err
 	}
 	err = configureAuthMounts(address, instancesToDesired[address], dryRun)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	groupChanges, err := configureLdapGroups(address, instancesToDesired[address], plan, dryRun, prune, threadPoolSize)
+	pendingChanges += groupChanges
+	if err != nil {
+		return nil, err
 	}
 	err = disableAuth(address, toBeDeleted, dryRun)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// apply github policy mappings
 	for _, e := range instancesToDesired[address] {
-		if e.Type == "github" {
+		if e.Type == "github" && !e.Unmanaged() {
 			//Build a array of existing policy mappings for current auth mount
 			existingPolicyMappings := make([]policyMapping, 0)
 			teamsList, err := vault.ListSecrets(address, filepath.Join("/auth", e.Path, "map/teams"))
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if teamsList != nil {
 
@@ -205,7 +361,7 @@ func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPo
 
 				for e := range ch {
 					if e != nil {
-						return e
+						return nil, e
 					}
 				}
 			}
@@ -213,23 +369,18 @@ func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPo
 			// remove all gh user policy mappings from vault
 			usersList, err := vault.ListSecrets(address, filepath.Join("/auth", e.Path, "map/users"))
 			if usersList != nil {
-
-				users := usersList.Data["keys"].([]interface{})
+				users := userMappingsToBeDeleted(address, e.Path, usersList.Data["keys"].([]interface{}), prune)
 
 				bwg := utils.NewBoundedWaitGroup(threadPoolSize)
 				// remove existing gh user policy mappings in parallel
-				for user := range users {
+				for _, user := range users {
 
 					bwg.Add(1)
 
-					go func(user int) {
-
-						policyMappingPath := filepath.Join("/auth/", e.Path, "map/users", users[user].(string))
-
-						deletePolicyMapping(address, policyMappingPath, dryRun)
-
+					go func(user vault.Item) {
 						defer bwg.Done()
 
+						deletePolicyMapping(address, user.Key(), dryRun)
 					}(user)
 				}
 				bwg.Wait()
@@ -237,6 +388,12 @@ func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPo
 
 			policiesMappingsToBeApplied, policiesMappingsToBeDeleted, _ :=
 				vault.DiffItems(policyMappingsAsItems(e.PolicyMappings), policyMappingsAsItems(existingPolicyMappings))
+			pendingChanges += len(policiesMappingsToBeApplied) + len(policiesMappingsToBeDeleted)
+			if !prune {
+				policiesMappingsToBeDeleted = vault.SkipDeletes(address, "[Vault Auth] policies mapping", policiesMappingsToBeDeleted, nil)
+			}
+			plan.Add("policies-mapping", policiesMappingsToBeApplied, nil,
+				policiesMappingsToBeDeleted, policyMappingsAsItems(existingPolicyMappings))
 
 			// apply policy mappings
 			for _, pm := range policiesMappingsToBeApplied {
@@ -257,29 +414,158 @@ func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPo
 			}
 		}
 	}
+	utils.RecordPendingChanges(address, toplevelName, pendingChanges)
+
+	return plan, nil
+}
+
+// skipLocalChanges removes auth backends whose `local` flag differs from the backend enabled at the same
+// path as the flag cannot be changed without disabling the backend, which requires a manual migration
+func skipLocalChanges(instanceAddr string, toBeWritten []vault.Item, existing []entry) []vault.Item {
+	written := make([]vault.Item, 0, len(toBeWritten))
+	for _, w := range toBeWritten {
+		ent := w.(entry)
+		changed := false
+		for _, e := range existing {
+			if vault.EqualPathNames(ent.Path, e.Path) && ent.Type == e.Type && ent.Local != e.Local {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			written = append(written, w)
+			continue
+		}
+		vault.Logger(instanceAddr, toplevelName).WithFields(log.Fields{
+			"path":  ent.Path,
+			"local": ent.Local,
+		}).Error("[Vault Auth] local flag of auth backend cannot be changed, a manual migration is required")
+		vault.RecordFailure(instanceAddr, toplevelName, "recreate", ent.Path, errors.New(fmt.Sprintf(
+			"local flag cannot be changed to %t without disabling the auth backend", ent.Local)))
+	}
+	return written
+}
 
+// readTuneSettings reads the tune settings of existing auth backends that are tuned by a desired backend
+// settings of other backends are left unread as they are not compared
+func readTuneSettings(instanceAddr string, desired []entry, existing []entry, threadPoolSize int) error {
+	var mutex = &sync.Mutex{}
+	var readErr error
+	bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+	for i := range existing {
+		tuned := false
+		for _, d := range desired {
+			if vault.EqualPathNames(d.Path, existing[i].Path) && d.tuned() && !d.Unmanaged() {
+				tuned = true
+				break
+			}
+		}
+		if !tuned {
+			continue
+		}
+		bwg.Add(1)
+
+		go func(e *entry) {
+			defer bwg.Done()
+
+			data, err := vault.ReadRaw(instanceAddr, filepath.Join("sys/auth", e.Path, "tune"))
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err != nil {
+				readErr = err
+				return
+			}
+			if data == nil {
+				return
+			}
+			e.DefaultLeaseTTL = fmt.Sprintf("%v", data["default_lease_ttl"])
+			e.MaxLeaseTTL = fmt.Sprintf("%v", data["max_lease_ttl"])
+			if tokenType, ok := data["token_type"]; ok {
+				e.TokenType = fmt.Sprintf("%v", tokenType)
+			}
+			// listing visibility is omitted by vault when hidden
+			e.ListingVisibility = "hidden"
+			if visibility, ok := data["listing_visibility"]; ok && fmt.Sprintf("%v", visibility) != "" {
+				e.ListingVisibility = fmt.Sprintf("%v", visibility)
+			}
+		}(&existing[i])
+	}
+	bwg.Wait()
+	return readErr
+}
+
+// determineTuneUpdates moves desired auth backends that only differ from the existing backend at
+// the same path by tune settings from the to be written set to the returned to be tuned set,
+// so that the backend is tuned rather than enabled again
+func determineTuneUpdates(toBeWritten []vault.Item, existing []entry) ([]vault.Item, []vault.Item) {
+	written := make([]vault.Item, 0)
+	tuned := make([]vault.Item, 0)
+	for _, w := range toBeWritten {
+		ent := w.(entry)
+		tuneChanged := false
+		for _, e := range existing {
+			if !vault.EqualPathNames(ent.Path, e.Path) {
+				continue
+			}
+			withTune := e
+			withTune.TokenType = ent.TokenType
+			withTune.DefaultLeaseTTL = ent.DefaultLeaseTTL
+			withTune.MaxLeaseTTL = ent.MaxLeaseTTL
+			withTune.ListingVisibility = ent.ListingVisibility
+			tuneChanged = ent.Equals(withTune)
+			break
+		}
+		if tuneChanged {
+			tuned = append(tuned, w)
+		} else {
+			written = append(written, w)
+		}
+	}
+	return written, tuned
+}
+
+// tuneAuth writes the tune settings of existing auth backends
+// backends enabled by enableAuth are already tuned when enabled
+func tuneAuth(instanceAddr string, toBeTuned []vault.Item, dryRun bool) error {
+	for _, t := range toBeTuned {
+		ent := t.(entry)
+		if dryRun == true {
+			vault.Logger(instanceAddr, toplevelName).WithFields(log.Fields{
+				"path": ent.Path,
+				"tune": ent.tuneData(),
+			}).Info("[Dry Run] [Vault Auth] auth backend to be tuned")
+			utils.RecordPlannedOperations(instanceAddr, toplevelName, utils.OperationUpdate, 1)
+			continue
+		}
+		err := vault.WriteRaw(instanceAddr, filepath.Join("sys/auth", ent.Path, "tune"), ent.tuneData())
+		if err != nil {
+			return err
+		}
+		utils.RecordOperation(instanceAddr, toplevelName, utils.OperationUpdate)
+		vault.Logger(instanceAddr, toplevelName).WithField("path", ent.Path).Info(
+			"[Vault Auth] auth backend is successfully tuned")
+	}
 	return nil
 }
 
 func enableAuth(instanceAddr string, toBeWritten []vault.Item, dryRun bool) error {
-	// TODO(riuvshin): implement auth tuning
 	for _, e := range toBeWritten {
 		ent := e.(entry)
 		if dryRun == true {
-			log.WithFields(log.Fields{
-				"path":     ent.Path,
-				"type":     ent.Type,
-				"instance": instanceAddr,
+			vault.Logger(instanceAddr, toplevelName).WithFields(log.Fields{
+				"path":    ent.Path,
+				"type":    ent.Type,
+				"options": utils.RedactStringOptions(ent.Options),
 			}).Info("[Dry Run] [Vault Auth] auth backend to be enabled")
+			utils.RecordPlannedOperations(instanceAddr, toplevelName, utils.OperationCreate, 1)
 		} else {
-			err := vault.EnableAuthWithOptions(instanceAddr, ent.Path,
-				&api.EnableAuthOptions{
-					Type:        ent.Type,
-					Description: ent.Description,
-				})
+			err := vault.EnableAuthWithOptions(instanceAddr, ent.Path, ent.enableOptions())
 			if err != nil {
 				return err
 			}
+			utils.RecordOperation(instanceAddr, toplevelName, utils.OperationCreate)
 		}
 	}
 	return nil
@@ -288,13 +574,21 @@ func enableAuth(instanceAddr string, toBeWritten []vault.Item, dryRun bool) erro
 func configureAuthMounts(instanceAddr string, entries []entry, dryRun bool) error {
 	// configure auth mounts
 	for _, e := range entries {
-		if e.Settings != nil {
+		if e.Settings != nil && !e.Unmanaged() {
 			if e.Type == "oidc" {
-				err := getOidcClientSecret(instanceAddr, e.Settings)
+				err := getConfigSecret(instanceAddr, e.Settings, vault.OIDC_CLIENT_SECRET, vault.OIDC_CLIENT_SECRET_KV_VER)
 				if err != nil {
 					return err
 				}
 			}
+			if e.Type == "ldap" {
+				if _, ok := e.Settings["config"][vault.LDAP_BINDPASS]; ok {
+					err := getConfigSecret(instanceAddr, e.Settings, vault.LDAP_BINDPASS, vault.LDAP_BINDPASS_KV_VER)
+					if err != nil {
+						return err
+					}
+				}
+			}
 			for name, cfg := range e.Settings {
 				path := filepath.Join("auth", e.Path, name)
 				dataExists, err := vault.DataInSecret(instanceAddr, cfg, path, vault.KV_V1)
@@ -303,14 +597,16 @@ func configureAuthMounts(instanceAddr string, entries []entry, dryRun bool) erro
 				}
 				if !dataExists {
 					if dryRun == true {
-						log.WithField("path", path).WithField("type", e.Type).WithField("instance", instanceAddr).Info(
-							"[Dry Run] [Vault Auth] auth backend configuration to be written")
+						vault.Logger(instanceAddr, toplevelName).WithField("path", path).WithField("type", e.Type).WithField(
+							"config", utils.RedactOptions(cfg)).Info("[Dry Run] [Vault Auth] auth backend configuration to be written")
+						utils.RecordPlannedOperations(instanceAddr, toplevelName, utils.OperationUpdate, 1)
 					} else {
 						err := vault.WriteSecret(instanceAddr, path, vault.KV_V1, cfg)
 						if err != nil {
 							return err
 						}
-						log.WithField("path", path).WithField("type", e.Type).WithField("instance", instanceAddr).Info(
+						utils.RecordOperation(instanceAddr, toplevelName, utils.OperationUpdate)
+						vault.Logger(instanceAddr, toplevelName).WithField("path", path).WithField("type", e.Type).Info(
 							"[Vault Auth] auth backend successfully configured")
 					}
 				}
@@ -323,34 +619,45 @@ func configureAuthMounts(instanceAddr string, entries []entry, dryRun bool) erro
 func disableAuth(instanceAddr string, toBeDeleted []vault.Item, dryRun bool) error {
 	for _, e := range toBeDeleted {
 		ent := e.(entry)
-		if strings.HasPrefix(ent.Path, "token/") {
-			continue
-		}
 		if dryRun == true {
-			log.WithField("path", ent.Path).WithField("type", ent.Type).WithField("instance", instanceAddr).Info(
+			vault.Logger(instanceAddr, toplevelName).WithField("path", ent.Path).WithField("type", ent.Type).Info(
 				"[Dry Run] [Vault Auth] auth backend to be disabled")
+			utils.RecordPlannedOperations(instanceAddr, toplevelName, utils.OperationDelete, 1)
 		} else {
 			err := vault.DisableAuth(instanceAddr, ent.Path)
 			if err != nil {
 				return err
 			}
-			log.WithField("path", ent.Path).WithField("type", ent.Type).WithField("instance", instanceAddr).Info(
+			utils.RecordOperation(instanceAddr, toplevelName, utils.OperationDelete)
+			vault.Logger(instanceAddr, toplevelName).WithField("path", ent.Path).WithField("type", ent.Type).Info(
 				"[Vault Auth] auth backend disabled")
 		}
 	}
 	return nil
 }
 
+// the token auth backend is mounted by vault and cannot be disabled
+// isDefault determines if an item is a builtin auth backend that is never disabled
+func isDefault(i vault.Item) bool {
+	return isDefaultMount(i.Key())
+}
+
+func isDefaultMount(path string) bool {
+	return strings.HasPrefix(path, "token/")
+}
+
 func writePolicyMapping(instanceAddr string, path string, data map[string]interface{}, dryRun bool) error {
 	if dryRun == true {
-		log.WithField("path", path).WithField("policies", data["value"]).WithField("instance", instanceAddr).Info(
+		vault.Logger(instanceAddr, toplevelName).WithField("path", path).WithField("policies", data["value"]).Info(
 			"[Dry Run] [Vault Auth] policies mapping to be applied")
+		utils.RecordPlannedOperations(instanceAddr, toplevelName, utils.OperationCreate, 1)
 	} else {
 		err := vault.WriteSecret(instanceAddr, path, vault.KV_V1, data)
 		if err != nil {
 			return err
 		}
-		log.WithField("path", path).WithField("policies", data["value"]).WithField("instance", instanceAddr).Info(
+		utils.RecordOperation(instanceAddr, toplevelName, utils.OperationCreate)
+		vault.Logger(instanceAddr, toplevelName).WithField("path", path).WithField("policies", data["value"]).Info(
 			"[Vault Auth] policies mapping is successfully applied")
 	}
 	return nil
@@ -366,15 +673,55 @@ func entriesAsItems(xs []entry) (items []vault.Item) {
 
 func deletePolicyMapping(instanceAddr string, path string, dryRun bool) {
 	if dryRun == true {
-		log.WithField("path", path).WithField("instance", instanceAddr).Info(
+		vault.Logger(instanceAddr, toplevelName).WithField("path", path).Info(
 			"[Dry Run] [Vault Auth] policies mapping to be deleted")
+		utils.RecordPlannedOperations(instanceAddr, toplevelName, utils.OperationDelete, 1)
 	} else {
-		vault.DeleteSecret(instanceAddr, path)
-		log.WithField("path", path).WithField("instance", instanceAddr).Info(
+		if vault.DeleteSecret(instanceAddr, path) == nil {
+			utils.RecordOperation(instanceAddr, toplevelName, utils.OperationDelete)
+		}
+		vault.Logger(instanceAddr, toplevelName).WithField("path", path).Info(
 			"[Vault Auth] policies mapping is successfully deleted")
 	}
 }
 
+// userMapping is a policy mapping of a github user, which are not supported and always deleted
+type userMapping struct {
+	path string
+}
+
+var _ vault.Item = userMapping{}
+
+func (u userMapping) Key() string {
+	return u.path
+}
+
+func (u userMapping) KeyForType() string {
+	return "github-user-mapping"
+}
+
+func (u userMapping) KeyForDescription() string {
+	return ""
+}
+
+func (u userMapping) Equals(i interface{}) bool {
+	user, ok := i.(userMapping)
+	return ok && u.path == user.path
+}
+
+// userMappingsToBeDeleted returns the github user policy mappings of an auth backend to be deleted,
+// without pruning they are skipped, or counted when the deletes of the instance are deferred
+func userMappingsToBeDeleted(instanceAddr, backend string, users []interface{}, prune bool) []vault.Item {
+	toBeDeleted := make([]vault.Item, 0, len(users))
+	for _, user := range users {
+		toBeDeleted = append(toBeDeleted, userMapping{path: filepath.Join("/auth/", backend, "map/users", user.(string))})
+	}
+	if !prune {
+		toBeDeleted = vault.SkipDeletes(instanceAddr, "[Vault Auth] policies mapping", toBeDeleted, nil)
+	}
+	return toBeDeleted
+}
+
 func policyMappingsAsItems(xs []policyMapping) (items []vault.Item) {
 	items = make([]vault.Item, 0)
 	for _, x := range xs {
@@ -383,19 +730,20 @@ func policyMappingsAsItems(xs []policyMapping) (items []vault.Item) {
 	return items
 }
 
-// retrieves client secret at vault location specified in oidc auth definition
-func getOidcClientSecret(instanceAddr string, settings map[string]map[string]interface{}) error {
+// retrieves a secret of the auth backend config, e.g. the oidc client secret or the ldap bind password,
+// at the vault location specified in the auth definition
+func getConfigSecret(instanceAddr string, settings map[string]map[string]interface{}, key, kvVersionKey string) error {
 	// logic to check existence of keys before referencing is unnecessary due to schema validation
 	cfg := settings["config"]
-	engineVersion := cfg[vault.OIDC_CLIENT_SECRET_KV_VER].(string)
-	location := cfg[vault.OIDC_CLIENT_SECRET].(map[interface{}]interface{})
+	engineVersion := cfg[kvVersionKey].(string)
+	location := cfg[key].(map[interface{}]interface{})
 	path := location["path"].(string)
 	field := location["field"].(string)
 	secret, err := vault.GetVaultSecretField(instanceAddr, path, field, engineVersion)
 	if err != nil {
 		return errors.New(fmt.Sprintf(
-			"[Vault Auth] failed to retrieve `oidc_client_secret` for %s", instanceAddr))
+			"[Vault Auth] failed to retrieve `%s` for %s", key, instanceAddr))
 	}
-	cfg[vault.OIDC_CLIENT_SECRET] = secret
+	cfg[key] = secret
 	return nil
 }
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnableOptions(t *testing.T) {
+	e := entry{Path: "oidc/", Type: "oidc", Description: "sso", Options: map[string]string{"x": "y"}, Local: true}
+
+	options := e.enableOptions()
+	require.Equal(t, "oidc", options.Type)
+	require.Equal(t, "sso", options.Description)
+	require.Equal(t, map[string]string{"x": "y"}, options.Options)
+	require.True(t, options.Local)
+}
+
+func TestSkipLocalChanges(t *testing.T) {
+	existing := []entry{
+		{Path: "oidc/", Type: "oidc"},
+		{Path: "github/", Type: "github", Local: true},
+	}
+	toBeWritten := []vault.Item{
+		entry{Path: "oidc", Type: "oidc", Local: true},
+		entry{Path: "github", Type: "github"},
+		entry{Path: "approle", Type: "approle", Local: true},
+	}
+
+	written := skipLocalChanges("https://vault.example.com", toBeWritten, existing)
+	require.Equal(t, []vault.Item{toBeWritten[2]}, written)
+}
+
+func TestDetermineTuneUpdates(t *testing.T) {
+	existing := []entry{
+		{Path: "oidc/", Type: "oidc", DefaultLeaseTTL: "3600", MaxLeaseTTL: "2764800", TokenType: "default-service",
+			ListingVisibility: "hidden"},
+		{Path: "github/", Type: "github", DefaultLeaseTTL: "3600"},
+	}
+	toBeWritten := []vault.Item{
+		entry{Path: "oidc/", Type: "oidc", DefaultLeaseTTL: "2h", ListingVisibility: "unauth"},
+		entry{Path: "github/", Type: "jwt", DefaultLeaseTTL: "2h"},
+		entry{Path: "approle/", Type: "approle", TokenType: "batch"},
+	}
+
+	written, tuned := determineTuneUpdates(toBeWritten, existing)
+	require.Equal(t, []vault.Item{toBeWritten[1], toBeWritten[2]}, written)
+	require.Equal(t, []vault.Item{toBeWritten[0]}, tuned)
+
+	require.True(t, entry{Path: "oidc/", Type: "oidc"}.Equals(existing[0]), "unset tune settings are not managed")
+	require.True(t, entry{Path: "oidc/", Type: "oidc", DefaultLeaseTTL: "1h", MaxLeaseTTL: "768h"}.Equals(existing[0]),
+		"ttls are compared regardless of their unit")
+	require.False(t, entry{Path: "oidc/", Type: "oidc", TokenType: "batch"}.Equals(existing[0]))
+}
+
+func TestTuneData(t *testing.T) {
+	e := entry{Path: "oidc/", Type: "oidc", DefaultLeaseTTL: "1h", ListingVisibility: "unauth"}
+	require.Equal(t, map[string]interface{}{"default_lease_ttl": "1h", "listing_visibility": "unauth"}, e.tuneData())
+
+	options := e.enableOptions()
+	require.Equal(t, "1h", options.Config.DefaultLeaseTTL)
+	require.Equal(t, "unauth", options.Config.ListingVisibility)
+	require.Empty(t, options.Config.MaxLeaseTTL)
+}
+
+func TestDefaultBackendsNotDisabled(t *testing.T) {
+	existing := []entry{
+		{Path: "token/", Type: "token"},
+		{Path: "github/", Type: "github"},
+	}
+
+	_, toBeDeleted, _ := vault.DiffItems(entriesAsItems([]entry{}), entriesAsItems(existing))
+	deleted := vault.ExcludeItems(toBeDeleted, isDefault)
+	require.Len(t, deleted, 1)
+	require.Equal(t, "github/", deleted[0].Key())
+}
+
+func TestLdapGroupEquals(t *testing.T) {
+	existing := ldapGroup{Name: "admins", Policies: toStrings([]interface{}{"b", "a"}), backend: "ldap/"}
+
+	require.True(t, ldapGroup{Name: "admins", Policies: []string{"a", "b"}, backend: "ldap/"}.Equals(existing),
+		"policies are compared regardless of order")
+	require.True(t, ldapGroup{Name: "admins", Policies: toStrings("a, b"), backend: "ldap/"}.Equals(existing),
+		"policies reported as a comma separated string")
+	require.False(t, ldapGroup{Name: "admins", Policies: []string{"a"}, backend: "ldap/"}.Equals(existing))
+	require.False(t, ldapGroup{Name: "admins", Policies: []string{"a", "b"}, backend: "ldap-other/"}.Equals(existing),
+		"groups of other backends are other groups")
+	require.Equal(t, "auth/ldap/groups/admins", existing.path())
+}
+
+func TestValidateLdap(t *testing.T) {
+	invalid := []byte(`
+- _path: github/
+  type: github
+  ldap_groups:
+  - name: admins
+- _path: ldap/
+  type: ldap
+  settings:
+    config:
+      bindpass: plaintext
+  ldap_groups:
+  - policies: [admin]
+`)
+	err := config{}.Validate(invalid)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "`ldap_groups` of auth backend `github/` require type ldap")
+	require.Contains(t, err.Error(), "`bindpass` of auth backend `ldap/` must reference a secret")
+	require.Contains(t, err.Error(), "ldap group without name on auth backend `ldap/`")
+
+	valid := []byte(`
+- _path: ldap/
+  type: ldap
+  settings:
+    config:
+      url: ldaps://ldap.test
+      bindpass:
+        path: ldap/creds
+        field: password
+      bindpass_kv_version: v1
+  ldap_groups:
+  - name: admins
+    policies: [admin]
+`)
+	require.NoError(t, config{}.Validate(valid))
+}
+
+func TestUserMappingsDeferredWithPrune(t *testing.T) {
+	address := "https://auth.vault.test"
+	users := []interface{}{"alice", "bob"}
+
+	toBeDeleted := userMappingsToBeDeleted(address, "github/", users, true)
+	require.Len(t, toBeDeleted, 2)
+	require.Equal(t, "/auth/github/map/users/alice", toBeDeleted[0].Key())
+
+	vault.DeferDeletes(address)
+	require.Empty(t, userMappingsToBeDeleted(address, "github/", users, false))
+	require.Equal(t, 2, vault.StopDeferringDeletes(address),
+		"deferred user mappings are counted so that the deletes are applied by the second pass")
+
+	require.Empty(t, userMappingsToBeDeleted(address, "github/", users, false))
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/app-sre/vault-manager/pkg/utils"
+	"github.com/app-sre/vault-manager/pkg/vault"
+	log "github.com/sirupsen/logrus"
+)
+
+// ldapGroup maps the members of an ldap group to policies, written to `auth/<path>/groups/<name>`
+type ldapGroup struct {
+	Name     string   `yaml:"name"`
+	Policies []string `yaml:"policies"`
+	// backend is the path of the ldap auth backend the group is configured on
+	backend string
+}
+
+var _ vault.Item = ldapGroup{}
+
+func (g ldapGroup) Key() string {
+	return filepath.Join(g.backend, "groups", g.Name)
+}
+
+func (g ldapGroup) KeyForType() string {
+	return "ldap-group"
+}
+
+func (g ldapGroup) KeyForDescription() string {
+	return ""
+}
+
+// Equals compares the policies of the groups regardless of their order
+func (g ldapGroup) Equals(i interface{}) bool {
+	group, ok := i.(ldapGroup)
+	if !ok {
+		return false
+	}
+	return g.Key() == group.Key() && equalStrings(g.Policies, group.Policies)
+}
+
+func (g ldapGroup) path() string {
+	return filepath.Join("auth", g.Key())
+}
+
+// validateLdapGroups ensures groups are only configured on ldap auth backends
+func validateLdapGroups(e entry) []error {
+	errs := []error{}
+	if e.LdapGroups != nil && e.Type != "ldap" {
+		errs = append(errs, errors.New(fmt.Sprintf(
+			"[Vault Auth] `ldap_groups` of auth backend `%s` require type ldap", e.Path)))
+	}
+	for _, g := range e.LdapGroups {
+		if g.Name == "" {
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault Auth] ldap group without name on auth backend `%s`", e.Path)))
+		}
+	}
+	return errs
+}
+
+// configureLdapGroups reconciles the groups of each ldap auth backend of an instance and returns
+// the number of pending changes
+func configureLdapGroups(instanceAddr string, entries []entry, plan *vault.Plan,
+	dryRun, prune bool, threadPoolSize int) (int, error) {
+	pendingChanges := 0
+	for _, e := range entries {
+		if e.Type != "ldap" || e.LdapGroups == nil || e.Unmanaged() {
+			continue
+		}
+		desired := make([]vault.Item, 0, len(e.LdapGroups))
+		for _, g := range e.LdapGroups {
+			g.backend = e.Path
+			desired = append(desired, g)
+		}
+		existing, err := readLdapGroups(instanceAddr, e.Path, threadPoolSize)
+		if err != nil {
+			return pendingChanges, err
+		}
+
+		toBeWritten, toBeDeleted, _ := vault.DiffItems(desired, existing)
+		pendingChanges += len(toBeWritten) + len(toBeDeleted)
+		if !prune {
+			toBeDeleted = vault.SkipDeletes(instanceAddr, "[Vault Auth] ldap group", toBeDeleted, nil)
+		}
+		if err := vault.CheckDeletes(instanceAddr, "[Vault Auth]", toBeDeleted); err != nil {
+			return pendingChanges, err
+		}
+		plan.Add("ldap-group", toBeWritten, nil, toBeDeleted, existing)
+
+		for _, w := range toBeWritten {
+			g := w.(ldapGroup)
+			if dryRun == true {
+				vault.Logger(instanceAddr, toplevelName).WithFields(log.Fields{
+					"path":     g.path(),
+					"policies": g.Policies,
+				}).Info("[Dry Run] [Vault Auth] ldap group to be written")
+				utils.RecordPlannedOperations(instanceAddr, toplevelName, utils.OperationCreate, 1)
+				continue
+			}
+			data := map[string]interface{}{"policies": strings.Join(g.Policies, ",")}
+			if err := vault.WriteRaw(instanceAddr, g.path(), data); err != nil {
+				return pendingChanges, err
+			}
+			utils.RecordOperation(instanceAddr, toplevelName, utils.OperationCreate)
+			vault.Logger(instanceAddr, toplevelName).WithField("path", g.path()).Info(
+				"[Vault Auth] ldap group is successfully written")
+		}
+		for _, d := range toBeDeleted {
+			g := d.(ldapGroup)
+			if dryRun == true {
+				vault.Logger(instanceAddr, toplevelName).WithField("path", g.path()).Info(
+					"[Dry Run] [Vault Auth] ldap group to be deleted")
+				utils.RecordPlannedOperations(instanceAddr, toplevelName, utils.OperationDelete, 1)
+				continue
+			}
+			if err := vault.DeleteRaw(instanceAddr, g.path()); err != nil {
+				return pendingChanges, err
+			}
+			utils.RecordOperation(instanceAddr, toplevelName, utils.OperationDelete)
+			vault.Logger(instanceAddr, toplevelName).WithField("path", g.path()).Info(
+				"[Vault Auth] ldap group is successfully deleted")
+		}
+	}
+	return pendingChanges, nil
+}
+
+// readLdapGroups reads the groups of an ldap auth backend
+func readLdapGroups(instanceAddr, backend string, threadPoolSize int) ([]vault.Item, error) {
+	names, err := vault.ListRaw(instanceAddr, filepath.Join("auth", backend, "groups"))
+	if err != nil {
+		return nil, err
+	}
+
+	existing := []vault.Item{}
+	var mutex = &sync.Mutex{}
+	var readErr error
+	bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+
+	for _, name := range names {
+		bwg.Add(1)
+
+		go func(name string) {
+			defer bwg.Done()
+
+			g := ldapGroup{Name: name, backend: backend}
+			data, err := vault.ReadRaw(instanceAddr, g.path())
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err != nil {
+				readErr = err
+				return
+			}
+			if data == nil {
+				return
+			}
+			g.Policies = toStrings(data["policies"])
+			existing = append(existing, g)
+		}(name)
+	}
+	bwg.Wait()
+	if readErr != nil {
+		return nil, readErr
+	}
+	return existing, nil
+}
+
+// toStrings converts a list returned by vault, or a comma separated string returned by older versions,
+// to a list of strings
+func toStrings(v interface{}) []string {
+	strs := []string{}
+	switch values := v.(type) {
+	case []interface{}:
+		for _, value := range values {
+			strs = append(strs, fmt.Sprintf("%v", value))
+		}
+	case string:
+		for _, value := range strings.Split(values, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				strs = append(strs, value)
+			}
+		}
+	}
+	return strs
+}
+
+// equalStrings compares lists of strings regardless of order
+func equalStrings(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	xs := append([]string{}, x...)
+	ys := append([]string{}, y...)
+	sort.Strings(xs)
+	sort.Strings(ys)
+	for i := range xs {
+		if xs[i] != ys[i] {
+			return false
+		}
+	}
+	return true
+}
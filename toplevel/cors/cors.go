@@ -0,0 +1,244 @@
+// Package cors implements the application of a declarative configuration
+// for the CORS settings of Vault instances, which control the origins allowed to use the UI and API.
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/app-sre/vault-manager/pkg/utils"
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/app-sre/vault-manager/toplevel"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const corsPath = "sys/config/cors"
+
+// standardHeaders are always allowed by vault and returned along with the configured headers
+var standardHeaders = map[string]bool{
+	"Content-Type":                  true,
+	"X-Requested-With":              true,
+	"X-Vault-Aws-Iam-Server-Id":     true,
+	"X-Vault-Mfa":                   true,
+	"X-Vault-No-Request-Forwarding": true,
+	"X-Vault-Wrap-Format":           true,
+	"X-Vault-Wrap-Ttl":              true,
+	"X-Vault-Policy-Override":       true,
+	"Authorization":                 true,
+	"X-Vault-Token":                 true,
+	"X-Vault-Namespace":             true,
+}
+
+// entry is the CORS configuration of an instance
+// an instance has a single CORS configuration, so entries are keyed by the path of the configuration
+type entry struct {
+	Instance       vault.Instance `yaml:"instance"`
+	Enabled        bool           `yaml:"enabled"`
+	AllowedOrigins []string       `yaml:"allowed_origins"`
+	AllowedHeaders []string       `yaml:"allowed_headers"`
+	vault.Toggle   `yaml:",inline"`
+}
+
+var _ vault.Item = entry{}
+
+func (e entry) Key() string {
+	return corsPath
+}
+
+func (e entry) KeyForType() string {
+	return ""
+}
+
+func (e entry) KeyForDescription() string {
+	return ""
+}
+
+// Equals compares origins regardless of order and headers regardless of order, case
+// and the standard headers vault allows anyway
+func (e entry) Equals(i interface{}) bool {
+	entry, ok := i.(entry)
+	if !ok {
+		return false
+	}
+
+	return e.Enabled == entry.Enabled &&
+		equalStrings(e.AllowedOrigins, entry.AllowedOrigins) &&
+		equalStrings(customHeaders(e.AllowedHeaders), customHeaders(entry.AllowedHeaders))
+}
+
+// customHeaders returns the canonical form of headers that are not allowed by vault by default
+func customHeaders(headers []string) []string {
+	custom := []string{}
+	for _, h := range headers {
+		h = http.CanonicalHeaderKey(h)
+		if !standardHeaders[h] {
+			custom = append(custom, h)
+		}
+	}
+	return custom
+}
+
+func (e entry) data() map[string]interface{} {
+	return map[string]interface{}{
+		"allowed_origins": e.AllowedOrigins,
+		"allowed_headers": customHeaders(e.AllowedHeaders),
+	}
+}
+
+type config struct{}
+
+var _ toplevel.Configuration = config{}
+
+const toplevelName = "vault_cors"
+
+func init() {
+	toplevel.RegisterConfiguration(toplevelName, config{})
+}
+
+// Validate ensures each instance has a single CORS configuration and enabled configurations allow an origin.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault CORS] failed to decode cors configuration: %v", err))
+	}
+	errs := []error{}
+	instances := map[string]bool{}
+	for _, e := range entries {
+		if instances[e.Instance.Key()] {
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault CORS] multiple cors configurations for instance `%s`", e.Instance.Key())))
+		}
+		instances[e.Instance.Key()] = true
+		if e.Enabled && len(e.AllowedOrigins) == 0 {
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault CORS] `allowed_origins` is required to enable cors for instance `%s`, use `*` to allow any origin",
+				e.Instance.Key())))
+		}
+	}
+	return utils.JoinErrors(errs)
+}
+
+// Apply ensures that the CORS configuration of an instance is exactly as provided.
+// Configurations that are not enabled disable CORS. Without a configuration for the instance
+// CORS is only disabled when pruning.
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return nil, errors.New(fmt.Sprintf("[Vault CORS] failed to decode cors configuration: %v", err))
+	}
+	var desired *entry
+	for i := range entries {
+		if entries[i].Instance.Key() == address {
+			desired = &entries[i]
+			break
+		}
+	}
+	plan := vault.NewPlan()
+	if desired != nil && desired.Unmanaged() {
+		return plan, nil
+	}
+
+	existing, err := getExistingCors(address)
+	if err != nil {
+		return nil, err
+	}
+
+	// vault only reports a configuration while cors is enabled, disabling it deletes the configuration
+	desiredItems := []vault.Item{}
+	if desired != nil && desired.Enabled {
+		desiredItems = append(desiredItems, *desired)
+	}
+	existingItems := []vault.Item{}
+	if existing.Enabled {
+		existingItems = append(existingItems, existing)
+	}
+	toBeWritten, toBeDeleted, _ := vault.DiffItems(desiredItems, existingItems)
+	utils.RecordPendingChanges(address, toplevelName, len(toBeWritten)+len(toBeDeleted))
+	if !prune && desired == nil {
+		toBeDeleted = vault.SkipDeletes(address, "[Vault CORS] cors configuration", toBeDeleted, nil)
+	}
+
+	plan.Add("cors", toBeWritten, nil, toBeDeleted, existingItems)
+
+	if dryRun == true {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate, len(toBeWritten))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationDelete, len(toBeDeleted))
+		for _, w := range toBeWritten {
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"origins": w.(entry).AllowedOrigins,
+				"headers": customHeaders(w.(entry).AllowedHeaders),
+			}).Info("[Dry Run] [Vault CORS] cors configuration to be written")
+		}
+		for range toBeDeleted {
+			vault.Logger(address, toplevelName).Info("[Dry Run] [Vault CORS] cors to be disabled")
+		}
+		return plan, nil
+	}
+
+	for _, w := range toBeWritten {
+		err := vault.WriteRaw(address, corsPath, w.(entry).data())
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationCreate)
+		vault.Logger(address, toplevelName).Info("[Vault CORS] cors configuration is successfully written")
+	}
+	for range toBeDeleted {
+		err := vault.DeleteRaw(address, corsPath)
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationDelete)
+		vault.Logger(address, toplevelName).Info("[Vault CORS] cors is successfully disabled")
+	}
+
+	return plan, nil
+}
+
+// getExistingCors reads the CORS configuration of an instance
+func getExistingCors(address string) (entry, error) {
+	existing := entry{Instance: vault.Instance{Address: address}}
+	data, err := vault.ReadRaw(address, corsPath)
+	if err != nil {
+		return existing, err
+	}
+	if data == nil {
+		return existing, nil
+	}
+	existing.Enabled, _ = strconv.ParseBool(fmt.Sprintf("%v", data["enabled"]))
+	existing.AllowedOrigins = toStrings(data["allowed_origins"])
+	existing.AllowedHeaders = toStrings(data["allowed_headers"])
+	return existing, nil
+}
+
+func toStrings(raw interface{}) []string {
+	values, ok := raw.([]interface{})
+	if !ok {
+		return []string{}
+	}
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, fmt.Sprintf("%v", v))
+	}
+	return strs
+}
+
+func equalStrings(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	xs := append([]string{}, x...)
+	ys := append([]string{}, y...)
+	sort.Strings(xs)
+	sort.Strings(ys)
+	for i := range xs {
+		if xs[i] != ys[i] {
+			return false
+		}
+	}
+	return true
+}
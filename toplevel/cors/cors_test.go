@@ -0,0 +1,91 @@
+package cors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryEquals(t *testing.T) {
+	existing := entry{
+		Enabled:        true,
+		AllowedOrigins: []string{"https://a.example.com", "https://b.example.com"},
+		AllowedHeaders: []string{"Content-Type", "X-Requested-With", "Authorization", "X-Vault-Token", "X-Custom"},
+	}
+
+	table := []struct {
+		description string
+		desired     entry
+		expected    bool
+	}{
+		{
+			description: "origins in different order and header in different case",
+			desired: entry{Enabled: true, AllowedOrigins: []string{"https://b.example.com", "https://a.example.com"},
+				AllowedHeaders: []string{"x-custom"}},
+			expected: true,
+		},
+		{
+			description: "standard headers configured explicitly",
+			desired: entry{Enabled: true, AllowedOrigins: []string{"https://a.example.com", "https://b.example.com"},
+				AllowedHeaders: []string{"X-Custom", "X-Vault-Token"}},
+			expected: true,
+		},
+		{
+			description: "different origins",
+			desired:     entry{Enabled: true, AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"X-Custom"}},
+			expected:    false,
+		},
+		{
+			description: "custom header removed",
+			desired:     entry{Enabled: true, AllowedOrigins: []string{"https://a.example.com", "https://b.example.com"}},
+			expected:    false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.desired.Equals(existing))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	table := []struct {
+		description string
+		config      string
+		expectErr   bool
+	}{
+		{
+			description: "enabled",
+			config:      "- instance:\n    address: https://vault.example.com\n  enabled: true\n  allowed_origins: ['*']\n",
+			expectErr:   false,
+		},
+		{
+			description: "disabled without origins",
+			config:      "- instance:\n    address: https://vault.example.com\n  enabled: false\n",
+			expectErr:   false,
+		},
+		{
+			description: "enabled without origins",
+			config:      "- instance:\n    address: https://vault.example.com\n  enabled: true\n",
+			expectErr:   true,
+		},
+		{
+			description: "multiple configurations of an instance",
+			config: "- instance:\n    address: https://vault.example.com\n  enabled: false\n" +
+				"- instance:\n    address: https://vault.example.com\n  enabled: false\n",
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			err := config{}.Validate([]byte(tt.config))
+			if tt.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,510 @@
+// Package database implements the application of a declarative configuration
+// for Vault Database Secrets Engine connections and roles.
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/app-sre/vault-manager/pkg/utils"
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/app-sre/vault-manager/toplevel"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+type entry struct {
+	Mount       string         `yaml:"mount"`
+	Instance    vault.Instance `yaml:"instance"`
+	Connections []connection   `yaml:"connections"`
+	Roles       []role         `yaml:"roles"`
+}
+
+// secretRef references a secret field stored within the instance being configured
+type secretRef struct {
+	Path    string `yaml:"path"`
+	Field   string `yaml:"field"`
+	Version string `yaml:"version"`
+}
+
+type connection struct {
+	Name          string     `yaml:"name"`
+	PluginName    string     `yaml:"plugin_name"`
+	ConnectionURL string     `yaml:"connection_url"`
+	AllowedRoles  []string   `yaml:"allowed_roles"`
+	Username      string     `yaml:"username"`
+	Password      *secretRef `yaml:"password"`
+	Mount         string     `yaml:"-"`
+	vault.Toggle  `yaml:",inline"`
+}
+
+var _ vault.Item = connection{}
+
+func (c connection) Key() string {
+	return filepath.Join(c.Mount, "config", c.Name)
+}
+
+func (c connection) KeyForType() string {
+	return c.PluginName
+}
+
+func (c connection) KeyForDescription() string {
+	return ""
+}
+
+// Equals does not compare passwords as vault never returns them
+func (c connection) Equals(i interface{}) bool {
+	conn, ok := i.(connection)
+	if !ok {
+		return false
+	}
+
+	return c.Key() == conn.Key() &&
+		c.PluginName == conn.PluginName &&
+		c.ConnectionURL == conn.ConnectionURL &&
+		c.Username == conn.Username &&
+		equalStrings(c.AllowedRoles, conn.AllowedRoles)
+}
+
+// logFields returns the fields logged for a connection with any password
+// embedded in the connection url masked
+func (c connection) logFields() log.Fields {
+	return log.Fields{
+		"path":           c.Key(),
+		"plugin":         c.PluginName,
+		"connection_url": utils.RedactURL(c.ConnectionURL),
+	}
+}
+
+type role struct {
+	Name               string   `yaml:"name"`
+	DbName             string   `yaml:"db_name"`
+	CreationStatements []string `yaml:"creation_statements"`
+	DefaultTTL         string   `yaml:"default_ttl"`
+	MaxTTL             string   `yaml:"max_ttl"`
+	Mount              string   `yaml:"-"`
+	vault.Toggle       `yaml:",inline"`
+}
+
+var _ vault.Item = role{}
+
+func (r role) Key() string {
+	return filepath.Join(r.Mount, "roles", r.Name)
+}
+
+func (r role) KeyForType() string {
+	return ""
+}
+
+func (r role) KeyForDescription() string {
+	return ""
+}
+
+func (r role) Equals(i interface{}) bool {
+	rl, ok := i.(role)
+	if !ok {
+		return false
+	}
+
+	return r.Key() == rl.Key() &&
+		r.DbName == rl.DbName &&
+		// creation statements are executed in order so they are not sorted
+		strings.Join(r.CreationStatements, "\n") == strings.Join(rl.CreationStatements, "\n") &&
+		vault.OptionsEqual(r.ttls(), rl.ttls())
+}
+
+func (r role) ttls() map[string]interface{} {
+	return map[string]interface{}{
+		"default_ttl": ttlOrZero(r.DefaultTTL),
+		"max_ttl":     ttlOrZero(r.MaxTTL),
+	}
+}
+
+type config struct{}
+
+var _ toplevel.Configuration = config{}
+
+const toplevelName = "vault_databases"
+
+func init() {
+	toplevel.RegisterConfiguration(toplevelName, config{}, "vault_secret_engines", "vault_secrets", "vault_plugins")
+	// connection passwords are read from secrets
+	toplevel.ReadsSecrets(toplevelName)
+}
+
+// Validate ensures the configuration can be decoded and that connections and
+// roles set the fields vault requires to write them.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault Database] failed to decode database configuration: %v", err))
+	}
+	errs := []error{}
+	for _, e := range entries {
+		for _, conn := range e.Connections {
+			if conn.PluginName == "" {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Database] `plugin_name` is required for connection `%s` of mount `%s`", conn.Name, e.Mount)))
+			}
+			if conn.ConnectionURL == "" {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Database] `connection_url` is required for connection `%s` of mount `%s`", conn.Name, e.Mount)))
+			}
+		}
+		for _, r := range e.Roles {
+			if r.DbName == "" {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Database] `db_name` is required for role `%s` of mount `%s`", r.Name, e.Mount)))
+			}
+		}
+	}
+	return utils.JoinErrors(errs)
+}
+
+// Apply ensures that an instance of Vault's database connections and roles
+// are configured exactly as provided.
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return nil, errors.New(fmt.Sprintf("[Vault Database] failed to decode database configuration: %v", err))
+	}
+
+	desiredConnections := []connection{}
+	desiredRoles := []role{}
+	for _, e := range entries {
+		if e.Instance.Key() != address {
+			continue
+		}
+		mount := strings.Trim(e.Mount, "/")
+		for _, conn := range e.Connections {
+			conn.Mount = mount
+			desiredConnections = append(desiredConnections, conn)
+		}
+		for _, r := range e.Roles {
+			r.Mount = mount
+			desiredRoles = append(desiredRoles, r)
+		}
+	}
+
+	mounts, err := getDatabaseMounts(address)
+	if err != nil {
+		return nil, err
+	}
+
+	existingConnections, err := getExistingConnections(address, mounts, threadPoolSize)
+	if err != nil {
+		return nil, err
+	}
+	existingRoles, err := getExistingRoles(address, mounts, threadPoolSize)
+	if err != nil {
+		return nil, err
+	}
+
+	connectionsToBeWritten, connectionsToBeDeleted, _ := vault.DiffItems(
+		vault.ExcludeUnmanaged(connectionsAsItems(desiredConnections), connectionsAsItems(existingConnections)))
+	rolesToBeWritten, rolesToBeDeleted, _ :=
+		vault.DiffItems(vault.ExcludeUnmanaged(rolesAsItems(desiredRoles), rolesAsItems(existingRoles)))
+	utils.RecordPendingChanges(address, toplevelName, len(connectionsToBeWritten)+len(connectionsToBeDeleted)+
+		len(rolesToBeWritten)+len(rolesToBeDeleted))
+	if !prune {
+		connectionsToBeDeleted = vault.SkipDeletes(address, "[Vault Database] connection", connectionsToBeDeleted, nil)
+		rolesToBeDeleted = vault.SkipDeletes(address, "[Vault Database] role", rolesToBeDeleted, nil)
+	}
+	if err := vault.CheckDeletes(address, "[Vault Database]", connectionsToBeDeleted, rolesToBeDeleted); err != nil {
+		return nil, err
+	}
+
+	plan := vault.NewPlan()
+	plan.Add("database-connection", connectionsToBeWritten, nil,
+		connectionsToBeDeleted, connectionsAsItems(existingConnections))
+	plan.Add("database-role", rolesToBeWritten, nil, rolesToBeDeleted, rolesAsItems(existingRoles))
+
+	if dryRun == true {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate,
+			len(connectionsToBeWritten)+len(rolesToBeWritten))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationDelete,
+			len(connectionsToBeDeleted)+len(rolesToBeDeleted))
+		for _, w := range connectionsToBeWritten {
+			vault.Logger(address, toplevelName).WithFields(w.(connection).logFields()).Info(
+				"[Dry Run] [Vault Database] connection to be written")
+		}
+		for _, w := range rolesToBeWritten {
+			vault.Logger(address, toplevelName).WithField("path", w.Key()).Info(
+				"[Dry Run] [Vault Database] role to be written")
+		}
+		for _, d := range rolesToBeDeleted {
+			vault.Logger(address, toplevelName).WithField("path", d.Key()).Info(
+				"[Dry Run] [Vault Database] role to be deleted")
+		}
+		for _, d := range connectionsToBeDeleted {
+			vault.Logger(address, toplevelName).WithField("path", d.Key()).Info(
+				"[Dry Run] [Vault Database] connection to be deleted")
+		}
+		return plan, nil
+	}
+
+	// connections must exist before the roles referencing them are written
+	// and roles are removed before the connections they reference
+	for _, w := range connectionsToBeWritten {
+		err := writeConnection(address, w.(connection))
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationCreate)
+	}
+	for _, w := range rolesToBeWritten {
+		err := writeRole(address, w.(role))
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationCreate)
+	}
+	for _, d := range rolesToBeDeleted {
+		err := vault.DeleteRaw(address, d.Key())
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationDelete)
+		vault.Logger(address, toplevelName).WithField("path", d.Key()).Info(
+			"[Vault Database] role is successfully deleted")
+	}
+	for _, d := range connectionsToBeDeleted {
+		err := vault.DeleteRaw(address, d.Key())
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationDelete)
+		vault.Logger(address, toplevelName).WithField("path", d.Key()).Info(
+			"[Vault Database] connection is successfully deleted")
+	}
+
+	return plan, nil
+}
+
+func writeConnection(address string, conn connection) error {
+	data := map[string]interface{}{
+		"plugin_name":    conn.PluginName,
+		"connection_url": conn.ConnectionURL,
+		"allowed_roles":  conn.AllowedRoles,
+	}
+	if conn.Username != "" {
+		data["username"] = conn.Username
+	}
+	if conn.Password != nil {
+		version := conn.Password.Version
+		if version == "" {
+			version = vault.KV_V1
+		}
+		password, err := vault.GetVaultSecretField(address, conn.Password.Path, conn.Password.Field, version)
+		if err != nil {
+			return errors.New(fmt.Sprintf(
+				"[Vault Database] failed to retrieve password for connection %s: %v", conn.Key(), err))
+		}
+		data["password"] = password
+	}
+	err := vault.WriteRaw(address, conn.Key(), data)
+	if err != nil {
+		return err
+	}
+	vault.Logger(address, toplevelName).WithFields(conn.logFields()).Info(
+		"[Vault Database] connection is successfully written")
+	return nil
+}
+
+func writeRole(address string, r role) error {
+	data := map[string]interface{}{
+		"db_name":             r.DbName,
+		"creation_statements": r.CreationStatements,
+		"default_ttl":         ttlOrZero(r.DefaultTTL),
+		"max_ttl":             ttlOrZero(r.MaxTTL),
+	}
+	err := vault.WriteRaw(address, r.Key(), data)
+	if err != nil {
+		return err
+	}
+	vault.Logger(address, toplevelName).WithField("path", r.Key()).Info(
+		"[Vault Database] role is successfully written")
+	return nil
+}
+
+// getDatabaseMounts returns the paths of all enabled database secrets engines
+func getDatabaseMounts(address string) ([]string, error) {
+	engines, err := vault.ListSecretsEngines(address)
+	if err != nil {
+		return nil, err
+	}
+	mounts := []string{}
+	for path, engine := range engines {
+		if engine.Type == "database" {
+			mounts = append(mounts, strings.Trim(path, "/"))
+		}
+	}
+	return mounts, nil
+}
+
+func getExistingConnections(address string, mounts []string, threadPoolSize int) ([]connection, error) {
+	existing := []connection{}
+	for _, mount := range mounts {
+		names, err := listNames(address, filepath.Join(mount, "config"))
+		if err != nil {
+			return nil, err
+		}
+
+		var mutex = &sync.Mutex{}
+		var readErr error
+		bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+
+		for _, name := range names {
+			bwg.Add(1)
+
+			go func(name string) {
+				defer bwg.Done()
+
+				conn := connection{Name: name, Mount: mount}
+				data, err := vault.ReadRaw(address, conn.Key())
+
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if err != nil {
+					readErr = err
+					return
+				}
+				if data == nil {
+					return
+				}
+				conn.PluginName = fmt.Sprintf("%v", data["plugin_name"])
+				conn.AllowedRoles = toStrings(data["allowed_roles"])
+				if details, ok := data["connection_details"].(map[string]interface{}); ok {
+					if url, ok := details["connection_url"].(string); ok {
+						conn.ConnectionURL = url
+					}
+					if username, ok := details["username"].(string); ok {
+						conn.Username = username
+					}
+				}
+				existing = append(existing, conn)
+			}(name)
+		}
+		bwg.Wait()
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	return existing, nil
+}
+
+func getExistingRoles(address string, mounts []string, threadPoolSize int) ([]role, error) {
+	existing := []role{}
+	for _, mount := range mounts {
+		names, err := listNames(address, filepath.Join(mount, "roles"))
+		if err != nil {
+			return nil, err
+		}
+
+		var mutex = &sync.Mutex{}
+		var readErr error
+		bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+
+		for _, name := range names {
+			bwg.Add(1)
+
+			go func(name string) {
+				defer bwg.Done()
+
+				r := role{Name: name, Mount: mount}
+				data, err := vault.ReadRaw(address, r.Key())
+
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if err != nil {
+					readErr = err
+					return
+				}
+				if data == nil {
+					return
+				}
+				r.DbName = fmt.Sprintf("%v", data["db_name"])
+				r.CreationStatements = toStrings(data["creation_statements"])
+				r.DefaultTTL = fmt.Sprintf("%v", data["default_ttl"])
+				r.MaxTTL = fmt.Sprintf("%v", data["max_ttl"])
+				existing = append(existing, r)
+			}(name)
+		}
+		bwg.Wait()
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	return existing, nil
+}
+
+// listNames returns the keys listed at path or an empty list if nothing exists
+func listNames(address, path string) ([]string, error) {
+	secret, err := vault.ListSecrets(address, path)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return []string{}, nil
+	}
+	return toStrings(secret.Data["keys"]), nil
+}
+
+func ttlOrZero(ttl string) string {
+	if ttl == "" {
+		return "0"
+	}
+	return ttl
+}
+
+func toStrings(raw interface{}) []string {
+	values, ok := raw.([]interface{})
+	if !ok {
+		return []string{}
+	}
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, fmt.Sprintf("%v", v))
+	}
+	return strs
+}
+
+func equalStrings(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	xs := append([]string{}, x...)
+	ys := append([]string{}, y...)
+	sort.Strings(xs)
+	sort.Strings(ys)
+	for i := range xs {
+		if xs[i] != ys[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func connectionsAsItems(xs []connection) (items []vault.Item) {
+	items = make([]vault.Item, 0)
+	for _, x := range xs {
+		items = append(items, x)
+	}
+
+	return
+}
+
+func rolesAsItems(xs []role) (items []vault.Item) {
+	items = make([]vault.Item, 0)
+	for _, x := range xs {
+		items = append(items, x)
+	}
+
+	return
+}
@@ -19,9 +19,10 @@ type config struct{}
 var _ toplevel.Configuration = config{}
 
 type user struct {
-	Name        string `yaml:"name"`
-	OrgUsername string `yaml:"org_username"`
-	Roles       []role `yaml:"roles"`
+	Name         string `yaml:"name"`
+	OrgUsername  string `yaml:"org_username"`
+	Roles        []role `yaml:"roles"`
+	vault.Toggle `yaml:",inline"`
 }
 
 type role struct {
@@ -42,6 +43,7 @@ type entity struct {
 	Metadata interface{}
 	Aliases  []entityAlias
 	Instance vault.Instance
+	vault.Toggle
 }
 
 type entityAlias struct {
@@ -83,28 +85,26 @@ func (e entity) CreateOrUpdate(action string) error {
 	config := map[string]interface{}{
 		"metadata": e.Metadata,
 	}
-	err := vault.WriteSecret(e.Instance.Address, path, vault.KV_V1, config)
+	err := vault.WriteSecret(e.Instance.Key(), path, vault.KV_V1, config)
 	if err != nil {
 		return err
 	}
-	log.WithFields(log.Fields{
-		"instance": e.Instance.Address,
-		"path":     path,
-		"type":     e.KeyForType(),
+	vault.Logger(e.Instance.Key(), toplevelName).WithFields(log.Fields{
+		"path": path,
+		"type": e.KeyForType(),
 	}).Infof("[Vault Identity] entity successfully %s", action)
 	return nil
 }
 
 func (e entity) Delete() error {
 	path := filepath.Join("identity", e.Type, "name", e.Name)
-	err := vault.DeleteSecret(e.Instance.Address, path)
+	err := vault.DeleteSecret(e.Instance.Key(), path)
 	if err != nil {
 		return err
 	}
-	log.WithFields(log.Fields{
-		"instance": e.Instance.Address,
-		"path":     path,
-		"type":     e.KeyForType(),
+	vault.Logger(e.Instance.Key(), toplevelName).WithFields(log.Fields{
+		"path": path,
+		"type": e.KeyForType(),
 	}).Info("[Vault Identity] entity successfully deleted")
 	return nil
 }
@@ -137,14 +137,13 @@ func (ea entityAlias) Create(entityId string) error {
 		"canonical_id":   entityId,
 		"mount_accessor": ea.AccessorId,
 	}
-	err := vault.WriteEntityAlias(ea.Instance.Address, path, config)
+	err := vault.WriteEntityAlias(ea.Instance.Key(), path, config)
 	if err != nil {
 		return err
 	}
-	log.WithFields(log.Fields{
-		"instance": ea.Instance.Address,
-		"path":     filepath.Join(path, ea.Name),
-		"type":     ea.AuthType,
+	vault.Logger(ea.Instance.Key(), toplevelName).WithFields(log.Fields{
+		"path": filepath.Join(path, ea.Name),
+		"type": ea.AuthType,
 	}).Info("[Vault Identity] entity alias successfully written")
 	return nil
 }
@@ -156,41 +155,50 @@ func (ea entityAlias) Update(entityId string) error {
 		"canonical_id":   entityId,
 		"mount_accessor": ea.AccessorId,
 	}
-	err := vault.WriteSecret(ea.Instance.Address, path, vault.KV_V1, config)
+	err := vault.WriteSecret(ea.Instance.Key(), path, vault.KV_V1, config)
 	if err != nil {
 		return err
 	}
-	log.WithFields(log.Fields{
-		"instance": ea.Instance.Address,
-		"path":     filepath.Join(path, ea.Name),
-		"type":     ea.AuthType,
+	vault.Logger(ea.Instance.Key(), toplevelName).WithFields(log.Fields{
+		"path": filepath.Join(path, ea.Name),
+		"type": ea.AuthType,
 	}).Info("[Vault Identity] entity alias successfully updated")
 	return nil
 }
 
 func (ea entityAlias) Delete() error {
 	path := filepath.Join("identity", ea.Type, "id", ea.Id)
-	err := vault.DeleteSecret(ea.Instance.Address, path)
+	err := vault.DeleteSecret(ea.Instance.Key(), path)
 	if err != nil {
 		return err
 	}
-	log.WithFields(log.Fields{
-		"instance": ea.Instance.Address,
-		"path":     filepath.Join(path, ea.Name),
-		"type":     ea.AuthType,
+	vault.Logger(ea.Instance.Key(), toplevelName).WithFields(log.Fields{
+		"path": filepath.Join(path, ea.Name),
+		"type": ea.AuthType,
 	}).Info("[Vault Identity] entity alias successfully deleted")
 	return nil
 }
 
+const toplevelName = "vault_entities"
+
 func init() {
-	toplevel.RegisterConfiguration("vault_entities", config{})
+	toplevel.RegisterConfiguration(toplevelName, config{}, "vault_auth_backends", "vault_policies")
+}
+
+// Validate ensures the configuration can be decoded.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []user
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault Identity] failed to decode entity configuration: %v", err))
+	}
+	return nil
 }
 
-func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPoolSize int) error {
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
 	// process desired entities/aliases
 	var entries []user
 	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
-		log.WithError(err).Fatal("[Vault Identity] failed to decode entity configuration")
+		return nil, errors.New(fmt.Sprintf("[Vault Identity] failed to decode entity configuration: %v", err))
 	}
 
 	desired := getDesired(address, entries)
@@ -199,21 +207,18 @@ func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPo
 	// Process data on existing entities/aliases
 	existingEntities, err := createBaseExistingEntities(address)
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"instance": address,
-		}).Info("[Vault Identity] failed to parse existing entities")
-		return err
+		vault.Logger(address, toplevelName).WithError(err).Info("[Vault Identity] failed to parse existing entities")
+		return nil, err
 	}
 
 	pruneNonOidcEntities(&existingEntities)
+	excludeUnmanagedEntities(&desired, &existingEntities)
 
 	if existingEntities != nil && len(existingEntities) > 0 {
 		err := getExistingEntitiesDetails(address, existingEntities, threadPoolSize)
 		if err != nil {
-			log.WithError(err).WithFields(log.Fields{
-				"instance": address,
-			}).Info("[Vault Identity] failed to gather existing entity details")
-			return err
+			vault.Logger(address, toplevelName).WithError(err).Info("[Vault Identity] failed to gather existing entity details")
+			return nil, err
 		}
 		populateAliasType(existingEntities)
 		copyIds(desired, existingEntities)
@@ -225,19 +230,45 @@ func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPo
 	// determine entity alias changes
 	aliasesToBeWritten, aliasesToBeDeleted, aliasesToBeUpdated :=
 		determineAliasActions(desired, existingEntities, entitiesToBeDeleted)
+	utils.RecordPendingChanges(address, toplevelName,
+		len(entitiesToBeWritten)+len(entitiesToBeUpdated)+len(entitiesToBeDeleted)+
+			len(flattenAliases(aliasesToBeWritten["id"]))+len(flattenAliases(aliasesToBeWritten["name"]))+
+			len(flattenAliases(aliasesToBeUpdated))+len(aliasesToBeDeleted))
+	if !prune {
+		entitiesToBeDeleted = vault.SkipDeletes(address, "[Vault Identity] entity", entitiesToBeDeleted, nil)
+		aliasesToBeDeleted = vault.SkipDeletes(address, "[Vault Identity] entity alias", aliasesToBeDeleted, nil)
+	}
+	if err := vault.CheckDeletes(address, "[Vault Identity]", entitiesToBeDeleted, aliasesToBeDeleted); err != nil {
+		return nil, err
+	}
+
+	existingAliases := []vault.Item{}
+	for _, e := range existingEntities {
+		existingAliases = append(existingAliases, aliasesAsItems(e.Aliases)...)
+	}
+	plan := vault.NewPlan()
+	plan.Add("entity", entitiesToBeWritten, entitiesToBeUpdated, entitiesToBeDeleted, entriesAsItems(existingEntities))
+	plan.Add("entity-alias",
+		append(flattenAliases(aliasesToBeWritten["id"]), flattenAliases(aliasesToBeWritten["name"])...),
+		flattenAliases(aliasesToBeUpdated), aliasesToBeDeleted, existingAliases)
 
 	// preform actions
 	if dryRun {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate, len(entitiesToBeWritten)+
+			len(flattenAliases(aliasesToBeWritten["id"]))+len(flattenAliases(aliasesToBeWritten["name"])))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationUpdate,
+			len(entitiesToBeUpdated)+len(flattenAliases(aliasesToBeUpdated)))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationDelete,
+			len(entitiesToBeDeleted)+len(aliasesToBeDeleted))
 		entitiesDryRunOutput(address, entitiesToBeWritten, "written")
 		entitiesDryRunOutput(address, entitiesToBeDeleted, "deleted")
 		entitiesDryRunOutput(address, entitiesToBeUpdated, "updated")
 		aliasesDryRunOutput(address, aliasesToBeWritten["id"], "written")
 		aliasesDryRunOutput(address, aliasesToBeWritten["name"], "written")
 		for _, alias := range aliasesToBeDeleted {
-			log.WithFields(log.Fields{
-				"name":     alias.Key(),
-				"type":     alias.(entityAlias).AuthType,
-				"instance": address,
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"name": alias.Key(),
+				"type": alias.(entityAlias).AuthType,
 			}).Info("[Dry Run] [Vault Identity] entity alias to be deleted")
 		}
 		aliasesDryRunOutput(address, aliasesToBeUpdated, "updated")
@@ -246,31 +277,32 @@ func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPo
 		for _, w := range entitiesToBeWritten {
 			err := w.(entity).CreateOrUpdate("written")
 			if err != nil {
-				return err
+				return nil, err
 			}
+			utils.RecordOperation(address, toplevelName, utils.OperationCreate)
 		}
 		for _, d := range entitiesToBeDeleted {
 			err := d.(entity).Delete()
 			if err != nil {
-				return err
+				return nil, err
 			}
+			utils.RecordOperation(address, toplevelName, utils.OperationDelete)
 		}
 		for _, u := range entitiesToBeUpdated {
 			err := u.(entity).CreateOrUpdate("update")
 			if err != nil {
-				return err
+				return nil, err
 			}
+			utils.RecordOperation(address, toplevelName, utils.OperationUpdate)
 		}
 		err = performAliasReconcile(address, aliasesToBeWritten, aliasesToBeDeleted, aliasesToBeUpdated)
 		if err != nil {
-			log.WithError(err).WithFields(log.Fields{
-				"instance": address,
-			}).Info("[Vault Identity] error occurred during reconciliation of entity aliases")
-			return err
+			vault.Logger(address, toplevelName).WithError(err).Info("[Vault Identity] error occurred during reconciliation of entity aliases")
+			return nil, err
 		}
 	}
 
-	return nil
+	return plan, nil
 }
 
 // getDesired accepts the yaml-marshalled result of the `vault_entities` graphql
@@ -287,7 +319,7 @@ func getDesired(address string, entries []user) []entity {
 				// only process first occurence of oidc ref for a user
 				// and only process oidc permissions for vault service
 				// and only process references to particular instance being reconciled
-				if !existing[u.OrgUsername] && p.Service == "vault" && p.Instance.Address == address {
+				if !existing[u.OrgUsername] && p.Service == "vault" && p.Instance.Key() == address {
 					newDesired := entity{
 						Name: u.OrgUsername,
 						Type: "entity",
@@ -303,6 +335,7 @@ func getDesired(address string, entries []user) []entity {
 							"name": u.Name,
 						},
 						Instance: p.Instance,
+						Toggle:   u.Toggle,
 					}
 					desired = append(desired, newDesired)
 					// ensure no further entities are added for this user in this instance
@@ -509,6 +542,31 @@ func pruneNonOidcEntities(entities *[]entity) {
 	*entities = (*entities)[:i]
 }
 
+// removes entities of users configured with `managed: false` from both desired and existing
+// entities so that neither the entities nor their aliases are reconciled
+func excludeUnmanagedEntities(desired, existing *[]entity) {
+	unmanaged := make(map[string]bool)
+	i := 0
+	for _, e := range *desired {
+		if e.Unmanaged() {
+			unmanaged[e.Name] = true
+			continue
+		}
+		(*desired)[i] = e
+		i++
+	}
+	*desired = (*desired)[:i]
+
+	i = 0
+	for _, e := range *existing {
+		if !unmanaged[e.Name] {
+			(*existing)[i] = e
+			i++
+		}
+	}
+	*existing = (*existing)[:i]
+}
+
 // calls vault.DiffItems for existing/desired list of aliases, within each exisitng/desired entity
 // vault.DiffItem cannot adequately handle reconcile of aliases in "top level" diffItem of entities
 // this logic goes a layer deeper and compares aliases of a entities one at a time
@@ -558,27 +616,21 @@ func determineAliasActions(entries, existingEntities []entity, entitiesToBeDelet
 // writes, deletes, and/or updates entity aliases
 func performAliasReconcile(instanceAddr string, aliasesToBeWritten map[string]map[string][]vault.Item,
 	aliasesToBeDeleted []vault.Item, aliasesToBeUpdated map[string][]vault.Item) error {
-	var accessorIds map[string]string
-	// extra work (vault api request) required to organize accessor ids
-	if len(aliasesToBeWritten) > 0 {
-		accessorIds = make(map[string]string)
-		authBackends, err := vault.ListAuthBackends(instanceAddr)
-		if err != nil {
-			return err
-		}
-		for k, v := range authBackends {
-			accessorIds[strings.TrimRight(k, "/")] = v.Accessor
-		}
-	}
+	// aliases reference the auth mount path which is resolved to the current accessor
 	if _, exists := aliasesToBeWritten["id"]; exists {
 		for id, ws := range aliasesToBeWritten["id"] {
 			for _, w := range ws {
 				a := w.(entityAlias)
-				a.AccessorId = accessorIds[a.AuthType]
-				err := a.Create(id)
+				accessor, err := vault.GetMountAccessor(instanceAddr, a.AuthType)
+				if err != nil {
+					return err
+				}
+				a.AccessorId = accessor
+				err = a.Create(id)
 				if err != nil {
 					return err
 				}
+				utils.RecordOperation(instanceAddr, toplevelName, utils.OperationCreate)
 			}
 		}
 	}
@@ -589,7 +641,11 @@ func performAliasReconcile(instanceAddr string, aliasesToBeWritten map[string]ma
 		for name, ws := range aliasesToBeWritten["name"] {
 			for _, w := range ws {
 				a := w.(entityAlias)
-				a.AccessorId = accessorIds[a.AuthType]
+				accessor, err := vault.GetMountAccessor(instanceAddr, a.AuthType)
+				if err != nil {
+					return err
+				}
+				a.AccessorId = accessor
 				newEntity, err := vault.GetEntityInfo(instanceAddr, name)
 				if err != nil {
 					return err
@@ -598,16 +654,28 @@ func performAliasReconcile(instanceAddr string, aliasesToBeWritten map[string]ma
 					return errors.New(fmt.Sprintf(
 						"[Vault Identity] failed to get info for newly created entity: %s", name))
 				}
-				a.Create(newEntity["id"].(string))
+				if a.Create(newEntity["id"].(string)) == nil {
+					utils.RecordOperation(instanceAddr, toplevelName, utils.OperationCreate)
+				}
 			}
 		}
 	}
 	for _, d := range aliasesToBeDeleted {
-		d.(entityAlias).Delete()
+		if d.(entityAlias).Delete() == nil {
+			utils.RecordOperation(instanceAddr, toplevelName, utils.OperationDelete)
+		}
 	}
 	for id, us := range aliasesToBeUpdated {
 		for _, u := range us {
-			u.(entityAlias).Update(id)
+			a := u.(entityAlias)
+			accessor, err := vault.GetMountAccessor(instanceAddr, a.AuthType)
+			if err != nil {
+				return err
+			}
+			a.AccessorId = accessor
+			if a.Update(id) == nil {
+				utils.RecordOperation(instanceAddr, toplevelName, utils.OperationUpdate)
+			}
 		}
 	}
 	return nil
@@ -685,22 +753,29 @@ func copyIds(entries, existingEntities []entity) {
 // reusable func to output updates on writes, deletes, and updates for entities
 func entitiesDryRunOutput(instanceAddr string, entities []vault.Item, action string) {
 	for _, e := range entities {
-		log.WithFields(log.Fields{
-			"name":     e.Key(),
-			"type":     e.KeyForType(),
-			"instance": instanceAddr,
+		vault.Logger(instanceAddr, toplevelName).WithFields(log.Fields{
+			"name": e.Key(),
+			"type": e.KeyForType(),
 		}).Infof("[Dry Run] [Vault Identity] entity to be %s", action)
 	}
 }
 
+// flattenAliases returns all aliases within a mapping of entity ids to aliases
+func flattenAliases(idsToAliases map[string][]vault.Item) []vault.Item {
+	aliases := []vault.Item{}
+	for _, a := range idsToAliases {
+		aliases = append(aliases, a...)
+	}
+	return aliases
+}
+
 // reusable func to output updates on writes, deletes, and updates for entity aliases
 func aliasesDryRunOutput(instanceAddr string, idsToAliases map[string][]vault.Item, action string) {
 	for _, aliases := range idsToAliases {
 		for _, alias := range aliases {
-			log.WithFields(log.Fields{
-				"name":     alias.Key(),
-				"type":     alias.(entityAlias).AuthType,
-				"instance": instanceAddr,
+			vault.Logger(instanceAddr, toplevelName).WithFields(log.Fields{
+				"name": alias.Key(),
+				"type": alias.(entityAlias).AuthType,
 			}).Infof("[Dry Run] [Vault Identity] entity alias to be %s", action)
 		}
 	}
@@ -0,0 +1,58 @@
+package entity
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPerformAliasReconcileUnknownMount(t *testing.T) {
+	written := []map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/v1/sys/health":
+			w.Write([]byte(`{"initialized":true,"sealed":false,"standby":false}`))
+		case r.URL.Path == "/v1/sys/auth":
+			w.Write([]byte(`{"data":{"github/":{"type":"github","accessor":"auth_github_1234"}}}`))
+		case strings.HasPrefix(r.URL.Path, "/v1/identity/entity-alias"):
+			body, _ := ioutil.ReadAll(r.Body)
+			alias := map[string]interface{}{}
+			json.Unmarshal(body, &alias)
+			written = append(written, alias)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_AUTHTYPE", vault.TOKEN_AUTH)
+	t.Setenv("VAULT_TOKEN", "s.token")
+	vault.GetInstances([]byte("[]"), 1)
+
+	instance := vault.Instance{Address: server.URL}
+	alias := func(authType string) entityAlias {
+		return entityAlias{Name: "user", Type: "entity-alias", AuthType: authType, Instance: instance}
+	}
+
+	err := performAliasReconcile(server.URL, map[string]map[string][]vault.Item{
+		"id": {"entity-id": {alias("github")}},
+	}, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, written, 1)
+	require.Equal(t, "auth_github_1234", written[0]["mount_accessor"])
+
+	err = performAliasReconcile(server.URL, map[string]map[string][]vault.Item{
+		"id": {"entity-id": {alias("oidc")}},
+	}, nil, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no auth backend mounted at `oidc`")
+	require.Len(t, written, 1, "aliases of unknown mounts are not written with an empty accessor")
+}
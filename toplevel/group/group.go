@@ -27,6 +27,10 @@ type user struct {
 type role struct {
 	Name        string           `yaml:"name"`
 	Permissions []oidcPermission `yaml:"oidc_permissions"`
+	// ExternalMembers leaves the members of the role's groups to be managed elsewhere
+	// the users of the role only become members when the group is created
+	ExternalMembers bool `yaml:"external_members"`
+	vault.Toggle    `yaml:",inline"`
 }
 
 type oidcPermission struct {
@@ -49,6 +53,9 @@ type group struct {
 	Metadata  map[string]interface{}
 	Policies  []string
 	EntityIds []string
+	// ExternalMembers excludes EntityIds from comparison and updates
+	ExternalMembers bool
+	vault.Toggle
 }
 
 func (g group) Key() string {
@@ -70,77 +77,121 @@ func (g group) Equals(i interface{}) bool {
 	}
 	return g.Name == group.Name &&
 		reflect.DeepEqual(g.Metadata, group.Metadata) &&
-		reflect.DeepEqual(g.Policies, group.Policies) &&
-		reflect.DeepEqual(g.EntityIds, group.EntityIds)
+		sameMembers(g.Policies, group.Policies) &&
+		(g.ExternalMembers || sameMembers(g.EntityIds, group.EntityIds))
 }
 
-func (g group) CreateOrUpdate(action string) error {
-	path := filepath.Join("identity", g.Type, "name", g.Name)
+// sameMembers compares two lists irrespective of ordering
+// vault returns nil for groups without members which is treated as an empty list
+func sameMembers(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	xs := append([]string{}, x...)
+	ys := append([]string{}, y...)
+	sort.Strings(xs)
+	sort.Strings(ys)
+	for i := range xs {
+		if xs[i] != ys[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// data returns the fields written to create or update the group
+// vault leaves fields omitted from an update as they are, so external members are only written on creation
+func (g group) data(update bool) map[string]interface{} {
 	config := map[string]interface{}{
-		"member_entity_ids": g.EntityIds,
-		"policies":          g.Policies,
-		"metadata":          g.Metadata,
+		"policies": g.Policies,
+		"metadata": g.Metadata,
+	}
+	if !update || !g.ExternalMembers {
+		config["member_entity_ids"] = g.EntityIds
 	}
-	err := vault.WriteSecret(g.Instance.Address, path, vault.KV_V1, config)
+	return config
+}
+
+func (g group) CreateOrUpdate(action string) error {
+	path := filepath.Join("identity", g.Type, "name", g.Name)
+	err := vault.WriteSecret(g.Instance.Key(), path, vault.KV_V1, g.data(action == "updated"))
 	if err != nil {
 		return err
 	}
-	log.WithFields(log.Fields{
-		"path":     path,
-		"type":     g.Type,
-		"instance": g.Instance.Address,
+	vault.Logger(g.Instance.Key(), toplevelName).WithFields(log.Fields{
+		"path": path,
+		"type": g.Type,
 	}).Infof("[Vault Identity] group successfully %s", action)
 	return nil
 }
 
 func (g group) Delete() error {
 	path := filepath.Join("identity", g.Type, "name", g.Name)
-	err := vault.DeleteSecret(g.Instance.Address, path)
+	err := vault.DeleteSecret(g.Instance.Key(), path)
 	if err != nil {
 		return err
 	}
-	log.WithFields(log.Fields{
-		"path":     path,
-		"type":     g.Type,
-		"instance": g.Instance.Address,
+	vault.Logger(g.Instance.Key(), toplevelName).WithFields(log.Fields{
+		"path": path,
+		"type": g.Type,
 	}).Info("[Vault Identity] group successfully deleted")
 	return nil
 }
 
 var _ vault.Item = group{}
 
+const toplevelName = "vault_groups"
+
 func init() {
-	toplevel.RegisterConfiguration("vault_groups", config{})
+	toplevel.RegisterConfiguration(toplevelName, config{}, "vault_entities", "vault_policies")
 }
 
-func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPoolSize int) error {
+// Validate ensures the configuration can be decoded.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []user
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault Identity] failed to decode group configuration: %v", err))
+	}
+	return nil
+}
+
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
 	var users []user
 	if err := yaml.Unmarshal(entriesBytes, &users); err != nil {
-		log.WithError(err).Fatal("[Vault Identity] failed to decode entity configuration")
+		return nil, errors.New(fmt.Sprintf("[Vault Identity] failed to decode entity configuration: %v", err))
 	}
 
 	entityNamesToIds, err := getEntityNamesToIds(address)
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"instance": address,
-		}).Info("[Vault Identity] failed to parse existing entities as prereq for group reconcile")
-		return err
+		vault.Logger(address, toplevelName).WithError(err).Info("[Vault Identity] failed to parse existing entities as prereq for group reconcile")
+		return nil, err
 	}
 
 	desired := processDesired(address, users, entityNamesToIds)
 	existing, err := getExistingGroups(address, threadPoolSize)
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"instance": address,
-		}).Info("[Vault Identity] failed to retrieve existing groups")
-		return err
+		vault.Logger(address, toplevelName).WithError(err).Info("[Vault Identity] failed to retrieve existing groups")
+		return nil, err
 	}
 
 	sortSlices(desired)
 	sortSlices(existing)
 
-	toBeWritten, toBeDeleted, toBeUpdated := vault.DiffItems(groupsAsItems(desired), groupsAsItems(existing))
+	toBeWritten, toBeDeleted, toBeUpdated :=
+		vault.DiffItems(vault.ExcludeUnmanaged(groupsAsItems(desired), groupsAsItems(existing)))
+	utils.RecordPendingChanges(address, toplevelName, len(toBeWritten)+len(toBeUpdated)+len(toBeDeleted))
+	if !prune {
+		toBeDeleted = vault.SkipDeletes(address, "[Vault Identity] group", toBeDeleted, nil)
+	}
+	if err := vault.CheckDeletes(address, "[Vault Identity]", toBeDeleted); err != nil {
+		return nil, err
+	}
+	plan := vault.NewPlan()
+	plan.Add("group", toBeWritten, toBeUpdated, toBeDeleted, groupsAsItems(existing))
 	if dryRun {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate, len(toBeWritten))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationUpdate, len(toBeUpdated))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationDelete, len(toBeDeleted))
 		dryRunOutput(address, toBeWritten, "written")
 		dryRunOutput(address, toBeDeleted, "deleted")
 		dryRunOutput(address, toBeUpdated, "updated")
@@ -148,24 +199,27 @@ func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPo
 		for _, w := range toBeWritten {
 			err := w.(group).CreateOrUpdate("written")
 			if err != nil {
-				return err
+				return nil, err
 			}
+			utils.RecordOperation(address, toplevelName, utils.OperationCreate)
 		}
 		for _, d := range toBeDeleted {
 			err := d.(group).Delete()
 			if err != nil {
-				return err
+				return nil, err
 			}
+			utils.RecordOperation(address, toplevelName, utils.OperationDelete)
 		}
 		for _, u := range toBeUpdated {
 			err := u.(group).CreateOrUpdate("updated")
 			if err != nil {
-				return err
+				return nil, err
 			}
+			utils.RecordOperation(address, toplevelName, utils.OperationUpdate)
 		}
 	}
 
-	return nil
+	return plan, nil
 }
 
 // processDesired accepts the yaml-marshalled result of the `vault_groups` graphql
@@ -178,7 +232,7 @@ func processDesired(instanceAddr string, users []user, entityNamesToIds map[stri
 	for _, user := range users {
 		for _, role := range user.Roles {
 			for _, permission := range role.Permissions {
-				if permission.Service == "vault" && permission.Instance.Address == instanceAddr {
+				if permission.Service == "vault" && permission.Instance.Key() == instanceAddr {
 					// a role can reference multiple permissions but a user
 					// should only be added once per role
 					if existingEntitiesPerGroup[role.Name] == nil {
@@ -187,6 +241,8 @@ func processDesired(instanceAddr string, users []user, entityNamesToIds map[stri
 
 					handleNewDesired(processedGroups, permission, role.Name,
 						entityNamesToIds[user.Name], existingEntitiesPerGroup[role.Name][user.Name])
+					processedGroups[role.Name].Toggle = role.Toggle
+					processedGroups[role.Name].ExternalMembers = role.ExternalMembers
 
 					// ensure user is not added again for this role
 					existingEntitiesPerGroup[role.Name][user.Name] = true
@@ -312,7 +368,7 @@ func getExistingGroups(instanceAddr string, threadPoolSize int) ([]group, error)
 // makes request to vault instance and updates a particular group object
 func getGroupDetails(g *group, ch chan<- error, wg *utils.BoundedWaitGroup) {
 	defer wg.Done()
-	info, err := vault.GetGroupInfo(g.Instance.Address, g.Name)
+	info, err := vault.GetGroupInfo(g.Instance.Key(), g.Name)
 	if err != nil {
 		ch <- err
 		return
@@ -418,10 +474,9 @@ func groupsAsItems(groups []group) []vault.Item {
 // reusable func to output updates on writes, deletes, and updates for groups
 func dryRunOutput(instanceAddr string, groups []vault.Item, action string) {
 	for _, g := range groups {
-		log.WithFields(log.Fields{
-			"name":     g.Key(),
-			"type":     g.KeyForType(),
-			"instance": instanceAddr,
+		vault.Logger(instanceAddr, toplevelName).WithFields(log.Fields{
+			"name": g.Key(),
+			"type": g.KeyForType(),
 		}).Infof("[Dry Run] [Vault Identity] group to be %s", action)
 	}
 }
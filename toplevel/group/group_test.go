@@ -0,0 +1,95 @@
+package group
+
+import (
+	"testing"
+
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupEquals(t *testing.T) {
+	table := []struct {
+		description string
+		desired     group
+		existing    group
+		expected    bool
+	}{
+		{
+			description: "member ordering is ignored",
+			desired:     group{Name: "x", Policies: []string{"a", "b"}, EntityIds: []string{"1", "2"}},
+			existing:    group{Name: "x", Policies: []string{"b", "a"}, EntityIds: []string{"2", "1"}},
+			expected:    true,
+		},
+		{
+			description: "no members equals nil members",
+			desired:     group{Name: "x", Policies: []string{}, EntityIds: []string{}},
+			existing:    group{Name: "x"},
+			expected:    true,
+		},
+		{
+			description: "removed member is not equal",
+			desired:     group{Name: "x", EntityIds: []string{"1"}},
+			existing:    group{Name: "x", EntityIds: []string{"1", "2"}},
+			expected:    false,
+		},
+		{
+			description: "changed policy is not equal",
+			desired:     group{Name: "x", Policies: []string{"a"}},
+			existing:    group{Name: "x", Policies: []string{"b"}},
+			expected:    false,
+		},
+		{
+			description: "members added elsewhere are ignored with external members",
+			desired:     group{Name: "x", Policies: []string{"a"}, EntityIds: []string{"1"}, ExternalMembers: true},
+			existing:    group{Name: "x", Policies: []string{"a"}, EntityIds: []string{"1", "2"}},
+			expected:    true,
+		},
+		{
+			description: "members removed elsewhere are ignored with external members",
+			desired:     group{Name: "x", Policies: []string{"a"}, EntityIds: []string{"1", "2"}, ExternalMembers: true},
+			existing:    group{Name: "x", Policies: []string{"a"}},
+			expected:    true,
+		},
+		{
+			description: "changed policy is not equal with external members",
+			desired:     group{Name: "x", Policies: []string{"a"}, ExternalMembers: true},
+			existing:    group{Name: "x", Policies: []string{"b"}, EntityIds: []string{"1"}},
+			expected:    false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.desired.Equals(tt.existing))
+		})
+	}
+}
+
+func TestGroupData(t *testing.T) {
+	g := group{Name: "x", Policies: []string{"a"}, EntityIds: []string{"1"},
+		Metadata: map[string]interface{}{"p": "d"}}
+	require.Contains(t, g.data(false), "member_entity_ids")
+	require.Contains(t, g.data(true), "member_entity_ids")
+
+	g.ExternalMembers = true
+	require.Equal(t, []string{"1"}, g.data(false)["member_entity_ids"], "members are written on creation")
+	require.NotContains(t, g.data(true), "member_entity_ids", "members managed elsewhere are not overwritten")
+	require.Equal(t, []string{"a"}, g.data(true)["policies"])
+}
+
+func TestProcessDesiredExternalMembers(t *testing.T) {
+	instance := vault.Instance{Address: "https://vault.test"}
+	permission := oidcPermission{Name: "p", Service: "vault", Instance: instance,
+		Policies: []vaultPolicy{{Name: "a"}}}
+	users := []user{
+		{Name: "alice", Roles: []role{{Name: "owned", Permissions: []oidcPermission{permission}},
+			{Name: "shared", Permissions: []oidcPermission{permission}, ExternalMembers: true}}},
+	}
+
+	desired := processDesired(instance.Key(), users, map[string]string{"alice": "1"})
+	external := map[string]bool{}
+	for _, g := range desired {
+		external[g.Name] = g.ExternalMembers
+	}
+	require.Equal(t, map[string]bool{"owned": false, "shared": true}, external)
+}
@@ -0,0 +1,378 @@
+// Package managedkey implements the application of a declarative configuration
+// for Vault Enterprise managed keys, e.g. keys stored within an HSM or a cloud KMS.
+package managedkey
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/app-sre/vault-manager/pkg/utils"
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/app-sre/vault-manager/toplevel"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const managedKeysPath = "sys/managed-keys"
+
+// supported backends of managed keys
+var keyTypes = []string{"pkcs11", "awskms", "azurekeyvault", "gcpckms"}
+
+// secretRef references a secret field stored within the instance being configured
+// that is written to the key as the setting of the same name, e.g. the `pin` of a pkcs11 key
+type secretRef struct {
+	Name    string `yaml:"name"`
+	Path    string `yaml:"path"`
+	Field   string `yaml:"field"`
+	Version string `yaml:"version"`
+}
+
+type entry struct {
+	Name     string         `yaml:"name"`
+	Type     string         `yaml:"type"`
+	Instance vault.Instance `yaml:"instance"`
+	// Settings only compares the settings present in the configuration, others are left to vault's defaults
+	Settings     map[string]interface{} `yaml:"settings"`
+	Secrets      []secretRef            `yaml:"secrets"`
+	vault.Toggle `yaml:",inline"`
+}
+
+var _ vault.Item = entry{}
+
+func (e entry) Key() string {
+	return filepath.Join(e.Type, e.Name)
+}
+
+func (e entry) KeyForType() string {
+	return e.Type
+}
+
+func (e entry) KeyForDescription() string {
+	return ""
+}
+
+// Equals compares the configured settings only, lists regardless of order.
+// Secrets are not compared as vault does not return them.
+func (e entry) Equals(i interface{}) bool {
+	entry, ok := i.(entry)
+	if !ok {
+		return false
+	}
+	if e.Key() != entry.Key() {
+		return false
+	}
+	for k, v := range e.Settings {
+		if !settingEqual(k, v, entry.Settings[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e entry) path() string {
+	return filepath.Join(managedKeysPath, e.Key())
+}
+
+type config struct{}
+
+var _ toplevel.Configuration = config{}
+
+const toplevelName = "vault_managed_keys"
+
+func init() {
+	// secret fields are read from secrets that already exist, so vault_secrets is not a dependency
+	// as it depends on vault_secret_engines which depends on managed keys
+	toplevel.RegisterConfiguration(toplevelName, config{})
+	// credentials of managed keys are read from secrets
+	toplevel.ReadsSecrets(toplevelName)
+	// sys/managed-keys was introduced with vault 1.10
+	toplevel.RequireVersion(toplevelName, "1.10.0")
+}
+
+// Validate ensures each key has a supported type and keeps credentials out of its settings.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault Managed Key] failed to decode managed key configuration: %v", err))
+	}
+	errs := []error{}
+	for _, e := range entries {
+		if e.Name == "" {
+			errs = append(errs, errors.New("[Vault Managed Key] managed key without name"))
+			continue
+		}
+		if !contains(keyTypes, e.Type) {
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault Managed Key] unsupported type `%s` of managed key `%s`, must be one of %v", e.Type, e.Name, keyTypes)))
+		}
+		for k := range e.Settings {
+			if utils.IsSensitiveKey(k) {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Managed Key] setting `%s` of managed key `%s` must be referenced within `secrets`", k, e.Name)))
+			}
+		}
+		for _, s := range e.Secrets {
+			if s.Name == "" || s.Path == "" || s.Field == "" {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Managed Key] secrets of managed key `%s` require `name`, `path` and `field`", e.Name)))
+			}
+		}
+	}
+	return utils.JoinErrors(errs)
+}
+
+// Apply ensures that the managed keys of an instance are configured exactly as provided.
+// Keys that secrets engines are allowed to use are only deleted with `-force-delete-managed-keys`.
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return nil, errors.New(fmt.Sprintf("[Vault Managed Key] failed to decode managed key configuration: %v", err))
+	}
+	desired := []entry{}
+	for _, e := range entries {
+		if e.Instance.Key() == address {
+			desired = append(desired, e)
+		}
+	}
+
+	existing, err := getExistingKeys(address, threadPoolSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// keys are written in full so that updates and creations are handled alike
+	toBeWritten, toBeDeleted, _ := vault.DiffItems(vault.ExcludeUnmanaged(asItems(desired), asItems(existing)))
+	utils.RecordPendingChanges(address, toplevelName, len(toBeWritten)+len(toBeDeleted))
+	if !prune {
+		toBeDeleted = vault.SkipDeletes(address, "[Vault Managed Key] managed key", toBeDeleted, nil)
+	}
+	if len(toBeDeleted) > 0 {
+		referenced, err := getReferencedKeys(address)
+		if err != nil {
+			return nil, err
+		}
+		toBeDeleted = vault.ExcludeItems(toBeDeleted, func(i vault.Item) bool {
+			mounts := referencingMounts(i.(entry), referenced)
+			if len(mounts) == 0 {
+				return false
+			}
+			logger := vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"key":    i.Key(),
+				"mounts": mounts,
+			})
+			if vault.ForceDeleteManagedKeys() {
+				logger.Warn("[Vault Managed Key] deleting managed key that secrets engines are allowed to use")
+				return false
+			}
+			logger.Warn("[Vault Managed Key] managed key is not deleted as secrets engines are allowed to use it, " +
+				"remove it from their `allowed_managed_keys` or use `-force-delete-managed-keys`")
+			return true
+		})
+	}
+	if err := vault.CheckDeletes(address, "[Vault Managed Key]", toBeDeleted); err != nil {
+		return nil, err
+	}
+
+	plan := vault.NewPlan()
+	plan.Add("managed-key", toBeWritten, nil, toBeDeleted, asItems(existing))
+
+	if dryRun == true {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate, len(toBeWritten))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationDelete, len(toBeDeleted))
+		for _, w := range toBeWritten {
+			k := w.(entry)
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"path":     k.path(),
+				"settings": utils.RedactOptions(k.Settings),
+				"secrets":  secretNames(k.Secrets),
+			}).Info("[Dry Run] [Vault Managed Key] managed key to be written")
+		}
+		for _, d := range toBeDeleted {
+			vault.Logger(address, toplevelName).WithField("path", d.(entry).path()).Info(
+				"[Dry Run] [Vault Managed Key] managed key to be deleted")
+		}
+		return plan, nil
+	}
+
+	for _, w := range toBeWritten {
+		if err := writeKey(address, w.(entry)); err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationCreate)
+	}
+	for _, d := range toBeDeleted {
+		k := d.(entry)
+		if err := vault.DeleteRaw(address, k.path()); err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationDelete)
+		vault.Logger(address, toplevelName).WithField("path", k.path()).Info(
+			"[Vault Managed Key] managed key is successfully deleted")
+	}
+
+	return plan, nil
+}
+
+// writeKey creates or updates a key along with the credentials it references
+func writeKey(address string, k entry) error {
+	data := make(map[string]interface{}, len(k.Settings)+len(k.Secrets))
+	for name, v := range k.Settings {
+		data[name] = v
+	}
+	for _, s := range k.Secrets {
+		version := s.Version
+		if version == "" {
+			version = vault.KV_V1
+		}
+		secret, err := vault.GetVaultSecretField(address, s.Path, s.Field, version)
+		if err != nil {
+			return errors.New(fmt.Sprintf(
+				"[Vault Managed Key] failed to retrieve `%s` of managed key %s: %v", s.Name, k.Key(), err))
+		}
+		data[s.Name] = secret
+	}
+	if err := vault.WriteRaw(address, k.path(), data); err != nil {
+		return err
+	}
+	vault.Logger(address, toplevelName).WithFields(log.Fields{
+		"path":     k.path(),
+		"settings": utils.RedactOptions(k.Settings),
+		"secrets":  secretNames(k.Secrets),
+	}).Info("[Vault Managed Key] managed key is successfully written")
+	return nil
+}
+
+// getExistingKeys reads the keys of each supported type
+func getExistingKeys(address string, threadPoolSize int) ([]entry, error) {
+	existing := []entry{}
+	for _, keyType := range keyTypes {
+		names, err := vault.ListRaw(address, filepath.Join(managedKeysPath, keyType))
+		if err != nil {
+			return nil, err
+		}
+
+		var mutex = &sync.Mutex{}
+		var readErr error
+		bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+
+		for _, name := range names {
+			bwg.Add(1)
+
+			go func(keyType, name string) {
+				defer bwg.Done()
+
+				k := entry{Name: name, Type: keyType, Instance: vault.Instance{Address: address}}
+				data, err := vault.ReadRaw(address, k.path())
+
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if err != nil {
+					readErr = err
+					return
+				}
+				if data == nil {
+					return
+				}
+				k.Settings = data
+				existing = append(existing, k)
+			}(keyType, name)
+		}
+		bwg.Wait()
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	return existing, nil
+}
+
+// getReferencedKeys returns the paths of the secrets engines allowed to use each managed key,
+// keys may be referenced by name or uuid
+func getReferencedKeys(address string) (map[string][]string, error) {
+	engines, err := vault.ListSecretsEngines(address)
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string][]string)
+	for path, engine := range engines {
+		for _, k := range engine.Config.AllowedManagedKeys {
+			referenced[k] = append(referenced[k], path)
+		}
+	}
+	return referenced, nil
+}
+
+// referencingMounts returns the sorted paths of the secrets engines allowed to use a key
+func referencingMounts(k entry, referenced map[string][]string) []string {
+	mounts := append([]string{}, referenced[k.Name]...)
+	if uuid, ok := k.Settings["uuid"]; ok && uuid != nil {
+		mounts = append(mounts, referenced[fmt.Sprintf("%v", uuid)]...)
+	}
+	sort.Strings(mounts)
+	return mounts
+}
+
+// settingEqual compares lists regardless of order and other values as options
+func settingEqual(k string, x, y interface{}) bool {
+	xs, xok := x.([]interface{})
+	ys, yok := y.([]interface{})
+	if xok && yok {
+		return equalStrings(toStrings(xs), toStrings(ys))
+	}
+	return vault.OptionsEqual(map[string]interface{}{k: x}, map[string]interface{}{k: y})
+}
+
+// secretNames lists the settings a key reads from secrets, their values are never logged
+func secretNames(secrets []secretRef) []string {
+	names := make([]string, 0, len(secrets))
+	for _, s := range secrets {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func toStrings(values []interface{}) []string {
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, fmt.Sprintf("%v", v))
+	}
+	return strs
+}
+
+func contains(xs []string, x string) bool {
+	for _, v := range xs {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
+
+// equalStrings compares lists of strings regardless of order
+func equalStrings(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	xs := append([]string{}, x...)
+	ys := append([]string{}, y...)
+	sort.Strings(xs)
+	sort.Strings(ys)
+	for i := range xs {
+		if xs[i] != ys[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func asItems(xs []entry) (items []vault.Item) {
+	items = make([]vault.Item, 0)
+	for _, x := range xs {
+		items = append(items, x)
+	}
+
+	return
+}
@@ -0,0 +1,101 @@
+package managedkey
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManagedKeyEquals(t *testing.T) {
+	existing := entry{Name: "hsm", Type: "pkcs11", Settings: map[string]interface{}{
+		"library":   "softhsm",
+		"key_label": "vault",
+		"key_bits":  2048,
+		"usages":    []interface{}{"sign", "encrypt"},
+		"uuid":      "1234",
+	}}
+
+	table := []struct {
+		description string
+		desired     entry
+		expected    bool
+	}{
+		{
+			description: "settings reported by vault are ignored",
+			desired:     entry{Name: "hsm", Type: "pkcs11", Settings: map[string]interface{}{"library": "softhsm"}},
+			expected:    true,
+		},
+		{
+			description: "lists are compared regardless of order",
+			desired: entry{Name: "hsm", Type: "pkcs11",
+				Settings: map[string]interface{}{"usages": []interface{}{"encrypt", "sign"}, "key_bits": "2048"}},
+			expected: true,
+		},
+		{
+			description: "secrets are not compared",
+			desired: entry{Name: "hsm", Type: "pkcs11",
+				Secrets: []secretRef{{Name: "pin", Path: "hsm/creds", Field: "pin"}}},
+			expected: true,
+		},
+		{
+			description: "changed list is not equal",
+			desired: entry{Name: "hsm", Type: "pkcs11",
+				Settings: map[string]interface{}{"usages": []interface{}{"sign"}}},
+			expected: false,
+		},
+		{
+			description: "other type is another key",
+			desired:     entry{Name: "hsm", Type: "awskms"},
+			expected:    false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.desired.Equals(existing))
+		})
+	}
+}
+
+func TestReferencingMounts(t *testing.T) {
+	referenced := map[string][]string{"hsm": {"pki/"}, "1234": {"transit/"}, "other": {"pki-int/"}}
+
+	require.Equal(t, []string{"pki/", "transit/"},
+		referencingMounts(entry{Name: "hsm", Settings: map[string]interface{}{"uuid": "1234"}}, referenced),
+		"keys are referenced by name or uuid")
+	require.Empty(t, referencingMounts(entry{Name: "unused", Settings: map[string]interface{}{}}, referenced))
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []byte(`
+- name: hsm
+  type: softhsm
+  instance:
+    address: https://vault.test
+  settings:
+    library: softhsm
+    pin: "1234"
+  secrets:
+  - name: secret_key
+`)
+	err := config{}.Validate(invalid)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unsupported type `softhsm`")
+	require.Contains(t, err.Error(), "setting `pin` of managed key `hsm`")
+	require.Contains(t, err.Error(), "require `name`, `path` and `field`")
+
+	valid := []byte(`
+- name: kms
+  type: awskms
+  instance:
+    address: https://vault.test
+  settings:
+    kms_key: alias/vault
+    region: us-east-1
+  secrets:
+  - name: secret_key
+    path: aws/kms
+    field: secret_key
+`)
+	require.NoError(t, config{}.Validate(valid))
+}
@@ -0,0 +1,648 @@
+// Package mfa implements the application of a declarative configuration
+// for Vault login MFA methods and the login enforcements binding them to auth mounts and identities.
+package mfa
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/app-sre/vault-manager/pkg/utils"
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/app-sre/vault-manager/toplevel"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	methodPath      = "identity/mfa/method"
+	enforcementPath = "identity/mfa/login-enforcement"
+)
+
+// supported types of mfa methods
+var methodTypes = []string{"totp", "duo", "okta", "pingid"}
+
+type entry struct {
+	Instance     vault.Instance `yaml:"instance"`
+	Methods      []method       `yaml:"methods"`
+	Enforcements []enforcement  `yaml:"enforcements"`
+}
+
+// secretRef references a secret field stored within the instance being configured
+// that is written to the method as the setting of the same name, e.g. the `secret_key` of a duo method
+type secretRef struct {
+	Name    string `yaml:"name"`
+	Path    string `yaml:"path"`
+	Field   string `yaml:"field"`
+	Version string `yaml:"version"`
+}
+
+type method struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	// Settings only compares the settings present in the configuration, others are left to vault's defaults
+	Settings     map[string]interface{} `yaml:"settings"`
+	Secrets      []secretRef            `yaml:"secrets"`
+	ID           string                 `yaml:"-"`
+	vault.Toggle `yaml:",inline"`
+}
+
+var _ vault.Item = method{}
+
+func (m method) Key() string {
+	return m.Name
+}
+
+func (m method) KeyForType() string {
+	return m.Type
+}
+
+func (m method) KeyForDescription() string {
+	return ""
+}
+
+// Equals compares the configured settings only, secrets are not compared as vault does not return them
+func (m method) Equals(i interface{}) bool {
+	entry, ok := i.(method)
+	if !ok {
+		return false
+	}
+	if m.Name != entry.Name || m.Type != entry.Type {
+		return false
+	}
+	for k, v := range m.Settings {
+		if !vault.OptionsEqual(map[string]interface{}{k: v}, map[string]interface{}{k: entry.Settings[k]}) {
+			return false
+		}
+	}
+	return true
+}
+
+// path returns the path a method is written to, methods are created without an id which is generated by vault
+func (m method) path() string {
+	return filepath.Join(methodPath, m.Type, m.ID)
+}
+
+type enforcement struct {
+	Name string `yaml:"name"`
+	// Methods, AuthMounts, Groups and Entities are referenced by name and resolved to the
+	// method ids, mount accessors, group ids and entity ids that are written to vault
+	Methods         []string `yaml:"mfa_methods"`
+	AuthMounts      []string `yaml:"auth_mounts"`
+	AuthMethodTypes []string `yaml:"auth_method_types"`
+	Groups          []string `yaml:"identity_groups"`
+	Entities        []string `yaml:"identity_entities"`
+	methodIDs       []string
+	accessors       []string
+	groupIDs        []string
+	entityIDs       []string
+	vault.Toggle    `yaml:",inline"`
+}
+
+var _ vault.Item = enforcement{}
+
+func (e enforcement) Key() string {
+	return e.Name
+}
+
+func (e enforcement) KeyForType() string {
+	return ""
+}
+
+func (e enforcement) KeyForDescription() string {
+	return ""
+}
+
+// Equals compares the resolved bindings of enforcements regardless of order
+func (e enforcement) Equals(i interface{}) bool {
+	entry, ok := i.(enforcement)
+	if !ok {
+		return false
+	}
+	return e.Name == entry.Name &&
+		equalStrings(e.methodIDs, entry.methodIDs) &&
+		equalStrings(e.accessors, entry.accessors) &&
+		equalStrings(e.AuthMethodTypes, entry.AuthMethodTypes) &&
+		equalStrings(e.groupIDs, entry.groupIDs) &&
+		equalStrings(e.entityIDs, entry.entityIDs)
+}
+
+func (e enforcement) path() string {
+	return filepath.Join(enforcementPath, e.Name)
+}
+
+func (e enforcement) data() map[string]interface{} {
+	return map[string]interface{}{
+		"mfa_method_ids":        nonNil(e.methodIDs),
+		"auth_method_accessors": nonNil(e.accessors),
+		"auth_method_types":     nonNil(e.AuthMethodTypes),
+		"identity_group_ids":    nonNil(e.groupIDs),
+		"identity_entity_ids":   nonNil(e.entityIDs),
+	}
+}
+
+type config struct{}
+
+var _ toplevel.Configuration = config{}
+
+const toplevelName = "vault_mfa"
+
+func init() {
+	toplevel.RegisterConfiguration(toplevelName, config{},
+		"vault_auth_backends", "vault_secrets", "vault_entities", "vault_groups")
+	// secret settings of methods are read from secrets
+	toplevel.ReadsSecrets(toplevelName)
+}
+
+// Validate ensures methods have a supported type and keep secrets out of their settings,
+// and that enforcements reference a method along with what they are enforced for.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault MFA] failed to decode mfa configuration: %v", err))
+	}
+	errs := []error{}
+	for _, e := range entries {
+		for _, m := range e.Methods {
+			if m.Name == "" {
+				errs = append(errs, errors.New("[Vault MFA] mfa method without name"))
+				continue
+			}
+			if !contains(methodTypes, m.Type) {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault MFA] unsupported type `%s` of mfa method `%s`, must be one of %v", m.Type, m.Name, methodTypes)))
+			}
+			for k := range m.Settings {
+				if utils.IsSensitiveKey(k) {
+					errs = append(errs, errors.New(fmt.Sprintf(
+						"[Vault MFA] setting `%s` of mfa method `%s` must be referenced within `secrets`", k, m.Name)))
+				}
+			}
+			for _, s := range m.Secrets {
+				if s.Name == "" || s.Path == "" || s.Field == "" {
+					errs = append(errs, errors.New(fmt.Sprintf(
+						"[Vault MFA] secrets of mfa method `%s` require `name`, `path` and `field`", m.Name)))
+				}
+			}
+		}
+		for _, enf := range e.Enforcements {
+			if enf.Name == "" {
+				errs = append(errs, errors.New("[Vault MFA] login enforcement without name"))
+				continue
+			}
+			if len(enf.Methods) == 0 {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault MFA] login enforcement `%s` must reference at least one mfa method", enf.Name)))
+			}
+			if len(enf.AuthMounts)+len(enf.AuthMethodTypes)+len(enf.Groups)+len(enf.Entities) == 0 {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault MFA] login enforcement `%s` must be bound to an auth mount, auth method type, group or entity",
+					enf.Name)))
+			}
+		}
+	}
+	return utils.JoinErrors(errs)
+}
+
+// Apply ensures that the login mfa methods and enforcements of an instance are configured exactly as provided.
+//
+// Methods are written before enforcements referencing them and deleted after the enforcements,
+// as vault refuses to delete a method that is still enforced.
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return nil, errors.New(fmt.Sprintf("[Vault MFA] failed to decode mfa configuration: %v", err))
+	}
+	desiredMethods := []method{}
+	desiredEnforcements := []enforcement{}
+	for _, e := range entries {
+		if e.Instance.Key() == address {
+			desiredMethods = append(desiredMethods, e.Methods...)
+			desiredEnforcements = append(desiredEnforcements, e.Enforcements...)
+		}
+	}
+
+	existingMethods, err := getExistingMethods(address, threadPoolSize)
+	if err != nil {
+		return nil, err
+	}
+	existingEnforcements, err := getExistingEnforcements(address, threadPoolSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// the type of a method is part of its path and cannot be changed
+	existingIDs := make(map[string]string, len(existingMethods))
+	for _, m := range existingMethods {
+		existingIDs[m.Name] = m.ID
+	}
+	for i, m := range desiredMethods {
+		for _, e := range existingMethods {
+			if e.Name == m.Name && e.Type != m.Type && !m.Unmanaged() {
+				return nil, errors.New(fmt.Sprintf(
+					"[Vault MFA] type of mfa method `%s` cannot be changed from %s to %s, the method must be renamed",
+					m.Name, e.Type, m.Type))
+			}
+		}
+		desiredMethods[i].ID = existingIDs[m.Name]
+	}
+
+	// references to objects that do not exist yet are kept as their names so that the enforcement differs
+	// from vault. Methods to be created are resolved once written, other objects are only expected to be
+	// missing in dry runs
+	methodIDs := make(map[string]string, len(desiredMethods)+len(existingIDs))
+	for _, m := range desiredMethods {
+		methodIDs[m.Name] = m.Name
+	}
+	for name, id := range existingIDs {
+		methodIDs[name] = id
+	}
+	for i := range desiredEnforcements {
+		if desiredEnforcements[i], err = resolve(address, desiredEnforcements[i], methodIDs, dryRun); err != nil {
+			return nil, err
+		}
+	}
+
+	methodsToBeWritten, methodsToBeDeleted, _ := vault.DiffItems(vault.ExcludeUnmanaged(
+		asItems(desiredMethods), asItems(existingMethods)))
+	enforcementsToBeWritten, enforcementsToBeDeleted, _ := vault.DiffItems(vault.ExcludeUnmanaged(
+		enforcementsAsItems(desiredEnforcements), enforcementsAsItems(existingEnforcements)))
+	utils.RecordPendingChanges(address, toplevelName, len(methodsToBeWritten)+len(methodsToBeDeleted)+
+		len(enforcementsToBeWritten)+len(enforcementsToBeDeleted))
+	if !prune {
+		methodsToBeDeleted = vault.SkipDeletes(address, "[Vault MFA] mfa method", methodsToBeDeleted, nil)
+		enforcementsToBeDeleted = vault.SkipDeletes(address, "[Vault MFA] login enforcement", enforcementsToBeDeleted, nil)
+	}
+	if err := vault.CheckDeletes(address, "[Vault MFA]", methodsToBeDeleted, enforcementsToBeDeleted); err != nil {
+		return nil, err
+	}
+
+	plan := vault.NewPlan()
+	plan.Add("mfa-method", methodsToBeWritten, nil, methodsToBeDeleted, asItems(existingMethods))
+	plan.Add("mfa-login-enforcement", enforcementsToBeWritten, nil, enforcementsToBeDeleted,
+		enforcementsAsItems(existingEnforcements))
+
+	if dryRun == true {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate,
+			len(methodsToBeWritten)+len(enforcementsToBeWritten))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationDelete,
+			len(methodsToBeDeleted)+len(enforcementsToBeDeleted))
+		for _, w := range methodsToBeWritten {
+			m := w.(method)
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"name":     m.Name,
+				"type":     m.Type,
+				"settings": utils.RedactOptions(m.Settings),
+				"secrets":  secretNames(m.Secrets),
+			}).Info("[Dry Run] [Vault MFA] mfa method to be written")
+		}
+		for _, w := range enforcementsToBeWritten {
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"path": w.(enforcement).path(),
+				"data": w.(enforcement).data(),
+			}).Info("[Dry Run] [Vault MFA] login enforcement to be written")
+		}
+		for _, d := range enforcementsToBeDeleted {
+			vault.Logger(address, toplevelName).WithField("path", d.(enforcement).path()).Info(
+				"[Dry Run] [Vault MFA] login enforcement to be deleted")
+		}
+		for _, d := range methodsToBeDeleted {
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"name": d.(method).Name,
+				"path": d.(method).path(),
+			}).Info("[Dry Run] [Vault MFA] mfa method to be deleted")
+		}
+		return plan, nil
+	}
+
+	for _, w := range methodsToBeWritten {
+		m := w.(method)
+		if err := writeMethod(address, m); err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationCreate)
+	}
+
+	// enforcements reference created methods by the id generated by vault
+	if len(methodsToBeWritten) > 0 {
+		written, err := getExistingMethods(address, threadPoolSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range written {
+			existingIDs[m.Name] = m.ID
+		}
+		for i, w := range enforcementsToBeWritten {
+			resolved, err := resolve(address, w.(enforcement), existingIDs, false)
+			if err != nil {
+				return nil, err
+			}
+			enforcementsToBeWritten[i] = resolved
+		}
+	}
+
+	for _, w := range enforcementsToBeWritten {
+		enf := w.(enforcement)
+		if err := vault.WriteRaw(address, enf.path(), enf.data()); err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationCreate)
+		vault.Logger(address, toplevelName).WithField("path", enf.path()).Info(
+			"[Vault MFA] login enforcement is successfully written")
+	}
+	for _, d := range enforcementsToBeDeleted {
+		enf := d.(enforcement)
+		if err := vault.DeleteRaw(address, enf.path()); err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationDelete)
+		vault.Logger(address, toplevelName).WithField("path", enf.path()).Info(
+			"[Vault MFA] login enforcement is successfully deleted")
+	}
+	for _, d := range methodsToBeDeleted {
+		m := d.(method)
+		if err := vault.DeleteRaw(address, m.path()); err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationDelete)
+		vault.Logger(address, toplevelName).WithFields(log.Fields{
+			"name": m.Name,
+			"path": m.path(),
+		}).Info("[Vault MFA] mfa method is successfully deleted")
+	}
+
+	return plan, nil
+}
+
+// writeMethod creates or updates a method along with the secrets it references
+func writeMethod(address string, m method) error {
+	data := make(map[string]interface{}, len(m.Settings)+len(m.Secrets)+1)
+	for k, v := range m.Settings {
+		data[k] = v
+	}
+	for _, s := range m.Secrets {
+		version := s.Version
+		if version == "" {
+			version = vault.KV_V1
+		}
+		secret, err := vault.GetVaultSecretField(address, s.Path, s.Field, version)
+		if err != nil {
+			return errors.New(fmt.Sprintf(
+				"[Vault MFA] failed to retrieve `%s` of mfa method %s: %v", s.Name, m.Name, err))
+		}
+		data[s.Name] = secret
+	}
+	data["method_name"] = m.Name
+	if err := vault.WriteRaw(address, m.path(), data); err != nil {
+		return err
+	}
+	vault.Logger(address, toplevelName).WithFields(log.Fields{
+		"name":     m.Name,
+		"type":     m.Type,
+		"settings": utils.RedactOptions(m.Settings),
+		"secrets":  secretNames(m.Secrets),
+	}).Info("[Vault MFA] mfa method is successfully written")
+	return nil
+}
+
+// resolve sets the identifiers vault reports for the names an enforcement references
+// names that cannot be resolved are an error unless allowMissing is set, in which case the name is kept
+func resolve(address string, enf enforcement, methodIDs map[string]string, allowMissing bool) (enforcement, error) {
+	var err error
+	enf.methodIDs, err = resolveNames(enf.Methods, allowMissing, func(name string) (string, error) {
+		if id, ok := methodIDs[name]; ok {
+			return id, nil
+		}
+		return "", errors.New(fmt.Sprintf("[Vault MFA] mfa method `%s` does not exist within %s", name, address))
+	})
+	if err != nil {
+		return enf, err
+	}
+	enf.accessors, err = resolveNames(enf.AuthMounts, allowMissing, func(path string) (string, error) {
+		return vault.GetMountAccessor(address, path)
+	})
+	if err != nil {
+		return enf, err
+	}
+	enf.groupIDs, err = resolveNames(enf.Groups, allowMissing, func(name string) (string, error) {
+		info, err := vault.GetGroupInfo(address, name)
+		return identityID(address, "group", name, info, err)
+	})
+	if err != nil {
+		return enf, err
+	}
+	enf.entityIDs, err = resolveNames(enf.Entities, allowMissing, func(name string) (string, error) {
+		info, err := vault.GetEntityInfo(address, name)
+		return identityID(address, "entity", name, info, err)
+	})
+	return enf, err
+}
+
+func resolveNames(names []string, allowMissing bool, lookup func(string) (string, error)) ([]string, error) {
+	resolved := make([]string, 0, len(names))
+	for _, name := range names {
+		id, err := lookup(name)
+		if err != nil {
+			if !allowMissing {
+				return nil, err
+			}
+			id = name
+		}
+		resolved = append(resolved, id)
+	}
+	return resolved, nil
+}
+
+func identityID(address, kind, name string, info map[string]interface{}, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	if info == nil || info["id"] == nil {
+		return "", errors.New(fmt.Sprintf("[Vault MFA] %s `%s` does not exist within %s", kind, name, address))
+	}
+	return fmt.Sprintf("%v", info["id"]), nil
+}
+
+// getExistingMethods reads the methods of each supported type
+func getExistingMethods(address string, threadPoolSize int) ([]method, error) {
+	existing := []method{}
+	for _, methodType := range methodTypes {
+		ids, err := listKeys(address, filepath.Join(methodPath, methodType))
+		if err != nil {
+			return nil, err
+		}
+
+		var mutex = &sync.Mutex{}
+		var readErr error
+		bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+
+		for _, id := range ids {
+			bwg.Add(1)
+
+			go func(methodType, id string) {
+				defer bwg.Done()
+
+				m := method{Type: methodType, ID: id}
+				data, err := vault.ReadRaw(address, m.path())
+
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if err != nil {
+					readErr = err
+					return
+				}
+				if data == nil {
+					return
+				}
+				m.Name = stringOrEmpty(data["name"])
+				m.Settings = data
+				existing = append(existing, m)
+			}(methodType, id)
+		}
+		bwg.Wait()
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	return existing, nil
+}
+
+func getExistingEnforcements(address string, threadPoolSize int) ([]enforcement, error) {
+	names, err := listKeys(address, enforcementPath)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := []enforcement{}
+	var mutex = &sync.Mutex{}
+	var readErr error
+	bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+
+	for _, name := range names {
+		bwg.Add(1)
+
+		go func(name string) {
+			defer bwg.Done()
+
+			enf := enforcement{Name: name}
+			data, err := vault.ReadRaw(address, enf.path())
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err != nil {
+				readErr = err
+				return
+			}
+			if data == nil {
+				return
+			}
+			enf.methodIDs = toStrings(data["mfa_method_ids"])
+			enf.accessors = toStrings(data["auth_method_accessors"])
+			enf.AuthMethodTypes = toStrings(data["auth_method_types"])
+			enf.groupIDs = toStrings(data["identity_group_ids"])
+			enf.entityIDs = toStrings(data["identity_entity_ids"])
+			existing = append(existing, enf)
+		}(name)
+	}
+	bwg.Wait()
+	if readErr != nil {
+		return nil, readErr
+	}
+	return existing, nil
+}
+
+// listKeys returns the keys listed at path or an empty list if nothing exists
+func listKeys(address, path string) ([]string, error) {
+	secret, err := vault.ListSecrets(address, path)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return []string{}, nil
+	}
+	return toStrings(secret.Data["keys"]), nil
+}
+
+// secretNames lists the settings a method reads from secrets, their values are never logged
+func secretNames(secrets []secretRef) []string {
+	names := make([]string, 0, len(secrets))
+	for _, s := range secrets {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func stringOrEmpty(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// nonNil ensures empty lists are written as such rather than as null
+func nonNil(xs []string) []string {
+	if xs == nil {
+		return []string{}
+	}
+	return xs
+}
+
+func toStrings(raw interface{}) []string {
+	values, ok := raw.([]interface{})
+	if !ok {
+		return []string{}
+	}
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, fmt.Sprintf("%v", v))
+	}
+	return strs
+}
+
+func contains(xs []string, x string) bool {
+	for _, v := range xs {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
+
+// equalStrings compares lists of strings regardless of order
+func equalStrings(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	xs := append([]string{}, x...)
+	ys := append([]string{}, y...)
+	sort.Strings(xs)
+	sort.Strings(ys)
+	for i := range xs {
+		if xs[i] != ys[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func asItems(xs []method) (items []vault.Item) {
+	items = make([]vault.Item, 0)
+	for _, x := range xs {
+		items = append(items, x)
+	}
+
+	return
+}
+
+func enforcementsAsItems(xs []enforcement) (items []vault.Item) {
+	items = make([]vault.Item, 0)
+	for _, x := range xs {
+		items = append(items, x)
+	}
+
+	return
+}
@@ -0,0 +1,128 @@
+package mfa
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMethodEquals(t *testing.T) {
+	existing := method{Name: "totp", Type: "totp", ID: "1",
+		Settings: map[string]interface{}{"name": "totp", "issuer": "vault", "period": 30, "digits": 6}}
+
+	table := []struct {
+		description string
+		desired     method
+		expected    bool
+	}{
+		{
+			description: "settings defaulted by vault are ignored",
+			desired:     method{Name: "totp", Type: "totp", Settings: map[string]interface{}{"issuer": "vault"}},
+			expected:    true,
+		},
+		{
+			description: "periods are compared regardless of unit",
+			desired:     method{Name: "totp", Type: "totp", Settings: map[string]interface{}{"period": "30s"}},
+			expected:    true,
+		},
+		{
+			description: "secrets are not compared",
+			desired: method{Name: "totp", Type: "totp",
+				Secrets: []secretRef{{Name: "secret_key", Path: "mfa/totp", Field: "key"}}},
+			expected: true,
+		},
+		{
+			description: "changed setting is not equal",
+			desired:     method{Name: "totp", Type: "totp", Settings: map[string]interface{}{"digits": 8}},
+			expected:    false,
+		},
+		{
+			description: "changed type is not equal",
+			desired:     method{Name: "totp", Type: "duo"},
+			expected:    false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.desired.Equals(existing))
+		})
+	}
+}
+
+func TestEnforcementEquals(t *testing.T) {
+	existing := enforcement{Name: "admins", methodIDs: []string{"1", "2"}, accessors: []string{"auth_oidc_a", "auth_github_b"},
+		groupIDs: []string{"g"}, entityIDs: []string{}}
+
+	desired := enforcement{Name: "admins", methodIDs: []string{"2", "1"}, accessors: []string{"auth_github_b", "auth_oidc_a"},
+		groupIDs: []string{"g"}}
+	require.True(t, desired.Equals(existing), "bindings are compared regardless of order")
+
+	desired.groupIDs = []string{"g", "h"}
+	require.False(t, desired.Equals(existing))
+}
+
+func TestResolveNames(t *testing.T) {
+	lookup := func(name string) (string, error) {
+		if name == "missing" {
+			return "", errors.New("missing")
+		}
+		return "id-" + name, nil
+	}
+
+	resolved, err := resolveNames([]string{"a", "b"}, false, lookup)
+	require.NoError(t, err)
+	require.Equal(t, []string{"id-a", "id-b"}, resolved)
+
+	_, err = resolveNames([]string{"a", "missing"}, false, lookup)
+	require.Error(t, err)
+
+	resolved, err = resolveNames([]string{"a", "missing"}, true, lookup)
+	require.NoError(t, err)
+	require.Equal(t, []string{"id-a", "missing"}, resolved, "unresolved names are kept")
+}
+
+func TestValidate(t *testing.T) {
+	valid := []byte(`
+- instance:
+    address: https://vault.test
+  methods:
+  - name: duo
+    type: duo
+    settings:
+      api_hostname: api.duosecurity.com
+    secrets:
+    - name: secret_key
+      path: mfa/duo
+      field: secret_key
+  enforcements:
+  - name: admins
+    mfa_methods: [duo]
+    auth_mounts: [oidc]
+`)
+	require.NoError(t, config{}.Validate(valid))
+
+	invalid := []byte(`
+- instance:
+    address: https://vault.test
+  methods:
+  - name: sms
+    type: sms
+  - name: duo
+    type: duo
+    settings:
+      secret_key: x
+  enforcements:
+  - name: unbound
+    mfa_methods: [duo]
+  - name: methodless
+    auth_method_types: [userpass]
+`)
+	err := config{}.Validate(invalid)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unsupported type `sms`")
+	require.Contains(t, err.Error(), "setting `secret_key` of mfa method `duo`")
+	require.Contains(t, err.Error(), "`unbound` must be bound")
+	require.Contains(t, err.Error(), "`methodless` must reference at least one mfa method")
+}
@@ -0,0 +1,512 @@
+// Package oidc implements the application of a declarative configuration
+// for Vault as an OIDC identity provider: signing keys, scopes, assignments, clients and providers.
+package oidc
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/app-sre/vault-manager/pkg/utils"
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/app-sre/vault-manager/toplevel"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const oidcPath = "identity/oidc"
+
+// object types in the order they are written, objects may reference objects of preceding types,
+// e.g. clients reference keys and assignments, so they are deleted in reverse order
+const (
+	keyType        = "key"
+	scopeType      = "scope"
+	assignmentType = "assignment"
+	clientType     = "client"
+	providerType   = "provider"
+)
+
+var types = []string{keyType, scopeType, assignmentType, clientType, providerType}
+
+// builtin objects created by vault that cannot be deleted
+var builtin = map[string]bool{
+	filepath.Join(keyType, "default"):          true,
+	filepath.Join(assignmentType, "allow_all"): true,
+	filepath.Join(providerType, "default"):     true,
+}
+
+type entry struct {
+	Name     string         `yaml:"name"`
+	Type     string         `yaml:"type"`
+	Instance vault.Instance `yaml:"instance"`
+	// AllowedClientIDs applies to keys and providers
+	AllowedClientIDs []string `yaml:"allowed_client_ids"`
+	// Algorithm, RotationPeriod and VerificationTTL only apply to keys
+	Algorithm       string `yaml:"algorithm"`
+	RotationPeriod  string `yaml:"rotation_period"`
+	VerificationTTL string `yaml:"verification_ttl"`
+	// Template and Description only apply to scopes
+	Template    string `yaml:"template"`
+	Description string `yaml:"description"`
+	// EntityIDs and GroupIDs only apply to assignments
+	EntityIDs []string `yaml:"entity_ids"`
+	GroupIDs  []string `yaml:"group_ids"`
+	// SigningKey, RedirectURIs, Assignments, ClientType, IDTokenTTL and AccessTokenTTL only apply to clients
+	// the client id and secret are generated by vault and are not managed
+	SigningKey     string   `yaml:"key"`
+	RedirectURIs   []string `yaml:"redirect_uris"`
+	Assignments    []string `yaml:"assignments"`
+	ClientType     string   `yaml:"client_type"`
+	IDTokenTTL     string   `yaml:"id_token_ttl"`
+	AccessTokenTTL string   `yaml:"access_token_ttl"`
+	// Issuer and ScopesSupported only apply to providers, an empty issuer is left unmanaged
+	Issuer          string   `yaml:"issuer"`
+	ScopesSupported []string `yaml:"scopes_supported"`
+	vault.Toggle    `yaml:",inline"`
+}
+
+var _ vault.Item = entry{}
+
+func (e entry) Key() string {
+	return filepath.Join(e.Type, e.Name)
+}
+
+func (e entry) KeyForType() string {
+	return e.Type
+}
+
+func (e entry) KeyForDescription() string {
+	return ""
+}
+
+// Equals compares the settings of the object's type, lists are compared regardless of order
+// and ttls regardless of their unit
+func (e entry) Equals(i interface{}) bool {
+	entry, ok := i.(entry)
+	if !ok {
+		return false
+	}
+
+	if e.Name != entry.Name || e.Type != entry.Type {
+		return false
+	}
+	switch e.Type {
+	case keyType:
+		return equalStrings(e.AllowedClientIDs, entry.AllowedClientIDs) &&
+			e.algorithm() == entry.algorithm() &&
+			durationsEqual(e.rotationPeriod(), entry.rotationPeriod()) &&
+			durationsEqual(e.verificationTTL(), entry.verificationTTL())
+	case scopeType:
+		return e.Template == entry.Template && e.Description == entry.Description
+	case assignmentType:
+		return equalStrings(e.EntityIDs, entry.EntityIDs) && equalStrings(e.GroupIDs, entry.GroupIDs)
+	case clientType:
+		return e.signingKey() == entry.signingKey() &&
+			equalStrings(e.RedirectURIs, entry.RedirectURIs) &&
+			equalStrings(e.Assignments, entry.Assignments) &&
+			e.clientType() == entry.clientType() &&
+			durationsEqual(e.idTokenTTL(), entry.idTokenTTL()) &&
+			durationsEqual(e.accessTokenTTL(), entry.accessTokenTTL())
+	case providerType:
+		return (e.Issuer == "" || e.Issuer == entry.Issuer) &&
+			equalStrings(e.AllowedClientIDs, entry.AllowedClientIDs) &&
+			equalStrings(e.ScopesSupported, entry.ScopesSupported)
+	}
+	return true
+}
+
+// defaults vault applies to settings that are omitted
+func (e entry) algorithm() string {
+	return defaultString(e.Algorithm, "RS256")
+}
+
+func (e entry) rotationPeriod() string {
+	return defaultString(e.RotationPeriod, "24h")
+}
+
+func (e entry) verificationTTL() string {
+	return defaultString(e.VerificationTTL, "24h")
+}
+
+func (e entry) signingKey() string {
+	return defaultString(e.SigningKey, "default")
+}
+
+func (e entry) clientType() string {
+	return defaultString(e.ClientType, "confidential")
+}
+
+func (e entry) idTokenTTL() string {
+	return defaultString(e.IDTokenTTL, "24h")
+}
+
+func (e entry) accessTokenTTL() string {
+	return defaultString(e.AccessTokenTTL, "24h")
+}
+
+// data returns the settings of the object's type written to vault
+func (e entry) data() map[string]interface{} {
+	switch e.Type {
+	case keyType:
+		return map[string]interface{}{
+			"allowed_client_ids": nonNil(e.AllowedClientIDs),
+			"algorithm":          e.algorithm(),
+			"rotation_period":    e.rotationPeriod(),
+			"verification_ttl":   e.verificationTTL(),
+		}
+	case scopeType:
+		return map[string]interface{}{
+			"template":    e.Template,
+			"description": e.Description,
+		}
+	case assignmentType:
+		return map[string]interface{}{
+			"entity_ids": nonNil(e.EntityIDs),
+			"group_ids":  nonNil(e.GroupIDs),
+		}
+	case clientType:
+		return map[string]interface{}{
+			"key":              e.signingKey(),
+			"redirect_uris":    nonNil(e.RedirectURIs),
+			"assignments":      nonNil(e.Assignments),
+			"client_type":      e.clientType(),
+			"id_token_ttl":     e.idTokenTTL(),
+			"access_token_ttl": e.accessTokenTTL(),
+		}
+	case providerType:
+		data := map[string]interface{}{
+			"allowed_client_ids": nonNil(e.AllowedClientIDs),
+			"scopes_supported":   nonNil(e.ScopesSupported),
+		}
+		if e.Issuer != "" {
+			data["issuer"] = e.Issuer
+		}
+		return data
+	}
+	return nil
+}
+
+func (e entry) path() string {
+	return filepath.Join(oidcPath, e.Type, e.Name)
+}
+
+type config struct{}
+
+var _ toplevel.Configuration = config{}
+
+const toplevelName = "vault_oidc"
+
+func init() {
+	toplevel.RegisterConfiguration(toplevelName, config{})
+}
+
+// Validate ensures each object is named, has a supported type and valid ttls.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault OIDC] failed to decode oidc configuration: %v", err))
+	}
+	errs := []error{}
+	for _, e := range entries {
+		if e.Name == "" {
+			errs = append(errs, errors.New("[Vault OIDC] oidc object without name"))
+			continue
+		}
+		ttls := map[string]string{}
+		switch e.Type {
+		case keyType:
+			ttls["rotation_period"] = e.rotationPeriod()
+			ttls["verification_ttl"] = e.verificationTTL()
+		case clientType:
+			if e.clientType() != "confidential" && e.clientType() != "public" {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault OIDC] unsupported `client_type` `%s` of client `%s`, must be confidential or public",
+					e.ClientType, e.Name)))
+			}
+			ttls["id_token_ttl"] = e.idTokenTTL()
+			ttls["access_token_ttl"] = e.accessTokenTTL()
+		case scopeType, assignmentType, providerType:
+		default:
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault OIDC] unsupported type `%s` of oidc object `%s`, must be one of %v", e.Type, e.Name, types)))
+		}
+		for name, ttl := range ttls {
+			if _, err := vault.ParseDuration(ttl); err != nil {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault OIDC] invalid `%s` of %s `%s`: %v", name, e.Type, e.Name, err)))
+			}
+		}
+	}
+	return utils.JoinErrors(errs)
+}
+
+// Apply ensures that the OIDC provider objects of an instance are configured exactly as provided.
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return nil, errors.New(fmt.Sprintf("[Vault OIDC] failed to decode oidc configuration: %v", err))
+	}
+	desired := []entry{}
+	for _, e := range entries {
+		if e.Instance.Key() == address {
+			desired = append(desired, e)
+		}
+	}
+
+	existing, err := getExistingObjects(address, threadPoolSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// objects are written in full so that updates and creations are handled alike
+	toBeWritten, toBeDeleted, _ := vault.DiffItems(vault.ExcludeUnmanaged(asItems(desired), asItems(existing)))
+	utils.RecordPendingChanges(address, toplevelName, len(toBeWritten)+len(vault.ExcludeItems(toBeDeleted, isBuiltin)))
+	if !prune {
+		toBeDeleted = vault.SkipDeletes(address, "[Vault OIDC] oidc object", toBeDeleted, isBuiltin)
+	}
+	toBeDeleted = vault.ExcludeItems(toBeDeleted, isBuiltin)
+	if err := vault.CheckDeletes(address, "[Vault OIDC]", toBeDeleted); err != nil {
+		return nil, err
+	}
+	sortByType(toBeWritten, false)
+	sortByType(toBeDeleted, true)
+
+	plan := vault.NewPlan()
+	for _, t := range types {
+		plan.Add("oidc-"+t, ofType(toBeWritten, t), nil, ofType(toBeDeleted, t), asItems(existing))
+	}
+
+	if dryRun == true {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate, len(toBeWritten))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationDelete, len(toBeDeleted))
+		for _, w := range toBeWritten {
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"path": w.(entry).path(),
+				"data": utils.RedactOptions(w.(entry).data()),
+			}).Info("[Dry Run] [Vault OIDC] oidc object to be written")
+		}
+		for _, d := range toBeDeleted {
+			vault.Logger(address, toplevelName).WithField("path", d.(entry).path()).Info(
+				"[Dry Run] [Vault OIDC] oidc object to be deleted")
+		}
+		return plan, nil
+	}
+
+	for _, w := range toBeWritten {
+		ent := w.(entry)
+		err := vault.WriteRaw(address, ent.path(), ent.data())
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationCreate)
+		fields := log.Fields{"path": ent.path()}
+		if ent.Type == clientType {
+			// the generated client id is logged for registering the client with its application,
+			// the client secret must be read from vault by those permitted to
+			data, err := vault.ReadRaw(address, ent.path())
+			if err == nil && data != nil {
+				fields["client_id"] = data["client_id"]
+			}
+		}
+		vault.Logger(address, toplevelName).WithFields(fields).Info(
+			"[Vault OIDC] oidc object is successfully written")
+	}
+	for _, d := range toBeDeleted {
+		ent := d.(entry)
+		err := vault.DeleteRaw(address, ent.path())
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationDelete)
+		vault.Logger(address, toplevelName).WithField("path", ent.path()).Info(
+			"[Vault OIDC] oidc object is successfully deleted")
+	}
+
+	return plan, nil
+}
+
+// getExistingObjects reads all objects of each supported type
+func getExistingObjects(address string, threadPoolSize int) ([]entry, error) {
+	existing := []entry{}
+	for _, objType := range types {
+		names, err := listNames(address, filepath.Join(oidcPath, objType))
+		if err != nil {
+			return nil, err
+		}
+
+		var mutex = &sync.Mutex{}
+		var readErr error
+		bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+
+		for _, name := range names {
+			bwg.Add(1)
+
+			go func(objType, name string) {
+				defer bwg.Done()
+
+				e := entry{Name: name, Type: objType, Instance: vault.Instance{Address: address}}
+				data, err := vault.ReadRaw(address, e.path())
+
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if err != nil {
+					readErr = err
+					return
+				}
+				if data == nil {
+					return
+				}
+				existing = append(existing, fromData(e, data))
+			}(objType, name)
+		}
+		bwg.Wait()
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	return existing, nil
+}
+
+// fromData populates the settings of an object read from vault
+// client secrets are read along with clients but are never retained
+func fromData(e entry, data map[string]interface{}) entry {
+	switch e.Type {
+	case keyType:
+		e.AllowedClientIDs = toStrings(data["allowed_client_ids"])
+		e.Algorithm = stringOrEmpty(data["algorithm"])
+		e.RotationPeriod = stringOrEmpty(data["rotation_period"])
+		e.VerificationTTL = stringOrEmpty(data["verification_ttl"])
+	case scopeType:
+		e.Template = stringOrEmpty(data["template"])
+		e.Description = stringOrEmpty(data["description"])
+	case assignmentType:
+		e.EntityIDs = toStrings(data["entity_ids"])
+		e.GroupIDs = toStrings(data["group_ids"])
+	case clientType:
+		e.SigningKey = stringOrEmpty(data["key"])
+		e.RedirectURIs = toStrings(data["redirect_uris"])
+		e.Assignments = toStrings(data["assignments"])
+		e.ClientType = stringOrEmpty(data["client_type"])
+		e.IDTokenTTL = stringOrEmpty(data["id_token_ttl"])
+		e.AccessTokenTTL = stringOrEmpty(data["access_token_ttl"])
+	case providerType:
+		e.Issuer = stringOrEmpty(data["issuer"])
+		e.AllowedClientIDs = toStrings(data["allowed_client_ids"])
+		e.ScopesSupported = toStrings(data["scopes_supported"])
+	}
+	return e
+}
+
+// sortByType orders objects by the order their types are written in, or the reverse
+func sortByType(items []vault.Item, reverse bool) {
+	rank := make(map[string]int, len(types))
+	for i, t := range types {
+		rank[t] = i
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if reverse {
+			return rank[items[i].KeyForType()] > rank[items[j].KeyForType()]
+		}
+		return rank[items[i].KeyForType()] < rank[items[j].KeyForType()]
+	})
+}
+
+func ofType(items []vault.Item, objType string) []vault.Item {
+	result := make([]vault.Item, 0)
+	for _, i := range items {
+		if i.KeyForType() == objType {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// isBuiltin determines if an item is an object created by vault that is never deleted
+func isBuiltin(i vault.Item) bool {
+	return builtin[i.Key()]
+}
+
+// listNames returns the keys listed at path or an empty list if nothing exists
+func listNames(address, path string) ([]string, error) {
+	secret, err := vault.ListSecrets(address, path)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return []string{}, nil
+	}
+	return toStrings(secret.Data["keys"]), nil
+}
+
+// durationsEqual compares durations regardless of their unit, vault reports ttls in seconds
+func durationsEqual(x, y string) bool {
+	if x == y {
+		return true
+	}
+	xdur, xerr := vault.ParseDuration(x)
+	ydur, yerr := vault.ParseDuration(y)
+	return xerr == nil && yerr == nil && xdur == ydur
+}
+
+func defaultString(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func stringOrEmpty(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// nonNil ensures empty lists are written as such rather than as null
+func nonNil(xs []string) []string {
+	if xs == nil {
+		return []string{}
+	}
+	return xs
+}
+
+func toStrings(raw interface{}) []string {
+	values, ok := raw.([]interface{})
+	if !ok {
+		return []string{}
+	}
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, fmt.Sprintf("%v", v))
+	}
+	return strs
+}
+
+// equalStrings compares lists of strings regardless of order
+func equalStrings(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	xs := append([]string{}, x...)
+	ys := append([]string{}, y...)
+	sort.Strings(xs)
+	sort.Strings(ys)
+	for i := range xs {
+		if xs[i] != ys[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func asItems(xs []entry) (items []vault.Item) {
+	items = make([]vault.Item, 0)
+	for _, x := range xs {
+		items = append(items, x)
+	}
+
+	return
+}
@@ -0,0 +1,117 @@
+package oidc
+
+import (
+	"testing"
+
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryEquals(t *testing.T) {
+	table := []struct {
+		description string
+		desired     entry
+		existing    entry
+		expected    bool
+	}{
+		{
+			description: "key with defaults and ttls in seconds",
+			desired:     entry{Name: "app", Type: keyType, AllowedClientIDs: []string{"b", "a"}},
+			existing: entry{Name: "app", Type: keyType, AllowedClientIDs: []string{"a", "b"},
+				Algorithm: "RS256", RotationPeriod: "86400", VerificationTTL: "86400"},
+			expected: true,
+		},
+		{
+			description: "key with different algorithm",
+			desired:     entry{Name: "app", Type: keyType, Algorithm: "ES256"},
+			existing: entry{Name: "app", Type: keyType, AllowedClientIDs: []string{},
+				Algorithm: "RS256", RotationPeriod: "86400", VerificationTTL: "86400"},
+			expected: false,
+		},
+		{
+			description: "client with redirect uris in different order",
+			desired: entry{Name: "app", Type: clientType, RedirectURIs: []string{"https://b", "https://a"},
+				Assignments: []string{"allow_all"}, IDTokenTTL: "1h"},
+			existing: entry{Name: "app", Type: clientType, SigningKey: "default",
+				RedirectURIs: []string{"https://a", "https://b"}, Assignments: []string{"allow_all"},
+				ClientType: "confidential", IDTokenTTL: "3600", AccessTokenTTL: "86400"},
+			expected: true,
+		},
+		{
+			description: "client with different redirect uris",
+			desired:     entry{Name: "app", Type: clientType, RedirectURIs: []string{"https://a"}},
+			existing: entry{Name: "app", Type: clientType, SigningKey: "default",
+				RedirectURIs: []string{"https://a", "https://b"}, Assignments: []string{},
+				ClientType: "confidential", IDTokenTTL: "86400", AccessTokenTTL: "86400"},
+			expected: false,
+		},
+		{
+			description: "provider with unmanaged issuer and scopes in different order",
+			desired:     entry{Name: "app", Type: providerType, ScopesSupported: []string{"groups", "user"}},
+			existing: entry{Name: "app", Type: providerType, Issuer: "https://vault:8200",
+				AllowedClientIDs: []string{}, ScopesSupported: []string{"user", "groups"}},
+			expected: true,
+		},
+		{
+			description: "provider with different issuer",
+			desired:     entry{Name: "app", Type: providerType, Issuer: "https://sso"},
+			existing: entry{Name: "app", Type: providerType, Issuer: "https://vault:8200",
+				AllowedClientIDs: []string{}, ScopesSupported: []string{}},
+			expected: false,
+		},
+		{
+			description: "scope with different template",
+			desired:     entry{Name: "user", Type: scopeType, Template: `{"username": {{identity.entity.name}}}`},
+			existing:    entry{Name: "user", Type: scopeType, Template: `{"name": {{identity.entity.name}}}`},
+			expected:    false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.desired.Equals(tt.existing))
+		})
+	}
+}
+
+func TestSortByType(t *testing.T) {
+	items := []vault.Item{
+		entry{Name: "app", Type: providerType},
+		entry{Name: "app", Type: clientType},
+		entry{Name: "app", Type: keyType},
+		entry{Name: "user", Type: scopeType},
+	}
+
+	sortByType(items, false)
+	require.Equal(t, []string{"key/app", "scope/user", "client/app", "provider/app"}, keys(items))
+
+	sortByType(items, true)
+	require.Equal(t, []string{"provider/app", "client/app", "scope/user", "key/app"}, keys(items))
+}
+
+func TestIsBuiltin(t *testing.T) {
+	require.True(t, isBuiltin(entry{Name: "default", Type: keyType}))
+	require.True(t, isBuiltin(entry{Name: "allow_all", Type: assignmentType}))
+	require.False(t, isBuiltin(entry{Name: "default", Type: clientType}))
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, config{}.Validate([]byte(
+		"- name: app\n  type: client\n  client_type: public\n  id_token_ttl: 1h\n")))
+	require.Error(t, config{}.Validate([]byte(
+		"- name: app\n  type: client\n  client_type: private\n")))
+	require.Error(t, config{}.Validate([]byte(
+		"- name: app\n  type: key\n  rotation_period: 1 day\n")))
+	require.Error(t, config{}.Validate([]byte(
+		"- name: app\n  type: role\n")))
+	require.Error(t, config{}.Validate([]byte(
+		"- type: scope\n")))
+}
+
+func keys(items []vault.Item) []string {
+	result := []string{}
+	for _, i := range items {
+		result = append(result, i.Key())
+	}
+	return result
+}
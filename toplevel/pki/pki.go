@@ -0,0 +1,470 @@
+// Package pki implements the application of a declarative configuration
+// for roles and URL configuration of Vault PKI secrets engines.
+//
+// The CA certificate material of a mount is never managed, so that reconciliation
+// cannot accidentally rotate a root or intermediate CA.
+package pki
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/app-sre/vault-manager/pkg/utils"
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/app-sre/vault-manager/toplevel"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+type entry struct {
+	Mount    string         `yaml:"mount"`
+	Instance vault.Instance `yaml:"instance"`
+	// URLs are left unmanaged when unset
+	URLs  *urls  `yaml:"urls"`
+	Roles []role `yaml:"roles"`
+}
+
+type urls struct {
+	IssuingCertificates   []string `yaml:"issuing_certificates"`
+	CRLDistributionPoints []string `yaml:"crl_distribution_points"`
+	OCSPServers           []string `yaml:"ocsp_servers"`
+	Mount                 string   `yaml:"-"`
+}
+
+var _ vault.Item = urls{}
+
+func (u urls) Key() string {
+	return filepath.Join(u.Mount, "config", "urls")
+}
+
+func (u urls) KeyForType() string {
+	return ""
+}
+
+func (u urls) KeyForDescription() string {
+	return ""
+}
+
+// Equals compares the lists of urls regardless of order
+func (u urls) Equals(i interface{}) bool {
+	ul, ok := i.(urls)
+	if !ok {
+		return false
+	}
+
+	return u.Key() == ul.Key() &&
+		equalStrings(u.IssuingCertificates, ul.IssuingCertificates) &&
+		equalStrings(u.CRLDistributionPoints, ul.CRLDistributionPoints) &&
+		equalStrings(u.OCSPServers, ul.OCSPServers)
+}
+
+func (u urls) data() map[string]interface{} {
+	return map[string]interface{}{
+		"issuing_certificates":    nonNil(u.IssuingCertificates),
+		"crl_distribution_points": nonNil(u.CRLDistributionPoints),
+		"ocsp_servers":            nonNil(u.OCSPServers),
+	}
+}
+
+type role struct {
+	Name            string   `yaml:"name"`
+	AllowedDomains  []string `yaml:"allowed_domains"`
+	AllowSubdomains bool     `yaml:"allow_subdomains"`
+	MaxTTL          string   `yaml:"max_ttl"`
+	// KeyType defaults to rsa and KeyBits to the default size of the key type, matching vault
+	KeyType      string `yaml:"key_type"`
+	KeyBits      int    `yaml:"key_bits"`
+	Mount        string `yaml:"-"`
+	vault.Toggle `yaml:",inline"`
+}
+
+var _ vault.Item = role{}
+
+func (r role) Key() string {
+	return filepath.Join(r.Mount, "roles", r.Name)
+}
+
+func (r role) KeyForType() string {
+	return ""
+}
+
+func (r role) KeyForDescription() string {
+	return ""
+}
+
+// Equals compares allowed domains regardless of order and the max ttl regardless of its unit
+func (r role) Equals(i interface{}) bool {
+	rl, ok := i.(role)
+	if !ok {
+		return false
+	}
+
+	return r.Key() == rl.Key() &&
+		equalStrings(r.AllowedDomains, rl.AllowedDomains) &&
+		r.AllowSubdomains == rl.AllowSubdomains &&
+		r.keyType() == rl.keyType() &&
+		r.keyBits() == rl.keyBits() &&
+		vault.OptionsEqual(
+			map[string]interface{}{"max_ttl": ttlOrZero(r.MaxTTL)},
+			map[string]interface{}{"max_ttl": ttlOrZero(rl.MaxTTL)})
+}
+
+func (r role) keyType() string {
+	if r.KeyType == "" {
+		return "rsa"
+	}
+	return r.KeyType
+}
+
+// keyBits returns the key size vault uses when none is given for the key type
+func (r role) keyBits() int {
+	if r.KeyBits != 0 {
+		return r.KeyBits
+	}
+	return defaultKeyBits[r.keyType()]
+}
+
+func (r role) data() map[string]interface{} {
+	return map[string]interface{}{
+		"allowed_domains":  nonNil(r.AllowedDomains),
+		"allow_subdomains": r.AllowSubdomains,
+		"max_ttl":          ttlOrZero(r.MaxTTL),
+		"key_type":         r.keyType(),
+		"key_bits":         r.keyBits(),
+	}
+}
+
+// defaultKeyBits are the key sizes vault assigns to roles that do not specify one
+var defaultKeyBits = map[string]int{
+	"rsa":     2048,
+	"ec":      256,
+	"ed25519": 0,
+	"any":     0,
+}
+
+type config struct{}
+
+var _ toplevel.Configuration = config{}
+
+const toplevelName = "vault_pki"
+
+func init() {
+	toplevel.RegisterConfiguration(toplevelName, config{}, "vault_secret_engines")
+}
+
+// Validate ensures each role is named and has a supported key type and a valid max ttl.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault PKI] failed to decode pki configuration: %v", err))
+	}
+	errs := []error{}
+	for _, e := range entries {
+		for _, r := range e.Roles {
+			if r.Name == "" {
+				errs = append(errs, errors.New(fmt.Sprintf("[Vault PKI] role without name in mount `%s`", e.Mount)))
+				continue
+			}
+			if _, ok := defaultKeyBits[r.keyType()]; !ok {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault PKI] unsupported `key_type` `%s` of role `%s` in mount `%s`", r.KeyType, r.Name, e.Mount)))
+			}
+			if _, err := vault.ParseDuration(ttlOrZero(r.MaxTTL)); err != nil {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault PKI] invalid `max_ttl` of role `%s` in mount `%s`: %v", r.Name, e.Mount, err)))
+			}
+		}
+	}
+	return utils.JoinErrors(errs)
+}
+
+// Apply ensures that the roles and URL configuration of an instance's PKI secrets engines
+// are configured exactly as provided.
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return nil, errors.New(fmt.Sprintf("[Vault PKI] failed to decode pki configuration: %v", err))
+	}
+
+	mounts := []string{}
+	desiredRoles := []role{}
+	desiredURLs := []urls{}
+	for _, e := range entries {
+		if e.Instance.Key() != address {
+			continue
+		}
+		mount := strings.Trim(e.Mount, "/")
+		mounts = append(mounts, mount)
+		for _, r := range e.Roles {
+			r.Mount = mount
+			desiredRoles = append(desiredRoles, r)
+		}
+		if e.URLs != nil {
+			u := *e.URLs
+			u.Mount = mount
+			desiredURLs = append(desiredURLs, u)
+		}
+	}
+
+	enabledMounts, err := getEnabledMounts(address, mounts)
+	if err != nil {
+		return nil, err
+	}
+	existingRoles, err := getExistingRoles(address, enabledMounts, threadPoolSize)
+	if err != nil {
+		return nil, err
+	}
+	existingURLs, err := getExistingURLs(address, enabledMounts, desiredURLs)
+	if err != nil {
+		return nil, err
+	}
+
+	// roles are written in full so that updates and creations are handled alike
+	rolesToBeWritten, rolesToBeDeleted, _ := vault.DiffItems(
+		vault.ExcludeUnmanaged(asItems(desiredRoles), asItems(existingRoles)))
+	// url configuration always exists on a mount and is never deleted
+	urlsToBeWritten, _, _ := vault.DiffItems(urlsAsItems(desiredURLs), urlsAsItems(existingURLs))
+	utils.RecordPendingChanges(address, toplevelName,
+		len(rolesToBeWritten)+len(rolesToBeDeleted)+len(urlsToBeWritten))
+	if !prune {
+		rolesToBeDeleted = vault.SkipDeletes(address, "[Vault PKI] role", rolesToBeDeleted, nil)
+	}
+	if err := vault.CheckDeletes(address, "[Vault PKI]", rolesToBeDeleted); err != nil {
+		return nil, err
+	}
+
+	plan := vault.NewPlan()
+	plan.Add("pki-role", rolesToBeWritten, nil, rolesToBeDeleted, asItems(existingRoles))
+	plan.Add("pki-urls", urlsToBeWritten, nil, nil, urlsAsItems(existingURLs))
+
+	if dryRun == true {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate, len(rolesToBeWritten))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationUpdate, len(urlsToBeWritten))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationDelete, len(rolesToBeDeleted))
+		for _, w := range rolesToBeWritten {
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"path":            w.Key(),
+				"allowed_domains": w.(role).AllowedDomains,
+			}).Info("[Dry Run] [Vault PKI] role to be written")
+		}
+		for _, u := range urlsToBeWritten {
+			vault.Logger(address, toplevelName).WithField("path", u.Key()).Info(
+				"[Dry Run] [Vault PKI] url configuration to be written")
+		}
+		for _, d := range rolesToBeDeleted {
+			vault.Logger(address, toplevelName).WithField("path", d.Key()).Info(
+				"[Dry Run] [Vault PKI] role to be deleted")
+		}
+		return plan, nil
+	}
+
+	for _, w := range rolesToBeWritten {
+		err := vault.WriteRaw(address, w.Key(), w.(role).data())
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationCreate)
+		vault.Logger(address, toplevelName).WithField("path", w.Key()).Info(
+			"[Vault PKI] role is successfully written")
+	}
+	for _, u := range urlsToBeWritten {
+		err := vault.WriteRaw(address, u.Key(), u.(urls).data())
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationUpdate)
+		vault.Logger(address, toplevelName).WithField("path", u.Key()).Info(
+			"[Vault PKI] url configuration is successfully written")
+	}
+	for _, d := range rolesToBeDeleted {
+		err := vault.DeleteRaw(address, d.Key())
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationDelete)
+		vault.Logger(address, toplevelName).WithField("path", d.Key()).Info(
+			"[Vault PKI] role is successfully deleted")
+	}
+
+	return plan, nil
+}
+
+// getEnabledMounts returns the mounts that are enabled as pki secrets engines
+// mounts that are not enabled yet, e.g. during a dry run, have no existing configuration
+func getEnabledMounts(address string, mounts []string) ([]string, error) {
+	engines, err := vault.ListSecretsEngines(address)
+	if err != nil {
+		return nil, err
+	}
+	pki := make(map[string]bool)
+	for path, engine := range engines {
+		if engine.Type == "pki" {
+			pki[vault.NormalizePath(path)] = true
+		}
+	}
+	enabled := []string{}
+	for _, mount := range mounts {
+		if pki[mount] {
+			enabled = append(enabled, mount)
+		}
+	}
+	return enabled, nil
+}
+
+// getExistingRoles reads the roles of each mount
+func getExistingRoles(address string, mounts []string, threadPoolSize int) ([]role, error) {
+	existing := []role{}
+	for _, mount := range mounts {
+		names, err := listNames(address, filepath.Join(mount, "roles"))
+		if err != nil {
+			return nil, err
+		}
+
+		var mutex = &sync.Mutex{}
+		var readErr error
+		bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+
+		for _, name := range names {
+			bwg.Add(1)
+
+			go func(name string) {
+				defer bwg.Done()
+
+				r := role{Name: name, Mount: mount}
+				data, err := vault.ReadRaw(address, r.Key())
+
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if err != nil {
+					readErr = err
+					return
+				}
+				if data == nil {
+					return
+				}
+				existing = append(existing, roleFromData(r, data))
+			}(name)
+		}
+		bwg.Wait()
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	return existing, nil
+}
+
+// roleFromData sets the settings of a role read from vault
+func roleFromData(r role, data map[string]interface{}) role {
+	r.AllowedDomains = toStrings(data["allowed_domains"])
+	r.AllowSubdomains, _ = strconv.ParseBool(fmt.Sprintf("%v", data["allow_subdomains"]))
+	r.MaxTTL = fmt.Sprintf("%v", data["max_ttl"])
+	r.KeyType = fmt.Sprintf("%v", data["key_type"])
+	r.KeyBits, _ = strconv.Atoi(fmt.Sprintf("%v", data["key_bits"]))
+	return r
+}
+
+// getExistingURLs reads the url configuration of the enabled mounts whose urls are desired
+func getExistingURLs(address string, mounts []string, desired []urls) ([]urls, error) {
+	enabled := make(map[string]bool)
+	for _, mount := range mounts {
+		enabled[mount] = true
+	}
+	existing := []urls{}
+	for _, d := range desired {
+		if !enabled[d.Mount] {
+			continue
+		}
+		u := urls{Mount: d.Mount}
+		data, err := vault.ReadRaw(address, u.Key())
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+		u.IssuingCertificates = toStrings(data["issuing_certificates"])
+		u.CRLDistributionPoints = toStrings(data["crl_distribution_points"])
+		u.OCSPServers = toStrings(data["ocsp_servers"])
+		existing = append(existing, u)
+	}
+	return existing, nil
+}
+
+// listNames returns the keys listed at path or an empty list if nothing exists
+func listNames(address, path string) ([]string, error) {
+	secret, err := vault.ListSecrets(address, path)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return []string{}, nil
+	}
+	return toStrings(secret.Data["keys"]), nil
+}
+
+func ttlOrZero(ttl string) string {
+	if ttl == "" {
+		return "0"
+	}
+	return ttl
+}
+
+func nonNil(xs []string) []string {
+	if xs == nil {
+		return []string{}
+	}
+	return xs
+}
+
+func toStrings(raw interface{}) []string {
+	values, ok := raw.([]interface{})
+	if !ok {
+		return []string{}
+	}
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, fmt.Sprintf("%v", v))
+	}
+	return strs
+}
+
+// equalStrings compares lists regardless of order, treating nil and empty lists alike
+func equalStrings(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	xs := append([]string{}, x...)
+	ys := append([]string{}, y...)
+	sort.Strings(xs)
+	sort.Strings(ys)
+	for i := range xs {
+		if xs[i] != ys[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func asItems(xs []role) (items []vault.Item) {
+	items = make([]vault.Item, 0)
+	for _, x := range xs {
+		items = append(items, x)
+	}
+
+	return
+}
+
+func urlsAsItems(xs []urls) (items []vault.Item) {
+	items = make([]vault.Item, 0)
+	for _, x := range xs {
+		items = append(items, x)
+	}
+
+	return
+}
@@ -0,0 +1,99 @@
+package pki
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoleEquals(t *testing.T) {
+	existing := roleFromData(role{Name: "web", Mount: "pki"}, map[string]interface{}{
+		"allowed_domains":  []interface{}{"example.com", "example.org"},
+		"allow_subdomains": true,
+		"max_ttl":          json.Number("259200"),
+		"key_type":         "rsa",
+		"key_bits":         json.Number("2048"),
+	})
+
+	table := []struct {
+		description string
+		desired     role
+		expected    bool
+	}{
+		{
+			description: "reordered domains, ttl unit and default key",
+			desired: role{Name: "web", Mount: "pki", AllowedDomains: []string{"example.org", "example.com"},
+				AllowSubdomains: true, MaxTTL: "72h"},
+			expected: true,
+		},
+		{
+			description: "different domains",
+			desired: role{Name: "web", Mount: "pki", AllowedDomains: []string{"example.com"},
+				AllowSubdomains: true, MaxTTL: "72h"},
+			expected: false,
+		},
+		{
+			description: "different max ttl",
+			desired: role{Name: "web", Mount: "pki", AllowedDomains: []string{"example.org", "example.com"},
+				AllowSubdomains: true, MaxTTL: "24h"},
+			expected: false,
+		},
+		{
+			description: "different key type",
+			desired: role{Name: "web", Mount: "pki", AllowedDomains: []string{"example.org", "example.com"},
+				AllowSubdomains: true, MaxTTL: "72h", KeyType: "ec"},
+			expected: false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.desired.Equals(existing))
+		})
+	}
+}
+
+func TestURLsEquals(t *testing.T) {
+	existing := urls{Mount: "pki", IssuingCertificates: []string{"https://vault.test/v1/pki/ca"},
+		CRLDistributionPoints: []string{}, OCSPServers: []string{}}
+
+	require.True(t, urls{Mount: "pki", IssuingCertificates: []string{"https://vault.test/v1/pki/ca"}}.Equals(existing),
+		"unset lists equal empty lists")
+	require.False(t, urls{Mount: "pki"}.Equals(existing))
+}
+
+func TestValidate(t *testing.T) {
+	table := []struct {
+		description string
+		config      string
+		expectErr   bool
+	}{
+		{
+			description: "valid role",
+			config:      "- mount: pki\n  roles:\n  - name: web\n    allowed_domains: [example.com]\n    key_type: ec\n    max_ttl: 72h\n",
+			expectErr:   false,
+		},
+		{
+			description: "unsupported key type",
+			config:      "- mount: pki\n  roles:\n  - name: web\n    key_type: dsa\n",
+			expectErr:   true,
+		},
+		{
+			description: "invalid max ttl",
+			config:      "- mount: pki\n  roles:\n  - name: web\n    max_ttl: forever\n",
+			expectErr:   true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			err := config{}.Validate([]byte(tt.config))
+			if tt.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
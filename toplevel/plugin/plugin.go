@@ -0,0 +1,368 @@
+// Package plugin implements the application of a declarative configuration
+// for the registration of external plugins within the Vault plugin catalog.
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/app-sre/vault-manager/pkg/utils"
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/app-sre/vault-manager/toplevel"
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// supported plugin types
+var pluginTypes = []string{"auth", "database", "secret"}
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+type entry struct {
+	Name     string         `yaml:"name"`
+	Type     string         `yaml:"type"`
+	Instance vault.Instance `yaml:"instance"`
+	// Command is the name of the plugin binary within the plugin directory of the instance
+	Command      string   `yaml:"command"`
+	SHA256       string   `yaml:"sha256"`
+	Version      string   `yaml:"version"`
+	Args         []string `yaml:"args"`
+	vault.Toggle `yaml:",inline"`
+}
+
+var _ vault.Item = entry{}
+
+// Key identifies a registration, each version of a plugin is registered separately
+func (e entry) Key() string {
+	if e.Version == "" {
+		return filepath.Join(e.Type, e.Name)
+	}
+	return filepath.Join(e.Type, e.Name, e.Version)
+}
+
+func (e entry) KeyForType() string {
+	return e.Type
+}
+
+func (e entry) KeyForDescription() string {
+	return ""
+}
+
+func (e entry) Equals(i interface{}) bool {
+	entry, ok := i.(entry)
+	if !ok {
+		return false
+	}
+
+	return e.Key() == entry.Key() &&
+		e.Command == entry.Command &&
+		strings.EqualFold(e.SHA256, entry.SHA256) &&
+		equalArgs(e.Args, entry.Args)
+}
+
+type config struct{}
+
+var _ toplevel.Configuration = config{}
+
+const toplevelName = "vault_plugins"
+
+func init() {
+	toplevel.RegisterConfiguration(toplevelName, config{})
+}
+
+// Validate ensures each plugin has a supported type, a command, a valid sha256 and a semantic version if any.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault Plugin] failed to decode plugin configuration: %v", err))
+	}
+	errs := []error{}
+	for _, e := range entries {
+		if e.Name == "" {
+			errs = append(errs, errors.New("[Vault Plugin] plugin without name"))
+			continue
+		}
+		if !contains(pluginTypes, e.Type) {
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault Plugin] unsupported type `%s` of plugin `%s`, must be one of %v", e.Type, e.Name, pluginTypes)))
+		}
+		if e.Command == "" {
+			errs = append(errs, errors.New(fmt.Sprintf("[Vault Plugin] plugin `%s` without command", e.Name)))
+		}
+		if !sha256Pattern.MatchString(e.SHA256) {
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault Plugin] `sha256` of plugin `%s` must be 64 hexadecimal characters", e.Name)))
+		}
+		if e.Version != "" {
+			if _, err := version.NewSemver(e.Version); err != nil {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Plugin] invalid `version` of plugin `%s`: %v", e.Name, err)))
+			}
+		}
+	}
+	return utils.JoinErrors(errs)
+}
+
+// Apply ensures that the external plugins registered within the catalog of an instance are exactly as provided.
+// Builtin plugins are never deregistered, neither are plugins that a mount or database connection still uses.
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return nil, errors.New(fmt.Sprintf("[Vault Plugin] failed to decode plugin configuration: %v", err))
+	}
+	desired := []entry{}
+	for _, e := range entries {
+		if e.Instance.Key() == address {
+			desired = append(desired, e)
+		}
+	}
+
+	existing, err := getExistingPlugins(address, threadPoolSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// registrations are written in full so that updates and creations are handled alike
+	toBeWritten, toBeDeleted, _ := vault.DiffItems(vault.ExcludeUnmanaged(asItems(desired), asItems(existing)))
+	utils.RecordPendingChanges(address, toplevelName, len(toBeWritten)+len(toBeDeleted))
+	if !prune {
+		toBeDeleted = vault.SkipDeletes(address, "[Vault Plugin] plugin", toBeDeleted, nil)
+	}
+	if len(toBeDeleted) > 0 {
+		used, err := getUsedPlugins(address)
+		if err != nil {
+			return nil, err
+		}
+		toBeDeleted = vault.ExcludeItems(toBeDeleted, func(i vault.Item) bool {
+			if !inUse(i.(entry), used) {
+				return false
+			}
+			vault.Logger(address, toplevelName).WithField("plugin", i.Key()).Warn(
+				"[Vault Plugin] plugin is not deregistered as it is in use, remove the mounts using it first")
+			return true
+		})
+	}
+	if err := vault.CheckDeletes(address, "[Vault Plugin]", toBeDeleted); err != nil {
+		return nil, err
+	}
+
+	plan := vault.NewPlan()
+	plan.Add("plugin", toBeWritten, nil, toBeDeleted, asItems(existing))
+
+	if dryRun == true {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate, len(toBeWritten))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationDelete, len(toBeDeleted))
+		for _, w := range toBeWritten {
+			p := w.(entry)
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"plugin":  p.Key(),
+				"command": p.Command,
+				"sha256":  p.SHA256,
+			}).Info("[Dry Run] [Vault Plugin] plugin to be registered")
+		}
+		for _, d := range toBeDeleted {
+			vault.Logger(address, toplevelName).WithField("plugin", d.Key()).Info(
+				"[Dry Run] [Vault Plugin] plugin to be deregistered")
+		}
+		return plan, nil
+	}
+
+	for _, w := range toBeWritten {
+		p := w.(entry)
+		err := vault.RegisterPlugin(address, p.Type, &api.RegisterPluginInput{
+			Name:    p.Name,
+			Args:    p.Args,
+			Command: p.Command,
+			SHA256:  p.SHA256,
+			Version: p.Version,
+		})
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationCreate)
+		vault.Logger(address, toplevelName).WithFields(log.Fields{
+			"plugin":  p.Key(),
+			"command": p.Command,
+			"sha256":  p.SHA256,
+		}).Info("[Vault Plugin] plugin is successfully registered")
+	}
+	for _, d := range toBeDeleted {
+		p := d.(entry)
+		if err := vault.DeregisterPlugin(address, p.Type, p.Name, p.Version); err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationDelete)
+		vault.Logger(address, toplevelName).WithField("plugin", p.Key()).Info(
+			"[Vault Plugin] plugin is successfully deregistered")
+	}
+
+	return plan, nil
+}
+
+// getExistingPlugins reads the registrations of all external plugins within the catalog
+func getExistingPlugins(address string, threadPoolSize int) ([]entry, error) {
+	details, err := vault.ListPlugins(address)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := []entry{}
+	var mutex = &sync.Mutex{}
+	var readErr error
+	bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+
+	for _, d := range details {
+		if d.Builtin {
+			continue
+		}
+		bwg.Add(1)
+
+		go func(d api.PluginDetails) {
+			defer bwg.Done()
+
+			plugin, err := vault.GetPlugin(address, d.Type, d.Name, d.Version)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err != nil {
+				readErr = err
+				return
+			}
+			if plugin == nil {
+				return
+			}
+			existing = append(existing, entry{
+				Name:     d.Name,
+				Type:     d.Type,
+				Instance: vault.Instance{Address: address},
+				Command:  plugin.Command,
+				SHA256:   plugin.SHA256,
+				Version:  d.Version,
+				Args:     plugin.Args,
+			})
+		}(d)
+	}
+	bwg.Wait()
+	if readErr != nil {
+		return nil, readErr
+	}
+	return existing, nil
+}
+
+// usage is a plugin used by a mount or database connection, the version is empty when not pinned
+type usage struct {
+	pluginType string
+	name       string
+	version    string
+}
+
+// getUsedPlugins returns the plugins used by the secrets engines, auth backends and database connections of an instance
+func getUsedPlugins(address string) ([]usage, error) {
+	used := []usage{}
+	engines, err := vault.ListSecretsEngines(address)
+	if err != nil {
+		return nil, err
+	}
+	for path, engine := range engines {
+		used = append(used, usage{"secret", engine.Type, engine.PluginVersion})
+		if engine.Type != "database" {
+			continue
+		}
+		connections, err := vault.ListSecrets(address, filepath.Join(path, "config"))
+		if err != nil {
+			return nil, err
+		}
+		if connections == nil {
+			continue
+		}
+		for _, name := range toStrings(connections.Data["keys"]) {
+			conn, err := vault.ReadRaw(address, filepath.Join(path, "config", name))
+			if err != nil {
+				return nil, err
+			}
+			if conn != nil && conn["plugin_name"] != nil {
+				used = append(used, usage{"database", fmt.Sprintf("%v", conn["plugin_name"]),
+					stringOrEmpty(conn["plugin_version"])})
+			}
+		}
+	}
+	backends, err := vault.ListAuthBackends(address)
+	if err != nil {
+		return nil, err
+	}
+	for _, backend := range backends {
+		used = append(used, usage{"auth", backend.Type, backend.PluginVersion})
+	}
+	return used, nil
+}
+
+// inUse determines if a plugin may be used by any mount or connection
+// versions only rule out a use when both the registration and the use are pinned to different versions
+func inUse(p entry, used []usage) bool {
+	for _, u := range used {
+		if u.pluginType != p.Type || u.name != p.Name {
+			continue
+		}
+		if p.Version == "" || u.version == "" || p.Version == u.version {
+			return true
+		}
+	}
+	return false
+}
+
+// equalArgs compares arguments in order as they are passed to the plugin in order
+func equalArgs(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(xs []string, x string) bool {
+	for _, v := range xs {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
+
+func stringOrEmpty(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func toStrings(raw interface{}) []string {
+	values, ok := raw.([]interface{})
+	if !ok {
+		return []string{}
+	}
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, fmt.Sprintf("%v", v))
+	}
+	return strs
+}
+
+func asItems(xs []entry) (items []vault.Item) {
+	items = make([]vault.Item, 0)
+	for _, x := range xs {
+		items = append(items, x)
+	}
+
+	return
+}
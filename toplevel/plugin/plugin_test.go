@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const sum = "f1e2d3c4b5a60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90"
+
+func TestPluginEquals(t *testing.T) {
+	existing := entry{Name: "venafi", Type: "secret", Command: "venafi", SHA256: sum, Version: "v1.0.0",
+		Args: []string{"-tls-skip-verify"}}
+
+	table := []struct {
+		description string
+		desired     entry
+		expected    bool
+	}{
+		{
+			description: "sha256 is compared regardless of case",
+			desired: entry{Name: "venafi", Type: "secret", Command: "venafi", SHA256: strings.ToUpper(sum),
+				Version: "v1.0.0", Args: []string{"-tls-skip-verify"}},
+			expected: true,
+		},
+		{
+			description: "changed sha256 is not equal",
+			desired: entry{Name: "venafi", Type: "secret", Command: "venafi", SHA256: strings.Repeat("0", 64),
+				Version: "v1.0.0", Args: []string{"-tls-skip-verify"}},
+			expected: false,
+		},
+		{
+			description: "changed args are not equal",
+			desired:     entry{Name: "venafi", Type: "secret", Command: "venafi", SHA256: sum, Version: "v1.0.0"},
+			expected:    false,
+		},
+		{
+			description: "other version is another registration",
+			desired: entry{Name: "venafi", Type: "secret", Command: "venafi", SHA256: sum, Version: "v1.1.0",
+				Args: []string{"-tls-skip-verify"}},
+			expected: false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.desired.Equals(existing))
+		})
+	}
+	require.Equal(t, "secret/venafi/v1.0.0", existing.Key())
+	require.Equal(t, "secret/venafi", entry{Name: "venafi", Type: "secret"}.Key())
+}
+
+func TestInUse(t *testing.T) {
+	used := []usage{{"secret", "venafi", "v1.0.0"}, {"auth", "keycloak", ""}, {"database", "snowflake", ""}}
+
+	table := []struct {
+		description string
+		plugin      entry
+		expected    bool
+	}{
+		{"pinned version in use", entry{Name: "venafi", Type: "secret", Version: "v1.0.0"}, true},
+		{"other pinned version unused", entry{Name: "venafi", Type: "secret", Version: "v0.9.0"}, false},
+		{"unpinned registration of pinned use", entry{Name: "venafi", Type: "secret"}, true},
+		{"any version of unpinned use", entry{Name: "keycloak", Type: "auth", Version: "v2.0.0"}, true},
+		{"database connection", entry{Name: "snowflake", Type: "database"}, true},
+		{"same name of other type unused", entry{Name: "keycloak", Type: "secret"}, false},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, inUse(tt.plugin, used))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := []byte(`
+- name: venafi
+  type: secret
+  instance:
+    address: https://vault.test
+  command: venafi
+  sha256: ` + sum + `
+  version: v1.0.0
+`)
+	require.NoError(t, config{}.Validate(valid))
+
+	invalid := []byte(`
+- name: venafi
+  type: plugin
+  instance:
+    address: https://vault.test
+  sha256: abc
+  version: latest
+`)
+	err := config{}.Validate(invalid)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unsupported type `plugin`")
+	require.Contains(t, err.Error(), "without command")
+	require.Contains(t, err.Error(), "64 hexadecimal characters")
+	require.Contains(t, err.Error(), "invalid `version`")
+}
@@ -3,11 +3,23 @@
 package policy
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"text/template"
 
 	"github.com/app-sre/vault-manager/pkg/utils"
 	"github.com/app-sre/vault-manager/pkg/vault"
 	"github.com/app-sre/vault-manager/toplevel"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 )
@@ -16,16 +28,94 @@ type config struct{}
 
 var _ toplevel.Configuration = config{}
 
+const toplevelName = "vault_policies"
+
 func init() {
-	toplevel.RegisterConfiguration("vault_policies", config{})
+	toplevel.RegisterConfiguration(toplevelName, config{})
 }
 
 type entry struct {
-	Name        string         `yaml:"name"`
-	Rules       string         `yaml:"rules"`
-	Type        string         `yaml:"type"`
-	Instance    vault.Instance `yaml:"instance"`
-	Description string         `yaml:"description"`
+	Name             string         `yaml:"name"`
+	Rules            string         `yaml:"rules"`
+	Type             string         `yaml:"type"`
+	Instance         vault.Instance `yaml:"instance"`
+	Description      string         `yaml:"description"`
+	EnforcementLevel string         `yaml:"enforcement_level"`
+	Paths            []string       `yaml:"paths"`
+	// RulesPath references a file containing the policy rules as an alternative to inline rules
+	// the file is loaded into Rules when unmarshalled and RulesPath is cleared
+	RulesPath string `yaml:"rules_path"`
+	// Variables renders the rules as a go template, e.g. `path "{{ .team }}/*"`, once they are loaded
+	// and are cleared once rendered. Rules of policies without variables are used as they are
+	Variables    map[string]string `yaml:"variables"`
+	vault.Toggle `yaml:",inline"`
+}
+
+// UnmarshalYAML loads the rules of policies configured with a `rules_path` and renders the rules of
+// policies configured with `variables`, so that rendered rules are written and compared
+func (e *entry) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain entry
+	if err := unmarshal((*plain)(e)); err != nil {
+		return err
+	}
+	if err := e.loadRules(); err != nil {
+		return err
+	}
+	return e.renderRules()
+}
+
+func (e *entry) loadRules() error {
+	if e.RulesPath == "" {
+		return nil
+	}
+	if e.Rules != "" {
+		return errors.New(fmt.Sprintf("[Vault Policy] policy `%s` cannot set both `rules` and `rules_path`", e.Name))
+	}
+	path := e.RulesPath
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(os.Getenv("POLICY_RULES_DIR"), path)
+	}
+	rules, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("[Vault Policy] failed to read rules of policy `%s`: %v", e.Name, err))
+	}
+	e.Rules = string(rules)
+	e.RulesPath = ""
+	return nil
+}
+
+// renderRules renders the rules with the policy's variables, referencing a variable that is not set is an error
+// vault's own templating, e.g. `{{identity.entity.id}}`, must be escaped as `{{"{{identity.entity.id}}"}}`
+func (e *entry) renderRules() error {
+	if len(e.Variables) == 0 {
+		return nil
+	}
+	tmpl, err := template.New(e.Name).Option("missingkey=error").Parse(e.Rules)
+	if err != nil {
+		return errors.New(fmt.Sprintf("[Vault Policy] failed to parse rules of policy `%s` as template: %v", e.Name, err))
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, e.Variables); err != nil {
+		return errors.New(fmt.Sprintf("[Vault Policy] failed to render rules of policy `%s`: %v", e.Name, err))
+	}
+	e.Rules = rendered.String()
+	e.Variables = nil
+	return nil
+}
+
+// policy types supported by vault
+// rgp and egp are sentinel policies only available within vault enterprise
+const (
+	aclPolicy = "acl"
+	rgpPolicy = "rgp"
+	egpPolicy = "egp"
+)
+
+// enforcementLevels are the enforcement levels vault accepts for sentinel policies
+var enforcementLevels = map[string]bool{
+	"advisory":       true,
+	"soft-mandatory": true,
+	"hard-mandatory": true,
 }
 
 var _ vault.Item = entry{}
@@ -48,24 +138,261 @@ func (e entry) Equals(i interface{}) bool {
 		return false
 	}
 
-	return e.Name == entry.Name && e.Rules == entry.Rules
+	return e.Name == entry.Name && rulesEqual(e.Rules, entry.Rules) &&
+		e.policyType() == entry.policyType() &&
+		e.EnforcementLevel == entry.EnforcementLevel &&
+		equalPaths(e.Paths, entry.Paths)
+}
+
+// policyType returns the type of the policy, defaulting to acl when unset
+func (e entry) policyType() string {
+	if e.Type == "" {
+		return aclPolicy
+	}
+	return e.Type
+}
+
+func (e entry) isSentinel() bool {
+	return e.policyType() == rgpPolicy || e.policyType() == egpPolicy
+}
+
+// rulesEqual compares policy rules by the capabilities they grant per path so that formatting,
+// block ordering and capability ordering differences are not reported as drift
+// rules that are not solely path blocks are compared by their parsed HCL structure instead,
+// falling back to a raw comparison if either set of rules fails to parse
+func rulesEqual(x, y string) bool {
+	if x == y {
+		return true
+	}
+	xpaths, xerr := parsePaths(x)
+	ypaths, yerr := parsePaths(y)
+	if xerr == nil && yerr == nil {
+		return reflect.DeepEqual(xpaths, ypaths)
+	}
+	xparsed, xerr := parseRules(x)
+	yparsed, yerr := parseRules(y)
+	if xerr != nil || yerr != nil {
+		return false
+	}
+	return reflect.DeepEqual(xparsed, yparsed)
+}
+
+// pathRules are the normalized rules of a single path
+// Capabilities are sorted and deduplicated, any other setting of the path is kept in its canonical form
+type pathRules struct {
+	Capabilities []string
+	Settings     map[string]interface{}
+}
+
+// parsePaths decodes acl policy rules into the normalized rules of each path
+// paths are compared as vault matches them, so a leading slash is ignored but a trailing glob is not,
+// e.g. `secret/foo` only matches that path whereas `secret/foo*` matches `secret/foobar` as well.
+// Path blocks repeated within the rules grant the union of their capabilities, as within vault,
+// and `deny` takes precedence over all other capabilities.
+func parsePaths(rules string) (map[string]pathRules, error) {
+	file, err := hcl.Parse(rules)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := file.Node.(*ast.ObjectList)
+	if !ok {
+		return nil, errors.New("rules are not an object")
+	}
+	items := list.Filter("path").Items
+	if len(items) != len(list.Items) {
+		return nil, errors.New("rules contain blocks other than paths")
+	}
+
+	paths := make(map[string]pathRules, len(items))
+	for _, item := range items {
+		if len(item.Keys) != 1 {
+			return nil, errors.New("path blocks must have a single key")
+		}
+		path, ok := item.Keys[0].Token.Value().(string)
+		if !ok {
+			return nil, errors.New("path is not a string")
+		}
+		path = strings.TrimPrefix(path, "/")
+
+		var decoded map[string]interface{}
+		if err := hcl.DecodeObject(&decoded, item.Val); err != nil {
+			return nil, err
+		}
+		capabilities := []string{}
+		if raw, ok := decoded["capabilities"]; ok {
+			values, ok := raw.([]interface{})
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("capabilities of path `%s` are not a list", path))
+			}
+			for _, v := range values {
+				c, ok := v.(string)
+				if !ok {
+					return nil, errors.New(fmt.Sprintf("capabilities of path `%s` are not strings", path))
+				}
+				capabilities = append(capabilities, c)
+			}
+			delete(decoded, "capabilities")
+		}
+		settings := canonicalize(decoded).(map[string]interface{})
+
+		if existing, dup := paths[path]; dup {
+			// settings such as allowed parameters of repeated path blocks are not merged
+			if len(settings) > 0 || len(existing.Settings) > 0 {
+				return nil, errors.New(fmt.Sprintf("path `%s` is repeated with settings", path))
+			}
+			capabilities = append(capabilities, existing.Capabilities...)
+		}
+		paths[path] = pathRules{Capabilities: normalizeCapabilities(capabilities), Settings: settings}
+	}
+	return paths, nil
+}
+
+// normalizeCapabilities sorts and deduplicates capabilities, deny is the only capability
+// of a path it is granted on as it overrides all others
+func normalizeCapabilities(capabilities []string) []string {
+	set := make(map[string]bool, len(capabilities))
+	for _, c := range capabilities {
+		if c == "deny" {
+			return []string{"deny"}
+		}
+		set[c] = true
+	}
+	normalized := make([]string, 0, len(set))
+	for c := range set {
+		normalized = append(normalized, c)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// parseRules decodes policy rules into a canonical form
+func parseRules(rules string) (interface{}, error) {
+	var decoded interface{}
+	if err := hcl.Decode(&decoded, rules); err != nil {
+		return nil, err
+	}
+	return canonicalize(decoded), nil
+}
+
+// hcl decodes each block as a list of single key maps in the order they were defined
+// canonicalize merges these lists into a single map so block ordering is irrelevant
+func canonicalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			out[k] = canonicalize(val)
+		}
+		return out
+	case []map[string]interface{}:
+		merged := make(map[string]interface{})
+		for _, m := range t {
+			for k, val := range m {
+				if _, dup := merged[k]; dup {
+					// duplicate keys cannot be merged without losing information
+					list := []interface{}{}
+					for _, m := range t {
+						list = append(list, canonicalize(m))
+					}
+					return list
+				}
+				merged[k] = canonicalize(val)
+			}
+		}
+		return merged
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, val := range t {
+			out[i] = canonicalize(val)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func equalPaths(x, y []string) bool {
+	if len(x) == 0 && len(y) == 0 {
+		return true
+	}
+	xs := append([]string{}, x...)
+	ys := append([]string{}, y...)
+	sort.Strings(xs)
+	sort.Strings(ys)
+	return reflect.DeepEqual(xs, ys)
+}
+
+// Validate ensures each policy has a supported type and that acl policy rules parse as HCL.
+// Sentinel policies are written in the sentinel language and are not parsed.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault Policy] failed to decode policies configuration: %v", err))
+	}
+	errs := []error{}
+	for _, e := range entries {
+		switch e.policyType() {
+		case aclPolicy:
+			if _, err := parseRules(e.Rules); err != nil {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Policy] failed to parse rules of policy `%s`: %v", e.Name, err)))
+			}
+		case rgpPolicy, egpPolicy:
+			if !enforcementLevels[e.EnforcementLevel] {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Policy] `enforcement_level` of %s policy `%s` must be one of advisory, soft-mandatory or hard-mandatory",
+					e.policyType(), e.Name)))
+			}
+		default:
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault Policy] unsupported type `%s` for policy `%s`", e.Type, e.Name)))
+		}
+	}
+	return utils.JoinErrors(errs)
+}
+
+var _ toplevel.Resolver = config{}
+
+// Resolve returns the policies with the rules of `rules_path` loaded and the rules of policies
+// with `variables` rendered, so that changing a file of rules changes the configuration
+func (c config) Resolve(entriesBytes []byte) ([]byte, error) {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return nil, errors.New(fmt.Sprintf("[Vault Policy] failed to decode policies configuration: %v", err))
+	}
+	// all other values are kept as configured
+	var resolved []map[interface{}]interface{}
+	if err := yaml.Unmarshal(entriesBytes, &resolved); err != nil {
+		return nil, errors.New(fmt.Sprintf("[Vault Policy] failed to decode policies configuration: %v", err))
+	}
+	for i, e := range entries {
+		resolved[i]["rules"] = e.Rules
+		delete(resolved[i], "rules_path")
+		delete(resolved[i], "variables")
+	}
+	return yaml.Marshal(resolved)
 }
 
 // TODO(dwelch): refactor into multiple functions
-func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPoolSize int) error {
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
 	// Unmarshal the list of configured secrets engines.
 	var entries []entry
 	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
-		log.WithError(err).Fatal("[Vault Policy] failed to decode policies configuration")
+		return nil, errors.New(fmt.Sprintf("[Vault Policy] failed to decode policies configuration: %v", err))
 	}
 	instancesToDesiredPolicies := make(map[string][]entry)
 	for _, e := range entries {
-		instancesToDesiredPolicies[e.Instance.Address] = append(instancesToDesiredPolicies[e.Instance.Address], e)
+		switch e.policyType() {
+		case aclPolicy, rgpPolicy, egpPolicy:
+		default:
+			return nil, errors.New(fmt.Sprintf("[Vault Policy] unsupported type `%s` for policy `%s`", e.Type, e.Name))
+		}
+		instancesToDesiredPolicies[e.Instance.Key()] = append(instancesToDesiredPolicies[e.Instance.Key()], e)
 	}
 
 	existingPolicyNames, err := vault.ListVaultPolicies(address)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Build a list of all the existing policies for each instance
@@ -101,53 +428,202 @@ func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPo
 
 	for e := range ch {
 		if e != nil {
-			return e
+			return nil, e
 		}
 	}
 
+	sentinelPolicies, err := getExistingSentinelPolicies(address, instancesToDesiredPolicies[address], threadPoolSize)
+	if err != nil {
+		return nil, err
+	}
+	existingPolicies = append(existingPolicies, sentinelPolicies...)
+
 	// Diff the local configuration with the Vault instance.
-	toBeWritten, toBeDeleted, _ :=
-		vault.DiffItems(asItems(instancesToDesiredPolicies[address]), asItems(existingPolicies))
+	// policies of different types may share a name so each type is diffed separately
+	toBeWritten, toBeDeleted := diffPoliciesByType(instancesToDesiredPolicies[address], existingPolicies)
+	utils.RecordPendingChanges(address, toplevelName, len(toBeWritten)+len(vault.ExcludeItems(toBeDeleted, isDefault)))
+	if !prune {
+		toBeDeleted = vault.SkipDeletes(address, "[Vault Policy] policy", toBeDeleted, isDefault)
+	}
+	// builtin policies are never deleted, so they are excluded before both dry runs and applies
+	toBeDeleted = vault.ExcludeItems(toBeDeleted, isDefault)
+	if err := vault.CheckDeletes(address, "[Vault Policy]", toBeDeleted); err != nil {
+		return nil, err
+	}
+
+	plan := vault.NewPlan()
+	plan.Add("policy", toBeWritten, nil, toBeDeleted, asItems(existingPolicies))
+	if vault.ShowDiff() {
+		for _, w := range toBeWritten {
+			plan.SetDiff("policy", w.Key(), rulesDiff(w.(entry), existingPolicies))
+		}
+	}
 
 	if dryRun == true {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate, len(toBeWritten))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationDelete, len(toBeDeleted))
 		for _, w := range toBeWritten {
-			log.WithField("instance", address).Infof("[Dry Run] [Vault Policy] policy to be written='%v'", w.Key())
+			vault.Logger(address, toplevelName).Infof("[Dry Run] [Vault Policy] policy to be written='%v'", w.Key())
+			if vault.ShowDiff() {
+				// the diff is written directly to the log output so that it remains readable
+				fmt.Fprint(log.StandardLogger().Out, rulesDiff(w.(entry), existingPolicies))
+			}
 		}
 		for _, d := range toBeDeleted {
-			if isDefaultPolicy(d.Key()) {
-				continue
-			}
-			log.WithField("instance", address).Infof("[Dry Run] [Vault Policy] policy to be deleted='%v'", d.Key())
+			vault.Logger(address, toplevelName).Infof("[Dry Run] [Vault Policy] policy to be deleted='%v'", d.Key())
 		}
 	} else {
 		// Write any missing policies to the Vault instance.
 		for _, e := range toBeWritten {
 			ent := e.(entry)
-			err := vault.PutVaultPolicy(address, ent.Name, ent.Rules)
+			var err error
+			if ent.isSentinel() {
+				err = vault.PutVaultSentinelPolicy(address, ent.policyType(), ent.Name, ent.Rules, ent.EnforcementLevel, ent.Paths)
+			} else {
+				err = vault.PutVaultPolicy(address, ent.Name, ent.Rules)
+			}
 			if err != nil {
-				return err
+				return nil, err
 			}
+			utils.RecordOperation(address, toplevelName, utils.OperationCreate)
 		}
 		// Delete any policies from the Vault instance.
 		for _, e := range toBeDeleted {
 			ent := e.(entry)
-			if isDefaultPolicy(ent.Name) {
-				continue
+			var err error
+			if ent.isSentinel() {
+				err = vault.DeleteVaultSentinelPolicy(address, ent.policyType(), ent.Name)
+			} else {
+				err = vault.DeleteVaultPolicy(address, ent.Name)
 			}
-			err := vault.DeleteVaultPolicy(address, ent.Name)
 			if err != nil {
-				return err
+				return nil, err
 			}
+			utils.RecordOperation(address, toplevelName, utils.OperationDelete)
 		}
 	}
 
-	return nil
+	return plan, nil
+}
+
+// rulesDiff returns the difference between the rules of an existing policy and the desired rules
+func rulesDiff(desired entry, existing []entry) string {
+	rules := ""
+	for _, e := range existing {
+		if e.Name == desired.Name && e.policyType() == desired.policyType() {
+			rules = e.Rules
+			break
+		}
+	}
+	return utils.UnifiedDiff(desired.Name, rules, desired.Rules)
+}
+
+// getExistingSentinelPolicies returns existing rgp and egp policies for enterprise instances
+// sentinel endpoints do not exist within oss vault so they are only queried for enterprise
+func getExistingSentinelPolicies(address string, desired []entry, threadPoolSize int) ([]entry, error) {
+	enterprise, err := vault.IsEnterprise(address)
+	if err != nil {
+		return nil, err
+	}
+	if !enterprise {
+		for _, e := range desired {
+			if e.isSentinel() {
+				return nil, errors.New(fmt.Sprintf(
+					"[Vault Policy] %s policy `%s` requires Vault Enterprise on %s", e.policyType(), e.Name, address))
+			}
+		}
+		return nil, nil
+	}
+
+	existing := []entry{}
+	for _, policyType := range []string{rgpPolicy, egpPolicy} {
+		names, err := vault.ListVaultSentinelPolicies(address, policyType)
+		if err != nil {
+			return nil, err
+		}
+
+		var mutex = &sync.Mutex{}
+		bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+		ch := make(chan error)
+
+		for i := range names {
+			bwg.Add(1)
+
+			go func(i int, ch chan<- error) {
+				defer bwg.Done()
+
+				policy, err := vault.GetVaultSentinelPolicy(address, policyType, names[i])
+				if err != nil {
+					ch <- err
+					return
+				}
+				if policy == nil {
+					return
+				}
+				ent := entry{Name: names[i], Type: policyType}
+				if rules, ok := policy["policy"].(string); ok {
+					ent.Rules = rules
+				}
+				if level, ok := policy["enforcement_level"].(string); ok {
+					ent.EnforcementLevel = level
+				}
+				if paths, ok := policy["paths"].([]interface{}); ok {
+					for _, path := range paths {
+						ent.Paths = append(ent.Paths, path.(string))
+					}
+				}
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				existing = append(existing, ent)
+			}(i, ch)
+		}
+
+		go func() {
+			bwg.Wait()
+			close(ch)
+		}()
+
+		for e := range ch {
+			if e != nil {
+				return nil, e
+			}
+		}
+	}
+	return existing, nil
+}
+
+// diffPoliciesByType performs a separate diff for each policy type
+func diffPoliciesByType(desired, existing []entry) (toBeWritten, toBeDeleted []vault.Item) {
+	desiredByType := make(map[string][]entry)
+	existingByType := make(map[string][]entry)
+	for _, e := range desired {
+		desiredByType[e.policyType()] = append(desiredByType[e.policyType()], e)
+	}
+	for _, e := range existing {
+		existingByType[e.policyType()] = append(existingByType[e.policyType()], e)
+	}
+
+	toBeWritten = make([]vault.Item, 0)
+	toBeDeleted = make([]vault.Item, 0)
+	for _, policyType := range []string{aclPolicy, rgpPolicy, egpPolicy} {
+		w, d, _ := vault.DiffItems(
+			vault.ExcludeUnmanaged(asItems(desiredByType[policyType]), asItems(existingByType[policyType])))
+		toBeWritten = append(toBeWritten, w...)
+		toBeDeleted = append(toBeDeleted, d...)
+	}
+	return
 }
 
 func isDefaultPolicy(name string) bool {
 	return name == "root" || name == "default"
 }
 
+// isDefault determines if an item is a builtin acl policy that is never deleted
+func isDefault(i vault.Item) bool {
+	return isDefaultPolicy(i.Key()) && !i.(entry).isSentinel()
+}
+
 func asItems(xs []entry) (items []vault.Item) {
 	items = make([]vault.Item, 0)
 	for _, x := range xs {
@@ -0,0 +1,298 @@
+package policy
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRulesEqual(t *testing.T) {
+	table := []struct {
+		description string
+		x, y        string
+		expected    bool
+	}{
+		{
+			description: "identical rules are equal",
+			x:           `path "secret/*" { capabilities = ["read"] }`,
+			y:           `path "secret/*" { capabilities = ["read"] }`,
+			expected:    true,
+		},
+		{
+			description: "whitespace differences are equal",
+			x:           `path "secret/*" { capabilities = ["read"] }`,
+			y:           "path \"secret/*\" {\n  capabilities = [\"read\"]\n}\n",
+			expected:    true,
+		},
+		{
+			description: "reordered path blocks are equal",
+			x:           "path \"a/*\" { capabilities = [\"read\"] }\npath \"b/*\" { capabilities = [\"list\"] }",
+			y:           "path \"b/*\" { capabilities = [\"list\"] }\npath \"a/*\" { capabilities = [\"read\"] }",
+			expected:    true,
+		},
+		{
+			description: "different capabilities are not equal",
+			x:           `path "secret/*" { capabilities = ["read"] }`,
+			y:           `path "secret/*" { capabilities = ["read", "list"] }`,
+			expected:    false,
+		},
+		{
+			description: "different paths are not equal",
+			x:           `path "secret/*" { capabilities = ["read"] }`,
+			y:           `path "other/*" { capabilities = ["read"] }`,
+			expected:    false,
+		},
+		{
+			description: "reordered capabilities are equal",
+			x:           `path "secret/*" { capabilities = ["read", "list"] }`,
+			y:           `path "secret/*" { capabilities = ["list", "read"] }`,
+			expected:    true,
+		},
+		{
+			description: "duplicate capabilities are equal",
+			x:           `path "secret/*" { capabilities = ["read", "list", "read"] }`,
+			y:           `path "secret/*" { capabilities = ["list", "read"] }`,
+			expected:    true,
+		},
+		{
+			description: "repeated path blocks grant the union of their capabilities",
+			x:           "path \"secret/*\" { capabilities = [\"read\"] }\npath \"secret/*\" { capabilities = [\"list\"] }",
+			y:           `path "secret/*" { capabilities = ["list", "read"] }`,
+			expected:    true,
+		},
+		{
+			description: "deny overrides other capabilities",
+			x:           `path "secret/*" { capabilities = ["read", "deny"] }`,
+			y:           `path "secret/*" { capabilities = ["deny"] }`,
+			expected:    true,
+		},
+		{
+			description: "leading slash of a path is ignored",
+			x:           `path "/secret/data/app" { capabilities = ["read"] }`,
+			y:           `path "secret/data/app" { capabilities = ["read"] }`,
+			expected:    true,
+		},
+		{
+			description: "trailing glob matches other paths and is not equal",
+			x:           `path "secret/data/app" { capabilities = ["read"] }`,
+			y:           `path "secret/data/app*" { capabilities = ["read"] }`,
+			expected:    false,
+		},
+		{
+			description: "different settings are not equal",
+			x:           `path "secret/*" { capabilities = ["read"] max_wrapping_ttl = "1h" }`,
+			y:           `path "secret/*" { capabilities = ["read"] max_wrapping_ttl = "2h" }`,
+			expected:    false,
+		},
+		{
+			description: "settings of reordered capabilities are compared",
+			x:           `path "secret/*" { capabilities = ["read", "list"] max_wrapping_ttl = "1h" }`,
+			y:           `path "secret/*" { capabilities = ["list", "read"] max_wrapping_ttl = "1h" }`,
+			expected:    true,
+		},
+		{
+			description: "unparseable rules fall back to raw comparison",
+			x:           `path "secret/*" { capabilities = [`,
+			y:           `path "secret/*" { capabilities = [`,
+			expected:    true,
+		},
+		{
+			description: "unparseable rules that differ are not equal",
+			x:           `path "secret/*" { capabilities = [`,
+			y:           `path "secret/*" { capabilities = ["read"] }`,
+			expected:    false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, rulesEqual(tt.x, tt.y))
+		})
+	}
+}
+
+func TestUnmarshalRulesPath(t *testing.T) {
+	dir := t.TempDir()
+	rules := "path \"secret/*\" {\n  capabilities = [\"read\"]\n}\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "read.hcl"), []byte(rules), 0600))
+	t.Setenv("POLICY_RULES_DIR", dir)
+
+	table := []struct {
+		description string
+		config      string
+		expected    string
+		expectErr   bool
+	}{
+		{
+			description: "inline rules",
+			config:      "- name: read\n  rules: inline\n",
+			expected:    "inline",
+		},
+		{
+			description: "rules loaded relative to base dir",
+			config:      "- name: read\n  rules_path: read.hcl\n",
+			expected:    rules,
+		},
+		{
+			description: "rules loaded from absolute path",
+			config:      "- name: read\n  rules_path: " + filepath.Join(dir, "read.hcl") + "\n",
+			expected:    rules,
+		},
+		{
+			description: "both rules and rules_path",
+			config:      "- name: read\n  rules: inline\n  rules_path: read.hcl\n",
+			expectErr:   true,
+		},
+		{
+			description: "missing rules file",
+			config:      "- name: read\n  rules_path: missing.hcl\n",
+			expectErr:   true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			var entries []entry
+			err := yaml.Unmarshal([]byte(tt.config), &entries)
+			if tt.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, entries[0].Rules)
+			require.Empty(t, entries[0].RulesPath)
+		})
+	}
+}
+
+func TestUnmarshalVariables(t *testing.T) {
+	table := []struct {
+		description string
+		config      string
+		expected    string
+		expectErr   bool
+	}{
+		{
+			description: "rules rendered with variables",
+			config:      "- name: team\n  rules: 'path \"{{ .team }}/*\" {}'\n  variables:\n    team: app-sre\n",
+			expected:    `path "app-sre/*" {}`,
+		},
+		{
+			description: "rules without variables are not rendered",
+			config:      "- name: team\n  rules: 'path \"{{identity.entity.id}}/*\" {}'\n",
+			expected:    `path "{{identity.entity.id}}/*" {}`,
+		},
+		{
+			description: "escaped vault templating",
+			config: "- name: team\n  rules: 'path \"{{ .team }}/{{\"{{identity.entity.id}}\"}}/*\" {}'\n" +
+				"  variables:\n    team: app-sre\n",
+			expected: `path "app-sre/{{identity.entity.id}}/*" {}`,
+		},
+		{
+			description: "undefined variable",
+			config:      "- name: team\n  rules: 'path \"{{ .prefix }}/*\" {}'\n  variables:\n    team: app-sre\n",
+			expectErr:   true,
+		},
+		{
+			description: "malformed template",
+			config:      "- name: team\n  rules: 'path \"{{ .team \" {}'\n  variables:\n    team: app-sre\n",
+			expectErr:   true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			var entries []entry
+			err := yaml.Unmarshal([]byte(tt.config), &entries)
+			if tt.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, entries[0].Rules)
+			require.Empty(t, entries[0].Variables)
+		})
+	}
+}
+
+func TestDefaultPoliciesNotDeleted(t *testing.T) {
+	existing := []entry{
+		{Name: "root"},
+		{Name: "default"},
+		{Name: "team"},
+		{Name: "default", Type: egpPolicy},
+	}
+
+	_, toBeDeleted := diffPoliciesByType([]entry{}, existing)
+	deleted := []string{}
+	for _, d := range vault.ExcludeItems(toBeDeleted, isDefault) {
+		deleted = append(deleted, d.(entry).policyType()+"/"+d.Key())
+	}
+	require.ElementsMatch(t, []string{"acl/team", "egp/default"}, deleted)
+}
+
+func TestResolveRulesPath(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("POLICY_RULES_DIR", dir)
+	cfg := []byte("- name: read\n  rules_path: read.hcl\n")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "read.hcl"), []byte("path \"a/*\" {}\n"), 0600))
+	before, err := config{}.Resolve(cfg)
+	require.NoError(t, err)
+	require.Contains(t, string(before), `path "a/*"`)
+	require.NotContains(t, string(before), "rules_path")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "read.hcl"), []byte("path \"b/*\" {}\n"), 0600))
+	after, err := config{}.Resolve(cfg)
+	require.NoError(t, err)
+	require.NotEqual(t, before, after, "changing the file of rules changes the resolved configuration")
+}
+
+func TestValidate(t *testing.T) {
+	table := []struct {
+		description string
+		config      string
+		expectErr   bool
+	}{
+		{
+			description: "acl policy",
+			config:      "- name: read\n  type: acl\n  rules: path \"secret/*\" {}\n",
+			expectErr:   false,
+		},
+		{
+			description: "rgp policy",
+			config:      "- name: business-hours\n  type: rgp\n  rules: main = rule { true }\n  enforcement_level: soft-mandatory\n",
+			expectErr:   false,
+		},
+		{
+			description: "sentinel policy without enforcement level",
+			config:      "- name: business-hours\n  type: egp\n  rules: main = rule { true }\n  paths: [\"*\"]\n",
+			expectErr:   true,
+		},
+		{
+			description: "sentinel policy with unknown enforcement level",
+			config:      "- name: business-hours\n  type: rgp\n  rules: main = rule { true }\n  enforcement_level: mandatory\n",
+			expectErr:   true,
+		},
+		{
+			description: "unsupported type",
+			config:      "- name: read\n  type: sentinel\n  rules: main = rule { true }\n",
+			expectErr:   true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			err := config{}.Validate([]byte(tt.config))
+			if tt.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
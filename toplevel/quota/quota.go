@@ -0,0 +1,296 @@
+// Package quota implements the application of a declarative configuration
+// for Vault rate limit and lease count quotas.
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/app-sre/vault-manager/pkg/utils"
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/app-sre/vault-manager/toplevel"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	rateLimit  = "rate-limit"
+	leaseCount = "lease-count"
+)
+
+type entry struct {
+	Name     string         `yaml:"name"`
+	Type     string         `yaml:"type"`
+	Instance vault.Instance `yaml:"instance"`
+	// Path is the mount or namespace the quota applies to, an empty path applies globally
+	Path string `yaml:"path"`
+	Role string `yaml:"role"`
+	// Rate and Interval only apply to rate-limit quotas
+	Rate     float64 `yaml:"rate"`
+	Interval string  `yaml:"interval"`
+	// MaxLeases only applies to lease-count quotas
+	MaxLeases    int `yaml:"max_leases"`
+	vault.Toggle `yaml:",inline"`
+}
+
+var _ vault.Item = entry{}
+
+func (e entry) Key() string {
+	return filepath.Join(e.Type, e.Name)
+}
+
+func (e entry) KeyForType() string {
+	return e.Type
+}
+
+func (e entry) KeyForDescription() string {
+	return ""
+}
+
+func (e entry) Equals(i interface{}) bool {
+	entry, ok := i.(entry)
+	if !ok {
+		return false
+	}
+
+	if e.Name != entry.Name ||
+		e.Type != entry.Type ||
+		!vault.EqualPathNames(e.Path, entry.Path) ||
+		e.Role != entry.Role {
+		return false
+	}
+	switch e.Type {
+	case rateLimit:
+		return e.Rate == entry.Rate && durationsEqual(e.interval(), entry.interval())
+	case leaseCount:
+		return e.MaxLeases == entry.MaxLeases
+	}
+	return true
+}
+
+// interval returns the interval of a rate-limit quota defaulting to vault's default of one second
+func (e entry) interval() string {
+	if e.Interval == "" || e.Interval == "0" {
+		return "1s"
+	}
+	return e.Interval
+}
+
+// durationsEqual compares durations regardless of their unit, vault reports intervals in seconds
+func durationsEqual(x, y string) bool {
+	if x == y {
+		return true
+	}
+	xdur, xerr := vault.ParseDuration(x)
+	ydur, yerr := vault.ParseDuration(y)
+	return xerr == nil && yerr == nil && xdur == ydur
+}
+
+func (e entry) data() map[string]interface{} {
+	data := map[string]interface{}{
+		"path": e.Path,
+		"role": e.Role,
+	}
+	switch e.Type {
+	case rateLimit:
+		data["rate"] = e.Rate
+		data["interval"] = e.interval()
+	case leaseCount:
+		data["max_leases"] = e.MaxLeases
+	}
+	return data
+}
+
+type config struct{}
+
+var _ toplevel.Configuration = config{}
+
+const toplevelName = "vault_quotas"
+
+func init() {
+	toplevel.RegisterConfiguration(toplevelName, config{}, "vault_secret_engines", "vault_auth_backends", "vault_roles")
+}
+
+// Validate ensures each quota has a supported type and the limit required by that type.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault Quota] failed to decode quota configuration: %v", err))
+	}
+	errs := []error{}
+	for _, e := range entries {
+		if e.Name == "" {
+			errs = append(errs, errors.New("[Vault Quota] quota without name"))
+			continue
+		}
+		switch e.Type {
+		case rateLimit:
+			if e.Rate <= 0 {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Quota] `rate` of rate-limit quota `%s` must be greater than 0", e.Name)))
+			}
+			if _, err := vault.ParseDuration(e.interval()); err != nil {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Quota] invalid `interval` of rate-limit quota `%s`: %v", e.Name, err)))
+			}
+		case leaseCount:
+			if e.MaxLeases <= 0 {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Quota] `max_leases` of lease-count quota `%s` must be greater than 0", e.Name)))
+			}
+		default:
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault Quota] unsupported type `%s` for quota `%s`, must be %s or %s", e.Type, e.Name, rateLimit, leaseCount)))
+		}
+	}
+	return utils.JoinErrors(errs)
+}
+
+// Apply ensures that an instance of Vault's quotas are configured exactly as provided.
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return nil, errors.New(fmt.Sprintf("[Vault Quota] failed to decode quota configuration: %v", err))
+	}
+	instancesToDesiredQuotas := make(map[string][]entry)
+	for _, e := range entries {
+		instancesToDesiredQuotas[e.Instance.Key()] = append(instancesToDesiredQuotas[e.Instance.Key()], e)
+	}
+
+	existingQuotas, err := getExistingQuotas(address, threadPoolSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// quotas are written in full so that updates and creations are handled alike
+	toBeWritten, toBeDeleted, _ :=
+		vault.DiffItems(vault.ExcludeUnmanaged(asItems(instancesToDesiredQuotas[address]), asItems(existingQuotas)))
+	utils.RecordPendingChanges(address, toplevelName, len(toBeWritten)+len(toBeDeleted))
+	if !prune {
+		toBeDeleted = vault.SkipDeletes(address, "[Vault Quota] quota", toBeDeleted, nil)
+	}
+	if err := vault.CheckDeletes(address, "[Vault Quota]", toBeDeleted); err != nil {
+		return nil, err
+	}
+
+	plan := vault.NewPlan()
+	plan.Add("quota", toBeWritten, nil, toBeDeleted, asItems(existingQuotas))
+
+	if dryRun == true {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate, len(toBeWritten))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationDelete, len(toBeDeleted))
+		for _, w := range toBeWritten {
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"name": w.(entry).Name,
+				"type": w.(entry).Type,
+				"path": w.(entry).Path,
+			}).Info("[Dry Run] [Vault Quota] quota to be written")
+		}
+		for _, d := range toBeDeleted {
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"name": d.(entry).Name,
+				"type": d.(entry).Type,
+			}).Info("[Dry Run] [Vault Quota] quota to be deleted")
+		}
+		return plan, nil
+	}
+
+	for _, w := range toBeWritten {
+		ent := w.(entry)
+		err := vault.WriteQuota(address, ent.Type, ent.Name, ent.data())
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationCreate)
+	}
+	for _, d := range toBeDeleted {
+		ent := d.(entry)
+		err := vault.DeleteQuota(address, ent.Type, ent.Name)
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationDelete)
+	}
+
+	return plan, nil
+}
+
+// getExistingQuotas reads all quotas of each supported type
+func getExistingQuotas(address string, threadPoolSize int) ([]entry, error) {
+	existing := []entry{}
+	for _, quotaType := range []string{rateLimit, leaseCount} {
+		names, err := vault.ListQuotas(address, quotaType)
+		if err != nil {
+			return nil, err
+		}
+
+		var mutex = &sync.Mutex{}
+		var readErr error
+		bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+
+		for _, name := range names {
+			bwg.Add(1)
+
+			go func(quotaType, name string) {
+				defer bwg.Done()
+
+				data, err := vault.ReadQuota(address, quotaType, name)
+
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if err != nil {
+					readErr = err
+					return
+				}
+				if data == nil {
+					return
+				}
+				existing = append(existing, fromData(address, quotaType, name, data))
+			}(quotaType, name)
+		}
+		bwg.Wait()
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	return existing, nil
+}
+
+// fromData converts a quota read from vault into an entry
+func fromData(address, quotaType, name string, data map[string]interface{}) entry {
+	e := entry{
+		Name:     name,
+		Type:     quotaType,
+		Instance: vault.Instance{Address: address},
+		Path:     stringOrEmpty(data["path"]),
+		Role:     stringOrEmpty(data["role"]),
+	}
+	switch quotaType {
+	case rateLimit:
+		e.Rate, _ = strconv.ParseFloat(fmt.Sprintf("%v", data["rate"]), 64)
+		e.Interval = fmt.Sprintf("%v", data["interval"])
+	case leaseCount:
+		e.MaxLeases, _ = strconv.Atoi(fmt.Sprintf("%v", data["max_leases"]))
+	}
+	return e
+}
+
+func stringOrEmpty(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func asItems(xs []entry) (items []vault.Item) {
+	items = make([]vault.Item, 0)
+	for _, x := range xs {
+		items = append(items, x)
+	}
+
+	return
+}
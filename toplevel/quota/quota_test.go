@@ -0,0 +1,117 @@
+package quota
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryEquals(t *testing.T) {
+	existingRateLimit := fromData("https://vault.test", rateLimit, "global", map[string]interface{}{
+		"path":     "",
+		"role":     "",
+		"rate":     json.Number("100"),
+		"interval": json.Number("60"),
+	})
+	existingLeaseCount := fromData("https://vault.test", leaseCount, "kv", map[string]interface{}{
+		"path":       "kv/",
+		"role":       "",
+		"max_leases": json.Number("500"),
+	})
+
+	table := []struct {
+		description string
+		desired     entry
+		existing    entry
+		expected    bool
+	}{
+		{
+			description: "rate limit with interval unit",
+			desired:     entry{Name: "global", Type: rateLimit, Rate: 100, Interval: "1m"},
+			existing:    existingRateLimit,
+			expected:    true,
+		},
+		{
+			description: "different rate",
+			desired:     entry{Name: "global", Type: rateLimit, Rate: 50, Interval: "1m"},
+			existing:    existingRateLimit,
+			expected:    false,
+		},
+		{
+			description: "different interval",
+			desired:     entry{Name: "global", Type: rateLimit, Rate: 100},
+			existing:    existingRateLimit,
+			expected:    false,
+		},
+		{
+			description: "lease count with path without trailing slash",
+			desired:     entry{Name: "kv", Type: leaseCount, Path: "kv", MaxLeases: 500},
+			existing:    existingLeaseCount,
+			expected:    true,
+		},
+		{
+			description: "different max leases",
+			desired:     entry{Name: "kv", Type: leaseCount, Path: "kv", MaxLeases: 100},
+			existing:    existingLeaseCount,
+			expected:    false,
+		},
+		{
+			description: "different path",
+			desired:     entry{Name: "kv", Type: leaseCount, Path: "kv2", MaxLeases: 500},
+			existing:    existingLeaseCount,
+			expected:    false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.desired.Equals(tt.existing))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	table := []struct {
+		description string
+		config      string
+		expectErr   bool
+	}{
+		{
+			description: "rate limit",
+			config:      "- name: global\n  type: rate-limit\n  rate: 100\n  interval: 1m\n",
+			expectErr:   false,
+		},
+		{
+			description: "lease count",
+			config:      "- name: kv\n  type: lease-count\n  path: kv/\n  max_leases: 500\n",
+			expectErr:   false,
+		},
+		{
+			description: "rate limit without rate",
+			config:      "- name: global\n  type: rate-limit\n",
+			expectErr:   true,
+		},
+		{
+			description: "lease count without max leases",
+			config:      "- name: kv\n  type: lease-count\n",
+			expectErr:   true,
+		},
+		{
+			description: "unsupported type",
+			config:      "- name: kv\n  type: concurrency\n",
+			expectErr:   true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			err := config{}.Validate([]byte(tt.config))
+			if tt.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -16,14 +16,13 @@ func populateApproleCreds(address string, roles []entry, dryRun bool) error {
 	}
 
 	for _, role := range roles {
-		if strings.ToLower(role.Type) == "approle" && len(role.OutputPath) > 0 {
+		if strings.ToLower(role.Type) == "approle" && len(role.OutputPath) > 0 && !role.Unmanaged() {
 			// root of secret path is name of the secret engine
 			pathRoot := strings.Split(role.OutputPath, "/")[0]
 			if _, exists := kvVersions[fmt.Sprint(pathRoot, "/")]; !exists {
-				log.WithFields(log.Fields{
-					"name":     role.Name,
-					"path":     role.OutputPath,
-					"instance": address,
+				vault.Logger(address, toplevelName).WithFields(log.Fields{
+					"name": role.Name,
+					"path": role.OutputPath,
 				}).Info("[Vault Approle] Specified output path does not match any existing KV engines")
 				return errors.New("approle creds invalid output path")
 			}
@@ -37,21 +36,19 @@ func populateApproleCreds(address string, roles []entry, dryRun bool) error {
 			case "2":
 				version = vault.KV_V2
 			default:
-				log.WithFields(log.Fields{
+				vault.Logger(address, toplevelName).WithFields(log.Fields{
 					"name":       role.Name,
 					"path":       role.OutputPath,
 					"kv_version": kvVersions[fmt.Sprint(pathRoot, "/")],
-					"instance":   address,
 				}).Info("[Vault Approle] Retrieved KV version is not supported")
 				return errors.New("approle creds unsupported KV version")
 			}
 			secret, err := vault.ReadSecret(address, role.OutputPath, version)
 			if err != nil {
-				log.WithFields(log.Fields{
+				vault.Logger(address, toplevelName).WithFields(log.Fields{
 					"name":       role.Name,
 					"path":       role.OutputPath,
 					"kv_version": kvVersions[fmt.Sprint(pathRoot, "/")],
-					"instance":   address,
 				}).Info("[Vault Approle] Unable to read desired output path")
 				return err
 			}
@@ -60,11 +57,10 @@ func populateApproleCreds(address string, roles []entry, dryRun bool) error {
 			}
 
 			if dryRun {
-				log.WithFields(log.Fields{
+				vault.Logger(address, toplevelName).WithFields(log.Fields{
 					"name":       role.Name,
 					"path":       role.OutputPath,
 					"kv_version": kvVersions[fmt.Sprint(pathRoot, "/")],
-					"instance":   address,
 				}).Info("[DRY RUN][Vault Approle] Credentials written to desired path")
 			} else {
 				creds, err := generatePayload(address, role)
@@ -76,11 +72,10 @@ func populateApproleCreds(address string, roles []entry, dryRun bool) error {
 				if err != nil {
 					return err
 				}
-				log.WithFields(log.Fields{
+				vault.Logger(address, toplevelName).WithFields(log.Fields{
 					"name":       role.Name,
 					"path":       role.OutputPath,
 					"kv_version": kvVersions[fmt.Sprint(pathRoot, "/")],
-					"instance":   address,
 				}).Info("[Vault Approle] Credentials written to desired path")
 			}
 		}
@@ -104,9 +99,8 @@ func getKvEngineVersions(address string) (map[string]string, error) {
 		if v, exists := config.Options["version"]; exists {
 			kvVersions[name] = v
 		} else {
-			log.WithFields(log.Fields{
-				"name":     name,
-				"instance": address,
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"name": name,
 			}).Info("Unable to determine KV version")
 			continue
 		}
@@ -126,9 +120,8 @@ func generatePayload(address string, role entry) (map[string]interface{}, error)
 		return nil, err
 	}
 	if _, exists := roleSecret["role_id"]; !exists {
-		log.WithFields(log.Fields{
-			"name":     role.Name,
-			"instance": address,
+		vault.Logger(address, toplevelName).WithFields(log.Fields{
+			"name": role.Name,
 		}).Info("[Vault Approle] Unable to retrieve role_id")
 		return nil, errors.New("role_id retrieval failed")
 	}
@@ -141,17 +134,15 @@ func generatePayload(address string, role entry) (map[string]interface{}, error)
 		return nil, err
 	}
 	if _, exists := secretIdResult.Data["secret_id"]; !exists {
-		log.WithFields(log.Fields{
-			"name":     role.Name,
-			"instance": address,
+		vault.Logger(address, toplevelName).WithFields(log.Fields{
+			"name": role.Name,
 		}).Info("[Vault Approle] Unable to retrieve secret_id")
 		return nil, errors.New("secret_id retrieval failed")
 	}
 	creds["secret_id"] = secretIdResult.Data["secret_id"]
 	if _, exists := secretIdResult.Data["secret_id_accessor"]; !exists {
-		log.WithFields(log.Fields{
-			"name":     role.Name,
-			"instance": address,
+		vault.Logger(address, toplevelName).WithFields(log.Fields{
+			"name": role.Name,
 		}).Info("[Vault Approle] Unable to retrieve secret_id_accessor")
 		return nil, errors.New("secret_id_accessor retrieval failed")
 	}
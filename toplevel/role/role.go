@@ -3,6 +3,8 @@
 package role
 
 import (
+	"errors"
+	"fmt"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -16,13 +18,14 @@ import (
 )
 
 type entry struct {
-	Name        string                 `yaml:"name"`
-	Type        string                 `yaml:"type"`
-	Mount       string                 `yaml:"mount"`
-	Instance    vault.Instance         `yaml:"instance"`
-	OutputPath  string                 `yaml:"output_path"`
-	Options     map[string]interface{} `yaml:"options"`
-	Description string                 `yaml:"description"`
+	Name         string                 `yaml:"name"`
+	Type         string                 `yaml:"type"`
+	Mount        string                 `yaml:"mount"`
+	Instance     vault.Instance         `yaml:"instance"`
+	OutputPath   string                 `yaml:"output_path"`
+	Options      map[string]interface{} `yaml:"options"`
+	Description  string                 `yaml:"description"`
+	vault.Toggle `yaml:",inline"`
 }
 
 var _ vault.Item = entry{}
@@ -63,28 +66,26 @@ func (e entry) Save() error {
 			options[k] = v
 		}
 	}
-	err := vault.WriteSecret(e.Instance.Address, path, vault.KV_V1, options)
+	err := vault.WriteSecret(e.Instance.Key(), path, vault.KV_V1, options)
 	if err != nil {
 		return err
 	}
-	log.WithFields(log.Fields{
-		"path":     path,
-		"type":     e.Type,
-		"instance": e.Instance.Address,
+	vault.Logger(e.Instance.Key(), toplevelName).WithFields(log.Fields{
+		"path": path,
+		"type": e.Type,
 	}).Info("[Vault Role] role is successfully written to Vault instance")
 	return nil
 }
 
 func (e entry) Delete() error {
 	path := filepath.Join("auth", e.Mount, "role", e.Name)
-	err := vault.DeleteSecret(e.Instance.Address, path)
+	err := vault.DeleteSecret(e.Instance.Key(), path)
 	if err != nil {
 		return nil
 	}
-	log.WithFields(log.Fields{
-		"path":     path,
-		"type":     e.Type,
-		"instance": e.Instance.Address,
+	vault.Logger(e.Instance.Key(), toplevelName).WithFields(log.Fields{
+		"path": path,
+		"type": e.Type,
 	}).Info("[Vault Role] role is successfully deleted from Vault instance")
 	return nil
 }
@@ -93,27 +94,38 @@ type config struct{}
 
 var _ toplevel.Configuration = config{}
 
+const toplevelName = "vault_roles"
+
 func init() {
-	toplevel.RegisterConfiguration("vault_roles", config{})
+	toplevel.RegisterConfiguration(toplevelName, config{}, "vault_auth_backends", "vault_policies")
+}
+
+// Validate ensures the configuration can be decoded.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault Role] failed to decode role configuration: %v", err))
+	}
+	return nil
 }
 
 // TODO(dwelch): refactor this into multiple functions
 // Apply ensures that an instance of Vault's roles are configured exactly
 // as provided.
-func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPoolSize int) error {
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
 	var entries []entry
 	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
-		log.WithError(err).Fatal("[Vault Role] failed to decode role configuration")
+		return nil, errors.New(fmt.Sprintf("[Vault Role] failed to decode role configuration: %v", err))
 	}
 	instancesToDesiredRoles := make(map[string][]entry)
 	for _, e := range entries {
-		instancesToDesiredRoles[e.Instance.Address] = append(instancesToDesiredRoles[e.Instance.Address], e)
+		instancesToDesiredRoles[e.Instance.Key()] = append(instancesToDesiredRoles[e.Instance.Key()], e)
 	}
 
 	// Get the existing auth backends
 	existingAuths, err := vault.ListAuthBackends(address)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// build list of all existing roles
@@ -123,12 +135,13 @@ func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPo
 		path := filepath.Join("auth", authBackend, "role")
 		secret, err := vault.ListSecrets(address, path)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if secret != nil {
 			roles := secret.Data["keys"].([]interface{})
 
 			var mutex = &sync.Mutex{}
+			var readErr error
 			bwg := utils.NewBoundedWaitGroup(threadPoolSize)
 
 			// fill existing policies array in parallel
@@ -145,7 +158,8 @@ func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPo
 					opts, err := vault.ReadSecret(address, path, vault.KV_V1)
 					if err != nil {
 						// reading of existing policies config failed
-						log.WithError(err).Fatal()
+						readErr = err
+						return
 					}
 					existingRoles = append(existingRoles,
 						entry{
@@ -158,34 +172,47 @@ func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPo
 				}(i)
 			}
 			bwg.Wait()
+			if readErr != nil {
+				return nil, readErr
+			}
 		}
 	}
 
 	addOptionalOidcDefaults(address, instancesToDesiredRoles[address])
 	err = pruneUnsupported(address, instancesToDesiredRoles[address])
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	err = unmarshallOptionObjects(instancesToDesiredRoles[address])
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{
-			"instance": address,
-		}).Info("[Vault Role] failed to unmarshall oidc options of desired role")
-		return err
+		vault.Logger(address, toplevelName).WithError(err).Info("[Vault Role] failed to unmarshall oidc options of desired role")
+		return nil, err
 	}
 
 	// Diff the desired configuration with the Vault instance.
 	entriesToBeWritten, entriesToBeDeleted, _ :=
-		vault.DiffItems(asItems(instancesToDesiredRoles[address]), asItems(existingRoles))
+		vault.DiffItems(vault.ExcludeUnmanaged(asItems(instancesToDesiredRoles[address]), asItems(existingRoles)))
+	utils.RecordPendingChanges(address, toplevelName, len(entriesToBeWritten)+len(entriesToBeDeleted))
+	if !prune {
+		entriesToBeDeleted = vault.SkipDeletes(address, "[Vault Role] role", entriesToBeDeleted, nil)
+	}
+	if err := vault.CheckDeletes(address, "[Vault Role]", entriesToBeDeleted); err != nil {
+		return nil, err
+	}
+
+	plan := vault.NewPlan()
+	plan.Add("role", entriesToBeWritten, nil, entriesToBeDeleted, asItems(existingRoles))
 
 	if dryRun == true {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate, len(entriesToBeWritten))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationDelete, len(entriesToBeDeleted))
 		for _, w := range entriesToBeWritten {
-			log.WithField("name", w.Key()).WithField("type", w.(entry).Type).WithField("instance", address).Info(
-				"[Dry Run] [Vault Role] role to be written")
+			vault.Logger(address, toplevelName).WithField("name", w.Key()).WithField("type", w.(entry).Type).WithField(
+				"options", utils.RedactOptions(w.(entry).Options)).Info("[Dry Run] [Vault Role] role to be written")
 		}
 		for _, d := range entriesToBeDeleted {
-			log.WithField("name", d.Key()).WithField("type", d.(entry).Type).WithField("instance", address).Info(
+			vault.Logger(address, toplevelName).WithField("name", d.Key()).WithField("type", d.(entry).Type).Info(
 				"[Dry Run] [Vault Role] role to be deleted")
 		}
 	} else {
@@ -193,25 +220,27 @@ func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPo
 		for _, e := range entriesToBeWritten {
 			err := e.(entry).Save()
 			if err != nil {
-				return err
+				return nil, err
 			}
+			utils.RecordOperation(address, toplevelName, utils.OperationCreate)
 		}
 
 		// Delete any roles from the Vault instance.
 		for _, e := range entriesToBeDeleted {
 			err := e.(entry).Delete()
 			if err != nil {
-				return err
+				return nil, err
 			}
+			utils.RecordOperation(address, toplevelName, utils.OperationDelete)
 		}
 	}
 
 	err = populateApproleCreds(address, instancesToDesiredRoles[address], dryRun)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return plan, nil
 }
 
 func asItems(xs []entry) (items []vault.Item) {
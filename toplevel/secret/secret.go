@@ -0,0 +1,245 @@
+// Package secret implements the application of a declarative configuration
+// for key/value data stored within Vault KV Secrets Engines.
+//
+// Only the paths present within the configuration are managed. Secrets are
+// never deleted because other secrets within the same engine (e.g. approle
+// output paths) are not owned by this configuration.
+package secret
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/app-sre/vault-manager/pkg/utils"
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/app-sre/vault-manager/toplevel"
+	"gopkg.in/yaml.v2"
+)
+
+type entry struct {
+	Path         string                 `yaml:"path"`
+	Instance     vault.Instance         `yaml:"instance"`
+	Version      string                 `yaml:"version"`
+	Data         map[string]interface{} `yaml:"data"`
+	vault.Toggle `yaml:",inline"`
+}
+
+var _ vault.Item = entry{}
+
+func (e entry) Key() string {
+	return e.Path
+}
+
+func (e entry) KeyForType() string {
+	return ""
+}
+
+func (e entry) KeyForDescription() string {
+	return ""
+}
+
+func (e entry) Equals(i interface{}) bool {
+	entry, ok := i.(entry)
+	if !ok {
+		return false
+	}
+
+	return vault.EqualPathNames(e.Path, entry.Path) &&
+		dataEqual(e.Data, entry.Data)
+}
+
+// engineVersion returns the kv version of the engine the secret is stored in
+// defaulting to v1 when not specified
+func (e entry) engineVersion() string {
+	if e.Version == "" {
+		return vault.KV_V1
+	}
+	return e.Version
+}
+
+type config struct{}
+
+var _ toplevel.Configuration = config{}
+
+const toplevelName = "vault_secrets"
+
+func init() {
+	toplevel.RegisterConfiguration(toplevelName, config{}, "vault_secret_engines")
+}
+
+// Validate ensures each secret targets a supported kv version.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault Secret] failed to decode secret configuration: %v", err))
+	}
+	errs := []error{}
+	for _, e := range entries {
+		// secrets are never deleted so unmanaged secrets only need to be left out of the desired secrets
+		if e.Unmanaged() {
+			continue
+		}
+		if e.engineVersion() != vault.KV_V1 && e.engineVersion() != vault.KV_V2 {
+			errs = append(errs, errors.New(fmt.Sprintf("unsupported kv version '%s' for secret %s", e.Version, e.Path)))
+		}
+	}
+	return utils.JoinErrors(errs)
+}
+
+// Apply ensures that the configured key/value data is stored within an
+// instance of Vault.
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return nil, errors.New(fmt.Sprintf("[Vault Secret] failed to decode secret configuration: %v", err))
+	}
+	instancesToDesiredSecrets, err := desiredSecrets(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	existingSecrets, err := getExistingSecrets(address, instancesToDesiredSecrets[address], threadPoolSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// secrets are only ever written so that unchanged data does not create new kv v2 versions
+	toBeWritten, _, _ := vault.DiffItems(asItems(instancesToDesiredSecrets[address]), asItems(existingSecrets))
+	utils.RecordPendingChanges(address, toplevelName, len(toBeWritten))
+	plan := vault.NewPlan()
+	plan.Add("secret", toBeWritten, nil, nil, asItems(existingSecrets))
+
+	if dryRun == true {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate, len(toBeWritten))
+		for _, w := range toBeWritten {
+			vault.Logger(address, toplevelName).WithField("path", w.Key()).Info(
+				"[Dry Run] [Vault Secret] secret to be written")
+		}
+	} else {
+		for _, w := range toBeWritten {
+			ent := w.(entry)
+			err := vault.OverwriteSecret(address, ent.Path, ent.engineVersion(), ent.Data)
+			if err != nil {
+				return nil, err
+			}
+			utils.RecordOperation(address, toplevelName, utils.OperationCreate)
+			vault.Logger(address, toplevelName).WithField("path", ent.Path).Info(
+				"[Vault Secret] secret is successfully written to Vault instance")
+		}
+	}
+
+	return plan, nil
+}
+
+// desiredSecrets groups the managed secrets by instance, unmanaged secrets are left untouched in vault
+// and as secrets are never deleted they are neither read nor written
+func desiredSecrets(entries []entry) (map[string][]entry, error) {
+	instancesToDesiredSecrets := make(map[string][]entry)
+	for _, e := range entries {
+		if e.Unmanaged() {
+			continue
+		}
+		if e.engineVersion() != vault.KV_V1 && e.engineVersion() != vault.KV_V2 {
+			return nil, errors.New(fmt.Sprintf("unsupported kv version '%s' for secret %s", e.Version, e.Path))
+		}
+		e.Data = stringKeys(e.Data).(map[string]interface{})
+		instancesToDesiredSecrets[e.Instance.Key()] = append(instancesToDesiredSecrets[e.Instance.Key()], e)
+	}
+	return instancesToDesiredSecrets, nil
+}
+
+// stringKeys converts the maps nested within secret data to maps with string keys
+// yaml decodes nested maps with interface{} keys, which cannot be encoded to json when the secret is written
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for k, nv := range v {
+			converted[k] = stringKeys(nv)
+		}
+		return converted
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for k, nv := range v {
+			converted[fmt.Sprintf("%v", k)] = stringKeys(nv)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, nv := range v {
+			converted[i] = stringKeys(nv)
+		}
+		return converted
+	default:
+		return value
+	}
+}
+
+// getExistingSecrets reads the data currently stored at each desired path
+// paths without any data are omitted from the result
+func getExistingSecrets(address string, desired []entry, threadPoolSize int) ([]entry, error) {
+	existing := []entry{}
+
+	var mutex = &sync.Mutex{}
+	var readErr error
+	bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+
+	for _, e := range desired {
+		bwg.Add(1)
+
+		go func(e entry) {
+			defer bwg.Done()
+
+			data, err := vault.ReadSecret(address, e.Path, e.engineVersion())
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err != nil {
+				readErr = err
+				return
+			}
+			if data == nil {
+				return
+			}
+			existing = append(existing, entry{
+				Path:     e.Path,
+				Instance: vault.Instance{Address: address},
+				Version:  e.engineVersion(),
+				Data:     data,
+			})
+		}(e)
+	}
+	bwg.Wait()
+
+	return existing, readErr
+}
+
+// dataEqual compares decoded secret data
+// values are compared by their string representation as vault returns numbers
+// and booleans in a different form than they are unmarshalled from the configuration
+func dataEqual(x, y map[string]interface{}) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for k, xv := range x {
+		yv, ok := y[k]
+		if !ok {
+			return false
+		}
+		if fmt.Sprintf("%v", xv) != fmt.Sprintf("%v", yv) {
+			return false
+		}
+	}
+	return true
+}
+
+func asItems(xs []entry) (items []vault.Item) {
+	items = make([]vault.Item, 0)
+	for _, x := range xs {
+		items = append(items, x)
+	}
+
+	return
+}
@@ -0,0 +1,98 @@
+package secret
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestDataEqual(t *testing.T) {
+	table := []struct {
+		description string
+		x, y        map[string]interface{}
+		expected    bool
+	}{
+		{
+			description: "nil equals map of len(0)",
+			x:           nil,
+			y:           map[string]interface{}{},
+			expected:    true,
+		},
+		{
+			description: "same values are equal",
+			x:           map[string]interface{}{"user": "admin", "password": "x"},
+			y:           map[string]interface{}{"password": "x", "user": "admin"},
+			expected:    true,
+		},
+		{
+			description: "numbers decoded by vault equal configured numbers",
+			x:           map[string]interface{}{"port": 5432},
+			y:           map[string]interface{}{"port": json.Number("5432")},
+			expected:    true,
+		},
+		{
+			description: "changed value is not equal",
+			x:           map[string]interface{}{"password": "new"},
+			y:           map[string]interface{}{"password": "old"},
+			expected:    false,
+		},
+		{
+			description: "removed key is not equal",
+			x:           map[string]interface{}{"user": "admin"},
+			y:           map[string]interface{}{"user": "admin", "password": "x"},
+			expected:    false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, dataEqual(tt.x, tt.y))
+		})
+	}
+}
+
+func TestDesiredSecretsExcludeUnmanaged(t *testing.T) {
+	unmanaged := false
+	instance := vault.Instance{Address: "https://vault.test"}
+	entries := []entry{
+		{Path: "secret/a", Instance: instance},
+		{Path: "secret/b", Instance: instance, Toggle: vault.Toggle{Managed: &unmanaged}},
+		// the kv version of unmanaged secrets is not used and therefore not checked, as by Validate
+		{Path: "secret/c", Instance: instance, Version: "3", Toggle: vault.Toggle{Managed: &unmanaged}},
+	}
+
+	desired, err := desiredSecrets(entries)
+	require.NoError(t, err)
+	require.Len(t, desired[instance.Key()], 1)
+	require.Equal(t, "secret/a", desired[instance.Key()][0].Path)
+
+	_, err = desiredSecrets([]entry{{Path: "secret/d", Instance: instance, Version: "3"}})
+	require.Error(t, err)
+}
+
+func TestDesiredSecretsNestedData(t *testing.T) {
+	var entries []entry
+	require.NoError(t, yaml.Unmarshal([]byte(`
+- path: secret/nested
+  instance:
+    address: https://vault.test
+  data:
+    config:
+      endpoints:
+      - name: primary
+        port: 443
+`), &entries))
+
+	desired, err := desiredSecrets(entries)
+	require.NoError(t, err)
+	data := desired["https://vault.test"][0].Data
+	encoded, err := json.Marshal(data)
+	require.NoError(t, err, "nested maps decoded from yaml can be written to vault")
+
+	var existing map[string]interface{}
+	require.NoError(t, json.Unmarshal(encoded, &existing))
+	require.True(t, dataEqual(data, existing), "nested data read back from vault is unchanged")
+}
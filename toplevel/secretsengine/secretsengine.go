@@ -5,22 +5,136 @@
 package secretsengine
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 
+	"github.com/app-sre/vault-manager/pkg/utils"
 	"github.com/app-sre/vault-manager/pkg/vault"
 	"github.com/app-sre/vault-manager/toplevel"
 )
 
 type entry struct {
-	Path        string            `yaml:"_path"`
-	Type        string            `yaml:"type"`
-	Instance    vault.Instance    `yaml:"instance"`
-	Description string            `yaml:"description"`
-	Options     map[string]string `yaml:"options"`
+	Path        string         `yaml:"_path"`
+	Type        string         `yaml:"type"`
+	Instance    vault.Instance `yaml:"instance"`
+	Description string         `yaml:"description"`
+	Options     options        `yaml:"options"`
+	// OptionsMode is `replace` (default) to enforce exactly the configured options or `merge` to
+	// only enforce the configured options, leaving any other options set on the engine alone
+	OptionsMode string `yaml:"options_mode"`
+	// PluginVersion pins the plugin version used by the secrets engine
+	// an empty version leaves the plugin version unmanaged
+	PluginVersion string `yaml:"plugin_version"`
+	// ListingVisibility, PassthroughRequestHeaders and AllowedResponseHeaders are tuned on the
+	// secrets engine and are left unmanaged when unset
+	ListingVisibility         string   `yaml:"listing_visibility"`
+	PassthroughRequestHeaders []string `yaml:"passthrough_request_headers"`
+	AllowedResponseHeaders    []string `yaml:"allowed_response_headers"`
+	// AllowedManagedKeys are the names of managed keys the secrets engine may use, e.g. HSM-backed
+	// keys of pki and transit engines, and are left unmanaged when unset
+	AllowedManagedKeys []string `yaml:"allowed_managed_keys"`
+	// KVConfig is the engine-level configuration of kv version 2 secrets engines
+	KVConfig *kvConfig `yaml:"kv_config"`
+	// SealWrap enables seal wrapping of the engine's data, requires vault enterprise and
+	// cannot be changed once the engine is enabled
+	SealWrap bool `yaml:"seal_wrap"`
+	// Local marks the engine as local to the cluster, excluding it from replication, and
+	// cannot be changed once the engine is enabled
+	Local        bool `yaml:"local"`
+	vault.Toggle `yaml:",inline"`
+}
+
+// kvConfig is written to `<path>/config` of kv version 2 secrets engines
+// unset fields are left unmanaged
+type kvConfig struct {
+	MaxVersions        *int   `yaml:"max_versions"`
+	CasRequired        *bool  `yaml:"cas_required"`
+	DeleteVersionAfter string `yaml:"delete_version_after"`
+}
+
+// equals compares the fields set on the desired configuration c with the existing configuration
+func (c *kvConfig) equals(existing *kvConfig) bool {
+	if existing == nil {
+		return false
+	}
+	if c.MaxVersions != nil && (existing.MaxVersions == nil || *c.MaxVersions != *existing.MaxVersions) {
+		return false
+	}
+	if c.CasRequired != nil && (existing.CasRequired == nil || *c.CasRequired != *existing.CasRequired) {
+		return false
+	}
+	if c.DeleteVersionAfter != "" {
+		desired, err := vault.ParseDuration(c.DeleteVersionAfter)
+		if err != nil {
+			return false
+		}
+		actual, err := vault.ParseDuration(existing.DeleteVersionAfter)
+		if err != nil || desired != actual {
+			return false
+		}
+	}
+	return true
+}
+
+// differences returns the fields set on the desired configuration c that differ from the existing configuration
+func (c *kvConfig) differences(existing *kvConfig) []vault.FieldDiff {
+	if existing == nil {
+		existing = &kvConfig{}
+	}
+	diffs := []vault.FieldDiff{}
+	if c.MaxVersions != nil && (existing.MaxVersions == nil || *c.MaxVersions != *existing.MaxVersions) {
+		var actual interface{}
+		if existing.MaxVersions != nil {
+			actual = *existing.MaxVersions
+		}
+		diffs = append(diffs, vault.FieldDiff{Field: "kv_config.max_versions", Desired: *c.MaxVersions, Existing: actual})
+	}
+	if c.CasRequired != nil && (existing.CasRequired == nil || *c.CasRequired != *existing.CasRequired) {
+		var actual interface{}
+		if existing.CasRequired != nil {
+			actual = *existing.CasRequired
+		}
+		diffs = append(diffs, vault.FieldDiff{Field: "kv_config.cas_required", Desired: *c.CasRequired, Existing: actual})
+	}
+	if c.DeleteVersionAfter != "" && !(&kvConfig{DeleteVersionAfter: c.DeleteVersionAfter}).equals(existing) {
+		var actual interface{}
+		if existing.DeleteVersionAfter != "" {
+			actual = existing.DeleteVersionAfter
+		}
+		diffs = append(diffs, vault.FieldDiff{Field: "kv_config.delete_version_after", Desired: c.DeleteVersionAfter, Existing: actual})
+	}
+	return diffs
+}
+
+// enablesCasRequired determines if writing the desired configuration c turns on `cas_required` for an existing
+// engine, which rejects writes by clients that do not send the version they expect to overwrite
+func (c *kvConfig) enablesCasRequired(existing *kvConfig) bool {
+	if c == nil || c.CasRequired == nil || !*c.CasRequired {
+		return false
+	}
+	return existing == nil || existing.CasRequired == nil || !*existing.CasRequired
+}
+
+func (c *kvConfig) data() map[string]interface{} {
+	data := make(map[string]interface{})
+	if c.MaxVersions != nil {
+		data["max_versions"] = *c.MaxVersions
+	}
+	if c.CasRequired != nil {
+		data["cas_required"] = *c.CasRequired
+	}
+	if c.DeleteVersionAfter != "" {
+		data["delete_version_after"] = c.DeleteVersionAfter
+	}
+	return data
 }
 
 var _ vault.Item = entry{}
@@ -38,7 +152,129 @@ func (e entry) Equals(i interface{}) bool {
 	return vault.EqualPathNames(e.Path, entry.Path) &&
 		e.Type == entry.Type &&
 		e.Description == entry.Description &&
-		vault.OptionsEqual(e.ambiguousOptions(), entry.ambiguousOptions())
+		e.SealWrap == entry.SealWrap &&
+		e.Local == entry.Local &&
+		vault.OptionsEqual(e.ambiguousOptions(), entry.comparedOptions(e)) &&
+		(e.PluginVersion == "" || e.PluginVersion == entry.PluginVersion) &&
+		(e.ListingVisibility == "" || e.ListingVisibility == entry.ListingVisibility) &&
+		(e.PassthroughRequestHeaders == nil || headersEqual(e.PassthroughRequestHeaders, entry.PassthroughRequestHeaders)) &&
+		(e.AllowedResponseHeaders == nil || headersEqual(e.AllowedResponseHeaders, entry.AllowedResponseHeaders)) &&
+		(e.AllowedManagedKeys == nil || equalStrings(e.AllowedManagedKeys, entry.AllowedManagedKeys)) &&
+		(e.KVConfig == nil || e.KVConfig.equals(entry.KVConfig))
+}
+
+// differences returns the settings compared by Equals that differ from an existing engine at the same path
+// sensitive option values are redacted
+func (e entry) differences(existing entry) []vault.FieldDiff {
+	diffs := []vault.FieldDiff{}
+	add := func(field string, desired, actual interface{}) {
+		diffs = append(diffs, vault.FieldDiff{Field: field, Desired: desired, Existing: actual})
+	}
+	if e.Type != existing.Type {
+		add("type", e.Type, existing.Type)
+	}
+	if e.Description != existing.Description {
+		add("description", e.Description, existing.Description)
+	}
+	if e.SealWrap != existing.SealWrap {
+		add("seal_wrap", e.SealWrap, existing.SealWrap)
+	}
+	if e.Local != existing.Local {
+		add("local", e.Local, existing.Local)
+	}
+	redact := func(option string, v interface{}) interface{} {
+		if v == nil {
+			return nil
+		}
+		return utils.RedactOptions(map[string]interface{}{option: v})[option]
+	}
+	for _, d := range vault.OptionsDifferences("options.", e.ambiguousOptions(), existing.comparedOptions(e)) {
+		option := strings.TrimPrefix(d.Field, "options.")
+		d.Desired, d.Existing = redact(option, d.Desired), redact(option, d.Existing)
+		diffs = append(diffs, d)
+	}
+	if e.PluginVersion != "" && e.PluginVersion != existing.PluginVersion {
+		add("plugin_version", e.PluginVersion, existing.PluginVersion)
+	}
+	if e.ListingVisibility != "" && e.ListingVisibility != existing.ListingVisibility {
+		add("listing_visibility", e.ListingVisibility, existing.ListingVisibility)
+	}
+	if e.PassthroughRequestHeaders != nil && !headersEqual(e.PassthroughRequestHeaders, existing.PassthroughRequestHeaders) {
+		add("passthrough_request_headers", e.PassthroughRequestHeaders, existing.PassthroughRequestHeaders)
+	}
+	if e.AllowedResponseHeaders != nil && !headersEqual(e.AllowedResponseHeaders, existing.AllowedResponseHeaders) {
+		add("allowed_response_headers", e.AllowedResponseHeaders, existing.AllowedResponseHeaders)
+	}
+	if e.AllowedManagedKeys != nil && !equalStrings(e.AllowedManagedKeys, existing.AllowedManagedKeys) {
+		add("allowed_managed_keys", e.AllowedManagedKeys, existing.AllowedManagedKeys)
+	}
+	if e.KVConfig != nil {
+		diffs = append(diffs, e.KVConfig.differences(existing.KVConfig)...)
+	}
+	return diffs
+}
+
+// recreateFields returns the settings differing from an existing engine that cannot be tuned
+func (e entry) recreateFields(existing entry) []string {
+	changed := []string{}
+	if e.Type != existing.Type {
+		changed = append(changed, "type")
+	}
+	if e.SealWrap != existing.SealWrap {
+		changed = append(changed, "seal_wrap")
+	}
+	if e.Local != existing.Local {
+		changed = append(changed, "local")
+	}
+	return changed
+}
+
+// mountInput returns the input enabling the secrets engine
+func (e entry) mountInput() *api.MountInput {
+	return &api.MountInput{
+		Type:        e.Type,
+		Description: e.Description,
+		Options:     e.Options,
+		SealWrap:    e.SealWrap,
+		Local:       e.Local,
+		Config: api.MountConfigInput{
+			PluginVersion:             e.PluginVersion,
+			ListingVisibility:         e.ListingVisibility,
+			PassthroughRequestHeaders: e.PassthroughRequestHeaders,
+			AllowedResponseHeaders:    e.AllowedResponseHeaders,
+			AllowedManagedKeys:        e.AllowedManagedKeys,
+		},
+	}
+}
+
+// headersEqual compares lists of header names regardless of order and case
+func headersEqual(x, y []string) bool {
+	lower := func(xs []string) []string {
+		lowered := make([]string, 0, len(xs))
+		for _, x := range xs {
+			lowered = append(lowered, strings.ToLower(x))
+		}
+		return lowered
+	}
+	return equalStrings(lower(x), lower(y))
+}
+
+// equalStrings compares lists of strings regardless of order
+func equalStrings(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	counts := make(map[string]int, len(x))
+	for _, s := range x {
+		counts[s]++
+	}
+	for _, s := range y {
+		counts[s]--
+		if counts[s] < 0 {
+			return false
+		}
+	}
+	return true
 }
 
 func (e entry) KeyForDescription() string {
@@ -49,6 +285,31 @@ func (e entry) KeyForType() string {
 	return e.Type
 }
 
+// options of a secrets engine may be configured as typed values, e.g. `version: 2` or `force_no_cache: true`,
+// and are normalized to the strings vault stores them as
+type options map[string]string
+
+func (o *options) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw map[string]interface{}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		*o = nil
+		return nil
+	}
+	normalized := make(options, len(raw))
+	for k, v := range raw {
+		s, err := utils.OptionString(k, v)
+		if err != nil {
+			return errors.New(fmt.Sprintf("[Vault Secrets engine] %v", err))
+		}
+		normalized[k] = s
+	}
+	*o = normalized
+	return nil
+}
+
 func (e entry) ambiguousOptions() map[string]interface{} {
 	opts := make(map[string]interface{}, len(e.Options))
 	for k, v := range e.Options {
@@ -57,117 +318,899 @@ func (e entry) ambiguousOptions() map[string]interface{} {
 	return opts
 }
 
+// options modes supported by secrets engines
+const (
+	optionsReplace = "replace"
+	optionsMerge   = "merge"
+)
+
+// comparedOptions returns the options of an existing engine that are compared with the desired engine
+// in merge mode options that are not configured on the desired engine are left out
+func (e entry) comparedOptions(desired entry) map[string]interface{} {
+	opts := e.ambiguousOptions()
+	if desired.OptionsMode != optionsMerge {
+		return opts
+	}
+	for k := range opts {
+		if _, ok := desired.Options[k]; !ok {
+			delete(opts, k)
+		}
+	}
+	return opts
+}
+
 type config struct{}
 
 var _ toplevel.Configuration = config{}
 
+const toplevelName = "vault_secret_engines"
+
 func init() {
-	toplevel.RegisterConfiguration("vault_secret_engines", config{})
+	toplevel.RegisterConfiguration(toplevelName, config{}, "vault_plugins", "vault_managed_keys")
+}
+
+// supportedTypes are the secrets engine types that may be enabled
+var supportedTypes = map[string]bool{
+	"ad":           true,
+	"alicloud":     true,
+	"aws":          true,
+	"azure":        true,
+	"consul":       true,
+	"database":     true,
+	"gcp":          true,
+	"gcpkms":       true,
+	"keymgmt":      true,
+	"kmip":         true,
+	"kubernetes":   true,
+	"kv":           true,
+	"ldap":         true,
+	"mongodbatlas": true,
+	"nomad":        true,
+	"openldap":     true,
+	"pki":          true,
+	"rabbitmq":     true,
+	"ssh":          true,
+	"terraform":    true,
+	"totp":         true,
+	"transform":    true,
+	"transit":      true,
+}
+
+// listingVisibilities are the values accepted by vault for the listing visibility of a secrets engine
+var listingVisibilities = map[string]bool{
+	"":       true,
+	"hidden": true,
+	"unauth": true,
+}
+
+// Validate ensures each secrets engine has a supported type and a well-formed path.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault Secrets engine] failed to decode secrets engines configuration: %v", err))
+	}
+	errs := []error{}
+	for _, e := range entries {
+		if !validPath(e.Path) {
+			errs = append(errs, errors.New(fmt.Sprintf("[Vault Secrets engine] malformed path `%s`", e.Path)))
+		}
+		if !supportedTypes[e.Type] {
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault Secrets engine] unsupported type `%s` for secrets-engine `%s`", e.Type, e.Path)))
+		}
+		if v := e.Options["version"]; e.Type == "kv" && v != "" && v != kvV1 && v != kvV2 {
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault Secrets engine] unsupported kv version `%s` for secrets-engine `%s`", v, e.Path)))
+		}
+		if e.OptionsMode != "" && e.OptionsMode != optionsReplace && e.OptionsMode != optionsMerge {
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault Secrets engine] unsupported options_mode `%s` for secrets-engine `%s`, must be merge or replace",
+				e.OptionsMode, e.Path)))
+		}
+		if !listingVisibilities[e.ListingVisibility] {
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault Secrets engine] unsupported listing_visibility `%s` for secrets-engine `%s`, must be hidden or unauth",
+				e.ListingVisibility, e.Path)))
+		}
+		if e.KVConfig != nil {
+			if e.Type != "kv" || e.Options["version"] == kvV1 {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Secrets engine] kv_config is only supported by kv version 2 secrets-engines, found on `%s`", e.Path)))
+			}
+			if e.KVConfig.MaxVersions != nil && *e.KVConfig.MaxVersions < 0 {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Secrets engine] `max_versions` of secrets-engine `%s` must not be negative", e.Path)))
+			}
+			if d := e.KVConfig.DeleteVersionAfter; d != "" {
+				if _, err := vault.ParseDuration(d); err != nil {
+					errs = append(errs, errors.New(fmt.Sprintf(
+						"[Vault Secrets engine] invalid `delete_version_after` of secrets-engine `%s`: %v", e.Path, err)))
+				}
+			}
+		}
+	}
+	return utils.JoinErrors(errs)
+}
+
+// validPath determines if a path can be used to mount a secrets engine
+// paths must be relative, contain no empty, relative or whitespace segments
+// and must not collide with the builtin mounts
+func validPath(path string) bool {
+	trimmed := strings.TrimSuffix(path, "/")
+	if trimmed == "" || strings.HasPrefix(trimmed, "/") || strings.ContainsAny(trimmed, " \t\n") {
+		return false
+	}
+	for _, segment := range strings.Split(trimmed, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	switch strings.Split(trimmed, "/")[0] {
+	case "sys", "cubbyhole", "identity", "auth":
+		return false
+	}
+	return true
 }
 
 // TODO(dwelch) refactor into multiple functions
 // Apply ensures that an instance of Vault's secrets engine are configured
 // exactly as provided.
-func (c config) Apply(address string, entriesBytes []byte, dryRun bool, threadPoolSize int) error {
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
 	// Unmarshal the list of configured secrets engines.
 	var entries []entry
 	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
-		log.WithError(err).Fatal("[Vault Secrets engine] failed to decode secrets engines configuration")
+		return nil, errors.New(fmt.Sprintf("[Vault Secrets engine] failed to decode secrets engines configuration: %v", err))
 	}
+	// paths are compared by key when determining changes so desired and existing engines
+	// both use the form vault reports mounts in, e.g. `aws` is configured as `aws/`
 	instancesToDesiredEngines := make(map[string][]entry)
 	for _, e := range entries {
-		instancesToDesiredEngines[e.Instance.Address] = append(instancesToDesiredEngines[e.Instance.Address], e)
+		e.Path = vault.MountPath(e.Path)
+		instancesToDesiredEngines[e.Instance.Key()] = append(instancesToDesiredEngines[e.Instance.Key()], e)
 	}
 
 	enabledSecretEngines, err := vault.ListSecretsEngines(address)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	existingSecretEngines := []entry{}
 	for path, engine := range enabledSecretEngines {
 		existingSecretEngines = append(existingSecretEngines, entry{
-			Path:        path,
-			Type:        engine.Type,
-			Description: engine.Description,
-			Options:     engine.Options,
+			Path:          vault.MountPath(path),
+			Type:          engine.Type,
+			Description:   engine.Description,
+			Options:       engine.Options,
+			PluginVersion: engine.PluginVersion,
+			// listing visibility is reported as empty by vault when hidden
+			ListingVisibility:         defaultListingVisibility(engine.Config.ListingVisibility),
+			PassthroughRequestHeaders: engine.Config.PassthroughRequestHeaders,
+			AllowedResponseHeaders:    engine.Config.AllowedResponseHeaders,
+			AllowedManagedKeys:        engine.Config.AllowedManagedKeys,
+			SealWrap:                  engine.SealWrap,
+			Local:                     engine.Local,
 		})
 	}
+
+	instancesToDesiredEngines[address], existingSecretEngines =
+		excludeUnmanaged(instancesToDesiredEngines[address], existingSecretEngines)
+	kvVersion, err := defaultKvVersion()
+	if err != nil {
+		return nil, err
+	}
+	applyKvVersionDefaults(instancesToDesiredEngines[address], kvVersion)
+	applyKvVersionDefaults(existingSecretEngines, kvV1)
+	err = checkKvVersions(instancesToDesiredEngines[address], existingSecretEngines)
+	if err != nil {
+		vault.Logger(address, toplevelName).WithError(err).Info(
+			"[Vault Secrets engine] kv version of existing secrets-engine cannot be changed")
+		return nil, err
+	}
+	skipKvV1Configs(address, instancesToDesiredEngines[address])
+	err = readKvConfigs(address, instancesToDesiredEngines[address], existingSecretEngines, threadPoolSize)
+	if err != nil {
+		return nil, err
+	}
+
 	toBeWritten, toBeDeleted, toBeUpdated :=
 		vault.DiffItems(asItems(instancesToDesiredEngines[address]), asItems(existingSecretEngines))
+	toBeWritten, toBeUpdated = determineTuneUpdates(toBeWritten, toBeUpdated, existingSecretEngines)
+	// an engine whose path changed would otherwise be enabled anew while the existing engine and its data are deleted
+	toBeWritten, toBeDeleted, pathChanges := detectPathChanges(address, prune, toBeWritten, toBeDeleted, existingSecretEngines)
+	utils.RecordPendingChanges(address, toplevelName,
+		len(toBeWritten)+len(toBeUpdated)+len(pathChanges)+len(vault.ExcludeItems(toBeDeleted, isDefault)))
+	moved := []pathChange{}
+	for _, p := range pathChanges {
+		if !vault.Remount() {
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"path":          p.desired.Path,
+				"existing_path": p.existing.Path,
+				"type":          p.desired.Type,
+			}).Warn("[Vault Secrets engine] secrets-engine appears to have moved, moving it with its data requires `-allow-remount`")
+			vault.RecordFailure(address, toplevelName, "remount", p.desired.Path, errors.New(fmt.Sprintf(
+				"path of secrets-engine `%s` changed to `%s`, moving it requires `-allow-remount`", p.existing.Path, p.desired.Path)))
+			continue
+		}
+		moved = append(moved, p)
+	}
+	if !prune {
+		toBeDeleted = vault.SkipDeletes(address, "[Vault Secrets engine] secrets-engine", toBeDeleted, isDefault)
+	}
+	// the type, seal wrapping and locality of a secrets engine cannot be changed in place so the existing engine must be disabled first
+	toBeWritten, recreated := determineTypeChanges(toBeWritten, existingSecretEngines)
+	for _, r := range recreated {
+		if !vault.ForceRecreate() {
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"path":          r.desired.Path,
+				"type":          r.desired.Type,
+				"existing_type": r.existing.Type,
+				"changed":       r.desired.recreateFields(r.existing),
+			}).Error("[Vault Secrets engine] secrets-engine cannot be changed without `-force-recreate`, a manual migration is required")
+			vault.RecordFailure(address, toplevelName, "recreate", r.desired.Path, errors.New(fmt.Sprintf(
+				"%s cannot be changed without `-force-recreate`", strings.Join(r.desired.recreateFields(r.existing), ", "))))
+			continue
+		}
+		toBeWritten = append(toBeWritten, r.desired)
+		toBeDeleted = append(toBeDeleted, r.existing)
+	}
+	// builtin and protected engines are never disabled, so they are excluded before both dry runs and applies
+	toBeDeleted = vault.ExcludeItems(toBeDeleted, isDefault)
+	// builtin engines are only excluded now so that they are not counted against `-max-deletes`,
+	// e.g. those of a newly initialized instance that are missing from the configuration
+	if err := vault.CheckDeletes(address, "[Vault Secrets engine]", toBeDeleted); err != nil {
+		return nil, err
+	}
+	plan := vault.NewPlan()
+	plan.Add("secrets-engine", toBeWritten, toBeUpdated, toBeDeleted, asItems(existingSecretEngines))
+	movedTo, movedFrom := make([]vault.Item, 0), make([]vault.Item, 0)
+	for _, m := range moved {
+		movedTo = append(movedTo, m.desired)
+		movedFrom = append(movedFrom, m.existing)
+	}
+	plan.Add("secrets-engine-move", movedTo, nil, movedFrom, nil)
 
 	if dryRun == true {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate, len(toBeWritten))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationUpdate, len(toBeUpdated)+len(moved))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationDelete, len(toBeDeleted))
 		for _, w := range toBeWritten {
-			log.WithFields(log.Fields{
-				"path":     w.Key(),
-				"type":     w.(entry).Type,
-				"instance": address,
-			}).Info("[Dry Run] [Vault Secrets engine] secrets-engine to be enabled")
+			fields := log.Fields{
+				"path":    w.Key(),
+				"type":    w.(entry).Type,
+				"options": utils.RedactStringOptions(w.(entry).Options),
+			}
+			// engines already enabled at the path are updated, the differences explain why, e.g. an option
+			// defaulted by vault that is not configured and could be added to the configuration or left out with merge mode
+			if existing, ok := existingAt(w.Key(), existingSecretEngines); ok {
+				fields["differences"] = formatDifferences(w.(entry).differences(existing))
+			}
+			vault.Logger(address, toplevelName).WithFields(fields).Info(
+				"[Dry Run] [Vault Secrets engine] secrets-engine to be enabled")
+			warnCasRequired(address, w.(entry), existingSecretEngines)
 		}
 		for _, u := range toBeUpdated {
-			log.WithFields(log.Fields{
-				"path":     u.Key(),
-				"type":     u.(entry).Type,
-				"instance": address,
-			}).Info("[Dry Run] [Vault Secrets engine] secrets-engine to be updated")
+			fields := log.Fields{
+				"path":    u.Key(),
+				"type":    u.(entry).Type,
+				"options": utils.RedactStringOptions(u.(entry).Options),
+			}
+			if existing, ok := existingAt(u.Key(), existingSecretEngines); ok {
+				fields["differences"] = formatDifferences(u.(entry).differences(existing))
+			}
+			vault.Logger(address, toplevelName).WithFields(fields).Info(
+				"[Dry Run] [Vault Secrets engine] secrets-engine to be updated")
+			warnCasRequired(address, u.(entry), existingSecretEngines)
+		}
+		for _, m := range moved {
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"path":          m.desired.Path,
+				"existing_path": m.existing.Path,
+				"type":          m.desired.Type,
+			}).Info("[Dry Run] [Vault Secrets engine] secrets-engine to be moved")
 		}
 		for _, d := range toBeDeleted {
-			if !isDefaultMount(d.Key()) {
-				log.WithFields(log.Fields{
-					"path":     d.Key(),
-					"type":     d.(entry).Type,
-					"instance": address,
-				}).Info("[Dry Run] [Vault Secrets engine] secrets-engine to be disabled")
-			}
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"path": d.Key(),
+				"type": d.(entry).Type,
+			}).Info("[Dry Run] [Vault Secrets engine] secrets-engine to be disabled")
 		}
 	} else {
 		// TODO(riuvshin): implement tuning
-		for _, e := range toBeWritten {
-			ent := e.(entry)
-			err := vault.EnableSecretsEngine(address, ent.Path, &api.MountInput{
-				Type:        ent.Type,
-				Description: ent.Description,
-				Options:     ent.Options,
-			})
+		// operations are grouped by path so that operations targeting the same
+		// path are performed in order while separate paths are reconciled in parallel
+		var mutex = &sync.Mutex{}
+		var applyErr error
+		bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+
+		for _, ops := range groupOperationsByPath(toBeWritten, toBeUpdated, toBeDeleted, moved, existingSecretEngines) {
+			bwg.Add(1)
+
+			go func(ops []operation) {
+				defer bwg.Done()
+
+				for _, op := range ops {
+					err := op.apply(address)
+					if err != nil {
+						mutex.Lock()
+						defer mutex.Unlock()
+						if applyErr == nil {
+							applyErr = err
+						}
+						return
+					}
+				}
+			}(ops)
+		}
+		bwg.Wait()
+
+		if applyErr != nil {
+			return nil, applyErr
+		}
+	}
+	return plan, nil
+}
+
+const (
+	kvV1 = "1"
+	kvV2 = "2"
+)
+
+// defaultKvVersion returns the version assigned to kv secrets engines that do not specify one
+// configurable via the `KV_DEFAULT_VERSION` env var and defaults to vault's own default of 1
+func defaultKvVersion() (string, error) {
+	switch v := os.Getenv("KV_DEFAULT_VERSION"); v {
+	case kvV1, kvV2:
+		return v, nil
+	case "":
+		return kvV1, nil
+	default:
+		return "", errors.New(fmt.Sprintf("[Vault Secrets engine] `KV_DEFAULT_VERSION` must be 1 or 2, not `%s`", v))
+	}
+}
+
+// excludeUnmanaged removes unmanaged secrets engines from the desired engines along with the
+// existing engines of the same path, before the engines are read or compared
+func excludeUnmanaged(desired, existing []entry) ([]entry, []entry) {
+	managed := []entry{}
+	unmanaged := []entry{}
+	for _, d := range desired {
+		if d.Unmanaged() {
+			unmanaged = append(unmanaged, d)
+			continue
+		}
+		managed = append(managed, d)
+	}
+	remaining := []entry{}
+	for _, e := range existing {
+		excluded := false
+		for _, u := range unmanaged {
+			if vault.EqualPathNames(e.Path, u.Path) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			remaining = append(remaining, e)
+		}
+	}
+	return managed, remaining
+}
+
+// applyKvVersionDefaults sets the version option of kv secrets engines that do not specify one
+func applyKvVersionDefaults(engines []entry, version string) {
+	for i := range engines {
+		if engines[i].Type != "kv" {
+			continue
+		}
+		if engines[i].Options == nil {
+			engines[i].Options = make(map[string]string)
+		}
+		if engines[i].Options["version"] == "" {
+			engines[i].Options["version"] = version
+		}
+	}
+}
+
+// skipKvV1Configs drops the kv config of desired engines that are not kv version 2
+// the kv version may only be known once the default version is applied
+func skipKvV1Configs(address string, engines []entry) {
+	for i := range engines {
+		if engines[i].KVConfig != nil && engines[i].Options["version"] != kvV2 {
+			vault.Logger(address, toplevelName).WithField("path", engines[i].Path).Warn(
+				"[Vault Secrets engine] kv_config is only supported by kv version 2 secrets-engines, skipping")
+			engines[i].KVConfig = nil
+		}
+	}
+}
+
+// readKvConfigs reads the kv config of existing kv version 2 engines whose config is desired
+func readKvConfigs(address string, desired, existing []entry, threadPoolSize int) error {
+	managed := make(map[string]bool)
+	for _, d := range desired {
+		if d.KVConfig != nil {
+			managed[d.Path] = true
+		}
+	}
+
+	var mutex = &sync.Mutex{}
+	var readErr error
+	bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+
+	for i := range existing {
+		if existing[i].Type != "kv" || existing[i].Options["version"] != kvV2 || !managed[existing[i].Path] {
+			continue
+		}
+		bwg.Add(1)
+
+		go func(e *entry) {
+			defer bwg.Done()
+
+			data, err := vault.ReadRaw(address, e.Path+"config")
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
 			if err != nil {
-				return err
+				readErr = err
+				return
+			}
+			if data == nil {
+				return
+			}
+			e.KVConfig = kvConfigFromData(data)
+		}(&existing[i])
+	}
+	bwg.Wait()
+	return readErr
+}
+
+// kvConfigFromData converts the kv config read from vault into a kvConfig
+func kvConfigFromData(data map[string]interface{}) *kvConfig {
+	c := &kvConfig{}
+	if v, err := strconv.Atoi(fmt.Sprintf("%v", data["max_versions"])); err == nil {
+		c.MaxVersions = &v
+	}
+	if v, err := strconv.ParseBool(fmt.Sprintf("%v", data["cas_required"])); err == nil {
+		c.CasRequired = &v
+	}
+	if v, ok := data["delete_version_after"]; ok && v != nil {
+		c.DeleteVersionAfter = fmt.Sprintf("%v", v)
+	}
+	return c
+}
+
+// checkKvVersions returns an error if a desired kv secrets engine differs in version from
+// the existing engine at the same path. upgrading existing engines is not supported.
+func checkKvVersions(desired, existing []entry) error {
+	for _, d := range desired {
+		if d.Type != "kv" {
+			continue
+		}
+		for _, e := range existing {
+			if e.Type == "kv" && vault.EqualPathNames(d.Path, e.Path) &&
+				d.Options["version"] != e.Options["version"] {
+				return errors.New(fmt.Sprintf(
+					"kv version of secrets-engine `%s` cannot be changed from %s to %s",
+					d.Path, e.Options["version"], d.Options["version"]))
 			}
 		}
+	}
+	return nil
+}
+
+const (
+	disableAction = iota
+	remountAction
+	enableAction
+	updateAction
+)
+
+// operation represents a single change to be made to a secrets engine
+type operation struct {
+	action int
+	entry  entry
+	// from is the existing engine moved to the path of entry by a remount
+	from entry
+	// existing is the engine currently enabled at the path of an update, if known,
+	// so that only the settings that changed are tuned
+	existing *entry
+}
 
-		for _, e := range toBeUpdated {
-			ent := e.(entry)
-			err := vault.UpdateSecretsEngine(address, ent.Path, api.MountConfigInput{
-				// vault.UpdateSecretsEngine(ent.Path, &api.MountInput{
-				Description: &ent.Description,
-			})
+func (o operation) apply(address string) error {
+	switch o.action {
+	case enableAction:
+		err := vault.EnableSecretsEngine(address, o.entry.Path, o.entry.mountInput())
+		if err == vault.ErrPathInUse {
+			// the engine was enabled since existing engines were listed, e.g. by an overlapping run
+			return o.updateEnabled(address)
+		}
+		if err != nil {
+			return err
+		}
+		err = o.writeKvConfig(address)
+		if err != nil {
+			return err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationCreate)
+	case updateAction:
+		changed := o.changedFields()
+		if input, ok := o.entry.tuneInput(changed); ok {
+			err := vault.UpdateSecretsEngine(address, o.entry.Path, input)
 			if err != nil {
 				return err
 			}
 		}
-
-		for _, e := range toBeDeleted {
-			ent := e.(entry)
-			if !isDefaultMount(ent.Path) {
-				err := vault.DisableSecretsEngine(address, ent.Path)
-				if err != nil {
-					return err
-				}
+		if o.entry.PluginVersion != "" && (changed == nil || changed["plugin_version"]) {
+			// a tuned plugin version only takes effect once the plugin is reloaded
+			err := vault.ReloadPlugin(address, o.entry.Path)
+			if err != nil {
+				return err
 			}
 		}
+		if changed == nil || changed["kv_config"] {
+			err := o.writeKvConfig(address)
+			if err != nil {
+				return err
+			}
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationUpdate)
+	case disableAction:
+		err := vault.DisableSecretsEngine(address, o.entry.Path)
+		if err != nil {
+			return err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationDelete)
+	case remountAction:
+		err := vault.RemountSecretsEngine(address, o.from.Path, o.entry.Path)
+		if err != nil {
+			return err
+		}
+		movedEngine := o.from
+		movedEngine.Path = o.entry.Path
+		if !o.entry.Equals(movedEngine) {
+			// settings such as the description may have changed along with the path
+			return operation{action: updateAction, entry: o.entry, existing: &movedEngine}.apply(address)
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationUpdate)
 	}
 	return nil
 }
 
-func isDefaultMount(path string) bool {
-	switch {
-	case strings.HasPrefix(path, "cubbyhole/"),
-		strings.HasPrefix(path, "identity/"),
-		strings.HasPrefix(path, "secret/"),
-		strings.HasPrefix(path, "sys/"):
-		return true
-	default:
+// updateEnabled tunes a secrets engine that could not be enabled as its path is already in use
+// the engine is only tuned if the engine now mounted at the path can be tuned into the desired engine
+func (o operation) updateEnabled(address string) error {
+	engines, err := vault.ListSecretsEngines(address)
+	if err != nil {
+		return err
+	}
+	for path, engine := range engines {
+		if !vault.EqualPathNames(path, o.entry.Path) {
+			continue
+		}
+		if engine.Type != o.entry.Type {
+			return errors.New(fmt.Sprintf("[Vault Secrets engine] path `%s` is already in use by a %s secrets-engine",
+				o.entry.Path, engine.Type))
+		}
+		enabled := entry{Type: engine.Type, SealWrap: engine.SealWrap, Local: engine.Local}
+		if changed := o.entry.recreateFields(enabled); len(changed) > 0 {
+			return errors.New(fmt.Sprintf("[Vault Secrets engine] %s of secrets-engine `%s` cannot be changed",
+				strings.Join(changed, ", "), o.entry.Path))
+		}
+		vault.Logger(address, toplevelName).WithField("path", o.entry.Path).Info(
+			"[Vault Secrets engine] secrets-engine is already enabled, updating it instead")
+		return operation{action: updateAction, entry: o.entry}.apply(address)
+	}
+	return vault.ErrPathInUse
+}
+
+// warnCasRequired warns during a dry run that `cas_required` is to be enabled on an existing kv engine
+// new engines have no clients yet that could be broken by it
+func warnCasRequired(address string, desired entry, existingEngines []entry) {
+	existing, ok := existingAt(desired.Key(), existingEngines)
+	if !ok || !desired.KVConfig.enablesCasRequired(existing.KVConfig) {
+		return
+	}
+	vault.Logger(address, toplevelName).WithField("path", desired.Key()).Warn(
+		"[Dry Run] [Vault Secrets engine] `cas_required` is to be enabled, writes to the secrets-engine " +
+			"that do not set `options.cas` will be rejected, ensure all clients writing to it send the version")
+}
+
+// changedFields returns the settings of an update that differ from the existing engine, kv config fields
+// are reported as `kv_config`. nil is returned if the existing engine is unknown, in which case all
+// settings are applied
+func (o operation) changedFields() map[string]bool {
+	if o.existing == nil {
+		return nil
+	}
+	changed := make(map[string]bool)
+	for _, d := range o.entry.differences(*o.existing) {
+		field := d.Field
+		if strings.HasPrefix(field, "kv_config.") {
+			field = "kv_config"
+		}
+		changed[field] = true
+	}
+	return changed
+}
+
+// tuneInput returns the input tuning the changed settings of the engine, e.g. only the description
+// if nothing else changed, and whether any setting is to be tuned. All tunable settings are
+// included if changed is nil
+func (e entry) tuneInput(changed map[string]bool) (api.MountConfigInput, bool) {
+	all := changed == nil
+	input := api.MountConfigInput{}
+	tuned := all
+	if all || changed["description"] {
+		input.Description = &e.Description
+		tuned = true
+	}
+	if all || changed["plugin_version"] {
+		input.PluginVersion = e.PluginVersion
+		tuned = tuned || e.PluginVersion != ""
+	}
+	if all || changed["listing_visibility"] {
+		input.ListingVisibility = e.ListingVisibility
+		tuned = tuned || e.ListingVisibility != ""
+	}
+	if all || changed["passthrough_request_headers"] {
+		input.PassthroughRequestHeaders = e.PassthroughRequestHeaders
+		tuned = tuned || e.PassthroughRequestHeaders != nil
+	}
+	if all || changed["allowed_response_headers"] {
+		input.AllowedResponseHeaders = e.AllowedResponseHeaders
+		tuned = tuned || e.AllowedResponseHeaders != nil
+	}
+	if all || changed["allowed_managed_keys"] {
+		input.AllowedManagedKeys = e.AllowedManagedKeys
+		tuned = tuned || e.AllowedManagedKeys != nil
+	}
+	return input, tuned
+}
+
+// writeKvConfig writes the desired kv config of a kv version 2 secrets engine, if any
+func (o operation) writeKvConfig(address string) error {
+	if o.entry.KVConfig == nil {
+		return nil
+	}
+	err := vault.WriteRaw(address, o.entry.Path+"config", o.entry.KVConfig.data())
+	if err != nil {
+		return err
+	}
+	vault.Logger(address, toplevelName).WithField("path", o.entry.Path).Info(
+		"[Vault Secrets engine] kv config is successfully written")
+	return nil
+}
+
+// determineTuneUpdates moves desired secrets engines that only differ from the existing
+// engine at the same path by tunable settings, such as the plugin version, listing
+// visibility or kv config, from the to be written set to the to be updated set so that the settings
+// are tuned rather than the engine re-enabled
+func determineTuneUpdates(toBeWritten, toBeUpdated []vault.Item, existing []entry) ([]vault.Item, []vault.Item) {
+	written := make([]vault.Item, 0)
+	for _, w := range toBeWritten {
+		ent := w.(entry)
+		tuneChanged := false
+		for _, e := range existing {
+			if !vault.EqualPathNames(ent.Path, e.Path) {
+				continue
+			}
+			tuned := e
+			tuned.PluginVersion = ent.PluginVersion
+			tuned.ListingVisibility = ent.ListingVisibility
+			tuned.PassthroughRequestHeaders = ent.PassthroughRequestHeaders
+			tuned.AllowedResponseHeaders = ent.AllowedResponseHeaders
+			tuned.AllowedManagedKeys = ent.AllowedManagedKeys
+			tuned.KVConfig = ent.KVConfig
+			tuneChanged = ent.Equals(tuned)
+			break
+		}
+		if tuneChanged {
+			toBeUpdated = append(toBeUpdated, w)
+		} else {
+			written = append(written, w)
+		}
+	}
+	return written, toBeUpdated
+}
+
+// typeChange is a secrets engine that differs from the engine mounted at the same path by settings that
+// cannot be changed without re-enabling the engine, see recreateFields
+type typeChange struct {
+	existing entry
+	desired  entry
+}
+
+// determineTypeChanges separates desired secrets engines that can only be changed by re-enabling the
+// existing engine mounted at the same path from the to be written set
+func determineTypeChanges(toBeWritten []vault.Item, existing []entry) ([]vault.Item, []typeChange) {
+	written := make([]vault.Item, 0)
+	changes := []typeChange{}
+	for _, w := range toBeWritten {
+		ent := w.(entry)
+		changed := false
+		for _, e := range existing {
+			if vault.EqualPathNames(ent.Path, e.Path) && len(ent.recreateFields(e)) > 0 {
+				changes = append(changes, typeChange{existing: e, desired: ent})
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			written = append(written, w)
+		}
+	}
+	return written, changes
+}
+
+// groupOperationsByPath organizes changes by mount path
+// within a path a disable or move is performed before an enable so the path is free to be reused
+// moves are grouped by the path they move from, as the path they move to is not in use
+func groupOperationsByPath(toBeWritten, toBeUpdated, toBeDeleted []vault.Item, moved []pathChange,
+	existing []entry) map[string][]operation {
+	grouped := make(map[string][]operation)
+	add := func(items []vault.Item, action int) {
+		for _, i := range items {
+			path := strings.Trim(i.Key(), "/")
+			op := operation{action: action, entry: i.(entry)}
+			if e, ok := existingAt(i.Key(), existing); ok && action == updateAction {
+				op.existing = &e
+			}
+			grouped[path] = append(grouped[path], op)
+		}
+	}
+	add(toBeDeleted, disableAction)
+	for _, m := range moved {
+		path := strings.Trim(m.existing.Path, "/")
+		grouped[path] = append(grouped[path], operation{action: remountAction, entry: m.desired, from: m.existing})
+	}
+	add(toBeWritten, enableAction)
+	add(toBeUpdated, updateAction)
+	return grouped
+}
+
+// pathChange is an existing secrets engine that is configured under a different path
+type pathChange struct {
+	existing entry
+	desired  entry
+}
+
+// detectPathChanges determines path changes only when engines missing from the configuration are deleted,
+// either with pruning enabled or once the deletes deferred by `-prune` are applied. Otherwise the existing engine
+// is left untouched and must not be moved, so the desired engine is enabled as a new engine
+func detectPathChanges(address string, prune bool, toBeWritten, toBeDeleted []vault.Item,
+	existing []entry) ([]vault.Item, []vault.Item, []pathChange) {
+	if !prune && !vault.DeferringDeletes(address) {
+		return toBeWritten, toBeDeleted, []pathChange{}
+	}
+	return determinePathChanges(toBeWritten, toBeDeleted, existing)
+}
+
+// determinePathChanges separates desired secrets engines that are likely moves of an engine to be deleted,
+// i.e. an engine of the same type, options, seal wrapping and locality whose path is missing from the configuration,
+// from the to be written and to be deleted sets. Engines matching more than one other engine are ambiguous
+// and left as they are
+func determinePathChanges(toBeWritten, toBeDeleted []vault.Item, existing []entry) ([]vault.Item, []vault.Item, []pathChange) {
+	matches := func(desired, existing entry) bool {
+		return !isDefault(existing) && len(desired.recreateFields(existing)) == 0 &&
+			vault.OptionsEqual(desired.ambiguousOptions(), existing.comparedOptions(desired))
+	}
+	exists := func(path string) bool {
+		for _, e := range existing {
+			if vault.EqualPathNames(path, e.Path) {
+				return true
+			}
+		}
 		return false
 	}
+
+	candidates := make(map[string][]entry)
+	matchCounts := make(map[string]int)
+	for _, w := range toBeWritten {
+		ent := w.(entry)
+		if exists(ent.Path) {
+			continue
+		}
+		for _, d := range toBeDeleted {
+			if matches(ent, d.(entry)) {
+				candidates[ent.Path] = append(candidates[ent.Path], d.(entry))
+				matchCounts[d.Key()]++
+			}
+		}
+	}
+
+	written := make([]vault.Item, 0)
+	movedFrom := make(map[string]bool)
+	changes := []pathChange{}
+	for _, w := range toBeWritten {
+		ent := w.(entry)
+		c := candidates[ent.Path]
+		if len(c) == 1 && matchCounts[c[0].Key()] == 1 {
+			changes = append(changes, pathChange{existing: c[0], desired: ent})
+			movedFrom[c[0].Key()] = true
+			continue
+		}
+		written = append(written, w)
+	}
+	deleted := make([]vault.Item, 0)
+	for _, d := range toBeDeleted {
+		if !movedFrom[d.Key()] {
+			deleted = append(deleted, d)
+		}
+	}
+	return written, deleted, changes
+}
+
+// existingAt returns the existing engine mounted at path, if any
+func existingAt(path string, existing []entry) (entry, bool) {
+	for _, e := range existing {
+		if vault.EqualPathNames(path, e.Path) {
+			return e, true
+		}
+	}
+	return entry{}, false
+}
+
+func formatDifferences(diffs []vault.FieldDiff) []string {
+	formatted := make([]string, 0, len(diffs))
+	for _, d := range diffs {
+		formatted = append(formatted, d.String())
+	}
+	return formatted
+}
+
+// defaultListingVisibility returns the listing visibility vault applies when none is reported
+func defaultListingVisibility(visibility string) string {
+	if visibility == "" {
+		return "hidden"
+	}
+	return visibility
+}
+
+// isDefault determines if an item is a builtin or protected secrets engine that is never disabled
+func isDefault(i vault.Item) bool {
+	return isDefaultMount(i.(entry), protectedPaths())
+}
+
+// builtinTypes are the types of secrets engines mounted by vault itself which cannot be disabled
+// the ns_ types are used for the builtin mounts of enterprise namespaces
+var builtinTypes = map[string]bool{
+	"cubbyhole":    true,
+	"identity":     true,
+	"system":       true,
+	"ns_cubbyhole": true,
+	"ns_identity":  true,
+	"ns_system":    true,
+}
+
+// isDefaultMount determines if a secrets engine is builtin, based on the type reported by vault,
+// or if its path is one of the protected paths
+func isDefaultMount(e entry, protected []string) bool {
+	if builtinTypes[e.Type] {
+		return true
+	}
+	for _, path := range protected {
+		if vault.EqualPathNames(e.Path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// protectedPaths returns the paths of secrets engines that are never disabled in addition to builtin engines
+// configurable via the `PROTECTED_SECRET_ENGINES` env var as a comma separated list and defaults to
+// the `secret/` kv engine mounted by vault dev servers
+func protectedPaths() []string {
+	paths, ok := os.LookupEnv("PROTECTED_SECRET_ENGINES")
+	if !ok {
+		return []string{"secret/"}
+	}
+	protected := []string{}
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			protected = append(protected, path)
+		}
+	}
+	return protected
 }
 
 func asItems(xs []entry) (items []vault.Item) {
@@ -0,0 +1,669 @@
+package secretsengine
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestCheckKvVersions(t *testing.T) {
+	table := []struct {
+		description string
+		desired     []entry
+		existing    []entry
+		defaultVer  string
+		expectErr   bool
+	}{
+		{
+			description: "matching versions are accepted",
+			desired:     []entry{{Path: "secret/", Type: "kv", Options: map[string]string{"version": "2"}}},
+			existing:    []entry{{Path: "secret/", Type: "kv", Options: map[string]string{"version": "2"}}},
+			defaultVer:  kvV1,
+			expectErr:   false,
+		},
+		{
+			description: "flipping existing v1 to v2 is refused",
+			desired:     []entry{{Path: "secret", Type: "kv", Options: map[string]string{"version": "2"}}},
+			existing:    []entry{{Path: "secret/", Type: "kv", Options: map[string]string{"version": "1"}}},
+			defaultVer:  kvV1,
+			expectErr:   true,
+		},
+		{
+			description: "flipping existing v2 to v1 is refused",
+			desired:     []entry{{Path: "secret/", Type: "kv", Options: map[string]string{"version": "1"}}},
+			existing:    []entry{{Path: "secret/", Type: "kv", Options: map[string]string{"version": "2"}}},
+			defaultVer:  kvV1,
+			expectErr:   true,
+		},
+		{
+			description: "missing desired version uses default",
+			desired:     []entry{{Path: "secret/", Type: "kv"}},
+			existing:    []entry{{Path: "secret/", Type: "kv", Options: map[string]string{"version": "2"}}},
+			defaultVer:  kvV2,
+			expectErr:   false,
+		},
+		{
+			description: "missing existing version is treated as v1",
+			desired:     []entry{{Path: "secret/", Type: "kv", Options: map[string]string{"version": "2"}}},
+			existing:    []entry{{Path: "secret/", Type: "kv"}},
+			defaultVer:  kvV1,
+			expectErr:   true,
+		},
+		{
+			description: "new kv engines are accepted",
+			desired:     []entry{{Path: "new/", Type: "kv", Options: map[string]string{"version": "2"}}},
+			existing:    []entry{{Path: "secret/", Type: "kv", Options: map[string]string{"version": "1"}}},
+			defaultVer:  kvV1,
+			expectErr:   false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			applyKvVersionDefaults(tt.desired, tt.defaultVer)
+			applyKvVersionDefaults(tt.existing, kvV1)
+			err := checkKvVersions(tt.desired, tt.existing)
+			if tt.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestEntryEqualsOptions(t *testing.T) {
+	table := []struct {
+		description string
+		desired     entry
+		existing    entry
+		expected    bool
+	}{
+		{
+			description: "nil options equal empty options",
+			desired:     entry{Path: "aws/", Type: "aws", Options: nil},
+			existing:    entry{Path: "aws/", Type: "aws", Options: map[string]string{}},
+			expected:    true,
+		},
+		{
+			description: "empty options equal nil options",
+			desired:     entry{Path: "aws/", Type: "aws", Options: map[string]string{}},
+			existing:    entry{Path: "aws/", Type: "aws", Options: nil},
+			expected:    true,
+		},
+		{
+			description: "kv without version equals existing kv with implied default version",
+			desired:     entry{Path: "secret/", Type: "kv"},
+			existing:    entry{Path: "secret/", Type: "kv", Options: map[string]string{"version": "1"}},
+			expected:    true,
+		},
+		{
+			description: "kv without version equals existing kv without options",
+			desired:     entry{Path: "secret/", Type: "kv", Options: map[string]string{}},
+			existing:    entry{Path: "secret/", Type: "kv"},
+			expected:    true,
+		},
+		{
+			description: "populated options do not equal nil options",
+			desired:     entry{Path: "aws/", Type: "aws", Options: map[string]string{"x": "x"}},
+			existing:    entry{Path: "aws/", Type: "aws"},
+			expected:    false,
+		},
+		{
+			description: "additional existing options do not equal in replace mode",
+			desired:     entry{Path: "aws/", Type: "aws", Options: map[string]string{"x": "x"}, OptionsMode: "replace"},
+			existing:    entry{Path: "aws/", Type: "aws", Options: map[string]string{"x": "x", "y": "y"}},
+			expected:    false,
+		},
+		{
+			description: "additional existing options are ignored in merge mode",
+			desired:     entry{Path: "aws/", Type: "aws", Options: map[string]string{"x": "x"}, OptionsMode: "merge"},
+			existing:    entry{Path: "aws/", Type: "aws", Options: map[string]string{"x": "x", "y": "y"}},
+			expected:    true,
+		},
+		{
+			description: "configured options are enforced in merge mode",
+			desired:     entry{Path: "aws/", Type: "aws", Options: map[string]string{"x": "z"}, OptionsMode: "merge"},
+			existing:    entry{Path: "aws/", Type: "aws", Options: map[string]string{"x": "x", "y": "y"}},
+			expected:    false,
+		},
+		{
+			description: "missing configured options are enforced in merge mode",
+			desired:     entry{Path: "aws/", Type: "aws", Options: map[string]string{"x": "x"}, OptionsMode: "merge"},
+			existing:    entry{Path: "aws/", Type: "aws", Options: map[string]string{"y": "y"}},
+			expected:    false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			desired := []entry{tt.desired}
+			existing := []entry{tt.existing}
+			applyKvVersionDefaults(desired, kvV1)
+			applyKvVersionDefaults(existing, kvV1)
+			require.Equal(t, tt.expected, desired[0].Equals(existing[0]))
+		})
+	}
+}
+
+func TestDetermineTuneUpdates(t *testing.T) {
+	existing := []entry{
+		{Path: "custom/", Type: "custom", PluginVersion: "v1.0.0"},
+		{Path: "other/", Type: "other", PluginVersion: "v1.0.0"},
+		{Path: "ui/", Type: "kv", ListingVisibility: "hidden", AllowedResponseHeaders: []string{"X-A"}},
+	}
+	toBeWritten := asItems([]entry{
+		{Path: "custom/", Type: "custom", PluginVersion: "v1.1.0"},
+		{Path: "other/", Type: "other", Description: "changed", PluginVersion: "v1.1.0"},
+		{Path: "new/", Type: "custom", PluginVersion: "v1.1.0"},
+		{Path: "ui/", Type: "kv", ListingVisibility: "unauth", PassthroughRequestHeaders: []string{"X-B"}},
+	})
+
+	written, updated := determineTuneUpdates(toBeWritten, asItems([]entry{}), existing)
+	require.Equal(t, []string{"other/", "new/"}, keys(written))
+	require.Equal(t, []string{"custom/", "ui/"}, keys(updated))
+
+	require.True(t, entry{Path: "custom/", Type: "custom"}.Equals(existing[0]),
+		"an unset plugin version is not managed")
+	require.True(t, entry{Path: "ui/", Type: "kv"}.Equals(existing[2]),
+		"unset listing visibility and headers are not managed")
+	require.True(t, entry{Path: "ui/", Type: "kv", AllowedResponseHeaders: []string{"x-a"}}.Equals(existing[2]),
+		"header names are compared regardless of case")
+	require.False(t, entry{Path: "ui/", Type: "kv", AllowedResponseHeaders: []string{}}.Equals(existing[2]),
+		"an empty list of headers is managed")
+
+	managed := []entry{{Path: "pki/", Type: "pki", AllowedManagedKeys: []string{"hsm-a", "hsm-b"}}}
+	require.True(t, entry{Path: "pki/", Type: "pki", AllowedManagedKeys: []string{"hsm-b", "hsm-a"}}.Equals(managed[0]),
+		"managed keys are compared regardless of order")
+	require.False(t, entry{Path: "pki/", Type: "pki", AllowedManagedKeys: []string{"HSM-A", "hsm-b"}}.Equals(managed[0]),
+		"managed key names are case sensitive")
+	written, updated = determineTuneUpdates(asItems([]entry{{Path: "pki/", Type: "pki", AllowedManagedKeys: []string{"hsm-c"}}}),
+		asItems([]entry{}), managed)
+	require.Empty(t, written)
+	require.Equal(t, []string{"pki/"}, keys(updated), "managed keys are tuned")
+}
+
+func TestKvConfigEquals(t *testing.T) {
+	existing := kvConfigFromData(map[string]interface{}{
+		"max_versions":         json.Number("10"),
+		"cas_required":         false,
+		"delete_version_after": "768h0m0s",
+	})
+	maxVersions := 10
+	otherMaxVersions := 5
+	casRequired := true
+
+	table := []struct {
+		description string
+		desired     kvConfig
+		existing    *kvConfig
+		expected    bool
+	}{
+		{
+			description: "matching max versions",
+			desired:     kvConfig{MaxVersions: &maxVersions},
+			existing:    existing,
+			expected:    true,
+		},
+		{
+			description: "different max versions",
+			desired:     kvConfig{MaxVersions: &otherMaxVersions},
+			existing:    existing,
+			expected:    false,
+		},
+		{
+			description: "different cas required",
+			desired:     kvConfig{CasRequired: &casRequired},
+			existing:    existing,
+			expected:    false,
+		},
+		{
+			description: "different delete version after",
+			desired:     kvConfig{DeleteVersionAfter: "24h"},
+			existing:    existing,
+			expected:    false,
+		},
+		{
+			description: "delete version after in hours",
+			desired:     kvConfig{DeleteVersionAfter: "768h"},
+			existing:    existing,
+			expected:    true,
+		},
+		{
+			description: "unread existing config",
+			desired:     kvConfig{MaxVersions: &maxVersions},
+			existing:    nil,
+			expected:    false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.desired.equals(tt.existing))
+		})
+	}
+
+	updated := entry{Path: "secret/", Type: "kv", KVConfig: &kvConfig{MaxVersions: &otherMaxVersions}}
+	written, toBeUpdated := determineTuneUpdates(asItems([]entry{updated}), asItems([]entry{}),
+		[]entry{{Path: "secret/", Type: "kv", KVConfig: existing}})
+	require.Empty(t, written)
+	require.Equal(t, []string{"secret/"}, keys(toBeUpdated), "kv config changes are tuned")
+}
+
+func TestKvConfigDifferences(t *testing.T) {
+	existing := kvConfigFromData(map[string]interface{}{
+		"max_versions":         json.Number("10"),
+		"cas_required":         false,
+		"delete_version_after": "768h0m0s",
+	})
+	maxVersions := 10
+	casRequired := true
+	casNotRequired := false
+
+	desired := kvConfig{MaxVersions: &maxVersions, CasRequired: &casRequired, DeleteVersionAfter: "24h"}
+	require.Equal(t, []string{
+		"kv_config.cas_required: desired=true existing=false",
+		"kv_config.delete_version_after: desired=24h existing=768h0m0s",
+	}, formatDifferences(desired.differences(existing)))
+	require.Equal(t, []string{
+		"kv_config.max_versions: desired=10 existing=<unset>",
+		"kv_config.cas_required: desired=true existing=<unset>",
+		"kv_config.delete_version_after: desired=24h existing=<unset>",
+	}, formatDifferences(desired.differences(nil)))
+
+	require.True(t, desired.enablesCasRequired(existing))
+	require.True(t, desired.enablesCasRequired(nil))
+	require.False(t, (&kvConfig{CasRequired: &casNotRequired}).enablesCasRequired(existing))
+	require.False(t, (&kvConfig{MaxVersions: &maxVersions}).enablesCasRequired(existing), "unmanaged cas_required")
+	require.False(t, desired.enablesCasRequired(&kvConfig{CasRequired: &casRequired}), "already required")
+	var unset *kvConfig
+	require.False(t, unset.enablesCasRequired(existing))
+}
+
+func keys(items []vault.Item) []string {
+	keys := []string{}
+	for _, i := range items {
+		keys = append(keys, i.Key())
+	}
+	return keys
+}
+
+func TestIsDefaultMount(t *testing.T) {
+	table := []struct {
+		description string
+		entry       entry
+		protected   []string
+		expected    bool
+	}{
+		{
+			description: "builtin system mount",
+			entry:       entry{Path: "sys/", Type: "system"},
+			expected:    true,
+		},
+		{
+			description: "builtin namespace cubbyhole mount",
+			entry:       entry{Path: "cubbyhole/", Type: "ns_cubbyhole"},
+			expected:    true,
+		},
+		{
+			description: "protected path",
+			entry:       entry{Path: "secret/", Type: "kv"},
+			protected:   []string{"secret"},
+			expected:    true,
+		},
+		{
+			description: "mount sharing prefix of protected path",
+			entry:       entry{Path: "secret/team/", Type: "kv"},
+			protected:   []string{"secret/"},
+			expected:    false,
+		},
+		{
+			description: "unprotected kv mount",
+			entry:       entry{Path: "secret/", Type: "kv"},
+			expected:    false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, isDefaultMount(tt.entry, tt.protected))
+		})
+	}
+}
+
+func TestDefaultMountsNotDisabled(t *testing.T) {
+	existing := []entry{
+		{Path: "sys/", Type: "system"},
+		{Path: "cubbyhole/", Type: "cubbyhole"},
+		{Path: "identity/", Type: "identity"},
+		{Path: "secret/", Type: "kv"},
+		{Path: "team/", Type: "kv"},
+	}
+
+	_, toBeDeleted, _ := vault.DiffItems(asItems([]entry{}), asItems(existing))
+	deleted := []string{}
+	for _, d := range vault.ExcludeItems(toBeDeleted, isDefault) {
+		deleted = append(deleted, d.Key())
+	}
+	require.Equal(t, []string{"team/"}, deleted)
+}
+
+func TestValidate(t *testing.T) {
+	table := []struct {
+		description string
+		config      string
+		expectErr   bool
+	}{
+		{
+			description: "valid kv engine",
+			config:      "- _path: app-sre/\n  type: kv\n  options:\n    version: \"2\"\n",
+			expectErr:   false,
+		},
+		{
+			description: "nested path",
+			config:      "- _path: team/app/\n  type: transit\n",
+			expectErr:   false,
+		},
+		{
+			description: "merge options mode",
+			config:      "- _path: aws/\n  type: aws\n  options_mode: merge\n",
+			expectErr:   false,
+		},
+		{
+			description: "unsupported options mode",
+			config:      "- _path: aws/\n  type: aws\n  options_mode: patch\n",
+			expectErr:   true,
+		},
+		{
+			description: "unsupported type",
+			config:      "- _path: app-sre/\n  type: kv3\n",
+			expectErr:   true,
+		},
+		{
+			description: "empty path segment",
+			config:      "- _path: app//sre/\n  type: kv\n",
+			expectErr:   true,
+		},
+		{
+			description: "builtin path",
+			config:      "- _path: sys/\n  type: kv\n",
+			expectErr:   true,
+		},
+		{
+			description: "unsupported kv version",
+			config:      "- _path: app-sre/\n  type: kv\n  options:\n    version: \"3\"\n",
+			expectErr:   true,
+		},
+		{
+			description: "unauthenticated listing visibility",
+			config:      "- _path: app-sre/\n  type: kv\n  listing_visibility: unauth\n",
+			expectErr:   false,
+		},
+		{
+			description: "unsupported listing visibility",
+			config:      "- _path: app-sre/\n  type: kv\n  listing_visibility: visible\n",
+			expectErr:   true,
+		},
+		{
+			description: "kv config",
+			config:      "- _path: app-sre/\n  type: kv\n  options:\n    version: \"2\"\n  kv_config:\n    max_versions: 5\n    delete_version_after: 768h\n",
+			expectErr:   false,
+		},
+		{
+			description: "kv config on kv version 1",
+			config:      "- _path: app-sre/\n  type: kv\n  options:\n    version: \"1\"\n  kv_config:\n    max_versions: 5\n",
+			expectErr:   true,
+		},
+		{
+			description: "invalid delete version after",
+			config:      "- _path: app-sre/\n  type: kv\n  kv_config:\n    delete_version_after: soon\n",
+			expectErr:   true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			err := config{}.Validate([]byte(tt.config))
+			if tt.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDetermineTypeChanges(t *testing.T) {
+	existing := []entry{
+		{Path: "app-sre/", Type: "kv"},
+		{Path: "transit/", Type: "transit"},
+		{Path: "wrapped/", Type: "kv", SealWrap: true},
+	}
+	toBeWritten := []vault.Item{
+		entry{Path: "app-sre/", Type: "totp"},
+		entry{Path: "transit/", Type: "transit", Description: "changed"},
+		entry{Path: "new/", Type: "kv"},
+		entry{Path: "wrapped/", Type: "kv"},
+	}
+
+	written, changes := determineTypeChanges(toBeWritten, existing)
+	require.Equal(t, []string{"transit/", "new/"}, keys(written))
+	require.Len(t, changes, 2)
+	require.Equal(t, "kv", changes[0].existing.Type)
+	require.Equal(t, "totp", changes[0].desired.Type)
+	require.True(t, changes[1].existing.SealWrap)
+	require.False(t, changes[1].desired.SealWrap)
+}
+
+func TestFirstApplyToInitializedInstance(t *testing.T) {
+	// the engines of a newly initialized dev server, reported by vault with the kv version as option
+	existing := []entry{
+		{Path: "sys/", Type: "system"},
+		{Path: "cubbyhole/", Type: "cubbyhole"},
+		{Path: "identity/", Type: "identity"},
+		{Path: "secret/", Type: "kv", Options: map[string]string{"version": "2"}},
+	}
+	desired := []entry{
+		{Path: "app/", Type: "kv", Options: map[string]string{"version": "2"}},
+		{Path: "transit/", Type: "transit"},
+	}
+
+	toBeWritten, toBeDeleted, _ := vault.DiffItems(asItems(desired), asItems(existing))
+	toBeWritten, toBeDeleted, changes := determinePathChanges(toBeWritten, toBeDeleted, existing)
+	require.Equal(t, []string{"app/", "transit/"}, keys(toBeWritten))
+	require.Empty(t, changes, "builtin and protected engines are never moved to configured paths")
+
+	toBeDeleted = vault.ExcludeItems(toBeDeleted, isDefault)
+	require.Empty(t, toBeDeleted)
+
+	vault.SetMaxDeletes(1)
+	defer vault.SetMaxDeletes(0)
+	require.NoError(t, vault.CheckDeletes("https://vault.test", "[Vault Secrets engine]", toBeDeleted),
+		"builtin engines are not counted against -max-deletes")
+}
+
+func TestDeterminePathChanges(t *testing.T) {
+	kv2 := map[string]string{"version": "2"}
+	existing := []entry{
+		{Path: "app-sre/", Type: "kv", Options: kv2},
+		{Path: "team-a/", Type: "aws"},
+		{Path: "team-b/", Type: "aws"},
+		{Path: "transit/", Type: "transit"},
+		{Path: "pki/", Type: "pki"},
+	}
+	toBeWritten := []vault.Item{
+		entry{Path: "app-interface/", Type: "kv", Options: kv2, Description: "renamed"},
+		entry{Path: "aws/", Type: "aws"},
+		entry{Path: "transit/", Type: "transit", Description: "changed"},
+		entry{Path: "pki-int/", Type: "pki", Local: true},
+	}
+	toBeDeleted := []vault.Item{existing[0], existing[1], existing[2], existing[4]}
+
+	written, deleted, changes := determinePathChanges(toBeWritten, toBeDeleted, existing)
+	require.Equal(t, []string{"aws/", "transit/", "pki-int/"}, keys(written),
+		"engines matching several deleted engines or differing in locality are not moved")
+	require.Equal(t, []string{"team-a/", "team-b/", "pki/"}, keys(deleted))
+	require.Len(t, changes, 1)
+	require.Equal(t, "app-sre/", changes[0].existing.Path)
+	require.Equal(t, "app-interface/", changes[0].desired.Path)
+}
+
+func TestDetectPathChangesWithoutPrune(t *testing.T) {
+	address := "https://path-changes.vault.test"
+	existing := []entry{{Path: "app-sre/", Type: "kv", Options: map[string]string{"version": "2"}}}
+	toBeWritten := []vault.Item{entry{Path: "app-interface/", Type: "kv", Options: map[string]string{"version": "2"}}}
+	toBeDeleted := []vault.Item{existing[0]}
+
+	written, deleted, changes := detectPathChanges(address, false, toBeWritten, toBeDeleted, existing)
+	require.Equal(t, []string{"app-interface/"}, keys(written), "engines that are not deleted are never moved")
+	require.Equal(t, []string{"app-sre/"}, keys(deleted))
+	require.Empty(t, changes)
+
+	_, _, changes = detectPathChanges(address, true, toBeWritten, toBeDeleted, existing)
+	require.Len(t, changes, 1)
+
+	vault.DeferDeletes(address)
+	defer vault.StopDeferringDeletes(address)
+	written, deleted, changes = detectPathChanges(address, false, toBeWritten, toBeDeleted, existing)
+	require.Empty(t, written, "engines whose deletes are deferred with -prune are moved")
+	require.Empty(t, deleted)
+	require.Len(t, changes, 1)
+}
+
+func TestGroupOperationsByPathMoves(t *testing.T) {
+	moved := []pathChange{{existing: entry{Path: "old/", Type: "kv"}, desired: entry{Path: "new/", Type: "kv"}}}
+	toBeWritten := []vault.Item{entry{Path: "old/", Type: "transit"}}
+
+	grouped := groupOperationsByPath(toBeWritten, nil, nil, moved, nil)
+	require.Len(t, grouped, 1)
+	require.Len(t, grouped["old"], 2)
+	require.Equal(t, remountAction, grouped["old"][0].action)
+	require.Equal(t, "new/", grouped["old"][0].entry.Path)
+	require.Equal(t, enableAction, grouped["old"][1].action)
+}
+
+func TestDifferences(t *testing.T) {
+	existing := entry{Path: "app-sre/", Type: "kv", Description: "app-sre",
+		Options:           map[string]string{"version": "2", "max_lease_ttl": "768h", "password": "old"},
+		ListingVisibility: "hidden"}
+	desired := entry{Path: "app-sre/", Type: "kv", Description: "app-sre",
+		Options:           map[string]string{"version": "2", "password": "new"},
+		ListingVisibility: "unauth"}
+
+	require.Equal(t, []string{
+		"options.max_lease_ttl: desired=<unset> existing=768h",
+		"options.password: desired=REDACTED existing=REDACTED",
+		"listing_visibility: desired=unauth existing=hidden",
+	}, formatDifferences(desired.differences(existing)))
+
+	desired.OptionsMode = optionsMerge
+	desired.Options["password"] = "old"
+	desired.ListingVisibility = ""
+	require.Empty(t, desired.differences(existing), "options only set on the existing engine are not compared in merge mode")
+	require.True(t, desired.Equals(existing))
+}
+
+func TestDiffItemsPathForms(t *testing.T) {
+	existing := []entry{
+		{Path: vault.MountPath("aws/"), Type: "aws"},
+		{Path: vault.MountPath("team/aws/"), Type: "aws"},
+	}
+	desired := []entry{
+		{Path: vault.MountPath("aws"), Type: "aws"},
+		{Path: vault.MountPath("/team/aws"), Type: "aws"},
+	}
+
+	toBeWritten, toBeDeleted, toBeUpdated := vault.DiffItems(asItems(desired), asItems(existing))
+	require.Empty(t, toBeWritten)
+	require.Empty(t, toBeDeleted, "an engine configured without trailing slash is not disabled")
+	require.Empty(t, toBeUpdated)
+}
+
+func TestMountInput(t *testing.T) {
+	e := entry{Path: "app-sre/", Type: "kv", Description: "app-sre", Options: map[string]string{"version": "2"},
+		SealWrap: true, Local: true, ListingVisibility: "unauth"}
+
+	input := e.mountInput()
+	require.Equal(t, "kv", input.Type)
+	require.Equal(t, "app-sre", input.Description)
+	require.Equal(t, map[string]string{"version": "2"}, input.Options)
+	require.True(t, input.SealWrap)
+	require.True(t, input.Local)
+	require.Equal(t, "unauth", input.Config.ListingVisibility)
+
+	require.False(t, entry{Path: "app-sre/", Type: "kv"}.mountInput().Local)
+}
+
+func TestRecreateFields(t *testing.T) {
+	existing := entry{Path: "app-sre/", Type: "kv"}
+
+	require.Empty(t, entry{Path: "app-sre/", Type: "kv", Description: "changed"}.recreateFields(existing))
+	require.Equal(t, []string{"local"}, entry{Path: "app-sre/", Type: "kv", Local: true}.recreateFields(existing))
+	require.Equal(t, []string{"type", "seal_wrap"},
+		entry{Path: "app-sre/", Type: "totp", SealWrap: true}.recreateFields(existing))
+}
+
+func TestTuneInput(t *testing.T) {
+	maxVersions := 5
+	existing := entry{Path: "app-sre/", Type: "kv", Description: "old", ListingVisibility: "hidden",
+		AllowedManagedKeys: []string{"hsm"}}
+	desired := entry{Path: "app-sre/", Type: "kv", Description: "new", ListingVisibility: "hidden",
+		AllowedManagedKeys: []string{"hsm"}}
+
+	op := operation{action: updateAction, entry: desired, existing: &existing}
+	require.Equal(t, map[string]bool{"description": true}, op.changedFields())
+	input, ok := desired.tuneInput(op.changedFields())
+	require.True(t, ok)
+	require.Equal(t, "new", *input.Description)
+	require.Empty(t, input.ListingVisibility, "unchanged settings are not tuned")
+	require.Nil(t, input.AllowedManagedKeys)
+
+	desired.Description = "old"
+	desired.KVConfig = &kvConfig{MaxVersions: &maxVersions}
+	op = operation{action: updateAction, entry: desired, existing: &existing}
+	require.Equal(t, map[string]bool{"kv_config": true}, op.changedFields())
+	_, ok = desired.tuneInput(op.changedFields())
+	require.False(t, ok, "kv config changes are written without tuning the mount")
+
+	op = operation{action: updateAction, entry: desired}
+	require.Nil(t, op.changedFields())
+	input, ok = desired.tuneInput(op.changedFields())
+	require.True(t, ok, "all settings are tuned when the existing engine is unknown")
+	require.Equal(t, "old", *input.Description)
+	require.Equal(t, "hidden", input.ListingVisibility)
+	require.Equal(t, []string{"hsm"}, input.AllowedManagedKeys)
+}
+
+func TestUnmarshalTypedOptions(t *testing.T) {
+	var engines []entry
+	require.NoError(t, yaml.Unmarshal([]byte(`
+- _path: typed/
+  type: kv
+  options:
+    version: 2
+    force_no_cache: true
+- _path: strings/
+  type: kv
+  options:
+    version: "2"
+    force_no_cache: "true"
+`), &engines))
+	require.Equal(t, options{"version": "2", "force_no_cache": "true"}, engines[0].Options)
+	require.Equal(t, engines[0].Options, engines[1].Options)
+
+	// configuration queried from graphql is decoded from json, where all numbers are floats
+	remarshalled, err := yaml.Marshal([]map[string]interface{}{
+		{"_path": "pki/", "type": "pki", "options": map[string]interface{}{"max_lease_ttl": float64(2592000)}},
+	})
+	require.NoError(t, err)
+	engines = nil
+	require.NoError(t, yaml.Unmarshal(remarshalled, &engines))
+	require.Equal(t, options{"max_lease_ttl": "2592000"}, engines[0].Options)
+
+	err = yaml.Unmarshal([]byte("- _path: kv/\n  type: kv\n  options:\n    version: [2]\n"), &engines)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "option `version` must be a string, number or boolean")
+}
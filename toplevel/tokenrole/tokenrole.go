@@ -0,0 +1,312 @@
+// Package tokenrole implements the application of a declarative configuration
+// for roles of Vault's token auth backend, which constrain the tokens created against them.
+package tokenrole
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/app-sre/vault-manager/pkg/utils"
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/app-sre/vault-manager/toplevel"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const rolesPath = "auth/token/roles"
+
+// defaultTokenType is the token type vault reports for roles configured without one
+const defaultTokenType = "default-service"
+
+var tokenTypes = map[string]bool{
+	"service":         true,
+	"batch":           true,
+	"default-service": true,
+	"default-batch":   true,
+}
+
+type entry struct {
+	Name               string         `yaml:"name"`
+	Instance           vault.Instance `yaml:"instance"`
+	AllowedPolicies    []string       `yaml:"allowed_policies"`
+	DisallowedPolicies []string       `yaml:"disallowed_policies"`
+	Orphan             bool           `yaml:"orphan"`
+	// Renewable defaults to true and TokenType to default-service when unset, matching vault
+	Renewable    *bool  `yaml:"renewable"`
+	TokenPeriod  string `yaml:"token_period"`
+	TokenType    string `yaml:"token_type"`
+	vault.Toggle `yaml:",inline"`
+}
+
+var _ vault.Item = entry{}
+
+func (e entry) Key() string {
+	return filepath.Join(rolesPath, e.Name)
+}
+
+func (e entry) KeyForType() string {
+	return ""
+}
+
+func (e entry) KeyForDescription() string {
+	return ""
+}
+
+// Equals compares policies regardless of order and the token period regardless of its unit
+func (e entry) Equals(i interface{}) bool {
+	entry, ok := i.(entry)
+	if !ok {
+		return false
+	}
+
+	return e.Name == entry.Name &&
+		equalStrings(e.AllowedPolicies, entry.AllowedPolicies) &&
+		equalStrings(e.DisallowedPolicies, entry.DisallowedPolicies) &&
+		e.Orphan == entry.Orphan &&
+		e.renewable() == entry.renewable() &&
+		e.tokenType() == entry.tokenType() &&
+		vault.OptionsEqual(map[string]interface{}{"token_period": ttlOrZero(e.TokenPeriod)},
+			map[string]interface{}{"token_period": ttlOrZero(entry.TokenPeriod)})
+}
+
+func (e entry) renewable() bool {
+	return e.Renewable == nil || *e.Renewable
+}
+
+func (e entry) tokenType() string {
+	if e.TokenType == "" {
+		return defaultTokenType
+	}
+	return e.TokenType
+}
+
+func (e entry) data() map[string]interface{} {
+	return map[string]interface{}{
+		"allowed_policies":    nonNil(e.AllowedPolicies),
+		"disallowed_policies": nonNil(e.DisallowedPolicies),
+		"orphan":              e.Orphan,
+		"renewable":           e.renewable(),
+		"token_period":        ttlOrZero(e.TokenPeriod),
+		"token_type":          e.tokenType(),
+	}
+}
+
+type config struct{}
+
+var _ toplevel.Configuration = config{}
+
+const toplevelName = "vault_token_roles"
+
+func init() {
+	toplevel.RegisterConfiguration(toplevelName, config{}, "vault_policies")
+}
+
+// Validate ensures each role is named and its token period and type are valid.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault Token Role] failed to decode token role configuration: %v", err))
+	}
+	errs := []error{}
+	for _, e := range entries {
+		if e.Name == "" {
+			errs = append(errs, errors.New("[Vault Token Role] token role without name"))
+			continue
+		}
+		if _, err := vault.ParseDuration(ttlOrZero(e.TokenPeriod)); err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault Token Role] invalid `token_period` of token role `%s`: %v", e.Name, err)))
+		}
+		if !tokenTypes[e.tokenType()] {
+			errs = append(errs, errors.New(fmt.Sprintf(
+				"[Vault Token Role] unsupported `token_type` `%s` of token role `%s`", e.TokenType, e.Name)))
+		}
+	}
+	return utils.JoinErrors(errs)
+}
+
+// Apply ensures that the token roles of an instance are configured exactly as provided.
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return nil, errors.New(fmt.Sprintf("[Vault Token Role] failed to decode token role configuration: %v", err))
+	}
+	desiredRoles := []entry{}
+	for _, e := range entries {
+		if e.Instance.Key() == address {
+			desiredRoles = append(desiredRoles, e)
+		}
+	}
+
+	existingRoles, err := getExistingRoles(address, threadPoolSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// roles are written in full so that updates and creations are handled alike
+	toBeWritten, toBeDeleted, _ := vault.DiffItems(
+		vault.ExcludeUnmanaged(asItems(desiredRoles), asItems(existingRoles)))
+	utils.RecordPendingChanges(address, toplevelName, len(toBeWritten)+len(toBeDeleted))
+	if !prune {
+		toBeDeleted = vault.SkipDeletes(address, "[Vault Token Role] token role", toBeDeleted, nil)
+	}
+	if err := vault.CheckDeletes(address, "[Vault Token Role]", toBeDeleted); err != nil {
+		return nil, err
+	}
+
+	plan := vault.NewPlan()
+	plan.Add("token-role", toBeWritten, nil, toBeDeleted, asItems(existingRoles))
+
+	if dryRun == true {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate, len(toBeWritten))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationDelete, len(toBeDeleted))
+		for _, w := range toBeWritten {
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"path":     w.Key(),
+				"policies": w.(entry).AllowedPolicies,
+			}).Info("[Dry Run] [Vault Token Role] token role to be written")
+		}
+		for _, d := range toBeDeleted {
+			vault.Logger(address, toplevelName).WithField("path", d.Key()).Info(
+				"[Dry Run] [Vault Token Role] token role to be deleted")
+		}
+		return plan, nil
+	}
+
+	for _, w := range toBeWritten {
+		err := vault.WriteRaw(address, w.Key(), w.(entry).data())
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationCreate)
+		vault.Logger(address, toplevelName).WithField("path", w.Key()).Info(
+			"[Vault Token Role] token role is successfully written")
+	}
+	for _, d := range toBeDeleted {
+		err := vault.DeleteRaw(address, d.Key())
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationDelete)
+		vault.Logger(address, toplevelName).WithField("path", d.Key()).Info(
+			"[Vault Token Role] token role is successfully deleted")
+	}
+
+	return plan, nil
+}
+
+// getExistingRoles reads all token roles of an instance
+func getExistingRoles(address string, threadPoolSize int) ([]entry, error) {
+	names, err := listNames(address, rolesPath)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := []entry{}
+	var mutex = &sync.Mutex{}
+	var readErr error
+	bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+
+	for _, name := range names {
+		bwg.Add(1)
+
+		go func(name string) {
+			defer bwg.Done()
+
+			e := entry{Name: name, Instance: vault.Instance{Address: address}}
+			data, err := vault.ReadRaw(address, e.Key())
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err != nil {
+				readErr = err
+				return
+			}
+			if data == nil {
+				return
+			}
+			e.AllowedPolicies = toStrings(data["allowed_policies"])
+			e.DisallowedPolicies = toStrings(data["disallowed_policies"])
+			e.Orphan, _ = strconv.ParseBool(fmt.Sprintf("%v", data["orphan"]))
+			renewable, _ := strconv.ParseBool(fmt.Sprintf("%v", data["renewable"]))
+			e.Renewable = &renewable
+			e.TokenPeriod = fmt.Sprintf("%v", data["token_period"])
+			e.TokenType = fmt.Sprintf("%v", data["token_type"])
+			existing = append(existing, e)
+		}(name)
+	}
+	bwg.Wait()
+	if readErr != nil {
+		return nil, readErr
+	}
+	return existing, nil
+}
+
+// listNames returns the keys listed at path or an empty list if nothing exists
+func listNames(address, path string) ([]string, error) {
+	secret, err := vault.ListSecrets(address, path)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return []string{}, nil
+	}
+	return toStrings(secret.Data["keys"]), nil
+}
+
+func ttlOrZero(ttl string) string {
+	if ttl == "" {
+		return "0"
+	}
+	return ttl
+}
+
+// nonNil ensures empty lists are written as such rather than as null
+func nonNil(xs []string) []string {
+	if xs == nil {
+		return []string{}
+	}
+	return xs
+}
+
+func toStrings(raw interface{}) []string {
+	values, ok := raw.([]interface{})
+	if !ok {
+		return []string{}
+	}
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, fmt.Sprintf("%v", v))
+	}
+	return strs
+}
+
+func equalStrings(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	xs := append([]string{}, x...)
+	ys := append([]string{}, y...)
+	sort.Strings(xs)
+	sort.Strings(ys)
+	for i := range xs {
+		if xs[i] != ys[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func asItems(xs []entry) (items []vault.Item) {
+	items = make([]vault.Item, 0)
+	for _, x := range xs {
+		items = append(items, x)
+	}
+
+	return
+}
@@ -0,0 +1,75 @@
+package tokenrole
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryEquals(t *testing.T) {
+	renewable := true
+	existing := entry{Name: "ci", AllowedPolicies: []string{"read", "write"}, DisallowedPolicies: []string{},
+		Renewable: &renewable, TokenPeriod: "3600", TokenType: "default-service"}
+
+	table := []struct {
+		description string
+		desired     entry
+		expected    bool
+	}{
+		{
+			description: "policies in different order and period with unit",
+			desired:     entry{Name: "ci", AllowedPolicies: []string{"write", "read"}, TokenPeriod: "1h"},
+			expected:    true,
+		},
+		{
+			description: "different allowed policies",
+			desired:     entry{Name: "ci", AllowedPolicies: []string{"read"}, TokenPeriod: "1h"},
+			expected:    false,
+		},
+		{
+			description: "different disallowed policies",
+			desired: entry{Name: "ci", AllowedPolicies: []string{"read", "write"},
+				DisallowedPolicies: []string{"root"}, TokenPeriod: "1h"},
+			expected: false,
+		},
+		{
+			description: "different period",
+			desired:     entry{Name: "ci", AllowedPolicies: []string{"read", "write"}, TokenPeriod: "2h"},
+			expected:    false,
+		},
+		{
+			description: "orphan",
+			desired:     entry{Name: "ci", AllowedPolicies: []string{"read", "write"}, TokenPeriod: "1h", Orphan: true},
+			expected:    false,
+		},
+		{
+			description: "not renewable",
+			desired: entry{Name: "ci", AllowedPolicies: []string{"read", "write"}, TokenPeriod: "1h",
+				Renewable: new(bool)},
+			expected: false,
+		},
+		{
+			description: "different token type",
+			desired: entry{Name: "ci", AllowedPolicies: []string{"read", "write"}, TokenPeriod: "1h",
+				TokenType: "batch"},
+			expected: false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.desired.Equals(existing))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, config{}.Validate([]byte(
+		"- name: ci\n  token_period: 1h\n  token_type: service\n")))
+	require.Error(t, config{}.Validate([]byte(
+		"- name: ci\n  token_period: 1 hour\n")))
+	require.Error(t, config{}.Validate([]byte(
+		"- name: ci\n  token_type: unknown\n")))
+	require.Error(t, config{}.Validate([]byte(
+		"- token_period: 1h\n")))
+}
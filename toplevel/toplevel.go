@@ -3,30 +3,53 @@
 package toplevel
 
 import (
+	"errors"
+	"fmt"
+	"sort"
 	"strings"
 	"sync"
 
+	"github.com/app-sre/vault-manager/pkg/vault"
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	configs  = make(map[string]Configuration)
-	configsM sync.RWMutex
+	configs       = make(map[string]Configuration)
+	dependencies  = make(map[string][]string)
+	secretReaders = make(map[string]bool)
+	configsM      sync.RWMutex
 )
 
 // Configuration represents a block of declarative configuration data that can
 // be applied to a service.
 //
-// If an error occurs applying a configuration, the process should exit.
+// Apply returns a Plan describing the changes determined for the instance, which
+// are only made when not running in dry-run mode. Errors are returned rather than
+// exiting the process so the caller decides how to handle them.
+// Objects missing from the configuration are only deleted when pruning is enabled.
+//
+// Validate is called for every configuration before any configuration is applied
+// and must not make any requests to Vault.
 type Configuration interface {
-	Apply(string, []byte, bool, int) error
+	Apply(string, []byte, bool, bool, int) (*vault.Plan, error)
+	Validate([]byte) error
+}
+
+// Resolver is implemented by configurations whose entries reference content outside of the
+// configuration, e.g. files containing the rules of policies. Resolve returns the configuration with
+// such references replaced by their content, so that a change to the referenced content changes the
+// configuration as seen by the cache and plan files.
+type Resolver interface {
+	Resolve([]byte) ([]byte, error)
 }
 
 // RegisterConfiguration makes a Configuration available by the provided name.
+// dependsOn names the configurations that must be applied before this one,
+// e.g. configurations referencing auth backends depend on `vault_auth_backends`.
 //
 // If called twice with the same name, the name is blank, or if the provided
 // Extractor is nil, this function panics.
-func RegisterConfiguration(name string, c Configuration) {
+func RegisterConfiguration(name string, c Configuration, dependsOn ...string) {
 	configsM.Lock()
 	defer configsM.Unlock()
 
@@ -45,16 +68,169 @@ func RegisterConfiguration(name string, c Configuration) {
 	}
 
 	configs[name] = c
+	for _, d := range dependsOn {
+		dependencies[name] = append(dependencies[name], strings.ToLower(d))
+	}
+}
+
+// ReadsSecrets declares that a Configuration reads values its entries reference from secrets stored within
+// the instance, e.g. passwords. As these values are not part of the configuration, such a Configuration is
+// applied regardless of the apply cache so that a changed value is not skipped along with an unchanged configuration.
+func ReadsSecrets(name string) {
+	configsM.Lock()
+	defer configsM.Unlock()
+	secretReaders[strings.ToLower(name)] = true
+}
+
+// Cacheable determines if a Configuration may be skipped when its configuration is unchanged, see ReadsSecrets.
+func Cacheable(name string) bool {
+	configsM.RLock()
+	defer configsM.RUnlock()
+	return !secretReaders[strings.ToLower(name)]
+}
+
+// Order sorts configuration names so that each configuration follows the configurations it depends on.
+// Dependencies that are not included within names are ignored so that a subset of configurations
+// can be applied. Configurations without a dependency between them are ordered by name.
+// An error is returned if the dependencies contain a cycle.
+func Order(names []string) ([]string, error) {
+	configsM.RLock()
+	defer configsM.RUnlock()
+
+	included := make(map[string]bool, len(names))
+	for _, name := range names {
+		included[name] = true
+	}
+	// number of unapplied dependencies and dependents of each configuration
+	remaining := make(map[string]int, len(names))
+	dependents := make(map[string][]string)
+	for _, name := range names {
+		for _, d := range dependencies[name] {
+			if included[d] {
+				remaining[name]++
+				dependents[d] = append(dependents[d], name)
+			}
+		}
+	}
+
+	ready := []string{}
+	for _, name := range names {
+		if remaining[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+	ordered := make([]string, 0, len(names))
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, name)
+		for _, dependent := range dependents[name] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(ordered) != len(names) {
+		cyclic := []string{}
+		for _, name := range names {
+			if remaining[name] > 0 {
+				cyclic = append(cyclic, name)
+			}
+		}
+		sort.Strings(cyclic)
+		return nil, errors.New(fmt.Sprintf("dependency cycle between top-level configurations: %s", strings.Join(cyclic, ", ")))
+	}
+	return ordered, nil
+}
+
+// IsRegistered determines if a Configuration has been registered by the provided name.
+func IsRegistered(name string) bool {
+	configsM.RLock()
+	defer configsM.RUnlock()
+	_, ok := configs[strings.ToLower(name)]
+	return ok
+}
+
+// Names returns the names of all registered configurations sorted by name.
+func Names() []string {
+	configsM.RLock()
+	defer configsM.RUnlock()
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate looks up registered top-level configuration by name and validates it.
+func Validate(name string, cfg []byte) error {
+	configsM.RLock()
+	defer configsM.RUnlock()
+	c, ok := configs[name]
+	if !ok {
+		log.WithField("name", name).Fatal("failed to find top-level configuration")
+	}
+	if err := validateMinVersions(name, cfg); err != nil {
+		return err
+	}
+	return c.Validate(cfg)
+}
+
+// Resolve looks up registered top-level configuration by name and returns the configuration with
+// references to external content resolved, see Resolver. Configurations without references are returned as they are.
+func Resolve(name string, cfg []byte) ([]byte, error) {
+	configsM.RLock()
+	defer configsM.RUnlock()
+	c, ok := configs[name]
+	if !ok {
+		log.WithField("name", name).Fatal("failed to find top-level configuration")
+	}
+	r, ok := c.(Resolver)
+	if !ok {
+		return cfg, nil
+	}
+	return r.Resolve(cfg)
 }
 
 // Apply looks up registered top-level configuration by name and applies it an
 // instance of Vault.
-func Apply(name string, address string, cfg []byte, dryRun bool, threadPoolSize int) error {
+// The process exits if no configuration is registered by the provided name.
+//
+// Configurations are not applied to instances marked invalid for the current reconcile
+// and an instance is marked invalid when applying a configuration to it fails, so that
+// a failure within one configuration skips the instance for all following configurations.
+// Failures are recorded to be reported once the reconcile has completed.
+//
+// Configurations requiring a newer version of vault than the instance runs are skipped, see RequireVersion,
+// as are entries configured with a `min_vault_version` newer than the instance.
+func Apply(name string, address string, cfg []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
 	configsM.RLock()
 	defer configsM.RUnlock()
 	c, ok := configs[name]
 	if !ok {
 		log.WithField("name", name).Fatal("failed to find top-level configuration")
 	}
-	return c.Apply(address, cfg, dryRun, threadPoolSize)
+	if vault.IsInvalid(address) {
+		vault.Logger(address, name).Info("skipping top-level configuration for invalid instance")
+		return vault.NewPlan(), nil
+	}
+	cfg, supported, err := applicableConfig(name, address, cfg)
+	if err != nil {
+		vault.RecordFailure(address, name, "version", "", err)
+		vault.AddInvalid(address)
+		return nil, err
+	}
+	if !supported {
+		return vault.NewPlan(), nil
+	}
+	plan, err := c.Apply(address, cfg, dryRun, prune, threadPoolSize)
+	if err != nil {
+		vault.RecordFailure(address, name, "apply", "", err)
+		vault.AddInvalid(address)
+	}
+	return plan, err
 }
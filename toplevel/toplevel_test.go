@@ -0,0 +1,169 @@
+package toplevel
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/hashicorp/go-version"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+type testConfig struct{}
+
+func (c testConfig) Apply(string, []byte, bool, bool, int) (*vault.Plan, error) {
+	return vault.NewPlan(), nil
+}
+
+// failingConfig fails to apply to the instances in fail and records the instances it is applied to
+type failingConfig struct {
+	fail    map[string]bool
+	applied *[]string
+}
+
+func (c failingConfig) Apply(address string, _ []byte, _, _ bool, _ int) (*vault.Plan, error) {
+	*c.applied = append(*c.applied, address)
+	if c.fail[address] {
+		return nil, errors.New("failed to apply")
+	}
+	return vault.NewPlan(), nil
+}
+
+func (c failingConfig) Validate([]byte) error { return nil }
+
+func (c testConfig) Validate([]byte) error { return nil }
+
+func init() {
+	RegisterConfiguration("test_engines", testConfig{})
+	RegisterConfiguration("test_policies", testConfig{})
+	RegisterConfiguration("test_auth", testConfig{}, "test_policies")
+	RegisterConfiguration("test_roles", testConfig{}, "test_auth", "test_policies")
+	RegisterConfiguration("test_cycle_a", testConfig{}, "test_cycle_b")
+	RegisterConfiguration("test_cycle_b", testConfig{}, "test_cycle_a")
+}
+
+func TestOrder(t *testing.T) {
+	table := []struct {
+		description string
+		names       []string
+		expected    []string
+		expectErr   bool
+	}{
+		{
+			description: "dependencies are applied first",
+			names:       []string{"test_roles", "test_auth", "test_policies"},
+			expected:    []string{"test_policies", "test_auth", "test_roles"},
+		},
+		{
+			description: "independent configurations are ordered by name",
+			names:       []string{"test_roles", "test_policies", "test_engines", "test_auth"},
+			expected:    []string{"test_engines", "test_policies", "test_auth", "test_roles"},
+		},
+		{
+			description: "missing dependencies are ignored",
+			names:       []string{"test_roles"},
+			expected:    []string{"test_roles"},
+		},
+		{
+			description: "cycles are refused",
+			names:       []string{"test_cycle_a", "test_cycle_b", "test_engines"},
+			expectErr:   true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			ordered, err := Order(tt.names)
+			if tt.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, ordered)
+		})
+	}
+}
+
+func TestApplySkipsInvalidInstances(t *testing.T) {
+	engines := []string{}
+	policies := []string{}
+	RegisterConfiguration("test_failing_engines", failingConfig{
+		fail:    map[string]bool{"https://a.vault.test": true},
+		applied: &engines,
+	})
+	RegisterConfiguration("test_failing_policies", failingConfig{applied: &policies}, "test_failing_engines")
+
+	for _, address := range []string{"https://a.vault.test", "https://b.vault.test"} {
+		_, err := Apply("test_failing_engines", address, nil, false, false, 1)
+		require.Equal(t, address == "https://a.vault.test", err != nil)
+		plan, err := Apply("test_failing_policies", address, nil, false, false, 1)
+		require.NoError(t, err)
+		require.NotNil(t, plan)
+	}
+
+	require.Equal(t, []string{"https://a.vault.test", "https://b.vault.test"}, engines)
+	require.Equal(t, []string{"https://b.vault.test"}, policies, "a failing instance is skipped by later configurations")
+	require.True(t, vault.IsInvalid("https://a.vault.test"))
+	require.False(t, vault.IsInvalid("https://b.vault.test"))
+}
+
+func TestExcludeUnsupported(t *testing.T) {
+	cfg := []byte(`
+- name: a
+  instance:
+    address: https://a.vault.test
+  min_vault_version: 1.13.0
+- name: b
+  instance:
+    address: https://a.vault.test
+  min_vault_version: 1.12.0
+- name: c
+  instance:
+    address: https://b.vault.test
+  min_vault_version: 1.13.0
+- name: d
+  instance:
+    address: https://a.vault.test
+- mount: approle
+  instance:
+    address: https://a.vault.test
+  roles:
+  - name: e
+    min_vault_version: 1.13.0
+  - name: f
+`)
+	current, err := version.NewVersion("1.12.1+ent")
+	require.NoError(t, err)
+
+	excluded, err := excludeUnsupported("test_policies", "https://a.vault.test", cfg, current)
+	require.NoError(t, err)
+	var entries []map[string]interface{}
+	require.NoError(t, yaml.Unmarshal(excluded, &entries))
+	require.Len(t, entries, 5)
+	require.Equal(t, false, entries[0]["managed"], "entries requiring a newer version are unmanaged")
+	for _, e := range entries[1:] {
+		require.NotContains(t, e, "managed", "entry %v", e["name"])
+	}
+	roles := entries[4]["roles"].([]interface{})
+	require.Equal(t, false, roles[0].(map[interface{}]interface{})["managed"], "nested items requiring a newer version are unmanaged")
+	require.NotContains(t, roles[1], "managed")
+
+	unchanged, err := excludeUnsupported("test_policies", "https://c.vault.test", cfg, current)
+	require.NoError(t, err)
+	require.Equal(t, cfg, unchanged, "entries of other instances are left as configured")
+}
+
+func TestValidateMinVersions(t *testing.T) {
+	require.NoError(t, validateMinVersions("test_policies", []byte("- name: a\n  min_vault_version: 1.12\n")))
+	require.Error(t, validateMinVersions("test_policies", []byte("- name: a\n  min_vault_version: latest\n")))
+	require.Error(t, validateMinVersions("test_approles", []byte("- mount: a\n  roles:\n  - name: b\n    min_vault_version: latest\n")))
+}
+
+func TestCacheable(t *testing.T) {
+	RegisterConfiguration("test_secret_readers", testConfig{})
+	ReadsSecrets("test_secret_readers")
+
+	require.False(t, Cacheable("test_secret_readers"), "values read from secrets are not part of the cached configuration")
+	require.True(t, Cacheable("test_policies"))
+}
@@ -0,0 +1,420 @@
+// Package transit implements the application of a declarative configuration
+// for keys of Vault Transit secrets engines.
+//
+// Keys are only deleted when pruning is enabled and deletion is allowed on the
+// existing key, as deleting a key destroys the ability to decrypt its data.
+package transit
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/app-sre/vault-manager/pkg/utils"
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/app-sre/vault-manager/toplevel"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+type entry struct {
+	Mount    string         `yaml:"mount"`
+	Instance vault.Instance `yaml:"instance"`
+	Keys     []key          `yaml:"keys"`
+}
+
+type key struct {
+	Name string `yaml:"name"`
+	// Type defaults to aes256-gcm96 when unset, matching vault
+	Type                 string `yaml:"type"`
+	Exportable           bool   `yaml:"exportable"`
+	AllowPlaintextBackup bool   `yaml:"allow_plaintext_backup"`
+	DeletionAllowed      bool   `yaml:"deletion_allowed"`
+	AutoRotatePeriod     string `yaml:"auto_rotate_period"`
+	Mount                string `yaml:"-"`
+	vault.Toggle         `yaml:",inline"`
+}
+
+var _ vault.Item = key{}
+
+func (k key) Key() string {
+	return filepath.Join(k.Mount, "keys", k.Name)
+}
+
+func (k key) KeyForType() string {
+	return ""
+}
+
+func (k key) KeyForDescription() string {
+	return ""
+}
+
+// Equals compares the auto rotate period regardless of its unit
+func (k key) Equals(i interface{}) bool {
+	ky, ok := i.(key)
+	if !ok {
+		return false
+	}
+
+	return k.Key() == ky.Key() &&
+		k.keyType() == ky.keyType() &&
+		k.Exportable == ky.Exportable &&
+		k.AllowPlaintextBackup == ky.AllowPlaintextBackup &&
+		k.DeletionAllowed == ky.DeletionAllowed &&
+		vault.OptionsEqual(
+			map[string]interface{}{"auto_rotate_period": k.autoRotatePeriod()},
+			map[string]interface{}{"auto_rotate_period": ky.autoRotatePeriod()})
+}
+
+func (k key) keyType() string {
+	if k.Type == "" {
+		return defaultType
+	}
+	return k.Type
+}
+
+func (k key) autoRotatePeriod() string {
+	if k.AutoRotatePeriod == "" {
+		return "0"
+	}
+	return k.AutoRotatePeriod
+}
+
+// config returns the settings written to `<mount>/keys/<name>/config`
+func (k key) config() map[string]interface{} {
+	return map[string]interface{}{
+		"exportable":             k.Exportable,
+		"allow_plaintext_backup": k.AllowPlaintextBackup,
+		"deletion_allowed":       k.DeletionAllowed,
+		"auto_rotate_period":     k.autoRotatePeriod(),
+	}
+}
+
+const defaultType = "aes256-gcm96"
+
+// supportedTypes are the key types accepted by the transit secrets engine
+var supportedTypes = map[string]bool{
+	"aes128-gcm96":      true,
+	"aes256-gcm96":      true,
+	"chacha20-poly1305": true,
+	"ed25519":           true,
+	"ecdsa-p256":        true,
+	"ecdsa-p384":        true,
+	"ecdsa-p521":        true,
+	"rsa-2048":          true,
+	"rsa-3072":          true,
+	"rsa-4096":          true,
+	"hmac":              true,
+}
+
+type config struct{}
+
+var _ toplevel.Configuration = config{}
+
+const toplevelName = "vault_transit_keys"
+
+func init() {
+	toplevel.RegisterConfiguration(toplevelName, config{}, "vault_secret_engines")
+}
+
+// Validate ensures each key is named and has a supported type and auto rotate period.
+func (c config) Validate(entriesBytes []byte) error {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return errors.New(fmt.Sprintf("[Vault Transit] failed to decode transit key configuration: %v", err))
+	}
+	errs := []error{}
+	for _, e := range entries {
+		for _, k := range e.Keys {
+			if k.Name == "" {
+				errs = append(errs, errors.New(fmt.Sprintf("[Vault Transit] key without name in mount `%s`", e.Mount)))
+				continue
+			}
+			if !supportedTypes[k.keyType()] {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Transit] unsupported type `%s` of key `%s` in mount `%s`", k.Type, k.Name, e.Mount)))
+			}
+			if _, err := vault.ParseDuration(k.autoRotatePeriod()); err != nil {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"[Vault Transit] invalid `auto_rotate_period` of key `%s` in mount `%s`: %v", k.Name, e.Mount, err)))
+			}
+		}
+	}
+	return utils.JoinErrors(errs)
+}
+
+// Apply ensures that the keys of an instance's transit secrets engines are
+// configured exactly as provided.
+func (c config) Apply(address string, entriesBytes []byte, dryRun, prune bool, threadPoolSize int) (*vault.Plan, error) {
+	var entries []entry
+	if err := yaml.Unmarshal(entriesBytes, &entries); err != nil {
+		return nil, errors.New(fmt.Sprintf("[Vault Transit] failed to decode transit key configuration: %v", err))
+	}
+
+	mounts := []string{}
+	desiredKeys := []key{}
+	for _, e := range entries {
+		if e.Instance.Key() != address {
+			continue
+		}
+		mount := strings.Trim(e.Mount, "/")
+		mounts = append(mounts, mount)
+		for _, k := range e.Keys {
+			k.Mount = mount
+			desiredKeys = append(desiredKeys, k)
+		}
+	}
+
+	existingKeys, err := getExistingKeys(address, mounts, threadPoolSize)
+	if err != nil {
+		return nil, err
+	}
+
+	toBeWritten, toBeDeleted, _ := vault.DiffItems(
+		vault.ExcludeUnmanaged(asItems(desiredKeys), asItems(existingKeys)))
+	toBeCreated, toBeUpdated := determineUpdates(address, toBeWritten, existingKeys)
+	utils.RecordPendingChanges(address, toplevelName, len(toBeCreated)+len(toBeUpdated)+len(toBeDeleted))
+	if !prune {
+		toBeDeleted = vault.SkipDeletes(address, "[Vault Transit] key", toBeDeleted, nil)
+	}
+	toBeDeleted = skipProtectedDeletes(address, toBeDeleted)
+	if err := vault.CheckDeletes(address, "[Vault Transit]", toBeDeleted); err != nil {
+		return nil, err
+	}
+
+	plan := vault.NewPlan()
+	plan.Add("transit-key", toBeCreated, toBeUpdated, toBeDeleted, asItems(existingKeys))
+
+	if dryRun == true {
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationCreate, len(toBeCreated))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationUpdate, len(toBeUpdated))
+		utils.RecordPlannedOperations(address, toplevelName, utils.OperationDelete, len(toBeDeleted))
+		for _, w := range toBeCreated {
+			vault.Logger(address, toplevelName).WithFields(log.Fields{
+				"path": w.Key(),
+				"type": w.(key).keyType(),
+			}).Info("[Dry Run] [Vault Transit] key to be created")
+		}
+		for _, u := range toBeUpdated {
+			vault.Logger(address, toplevelName).WithField("path", u.Key()).Info(
+				"[Dry Run] [Vault Transit] key config to be updated")
+		}
+		for _, d := range toBeDeleted {
+			vault.Logger(address, toplevelName).WithField("path", d.Key()).Info(
+				"[Dry Run] [Vault Transit] key to be deleted")
+		}
+		return plan, nil
+	}
+
+	for _, w := range toBeCreated {
+		err := createKey(address, w.(key))
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationCreate)
+	}
+	for _, u := range toBeUpdated {
+		err := writeKeyConfig(address, u.(key))
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationUpdate)
+	}
+	for _, d := range toBeDeleted {
+		err := vault.DeleteRaw(address, d.Key())
+		if err != nil {
+			return nil, err
+		}
+		utils.RecordOperation(address, toplevelName, utils.OperationDelete)
+		vault.Logger(address, toplevelName).WithField("path", d.Key()).Info(
+			"[Vault Transit] key is successfully deleted")
+	}
+
+	return plan, nil
+}
+
+// determineUpdates separates keys that do not exist yet from existing keys whose config changed
+// changes that vault cannot apply to an existing key, a different type or disabling export or
+// plaintext backup, are logged and skipped
+func determineUpdates(address string, toBeWritten []vault.Item, existing []key) ([]vault.Item, []vault.Item) {
+	existingByKey := make(map[string]key, len(existing))
+	for _, e := range existing {
+		existingByKey[e.Key()] = e
+	}
+
+	toBeCreated := make([]vault.Item, 0)
+	toBeUpdated := make([]vault.Item, 0)
+	for _, w := range toBeWritten {
+		desired := w.(key)
+		current, ok := existingByKey[desired.Key()]
+		if !ok {
+			toBeCreated = append(toBeCreated, w)
+			continue
+		}
+		if err := checkImmutable(desired, current); err != nil {
+			vault.Logger(address, toplevelName).WithError(err).WithField("path", desired.Key()).Error(
+				"[Vault Transit] key cannot be updated, a manual migration is required")
+			vault.RecordFailure(address, toplevelName, "update", desired.Key(), err)
+			continue
+		}
+		toBeUpdated = append(toBeUpdated, w)
+	}
+	return toBeCreated, toBeUpdated
+}
+
+// checkImmutable returns an error if the desired key differs from the existing key in a way
+// that vault does not allow, export and plaintext backup cannot be disabled once enabled
+func checkImmutable(desired, existing key) error {
+	if desired.keyType() != existing.keyType() {
+		return errors.New(fmt.Sprintf("type cannot be changed from %s to %s", existing.keyType(), desired.keyType()))
+	}
+	if existing.Exportable && !desired.Exportable {
+		return errors.New("exportable cannot be disabled once enabled")
+	}
+	if existing.AllowPlaintextBackup && !desired.AllowPlaintextBackup {
+		return errors.New("allow_plaintext_backup cannot be disabled once enabled")
+	}
+	return nil
+}
+
+// skipProtectedDeletes removes keys that do not allow deletion as deleting them would fail
+func skipProtectedDeletes(address string, toBeDeleted []vault.Item) []vault.Item {
+	deletable := make([]vault.Item, 0, len(toBeDeleted))
+	for _, d := range toBeDeleted {
+		if !d.(key).DeletionAllowed {
+			vault.Logger(address, toplevelName).WithField("path", d.Key()).Info(
+				"[Vault Transit] key not deleted as `deletion_allowed` is not set on the existing key")
+			continue
+		}
+		deletable = append(deletable, d)
+	}
+	return deletable
+}
+
+// createKey creates a key and then writes its config as deletion_allowed cannot be set on creation
+func createKey(address string, k key) error {
+	err := vault.WriteRaw(address, k.Key(), map[string]interface{}{
+		"type":                   k.keyType(),
+		"exportable":             k.Exportable,
+		"allow_plaintext_backup": k.AllowPlaintextBackup,
+		"auto_rotate_period":     k.autoRotatePeriod(),
+	})
+	if err != nil {
+		return err
+	}
+	vault.Logger(address, toplevelName).WithField("path", k.Key()).Info(
+		"[Vault Transit] key is successfully created")
+	return writeKeyConfig(address, k)
+}
+
+func writeKeyConfig(address string, k key) error {
+	err := vault.WriteRaw(address, filepath.Join(k.Key(), "config"), k.config())
+	if err != nil {
+		return err
+	}
+	vault.Logger(address, toplevelName).WithField("path", k.Key()).Info(
+		"[Vault Transit] key config is successfully written")
+	return nil
+}
+
+// getExistingKeys reads the keys of each mount
+// mounts that are not enabled as transit secrets engines, e.g. during a dry run, do not contain any keys
+func getExistingKeys(address string, mounts []string, threadPoolSize int) ([]key, error) {
+	engines, err := vault.ListSecretsEngines(address)
+	if err != nil {
+		return nil, err
+	}
+	enabled := make(map[string]bool)
+	for path, engine := range engines {
+		if engine.Type == "transit" {
+			enabled[vault.NormalizePath(path)] = true
+		}
+	}
+
+	existing := []key{}
+	for _, mount := range mounts {
+		if !enabled[mount] {
+			continue
+		}
+		names, err := listNames(address, filepath.Join(mount, "keys"))
+		if err != nil {
+			return nil, err
+		}
+
+		var mutex = &sync.Mutex{}
+		var readErr error
+		bwg := utils.NewBoundedWaitGroup(threadPoolSize)
+
+		for _, name := range names {
+			bwg.Add(1)
+
+			go func(name string) {
+				defer bwg.Done()
+
+				k := key{Name: name, Mount: mount}
+				data, err := vault.ReadRaw(address, k.Key())
+
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if err != nil {
+					readErr = err
+					return
+				}
+				if data == nil {
+					return
+				}
+				existing = append(existing, fromData(k, data))
+			}(name)
+		}
+		bwg.Wait()
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	return existing, nil
+}
+
+// fromData sets the settings of a key read from vault
+func fromData(k key, data map[string]interface{}) key {
+	k.Type = fmt.Sprintf("%v", data["type"])
+	k.Exportable, _ = strconv.ParseBool(fmt.Sprintf("%v", data["exportable"]))
+	k.AllowPlaintextBackup, _ = strconv.ParseBool(fmt.Sprintf("%v", data["allow_plaintext_backup"]))
+	k.DeletionAllowed, _ = strconv.ParseBool(fmt.Sprintf("%v", data["deletion_allowed"]))
+	if period, ok := data["auto_rotate_period"]; ok && period != nil {
+		k.AutoRotatePeriod = fmt.Sprintf("%v", period)
+	}
+	return k
+}
+
+// listNames returns the keys listed at path or an empty list if nothing exists
+func listNames(address, path string) ([]string, error) {
+	secret, err := vault.ListSecrets(address, path)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return []string{}, nil
+	}
+	values, ok := secret.Data["keys"].([]interface{})
+	if !ok {
+		return []string{}, nil
+	}
+	names := make([]string, 0, len(values))
+	for _, v := range values {
+		names = append(names, fmt.Sprintf("%v", v))
+	}
+	return names, nil
+}
+
+func asItems(xs []key) (items []vault.Item) {
+	items = make([]vault.Item, 0)
+	for _, x := range xs {
+		items = append(items, x)
+	}
+
+	return
+}
@@ -0,0 +1,126 @@
+package transit
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyEquals(t *testing.T) {
+	existing := fromData(key{Name: "app", Mount: "transit"}, map[string]interface{}{
+		"type":                   "aes256-gcm96",
+		"exportable":             false,
+		"allow_plaintext_backup": false,
+		"deletion_allowed":       true,
+		"auto_rotate_period":     json.Number("86400"),
+	})
+
+	table := []struct {
+		description string
+		desired     key
+		expected    bool
+	}{
+		{
+			description: "default type and period unit",
+			desired:     key{Name: "app", Mount: "transit", DeletionAllowed: true, AutoRotatePeriod: "24h"},
+			expected:    true,
+		},
+		{
+			description: "deletion not allowed",
+			desired:     key{Name: "app", Mount: "transit", AutoRotatePeriod: "24h"},
+			expected:    false,
+		},
+		{
+			description: "different period",
+			desired:     key{Name: "app", Mount: "transit", DeletionAllowed: true},
+			expected:    false,
+		},
+		{
+			description: "different type",
+			desired:     key{Name: "app", Mount: "transit", Type: "ed25519", DeletionAllowed: true, AutoRotatePeriod: "24h"},
+			expected:    false,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.desired.Equals(existing))
+		})
+	}
+}
+
+func TestDetermineUpdates(t *testing.T) {
+	existing := []key{
+		{Name: "config", Mount: "transit", Type: "aes256-gcm96"},
+		{Name: "type", Mount: "transit", Type: "aes256-gcm96"},
+		{Name: "export", Mount: "transit", Type: "aes256-gcm96", Exportable: true},
+	}
+	toBeWritten := asItems([]key{
+		{Name: "new", Mount: "transit"},
+		{Name: "config", Mount: "transit", DeletionAllowed: true},
+		{Name: "type", Mount: "transit", Type: "ed25519"},
+		{Name: "export", Mount: "transit"},
+	})
+
+	created, updated := determineUpdates("https://vault.test", toBeWritten, existing)
+	require.Equal(t, []string{"transit/keys/new"}, keys(created))
+	require.Equal(t, []string{"transit/keys/config"}, keys(updated))
+}
+
+func TestSkipProtectedDeletes(t *testing.T) {
+	toBeDeleted := asItems([]key{
+		{Name: "protected", Mount: "transit"},
+		{Name: "deletable", Mount: "transit", DeletionAllowed: true},
+	})
+	require.Equal(t, []string{"transit/keys/deletable"}, keys(skipProtectedDeletes("https://vault.test", toBeDeleted)))
+}
+
+func TestValidate(t *testing.T) {
+	table := []struct {
+		description string
+		config      string
+		expectErr   bool
+	}{
+		{
+			description: "valid key",
+			config:      "- mount: transit\n  keys:\n  - name: app\n    type: ed25519\n    auto_rotate_period: 720h\n",
+			expectErr:   false,
+		},
+		{
+			description: "unsupported type",
+			config:      "- mount: transit\n  keys:\n  - name: app\n    type: des\n",
+			expectErr:   true,
+		},
+		{
+			description: "invalid period",
+			config:      "- mount: transit\n  keys:\n  - name: app\n    auto_rotate_period: monthly\n",
+			expectErr:   true,
+		},
+		{
+			description: "key without name",
+			config:      "- mount: transit\n  keys:\n  - type: ed25519\n",
+			expectErr:   true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.description, func(t *testing.T) {
+			err := config{}.Validate([]byte(tt.config))
+			if tt.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func keys(items []vault.Item) []string {
+	keys := []string{}
+	for _, i := range items {
+		keys = append(keys, i.Key())
+	}
+	return keys
+}
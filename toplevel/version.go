@@ -0,0 +1,180 @@
+package toplevel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/app-sre/vault-manager/pkg/vault"
+	"github.com/hashicorp/go-version"
+	"gopkg.in/yaml.v2"
+)
+
+const minVersionKey = "min_vault_version"
+
+var minVersions = make(map[string]string)
+
+// RequireVersion declares the minimum version of vault a Configuration requires, instances running
+// an older version are skipped by Apply with a warning instead of failing on requests vault does not support.
+//
+// If called with a blank or invalid version, this function panics.
+func RequireVersion(name, minimum string) {
+	configsM.Lock()
+	defer configsM.Unlock()
+	if err := vault.ValidateMinVersion(minimum); err != nil {
+		panic("toplevel: " + err.Error())
+	}
+	minVersions[strings.ToLower(name)] = minimum
+}
+
+// validateMinVersions ensures each `min_vault_version` of the entries and the items nested within them can be parsed
+func validateMinVersions(name string, cfg []byte) error {
+	if !bytes.Contains(cfg, []byte(minVersionKey)) {
+		return nil
+	}
+	var entries []interface{}
+	if err := yaml.Unmarshal(cfg, &entries); err != nil {
+		// malformed entries are reported by the Validate of the configuration
+		return nil
+	}
+	return validateMinVersion(name, entries)
+}
+
+func validateMinVersion(name string, v interface{}) error {
+	switch value := v.(type) {
+	case []interface{}:
+		for _, item := range value {
+			if err := validateMinVersion(name, item); err != nil {
+				return err
+			}
+		}
+	case map[interface{}]interface{}:
+		if minimum, ok := value[minVersionKey]; ok {
+			if err := vault.ValidateMinVersion(fmt.Sprintf("%v", minimum)); err != nil {
+				return errors.New(fmt.Sprintf("[%s] entry `%v`: %v", name, entryName(value), err))
+			}
+		}
+		for _, item := range value {
+			if err := validateMinVersion(name, item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// excludeUnsupported marks the entries of an instance, and the items nested within them such as the roles
+// of a mount, that require a newer version of vault than the instance runs as `managed: false`, so that the
+// objects are neither written nor pruned until the instance is upgraded.
+// Entries of other instances and without `min_vault_version` are unchanged.
+func excludeUnsupported(name, address string, cfg []byte, current *version.Version) ([]byte, error) {
+	var entries []map[interface{}]interface{}
+	if err := yaml.Unmarshal(cfg, &entries); err != nil {
+		return nil, err
+	}
+	// the instances of the entries, in the same order, resolved the way configurations resolve them
+	var instances []struct {
+		Instance vault.Instance `yaml:"instance"`
+	}
+	if err := yaml.Unmarshal(cfg, &instances); err != nil {
+		return nil, err
+	}
+	excluded := false
+	for i, e := range entries {
+		if instances[i].Instance.Key() != address {
+			continue
+		}
+		marked, err := markUnsupported(name, address, e, current)
+		if err != nil {
+			return nil, err
+		}
+		excluded = excluded || marked
+	}
+	if !excluded {
+		return cfg, nil
+	}
+	vault.RecordUnsupported(address, name)
+	return yaml.Marshal(entries)
+}
+
+// markUnsupported sets `managed: false` on a value and any value nested within it whose
+// `min_vault_version` is newer than the current version and returns if any value was marked
+func markUnsupported(name, address string, v interface{}, current *version.Version) (bool, error) {
+	marked := false
+	switch value := v.(type) {
+	case []interface{}:
+		for _, item := range value {
+			m, err := markUnsupported(name, address, item, current)
+			if err != nil {
+				return false, err
+			}
+			marked = marked || m
+		}
+	case map[interface{}]interface{}:
+		if minimum, ok := value[minVersionKey]; ok {
+			min, err := version.NewVersion(fmt.Sprintf("%v", minimum))
+			if err != nil {
+				return false, errors.New(fmt.Sprintf("invalid minimum vault version `%v`: %v", minimum, err))
+			}
+			if current.Core().LessThan(min.Core()) {
+				vault.Logger(address, name).WithField("entry", entryName(value)).WithField("version", current.String()).
+					WithField(minVersionKey, min.String()).Warn("skipping entry requiring a newer version of vault")
+				value["managed"] = false
+				// nested items are left unmanaged along with the entry
+				return true, nil
+			}
+		}
+		for key, item := range value {
+			if key == "instance" {
+				continue
+			}
+			m, err := markUnsupported(name, address, item, current)
+			if err != nil {
+				return false, err
+			}
+			marked = marked || m
+		}
+	}
+	return marked, nil
+}
+
+// entryName returns the value identifying an entry within its configuration for logging
+func entryName(e map[interface{}]interface{}) interface{} {
+	for _, key := range []string{"name", "path", "role"} {
+		if v, ok := e[key]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// applicableConfig returns the entries of a configuration that are supported by the version of vault
+// an instance runs, or false if the configuration itself requires a newer version.
+// The version of the instance is only read when a minimum version is declared.
+func applicableConfig(name, address string, cfg []byte) ([]byte, bool, error) {
+	if minimum, ok := minVersions[name]; ok {
+		supported, current, err := vault.MeetsMinVersion(address, minimum)
+		if err != nil {
+			return nil, false, err
+		}
+		if !supported {
+			vault.Logger(address, name).WithField("version", current.String()).WithField(minVersionKey, minimum).
+				Warn("skipping top-level configuration requiring a newer version of vault")
+			vault.RecordUnsupported(address, name)
+			return nil, false, nil
+		}
+	}
+	if !bytes.Contains(cfg, []byte(minVersionKey)) {
+		return cfg, true, nil
+	}
+	current, err := vault.GetVersion(address)
+	if err != nil {
+		return nil, false, err
+	}
+	cfg, err = excludeUnsupported(name, address, cfg, current)
+	if err != nil {
+		return nil, false, err
+	}
+	return cfg, true, nil
+}